@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"strings"
+)
+
+// Annotate prepends annotation comment (e.g. "/* query_get_user */") to the SQL query.
+// Such annotations are parsed by ParseAnnotationInQuery and used for labeling metrics and slow queries logging
+// regardless of the query builder (dbr, goqu, sqlx or raw SQL).
+// Comment delimiters are removed from the name, so it cannot break the query.
+func Annotate(query, name string) string {
+	name = strings.TrimSpace(strings.NewReplacer("/*", "", "*/", "").Replace(name))
+	if name == "" {
+		return query
+	}
+	return "/* " + name + " */ " + query
+}
+
+// ParseAnnotationInQuery parses annotation from comments in SQL query with specified prefix.
+// If SQL query contains multiple annotations, they will be concatenated with "|" character.
+func ParseAnnotationInQuery(query, prefix string, modifier func(string) string) string {
+	var left int
+	var buf bytes.Buffer
+	for left < len(query) {
+		if !strings.HasPrefix(query[left:], "/*") {
+			break
+		}
+		left += 2
+		r := strings.Index(query[left:], "*/")
+		if r == -1 {
+			break
+		}
+		right := left + r
+		annotation := strings.TrimSpace(query[left:right])
+		if annotation != "" && strings.HasPrefix(annotation, prefix) {
+			if modifier != nil {
+				annotation = modifier(annotation)
+			}
+			if annotation != "" {
+				if buf.Len() != 0 {
+					buf.WriteString("|") // nolint: gosec
+				}
+				buf.WriteString(annotation) // nolint: gosec
+			}
+		}
+		left = right + 2
+		for left < len(query) && (query[left] == ' ' || query[left] == '\n') {
+			left++
+		}
+	}
+	return buf.String()
+}
+
+type queryAnnotationCtxKey struct{}
+
+// WithQueryAnnotation returns a new context with the query annotation (e.g. "query_create_user").
+// It allows setting annotation once per use-case at the service layer instead of on every query builder chain.
+// Annotation is prepended (see Annotate) to the SQL queries executed with this context
+// by the connector wrapped with WrapConnectorForContextAnnotations and by goquutil queriers.
+func WithQueryAnnotation(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryAnnotationCtxKey{}, name)
+}
+
+// QueryAnnotationFromContext returns the query annotation set by WithQueryAnnotation.
+func QueryAnnotationFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	name, _ := ctx.Value(queryAnnotationCtxKey{}).(string)
+	return name
+}
+
+// AnnotateFromContext prepends annotation from the context (see WithQueryAnnotation) to the SQL query.
+// Queries that already start with a comment (annotated explicitly) are not changed.
+func AnnotateFromContext(ctx context.Context, query string) string {
+	name := QueryAnnotationFromContext(ctx)
+	if name == "" || strings.HasPrefix(strings.TrimSpace(query), "/*") {
+		return query
+	}
+	return Annotate(query, name)
+}
+
+// WrapConnectorForContextAnnotations wraps driver.Connector for prepending annotation from the context
+// (see WithQueryAnnotation) to all SQL queries executed (or prepared) with this context.
+// It should be the outermost wrapper, so other wrappers (e.g. MetricsCollector.WrapConnectorForRowsMetrics)
+// receive already annotated queries.
+func WrapConnectorForContextAnnotations(connector driver.Connector) driver.Connector {
+	return wrapConnector(connector, driverHooks{rewriteQuery: AnnotateFromContext})
+}
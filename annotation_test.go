@@ -0,0 +1,86 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotate(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		annotation string
+		want       string
+	}{
+		{name: "simple", query: "SELECT 1", annotation: "query_select_1", want: "/* query_select_1 */ SELECT 1"},
+		{name: "empty annotation", query: "SELECT 1", annotation: " ", want: "SELECT 1"},
+		{name: "comment delimiters are removed", query: "SELECT 1", annotation: "query_x */ DROP TABLE users; /*",
+			want: "/* query_x  DROP TABLE users; */ SELECT 1"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Annotate(tt.query, tt.annotation))
+		})
+	}
+}
+
+func TestParseAnnotationInQuery(t *testing.T) {
+	query := Annotate(Annotate("SELECT * FROM users", "query_select_users"), "query_list_users")
+	require.Equal(t, "query_list_users|query_select_users", ParseAnnotationInQuery(query, "query_", nil))
+	require.Equal(t, "QUERY_LIST_USERS|QUERY_SELECT_USERS", ParseAnnotationInQuery(query, "query_", strings.ToUpper))
+	require.Equal(t, "", ParseAnnotationInQuery(query, "prom_", nil))
+	require.Equal(t, "", ParseAnnotationInQuery("SELECT 1 /* query_select_1 */", "", nil))
+}
+
+func TestAnnotateFromContext(t *testing.T) {
+	ctx := WithQueryAnnotation(context.Background(), "query_create_user")
+	require.Equal(t, "query_create_user", QueryAnnotationFromContext(ctx))
+	require.Equal(t, "/* query_create_user */ INSERT INTO users VALUES (1)", AnnotateFromContext(ctx, "INSERT INTO users VALUES (1)"))
+	require.Equal(t, "/* query_insert_user */ INSERT INTO users VALUES (1)",
+		AnnotateFromContext(ctx, "/* query_insert_user */ INSERT INTO users VALUES (1)"))
+	require.Equal(t, "INSERT INTO users VALUES (1)", AnnotateFromContext(context.Background(), "INSERT INTO users VALUES (1)"))
+}
+
+func TestWrapConnectorForContextAnnotations(t *testing.T) {
+	const dsn = "context_annotations_test"
+	mockDB, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	connector, err := NewConnector("sqlmock", dsn)
+	require.NoError(t, err)
+	db := sql.OpenDB(WrapConnectorForContextAnnotations(connector))
+	defer func() {
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		mock.ExpectClose()
+		requireNoErrOnClose(t, mockDB)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	ctx := WithQueryAnnotation(context.Background(), "query_update_users")
+	mock.ExpectExec("/* query_update_users */ UPDATE users SET name = ?").WithArgs("Bob").WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err = db.ExecContext(ctx, "UPDATE users SET name = ?", "Bob")
+	require.NoError(t, err)
+
+	mock.ExpectQuery("/* query_select_users */ SELECT name FROM users").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	rows, err := db.QueryContext(ctx, "/* query_select_users */ SELECT name FROM users")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	mock.ExpectQuery("SELECT name FROM users").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	rows, err = db.QueryContext(context.Background(), "SELECT name FROM users")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+type txAuditCtxKey int
+
+const ctxKeyTxAudit txAuditCtxKey = iota
+
+// AuditEntry represents a record about the change made in the transaction.
+type AuditEntry struct {
+	// Actor is who made the change (e.g. user or service ID).
+	Actor string
+	// Action is what was done (e.g. "user.create").
+	Action string
+	// Resource is what was changed (e.g. "users/42").
+	Resource string
+	// Details is an optional free-form description of the change (e.g. JSON with the changed fields).
+	Details string
+}
+
+// TxAuditor is called by DoInTx and DoInTxWithOpts right before the commit with the audit entries
+// recorded during the transaction (see RecordAudit). It's called within the same transaction,
+// so audit records are written atomically with the changes. If error is returned, the transaction is rolled back.
+// See auditlog package for the ready-made implementation that writes entries into the audit log table.
+type TxAuditor interface {
+	AuditTx(ctx context.Context, tx *sql.Tx, entries []AuditEntry) error
+}
+
+// TxAuditorFunc is an adapter to allow the use of ordinary functions as TxAuditor.
+type TxAuditorFunc func(ctx context.Context, tx *sql.Tx, entries []AuditEntry) error
+
+// AuditTx calls f(ctx, tx, entries).
+func (f TxAuditorFunc) AuditTx(ctx context.Context, tx *sql.Tx, entries []AuditEntry) error {
+	return f(ctx, tx, entries)
+}
+
+type txAudit struct {
+	auditor TxAuditor
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+// NewContextWithTxAuditor creates a new context with TxAuditor.
+// Audit entries are recorded in this context (see RecordAudit), so it should not be shared by concurrent transactions.
+func NewContextWithTxAuditor(ctx context.Context, auditor TxAuditor) context.Context {
+	return context.WithValue(ctx, ctxKeyTxAudit, &txAudit{auditor: auditor})
+}
+
+// RecordAudit records audit entries that will be passed to the TxAuditor from the context when the transaction is committed.
+// Entries are discarded if the transaction is rolled back. It does nothing if there is no TxAuditor in the context.
+func RecordAudit(ctx context.Context, entries ...AuditEntry) {
+	a, ok := ctx.Value(ctxKeyTxAudit).(*txAudit)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entries...)
+}
+
+// takeAuditEntries returns entries recorded in the context and resets them.
+func takeAuditEntries(ctx context.Context) (*txAudit, []AuditEntry) {
+	a, ok := ctx.Value(ctxKeyTxAudit).(*txAudit)
+	if !ok {
+		return nil, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := a.entries
+	a.entries = nil
+	return a, entries
+}
+
+// auditTx passes audit entries recorded in the context to the TxAuditor before the commit.
+func auditTx(ctx context.Context, tx *sql.Tx) error {
+	a, entries := takeAuditEntries(ctx)
+	if a == nil || len(entries) == 0 {
+		return nil
+	}
+	if err := a.auditor.AuditTx(ctx, tx, entries); err != nil {
+		return fmt.Errorf("audit tx: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoInTx_Audit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	var audited [][]AuditEntry
+	var auditErr error
+	ctx := NewContextWithTxAuditor(context.Background(), TxAuditorFunc(
+		func(ctx context.Context, tx *sql.Tx, entries []AuditEntry) error {
+			audited = append(audited, entries)
+			if auditErr != nil {
+				return auditErr
+			}
+			_, execErr := tx.ExecContext(ctx, "INSERT INTO audit_log")
+			return execErr
+		}))
+
+	t.Run("entries are audited before commit", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO audit_log").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		require.NoError(t, DoInTx(ctx, db, func(tx *sql.Tx) error {
+			RecordAudit(ctx, AuditEntry{Actor: "admin", Action: "user.create", Resource: "users/1"})
+			RecordAudit(ctx, AuditEntry{Actor: "admin", Action: "user.create", Resource: "users/2"})
+			return nil
+		}))
+		require.Equal(t, [][]AuditEntry{{
+			{Actor: "admin", Action: "user.create", Resource: "users/1"},
+			{Actor: "admin", Action: "user.create", Resource: "users/2"},
+		}}, audited)
+	})
+
+	t.Run("auditor is not called without entries and for rolled back transactions", func(t *testing.T) {
+		audited = nil
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		require.NoError(t, DoInTx(ctx, db, func(tx *sql.Tx) error {
+			return nil
+		}))
+
+		fnErr := errors.New("fn error")
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		require.ErrorIs(t, DoInTx(ctx, db, func(tx *sql.Tx) error {
+			RecordAudit(ctx, AuditEntry{Action: "user.delete"})
+			return fnErr
+		}), fnErr)
+		require.Empty(t, audited)
+	})
+
+	t.Run("transaction is rolled back if auditor fails", func(t *testing.T) {
+		audited = nil
+		auditErr = errors.New("audit error")
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		err := DoInTx(ctx, db, func(tx *sql.Tx) error {
+			RecordAudit(ctx, AuditEntry{Action: "user.update"})
+			return nil
+		})
+		require.ErrorIs(t, err, auditErr)
+		require.EqualError(t, err, "audit tx: audit error")
+		require.Equal(t, [][]AuditEntry{{{Action: "user.update"}}}, audited)
+	})
+
+	// RecordAudit does nothing without auditor in the context.
+	RecordAudit(context.Background(), AuditEntry{Action: "noop"})
+
+	mock.ExpectClose()
+	requireNoErrOnClose(t, db)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package auditlog provides the ready-made dbkit.TxAuditor that writes audit entries into the audit log table
+// within the audited transaction, so write auditing is done in the same way across services.
+package auditlog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+// DefaultTableName is a name of the audit log table that is used by default.
+const DefaultTableName = "audit_log"
+
+// CreateTableMigrationID is an ID of the migration returned by Writer.Migrations.
+const CreateTableMigrationID = "auditlog_00001_create_table"
+
+// WriterOpts represents an options for Writer.
+type WriterOpts struct {
+	// TableName is a name of the audit log table. DefaultTableName is used by default.
+	TableName string
+
+	// Now returns the current time that is stored as a time of the audit entry. By default, time.Now is used.
+	Now func() time.Time
+}
+
+// Writer is a dbkit.TxAuditor that inserts audit entries into the audit log table.
+type Writer struct {
+	queries dbQueries
+	now     func() time.Time
+}
+
+var _ dbkit.TxAuditor = (*Writer)(nil)
+
+// NewWriter creates a new Writer for the specified dialect.
+func NewWriter(dialect dbkit.Dialect) (*Writer, error) {
+	return NewWriterWithOpts(dialect, WriterOpts{})
+}
+
+// NewWriterWithOpts is a more configurable version of the NewWriter.
+func NewWriterWithOpts(dialect dbkit.Dialect, opts WriterOpts) (*Writer, error) {
+	if opts.TableName == "" {
+		opts.TableName = DefaultTableName
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	q, err := newDBQueries(dialect, opts.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{queries: q, now: opts.Now}, nil
+}
+
+// Migrations returns set of migrations that must be applied before writing audit entries.
+func (w *Writer) Migrations() []migrate.Migration {
+	return []migrate.Migration{
+		migrate.NewCustomMigration(CreateTableMigrationID, []string{w.queries.createTable}, []string{w.queries.dropTable}, nil, nil),
+	}
+}
+
+// AuditTx inserts audit entries into the audit log table within the passed transaction.
+func (w *Writer) AuditTx(ctx context.Context, tx *sql.Tx, entries []dbkit.AuditEntry) error {
+	createdAt := w.now().UTC()
+	for i := range entries {
+		e := &entries[i]
+		if _, err := tx.ExecContext(ctx, w.queries.insert, createdAt, e.Actor, e.Action, e.Resource, e.Details); err != nil {
+			return fmt.Errorf("insert audit entry: %w", err)
+		}
+	}
+	return nil
+}
+
+type dbQueries struct {
+	createTable string
+	dropTable   string
+	insert      string
+}
+
+func newDBQueries(dialect dbkit.Dialect, tableName string) (dbQueries, error) {
+	var createTableQuery string
+	switch dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		createTableQuery = postgresCreateTableQuery
+	case dbkit.DialectMySQL:
+		createTableQuery = mySQLCreateTableQuery
+	case dbkit.DialectSQLite:
+		createTableQuery = sqliteCreateTableQuery
+	case dbkit.DialectMSSQL:
+		createTableQuery = msSQLCreateTableQuery
+	default:
+		return dbQueries{}, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+	if err := dbkit.ValidateIdentifier(dialect, tableName); err != nil {
+		return dbQueries{}, fmt.Errorf("table name: %w", err)
+	}
+	table := dbkit.QuoteIdentifier(dialect, tableName)
+	p := func(n int) string { return dbkit.MakePlaceholder(dialect, n) }
+	return dbQueries{
+		createTable: fmt.Sprintf(createTableQuery, table),
+		dropTable:   fmt.Sprintf("DROP TABLE IF EXISTS %s;", table),
+		insert: fmt.Sprintf("INSERT INTO %s (created_at, actor, action, resource, details) VALUES (%s, %s, %s, %s, %s);",
+			table, p(1), p(2), p(3), p(4), p(5)),
+	}, nil
+}
+
+//nolint:lll
+const (
+	postgresCreateTableQuery = `CREATE TABLE %s (id bigserial PRIMARY KEY, created_at timestamp NOT NULL, actor varchar(255) NOT NULL, action varchar(255) NOT NULL, resource varchar(255) NOT NULL, details text NOT NULL);`
+	mySQLCreateTableQuery    = `CREATE TABLE %s (id BIGINT AUTO_INCREMENT PRIMARY KEY, created_at DATETIME(6) NOT NULL, actor VARCHAR(255) NOT NULL, action VARCHAR(255) NOT NULL, resource VARCHAR(255) NOT NULL, details LONGTEXT NOT NULL);`
+	sqliteCreateTableQuery   = `CREATE TABLE %s (id INTEGER PRIMARY KEY AUTOINCREMENT, created_at TIMESTAMP NOT NULL, actor VARCHAR(255) NOT NULL, action VARCHAR(255) NOT NULL, resource VARCHAR(255) NOT NULL, details TEXT NOT NULL);`
+	msSQLCreateTableQuery    = `CREATE TABLE %s (id BIGINT IDENTITY(1,1) PRIMARY KEY, created_at DATETIME2 NOT NULL, actor NVARCHAR(255) NOT NULL, action NVARCHAR(255) NOT NULL, resource NVARCHAR(255) NOT NULL, details NVARCHAR(MAX) NOT NULL);`
+)
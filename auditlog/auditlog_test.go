@@ -0,0 +1,82 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package auditlog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+func TestWriter(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "auditlog.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writer, err := NewWriterWithOpts(dbkit.DialectSQLite, WriterOpts{Now: func() time.Time { return now }})
+	require.NoError(t, err)
+	migMngr, err := migrate.NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(writer.Migrations(), migrate.MigrationsDirectionUp))
+	_, err = dbConn.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL)")
+	require.NoError(t, err)
+
+	ctx := dbkit.NewContextWithTxAuditor(context.Background(), writer)
+
+	require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+		if _, execErr := tx.ExecContext(ctx, "INSERT INTO users (id, name) VALUES (42, 'John')"); execErr != nil {
+			return execErr
+		}
+		dbkit.RecordAudit(ctx, dbkit.AuditEntry{Actor: "admin", Action: "user.create", Resource: "users/42", Details: `{"name":"John"}`})
+		return nil
+	}))
+
+	// Entries of the rolled back transaction are not written.
+	txErr := errors.New("validation failed")
+	require.ErrorIs(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+		dbkit.RecordAudit(ctx, dbkit.AuditEntry{Actor: "admin", Action: "user.delete", Resource: "users/42"})
+		return txErr
+	}), txErr)
+
+	rows, err := dbConn.Query("SELECT created_at, actor, action, resource, details FROM audit_log ORDER BY id")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, rows.Close()) }()
+	var entries []dbkit.AuditEntry
+	for rows.Next() {
+		var e dbkit.AuditEntry
+		var createdAt time.Time
+		require.NoError(t, rows.Scan(&createdAt, &e.Actor, &e.Action, &e.Resource, &e.Details))
+		require.True(t, now.Equal(createdAt))
+		entries = append(entries, e)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []dbkit.AuditEntry{
+		{Actor: "admin", Action: "user.create", Resource: "users/42", Details: `{"name":"John"}`},
+	}, entries)
+}
+
+func TestNewWriter(t *testing.T) {
+	for _, dialect := range []dbkit.Dialect{
+		dbkit.DialectPostgres, dbkit.DialectPgx, dbkit.DialectMySQL, dbkit.DialectSQLite, dbkit.DialectMSSQL,
+	} {
+		_, err := NewWriter(dialect)
+		require.NoError(t, err, dialect)
+	}
+	_, err := NewWriter("oracle")
+	require.EqualError(t, err, `unsupported sql dialect "oracle"`)
+}
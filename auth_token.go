@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AuthTokenRefreshBeforeExpiry is a time before the token expiration when a new token will be requested.
+const AuthTokenRefreshBeforeExpiry = time.Minute
+
+// AuthTokenProvider is a function that provides a token that is used instead of the password
+// for establishing new database connections (e.g. AWS RDS IAM authentication token
+// that may be built by the auth.BuildAuthToken() function from the github.com/aws/aws-sdk-go-v2/feature/rds/auth package).
+// Zero expiresAt means that the token should not be cached and will be requested for each new connection.
+type AuthTokenProvider func(ctx context.Context) (token string, expiresAt time.Time, err error)
+
+// AuthTokenProvider returns AuthTokenProvider specified for the configured dialect.
+func (c *Config) AuthTokenProvider() AuthTokenProvider {
+	switch c.Dialect {
+	case DialectMySQL:
+		return c.MySQL.AuthTokenProvider
+	case DialectPostgres, DialectPgx:
+		return c.Postgres.AuthTokenProvider
+	}
+	return nil
+}
+
+func (c *Config) makeDSNWithPassword(password string) string {
+	switch c.Dialect {
+	case DialectMySQL:
+		mysqlCfg := c.mySQLConfig()
+		mysqlCfg.Password = password
+		return MakeMySQLDSN(mysqlCfg)
+	case DialectPostgres:
+		pgCfg := c.postgresConfig()
+		pgCfg.Password = password
+		return MakePostgresDSN(pgCfg)
+	case DialectPgx:
+		pgCfg := c.postgresConfig()
+		pgCfg.Password = password
+		return MakePgxDSN(pgCfg)
+	}
+	_, dsn := c.DriverNameAndDSN()
+	return dsn
+}
+
+// getDriverByName resolves the driver registered in database/sql with the given name.
+// DSN is required since drivers implementing driver.DriverContext may reject an empty one.
+func getDriverByName(driverName, dsn string) (driver.Driver, error) {
+	// sql.Open doesn't establish any connections, it's used here only for resolving driver by its name.
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+	drv := db.Driver()
+	rememberDriverName(driverName, drv)
+	return drv, nil
+}
+
+type authTokenConnector struct {
+	driver  driver.Driver
+	tokens  *authTokenCache
+	makeDSN func(password string) string
+
+	// openConnector (if set) is used instead of the driver for opening connectors (e.g. with the custom dialer).
+	openConnector func(dsn string) (driver.Connector, error)
+}
+
+func newAuthTokenConnector(d driver.Driver, provider AuthTokenProvider, makeDSN func(password string) string) *authTokenConnector {
+	return &authTokenConnector{driver: d, tokens: &authTokenCache{provider: provider}, makeDSN: makeDSN}
+}
+
+// Connect implements driver.Connector interface.
+func (c *authTokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := c.tokens.get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get auth token: %w", err)
+	}
+	dsn := c.makeDSN(token)
+	if c.openConnector != nil {
+		connector, connectorErr := c.openConnector(dsn)
+		if connectorErr != nil {
+			return nil, connectorErr
+		}
+		return connector.Connect(ctx)
+	}
+	if driverCtx, ok := c.driver.(driver.DriverContext); ok {
+		connector, connectorErr := driverCtx.OpenConnector(dsn)
+		if connectorErr != nil {
+			return nil, connectorErr
+		}
+		return connector.Connect(ctx)
+	}
+	return c.driver.Open(dsn)
+}
+
+// Driver implements driver.Connector interface.
+func (c *authTokenConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type authTokenCache struct {
+	provider  AuthTokenProvider
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func (c *authTokenCache) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.expiresAt.IsZero() && time.Now().Before(c.expiresAt.Add(-AuthTokenRefreshBeforeExpiry)) {
+		return c.token, nil
+	}
+	token, expiresAt, err := c.provider(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expiresAt = token, expiresAt
+	return token, nil
+}
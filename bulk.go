@@ -0,0 +1,150 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DefaultBulkInsertBatchSize is a default maximum number of rows that are inserted by a single INSERT statement.
+const DefaultBulkInsertBatchSize = 500
+
+// bulkInsertMaxParams is a maximum number of parameters in a single INSERT statement.
+// MSSQL has the lowest limit (2100) among the supported dialects.
+const bulkInsertMaxParams = 2000
+
+// mssqlBulkInsertMaxRows is a maximum number of row value expressions in a single INSERT ... VALUES statement in MSSQL.
+const mssqlBulkInsertMaxRows = 1000
+
+// BulkInsertOpts represents options for BulkInsertWithOpts.
+type BulkInsertOpts struct {
+	// BatchSize is a maximum number of rows that are inserted by a single INSERT statement.
+	// It's decreased automatically if the number of statement parameters exceeds the limit
+	// or if the number of rows exceeds the dialect limit (1000 rows for MSSQL).
+	// DefaultBulkInsertBatchSize is used by default.
+	BatchSize int
+}
+
+// MakePlaceholder returns placeholder for the n-th (starting from 1) query parameter according to the dialect.
+func MakePlaceholder(dialect Dialect, n int) string {
+	switch dialect {
+	case DialectPostgres, DialectPgx, DialectMSSQL:
+		return fmt.Sprintf("$%d", n)
+	default:
+		return "?"
+	}
+}
+
+// BulkInsert inserts rows into the table via multi-row INSERT statements in a single transaction.
+// Table name may be qualified with schema (e.g. "public.users"). Number of inserted rows is returned.
+func BulkInsert(ctx context.Context, db *sql.DB, dialect Dialect, table string, columns []string, rows [][]interface{}) (int64, error) {
+	return BulkInsertWithOpts(ctx, db, dialect, table, columns, rows, BulkInsertOpts{})
+}
+
+// BulkInsertWithOpts is a more configurable version of BulkInsert.
+func BulkInsertWithOpts(
+	ctx context.Context, db *sql.DB, dialect Dialect, table string, columns []string, rows [][]interface{}, opts BulkInsertOpts,
+) (int64, error) {
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("no columns specified")
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	quotedTableParts := strings.Split(table, ".")
+	for i := range quotedTableParts {
+		quotedTableParts[i] = QuoteIdentifier(dialect, quotedTableParts[i])
+	}
+	var inserted int64
+	err := DoInTx(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		inserted, err = bulkInsert(ctx, tx, dialect, strings.Join(quotedTableParts, "."), columns, rows, opts.BatchSize)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// bulkInsert inserts rows into the table (its name should be already quoted) via multi-row INSERT statements.
+func bulkInsert(
+	ctx context.Context, executor SQLExecutor, dialect Dialect, quotedTable string, columns []string, rows [][]interface{}, batchSize int,
+) (int64, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	batchSize = bulkInsertBatchSize(dialect, len(columns), batchSize)
+
+	quotedColumns := make([]string, 0, len(columns))
+	for _, column := range columns {
+		quotedColumns = append(quotedColumns, QuoteIdentifier(dialect, column))
+	}
+	queryPrefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", quotedTable, strings.Join(quotedColumns, ", "))
+
+	var inserted int64
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		query, args, err := buildBulkInsertQuery(dialect, queryPrefix, len(columns), rows[start:end])
+		if err != nil {
+			return inserted, err
+		}
+		if _, err = executor.ExecContext(ctx, query, args...); err != nil {
+			return inserted, fmt.Errorf("insert rows %d-%d: %w", start, end-1, err)
+		}
+		inserted += int64(end - start)
+	}
+	return inserted, nil
+}
+
+// bulkInsertBatchSize returns the number of rows inserted by a single INSERT statement
+// taking into account the limits of the number of parameters and rows.
+func bulkInsertBatchSize(dialect Dialect, columnsNum, batchSize int) int {
+	if batchSize <= 0 {
+		batchSize = DefaultBulkInsertBatchSize
+	}
+	if dialect == DialectMSSQL && batchSize > mssqlBulkInsertMaxRows {
+		batchSize = mssqlBulkInsertMaxRows
+	}
+	if maxBatchSize := bulkInsertMaxParams / columnsNum; batchSize > maxBatchSize {
+		batchSize = maxBatchSize
+	}
+	if batchSize == 0 {
+		batchSize = 1
+	}
+	return batchSize
+}
+
+func buildBulkInsertQuery(dialect Dialect, queryPrefix string, columnsNum int, rows [][]interface{}) (string, []interface{}, error) {
+	var query strings.Builder
+	query.WriteString(queryPrefix)
+	args := make([]interface{}, 0, len(rows)*columnsNum)
+	for i, row := range rows {
+		if len(row) != columnsNum {
+			return "", nil, fmt.Errorf("row has %d values, %d expected", len(row), columnsNum)
+		}
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j := range row {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString(MakePlaceholder(dialect, len(args)+j+1))
+		}
+		query.WriteString(")")
+		args = append(args, row...)
+	}
+	return query.String(), args, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkInsert(t *testing.T) {
+	tests := []struct {
+		name        string
+		dialect     Dialect
+		batchSize   int
+		wantQueries []string
+	}{
+		{
+			name:    "postgres, single batch",
+			dialect: DialectPostgres,
+			wantQueries: []string{
+				`INSERT INTO "public"."users" ("id", "name") VALUES ($1, $2), ($3, $4), ($5, $6)`,
+			},
+		},
+		{
+			name:      "mysql, multiple batches",
+			dialect:   DialectMySQL,
+			batchSize: 2,
+			wantQueries: []string{
+				"INSERT INTO `public`.`users` (`id`, `name`) VALUES (?, ?), (?, ?)",
+				"INSERT INTO `public`.`users` (`id`, `name`) VALUES (?, ?)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() {
+				mock.ExpectClose()
+				requireNoErrOnClose(t, db)
+				require.NoError(t, mock.ExpectationsWereMet())
+			}()
+
+			rows := [][]interface{}{{int64(1), "Albert"}, {int64(2), "Bob"}, {int64(3), "Sam"}}
+			mock.ExpectBegin()
+			insertedRows := 0
+			for _, query := range tt.wantQueries {
+				rowsNum := strings.Count(query, "(") - 1
+				var args []driver.Value
+				for _, row := range rows[insertedRows : insertedRows+rowsNum] {
+					for _, value := range row {
+						args = append(args, value)
+					}
+				}
+				insertedRows += rowsNum
+				mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, int64(rowsNum)))
+			}
+			mock.ExpectCommit()
+
+			inserted, err := BulkInsertWithOpts(context.Background(), db, tt.dialect, "public.users", []string{"id", "name"}, rows,
+				BulkInsertOpts{BatchSize: tt.batchSize})
+			require.NoError(t, err)
+			require.EqualValues(t, 3, inserted)
+		})
+	}
+}
+
+func TestBulkInsert_InvalidRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	_, err = BulkInsert(context.Background(), db, DialectSQLite, "users", []string{"id", "name"}, [][]interface{}{{1}})
+	require.EqualError(t, err, "row has 1 values, 2 expected")
+}
+
+func TestBulkInsertBatchSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		dialect    Dialect
+		columnsNum int
+		batchSize  int
+		want       int
+	}{
+		{name: "default", dialect: DialectPostgres, columnsNum: 2, want: DefaultBulkInsertBatchSize},
+		{name: "custom", dialect: DialectMySQL, columnsNum: 1, batchSize: 1500, want: 1500},
+		{name: "limited by params", dialect: DialectPostgres, columnsNum: 10, batchSize: 1000, want: 200},
+		{name: "too many columns", dialect: DialectPostgres, columnsNum: 3000, batchSize: 10, want: 1},
+		{name: "mssql, limited by rows", dialect: DialectMSSQL, columnsNum: 1, batchSize: 1500, want: 1000},
+		{name: "mssql, limited by params", dialect: DialectMSSQL, columnsNum: 4, batchSize: 1500, want: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, bulkInsertBatchSize(tt.dialect, tt.columnsNum, tt.batchSize))
+		})
+	}
+}
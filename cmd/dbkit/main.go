@@ -0,0 +1,409 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Command dbkit is a CLI tool for operating databases of services built on top of the dbkit library.
+// It reads the standard "db.*" YAML configuration and supports the following commands:
+//
+//	dbkit [-config path] ping
+//	dbkit [-config path] migrate up|down [-dir path] [-limit N] [-table name] [-lock-timeout duration]
+//	dbkit [-config path] migrate plan up|down [-dir path] [-limit N] [-table name]
+//	dbkit [-config path] migrate status [-table name]
+//	dbkit [-config path] migrate check [-dir path] [-table name]
+//	dbkit [-config path] lock list [-table name]
+//	dbkit [-config path] lock force-release [-table name] <key>
+//	dbkit help
+//
+// Common flags (-config, -env-prefix, -timeout) may be specified both before and after the command.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/acronis/go-appkit/config"
+	"github.com/acronis/go-appkit/log"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/distrlock"
+	"github.com/acronis/go-dbkit/migrate"
+	_ "github.com/acronis/go-dbkit/mssql"
+	_ "github.com/acronis/go-dbkit/mysql"
+	_ "github.com/acronis/go-dbkit/pgx"
+	_ "github.com/acronis/go-dbkit/postgres"
+	_ "github.com/acronis/go-dbkit/sqlite"
+)
+
+const (
+	defaultConfigPath      = "config.yml"
+	defaultMigrationsDir   = "migrations"
+	defaultCommandTimeout  = 5 * time.Minute
+	defaultLockTimeout     = time.Minute
+	exitCodeFailure        = 1
+	exitCodeUsageViolation = 2
+)
+
+const usage = `Usage: dbkit [flags] <command> [command flags] [args]
+
+Commands:
+  ping                                Check connection to the database.
+  migrate up|down                     Apply or roll back migrations.
+  migrate plan up|down                Show migrations that will be applied or rolled back.
+  migrate status                      Show applied migrations.
+  migrate check                       Fail if there are pending or unknown applied migrations (for CI/CD).
+  lock list                           Show distributed locks.
+  lock force-release <key>            Release distributed lock regardless of its owner.
+  help                                Show this help.
+
+Flags (may be also specified after the command):
+`
+
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Error:", err)
+		if _, ok := err.(*usageError); ok {
+			os.Exit(exitCodeUsageViolation)
+		}
+		os.Exit(exitCodeFailure)
+	}
+}
+
+// globalFlags contains flags that are common for all commands.
+type globalFlags struct {
+	configPath string
+	envPrefix  string
+	timeout    time.Duration
+}
+
+// newFlagSet creates a new flag set with the common flags registered,
+// so they may be specified both before and after the command.
+func (gf *globalFlags) newFlagSet(name string, output io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&gf.configPath, "config", gf.configPath, "path to the YAML configuration file with db.* section")
+	flags.StringVar(&gf.envPrefix, "env-prefix", gf.envPrefix, "prefix of environment variables that override configuration values")
+	flags.DurationVar(&gf.timeout, "timeout", gf.timeout, "timeout for the command")
+	return flags
+}
+
+// commandFunc executes the command which arguments are already parsed and validated.
+type commandFunc func(ctx context.Context, c *command) error
+
+func run(args []string, stdout, stderr io.Writer) error {
+	gf := &globalFlags{configPath: defaultConfigPath, timeout: defaultCommandTimeout}
+	flags := gf.newFlagSet("dbkit", stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return makeFlagsError(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return &usageError{"command is not specified"}
+	}
+
+	// Command and its arguments are validated before opening the database.
+	var cmdFunc commandFunc
+	var err error
+	switch cmdName, cmdArgs := flags.Arg(0), flags.Args()[1:]; cmdName {
+	case "help":
+		flags.Usage()
+		return nil
+	case "ping":
+		cmdFunc, err = parsePingCommand(gf, cmdArgs, stderr)
+	case "migrate":
+		cmdFunc, err = parseMigrateCommand(gf, cmdArgs, stderr)
+	case "lock":
+		cmdFunc, err = parseLockCommand(gf, cmdArgs, stderr)
+	default:
+		flags.Usage()
+		return &usageError{fmt.Sprintf("unknown command %q", cmdName)}
+	}
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	cfg := dbkit.NewConfig(nil)
+	if err = config.NewDefaultLoader(gf.envPrefix).LoadFromFile(gf.configPath, config.DataTypeYAML, cfg); err != nil {
+		return fmt.Errorf("load configuration: %w", err)
+	}
+
+	dbConn, err := dbkit.Open(cfg, false)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer func() { _ = dbConn.Close() }()
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), gf.timeout)
+	defer ctxCancel()
+
+	return cmdFunc(ctx, &command{cfg: cfg, dbConn: dbConn, stdout: stdout, stderr: stderr})
+}
+
+// parseFlags parses flags that may be interspersed with positional arguments and returns the latter.
+// All arguments after "--" are considered positional.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var posArgs []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, makeFlagsError(err)
+		}
+		if parsed := len(args) - flags.NArg(); parsed > 0 && args[parsed-1] == "--" {
+			return append(posArgs, flags.Args()...), nil
+		}
+		if flags.NArg() == 0 {
+			return posArgs, nil
+		}
+		posArgs = append(posArgs, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func makeFlagsError(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return &usageError{err.Error()}
+}
+
+func checkNoArgs(args []string) error {
+	if len(args) != 0 {
+		return &usageError{fmt.Sprintf("unexpected arguments: %s", strings.Join(args, " "))}
+	}
+	return nil
+}
+
+func parsePingCommand(gf *globalFlags, args []string, stderr io.Writer) (commandFunc, error) {
+	posArgs, err := parseFlags(gf.newFlagSet("ping", stderr), args)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkNoArgs(posArgs); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, c *command) error {
+		return c.ping(ctx)
+	}, nil
+}
+
+type migrateArgs struct {
+	subCmd      string
+	planOnly    bool
+	direction   migrate.MigrationsDirection
+	dir         string
+	limit       int
+	tableName   string
+	lockTimeout time.Duration
+}
+
+func parseMigrateCommand(gf *globalFlags, args []string, stderr io.Writer) (commandFunc, error) {
+	var migArgs migrateArgs
+	flags := gf.newFlagSet("migrate", stderr)
+	flags.StringVar(&migArgs.dir, "dir", defaultMigrationsDir, "directory with migration *.sql files in the sql-migrate format")
+	flags.IntVar(&migArgs.limit, "limit", migrate.MigrationsNoLimit,
+		"max number of migrations to apply or roll back (0 means no limit)")
+	flags.StringVar(&migArgs.tableName, "table", migrate.MigrationsTableName, "name of the table that stores applied migrations")
+	flags.DurationVar(&migArgs.lockTimeout, "lock-timeout", defaultLockTimeout,
+		"timeout for acquiring the lock that prevents concurrent running of migrations")
+	posArgs, err := parseFlags(flags, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(posArgs) == 0 {
+		return nil, &usageError{"migrate subcommand is not specified (should be one of up, down, plan, status, check)"}
+	}
+	migArgs.subCmd, posArgs = posArgs[0], posArgs[1:]
+	if migArgs.subCmd == "plan" {
+		if len(posArgs) == 0 {
+			return nil, &usageError{"migrate plan direction is not specified (should be one of up, down)"}
+		}
+		migArgs.planOnly = true
+		migArgs.subCmd, posArgs = posArgs[0], posArgs[1:]
+	}
+	if err = checkNoArgs(posArgs); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case (migArgs.subCmd == "status" || migArgs.subCmd == "check") && !migArgs.planOnly:
+	case migArgs.subCmd == string(migrate.MigrationsDirectionUp):
+		migArgs.direction = migrate.MigrationsDirectionUp
+	case migArgs.subCmd == string(migrate.MigrationsDirectionDown):
+		migArgs.direction = migrate.MigrationsDirectionDown
+	default:
+		return nil, &usageError{fmt.Sprintf("unknown migrate subcommand %q", migArgs.subCmd)}
+	}
+
+	return func(ctx context.Context, c *command) error {
+		return c.migrate(ctx, migArgs)
+	}, nil
+}
+
+type lockArgs struct {
+	subCmd    string
+	tableName string
+	key       string
+}
+
+func parseLockCommand(gf *globalFlags, args []string, stderr io.Writer) (commandFunc, error) {
+	var lkArgs lockArgs
+	flags := gf.newFlagSet("lock", stderr)
+	flags.StringVar(&lkArgs.tableName, "table", "", "name of the table that stores distributed locks (default \"distributed_locks\")")
+	posArgs, err := parseFlags(flags, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(posArgs) == 0 {
+		return nil, &usageError{"lock subcommand is not specified (should be one of list, force-release)"}
+	}
+	lkArgs.subCmd, posArgs = posArgs[0], posArgs[1:]
+	switch lkArgs.subCmd {
+	case "list":
+		if err = checkNoArgs(posArgs); err != nil {
+			return nil, err
+		}
+	case "force-release":
+		if len(posArgs) != 1 {
+			return nil, &usageError{"lock key should be specified"}
+		}
+		lkArgs.key = posArgs[0]
+	default:
+		return nil, &usageError{fmt.Sprintf("unknown lock subcommand %q", lkArgs.subCmd)}
+	}
+
+	return func(ctx context.Context, c *command) error {
+		return c.lock(ctx, lkArgs)
+	}, nil
+}
+
+type command struct {
+	cfg    *dbkit.Config
+	dbConn *sql.DB
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *command) ping(ctx context.Context) error {
+	if err := c.dbConn.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping database: %w", err)
+	}
+	_, _ = fmt.Fprintln(c.stdout, "OK")
+	return nil
+}
+
+func (c *command) migrate(ctx context.Context, args migrateArgs) error {
+	logger, loggerClose := log.NewLogger(&log.Config{Level: log.LevelInfo, Format: log.FormatText, Output: log.OutputStderr})
+	defer loggerClose()
+	migMngr, err := migrate.NewMigrationsManagerWithOpts(
+		c.dbConn, c.cfg.Dialect, logger, migrate.MigrationsManagerOpts{TableName: args.tableName})
+	if err != nil {
+		return err
+	}
+
+	if args.subCmd == "status" && !args.planOnly {
+		return c.printMigrationsStatus(migMngr)
+	}
+
+	migrations, err := migrate.LoadMigrationsFromDir(args.dir)
+	if err != nil {
+		return err
+	}
+
+	if args.subCmd == "check" && !args.planOnly {
+		if err = migMngr.CheckUpToDate(migrations); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(c.stdout, "Migrations are up to date")
+		return nil
+	}
+
+	if args.planOnly {
+		plannedMigs, planErr := migMngr.Plan(migrations, args.direction, args.limit)
+		if planErr != nil {
+			return planErr
+		}
+		if len(plannedMigs) == 0 {
+			_, _ = fmt.Fprintln(c.stdout, "No migrations to apply")
+			return nil
+		}
+		for _, plannedMig := range plannedMigs {
+			_, _ = fmt.Fprintf(c.stdout, "-- %s\n", plannedMig.ID)
+			for _, query := range plannedMig.Queries {
+				_, _ = fmt.Fprintln(c.stdout, query)
+			}
+		}
+		return nil
+	}
+
+	return migMngr.RunLimitLocked(ctx, migrations, args.direction, args.limit, args.lockTimeout)
+}
+
+func (c *command) printMigrationsStatus(migMngr *migrate.MigrationsManager) error {
+	migStatus, err := migMngr.Status()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "MIGRATION\tAPPLIED AT")
+	for _, appliedMig := range migStatus.AppliedMigrations {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\n", appliedMig.ID, appliedMig.AppliedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func (c *command) lock(ctx context.Context, args lockArgs) error {
+	lockMngr, err := distrlock.NewDBManagerWithOpts(c.cfg.Dialect, distrlock.DBManagerOpts{TableName: args.tableName})
+	if err != nil {
+		return err
+	}
+
+	if args.subCmd == "force-release" {
+		if err = dbkit.DoInTx(ctx, c.dbConn, func(tx *sql.Tx) error {
+			return lockMngr.ForceRelease(ctx, tx, args.key)
+		}); err != nil {
+			return fmt.Errorf("force release lock: %w", err)
+		}
+		_, _ = fmt.Fprintln(c.stdout, "Released")
+		return nil
+	}
+
+	locks, err := lockMngr.ListLocks(ctx, c.dbConn)
+	if err != nil {
+		return fmt.Errorf("list locks: %w", err)
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "KEY\tACQUIRED\tTOKEN\tOWNER\tFENCE\tEXPIRE AT")
+	for _, lock := range locks {
+		expireAt := "-"
+		if !lock.ExpireAt.IsZero() {
+			expireAt = lock.ExpireAt.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%t\t%s\t%s\t%d\t%s\n", lock.Key, lock.Acquired, lock.Token, lock.Owner, lock.Fence, expireAt)
+	}
+	return tw.Flush()
+}
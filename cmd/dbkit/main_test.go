@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yml")
+	migrationsDir := filepath.Join(dir, "migrations")
+	require.NoError(t, os.WriteFile(cfgPath, []byte(fmt.Sprintf(`
+db:
+  dialect: sqlite3
+  sqlite3:
+    path: %s
+`, filepath.Join(dir, "test.db"))), 0o600))
+	require.NoError(t, os.Mkdir(migrationsDir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(migrationsDir, "00001_create_users.sql"), []byte(`
+-- +migrate Up
+CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL);
+
+-- +migrate Down
+DROP TABLE users;
+`), 0o600))
+
+	runCmd := func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		err := run(append([]string{"-config", cfgPath}, args...), &stdout, &stderr)
+		return stdout.String(), err
+	}
+
+	out, err := runCmd("ping")
+	require.NoError(t, err)
+	require.Equal(t, "OK\n", out)
+
+	out, err = runCmd("migrate", "plan", "up", "-dir", migrationsDir)
+	require.NoError(t, err)
+	require.Contains(t, out, "-- 00001_create_users.sql\n")
+	require.Contains(t, out, "CREATE TABLE users")
+
+	_, err = runCmd("migrate", "check", "-dir", migrationsDir)
+	require.EqualError(t, err, "database migrations are not up to date (pending migrations: 00001_create_users.sql)")
+
+	// Migrations are not run if the command timeout is exceeded.
+	_, err = runCmd("-timeout", "1ns", "migrate", "up", "-dir", migrationsDir)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = runCmd("migrate", "up", "-dir", migrationsDir)
+	require.NoError(t, err)
+
+	out, err = runCmd("migrate", "check", "-dir", migrationsDir)
+	require.NoError(t, err)
+	require.Equal(t, "Migrations are up to date\n", out)
+
+	out, err = runCmd("migrate", "status")
+	require.NoError(t, err)
+	require.Contains(t, out, "00001_create_users.sql")
+
+	out, err = runCmd("migrate", "plan", "up", "-dir", migrationsDir)
+	require.NoError(t, err)
+	require.Equal(t, "No migrations to apply\n", out)
+
+	_, err = runCmd("migrate", "down", "-dir", migrationsDir)
+	require.NoError(t, err)
+
+	_, err = runCmd("unknown")
+	require.IsType(t, &usageError{}, err)
+
+	_, err = runCmd("migrate", "sideways")
+	require.IsType(t, &usageError{}, err)
+
+	_, err = runCmd("ping", "extra")
+	require.IsType(t, &usageError{}, err)
+
+	// Flags may be specified after the command.
+	_, err = runCmd("migrate", "up", "-dir", migrationsDir, "-timeout", "1ns")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	out, err = runCmd("migrate", "-dir", migrationsDir, "plan", "up", "-limit", "1")
+	require.NoError(t, err)
+	require.Contains(t, out, "-- 00001_create_users.sql\n")
+
+	// Distributed locks are not supported for SQLite.
+	_, err = runCmd("lock", "list")
+	require.Error(t, err)
+}
+
+func TestRun_ValidatesCommandBeforeOpeningDB(t *testing.T) {
+	// Configuration file doesn't exist, so any attempt to open the database fails.
+	cfgPath := filepath.Join(t.TempDir(), "missing.yml")
+	runCmd := func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		err := run(args, &stdout, &stderr)
+		return stderr.String(), err
+	}
+
+	out, err := runCmd("-config", cfgPath, "help")
+	require.NoError(t, err)
+	require.Contains(t, out, "Usage: dbkit")
+
+	_, err = runCmd("-config", cfgPath, "unknown")
+	require.EqualError(t, err, `unknown command "unknown"`)
+
+	_, err = runCmd("migrate", "sideways", "-config", cfgPath)
+	require.EqualError(t, err, `unknown migrate subcommand "sideways"`)
+
+	_, err = runCmd("lock", "force-release", "-config", cfgPath)
+	require.EqualError(t, err, "lock key should be specified")
+
+	_, err = runCmd("ping", "-config", cfgPath)
+	require.ErrorContains(t, err, "load configuration")
+}
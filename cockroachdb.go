@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// CockroachDBRestartSavepoint is a name of the savepoint that is used by CockroachDB client-side transaction retry protocol.
+const CockroachDBRestartSavepoint = "cockroach_restart"
+
+// CockroachDBRetryErrCode is a SQLSTATE code of the CockroachDB transaction retry error.
+const CockroachDBRetryErrCode = "40001"
+
+// DefaultCockroachDBTxMaxRetries is a default maximum number of the transaction retries in DoInCockroachDBTx.
+const DefaultCockroachDBTxMaxRetries = 10
+
+// CockroachDBTxOpts represents options for DoInCockroachDBTxWithOpts.
+type CockroachDBTxOpts struct {
+	// TxOptions are passed to the BeginTx.
+	TxOptions *sql.TxOptions
+
+	// MaxRetries is a maximum number of the transaction retries. DefaultCockroachDBTxMaxRetries is used by default.
+	MaxRetries int
+}
+
+// DoInCockroachDBTx begins a new transaction and calls passed function using CockroachDB client-side retry protocol
+// (https://www.cockroachlabs.com/docs/stable/advanced-client-side-transaction-retries):
+// SAVEPOINT cockroach_restart is created after BEGIN, and on the retry error (SQLSTATE 40001)
+// the transaction is rolled back to this savepoint and the function is called again within the same transaction,
+// so the transaction keeps its priority and eventually succeeds under contention.
+// It may be used with Postgres dialects (lib/pq and pgx drivers) when the database is CockroachDB.
+// Function may be called several times, so it should not have side effects outside the transaction.
+func DoInCockroachDBTx(ctx context.Context, dbConn *sql.DB, fn func(tx *sql.Tx) error) error {
+	return DoInCockroachDBTxWithOpts(ctx, dbConn, CockroachDBTxOpts{}, fn)
+}
+
+// DoInCockroachDBTxWithOpts is a more configurable version of DoInCockroachDBTx.
+func DoInCockroachDBTxWithOpts(ctx context.Context, dbConn *sql.DB, opts CockroachDBTxOpts, fn func(tx *sql.Tx) error) (err error) {
+	maxRetries := opts.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultCockroachDBTxMaxRetries
+	}
+
+	var tx *sql.Tx
+	if tx, err = dbConn.BeginTx(ctx, opts.TxOptions); err != nil {
+		return &TxBeginError{Inner: err}
+	}
+	defer WatchTx(ctx)()
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			err = &TxCommitError{Inner: err}
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT "+CockroachDBRestartSavepoint); err != nil {
+		return fmt.Errorf("create savepoint: %w", err)
+	}
+	for retry := 0; ; retry++ {
+		err = fn(tx)
+		if err == nil {
+			// RELEASE SAVEPOINT cockroach_restart commits the transaction in CockroachDB, so retry error may be returned here.
+			if _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+CockroachDBRestartSavepoint); err == nil {
+				return nil
+			}
+		}
+		if !IsCockroachDBRetryError(err) {
+			return err
+		}
+		if retry == maxRetries {
+			return fmt.Errorf("max retries (%d) exceeded: %w", maxRetries, err)
+		}
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+CockroachDBRestartSavepoint); rollbackErr != nil {
+			return fmt.Errorf("rollback to savepoint: %w", rollbackErr)
+		}
+	}
+}
+
+// IsCockroachDBRetryError checks if the passed error (or any error in its chain) is a transaction retry error (SQLSTATE 40001).
+// Errors of both lib/pq and pgx drivers are supported.
+func IsCockroachDBRetryError(err error) bool {
+	var sqlStateErr interface{ SQLState() string }
+	if errors.As(err, &sqlStateErr) {
+		return sqlStateErr.SQLState() == CockroachDBRetryErrCode
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string {
+	return "sql error " + string(e)
+}
+
+func (e sqlStateError) SQLState() string {
+	return string(e)
+}
+
+func TestIsCockroachDBRetryError(t *testing.T) {
+	require.True(t, IsCockroachDBRetryError(sqlStateError(CockroachDBRetryErrCode)))
+	require.True(t, IsCockroachDBRetryError(fmt.Errorf("wrapped: %w", sqlStateError(CockroachDBRetryErrCode))))
+	require.False(t, IsCockroachDBRetryError(sqlStateError("23505")))
+	require.False(t, IsCockroachDBRetryError(errors.New("some error")))
+	require.False(t, IsCockroachDBRetryError(nil))
+}
+
+func TestDoInCockroachDBTx(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retry error is returned from function", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectClose()
+
+		calls := 0
+		require.NoError(t, DoInCockroachDBTx(ctx, db, func(tx *sql.Tx) error {
+			calls++
+			if calls == 1 {
+				return sqlStateError(CockroachDBRetryErrCode)
+			}
+			return nil
+		}))
+		require.Equal(t, 2, calls)
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("retry error is returned on release savepoint", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT cockroach_restart").WillReturnError(sqlStateError(CockroachDBRetryErrCode))
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectClose()
+
+		calls := 0
+		require.NoError(t, DoInCockroachDBTx(ctx, db, func(tx *sql.Tx) error {
+			calls++
+			return nil
+		}))
+		require.Equal(t, 2, calls)
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("max retries exceeded", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		mock.ExpectClose()
+
+		calls := 0
+		err = DoInCockroachDBTxWithOpts(ctx, db, CockroachDBTxOpts{MaxRetries: 1}, func(tx *sql.Tx) error {
+			calls++
+			return sqlStateError(CockroachDBRetryErrCode)
+		})
+		require.EqualError(t, err, "max retries (1) exceeded: sql error 40001")
+		require.Equal(t, 2, calls)
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("non-retryable error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT cockroach_restart").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		mock.ExpectClose()
+
+		fnErr := errors.New("some error")
+		require.ErrorIs(t, DoInCockroachDBTx(ctx, db, func(tx *sql.Tx) error {
+			return fnErr
+		}), fnErr)
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -8,7 +8,11 @@ package dbkit
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/acronis/go-appkit/config"
@@ -19,6 +23,9 @@ const (
 	cfgKeyMaxIdleConns    = "db.maxIdleConns"
 	cfgKeyMaxOpenConns    = "db.maxOpenConns"
 	cfgKeyConnMaxLifetime = "db.connMaxLifeTime"
+	cfgKeyReadOnly        = "db.readOnly"
+	cfgKeyShards          = "db.shards"
+	cfgKeyApplicationName = "db.applicationName"
 
 	cfgKeyMySQLHost     = "db.mysql.host"
 	cfgKeyMySQLPort     = "db.mysql.port"
@@ -27,7 +34,16 @@ const (
 	cfgKeyMySQLPassword = "db.mysql.password" //nolint: gosec
 	cfgKeyMySQLTxLevel  = "db.mysql.txLevel"
 
-	cfgKeySQLitePath = "db.sqlite3.path"
+	cfgKeyMySQLInterpolateParams = "db.mysql.interpolateParams"
+	cfgKeyMySQLClientFoundRows   = "db.mysql.clientFoundRows"
+	cfgKeyMySQLRejectReadOnly    = "db.mysql.rejectReadOnly"
+	cfgKeyMySQLMaxAllowedPacket  = "db.mysql.maxAllowedPacket"
+	cfgKeyMySQLAutocommit        = "db.mysql.autocommit"
+	cfgKeyMySQLSocket            = "db.mysql.socket"
+	cfgKeyMySQLDialer            = "db.mysql.dialer"
+
+	cfgKeySQLitePath    = "db.sqlite3.path"
+	cfgKeySQLiteTxLevel = "db.sqlite3.txLevel"
 
 	cfgKeyPostgresHost             = "db.postgres.host"
 	cfgKeyPostgresPort             = "db.postgres.port"
@@ -36,6 +52,12 @@ const (
 	cfgKeyPostgresPassword         = "db.postgres.password" //nolint: gosec
 	cfgKeyPostgresTxLevel          = "db.postgres.txLevel"
 	cfgKeyPostgresSSLMode          = "db.postgres.sslMode"
+	cfgKeyPostgresSSLRootCert      = "db.postgres.sslRootCert"
+	cfgKeyPostgresSSLCert          = "db.postgres.sslCert"
+	cfgKeyPostgresSSLKey           = "db.postgres.sslKey"
+	cfgKeyPostgresSSLPassword      = "db.postgres.sslPassword" //nolint: gosec
+	cfgKeyPostgresSocket           = "db.postgres.socket"
+	cfgKeyPostgresDialer           = "db.postgres.dialer"
 	cfgKeyPostgresSearchPath       = "db.postgres.searchPath"
 	cfgKeyPostgresAdditionalParams = "db.postgres.additionalParameters"
 	cfgKeyMSSQLHost                = "db.mssql.host"
@@ -44,6 +66,19 @@ const (
 	cfgKeyMSSQLUser                = "db.mssql.user"
 	cfgKeyMSSQLPassword            = "db.mssql.password" //nolint: gosec
 	cfgKeyMSSQLTxLevel             = "db.mssql.txLevel"
+	cfgKeyMSSQLFedAuth             = "db.mssql.fedAuth"
+	cfgKeyMSSQLTenantID            = "db.mssql.tenantID"
+	cfgKeyMSSQLClientID            = "db.mssql.clientID"
+
+	cfgKeyRetryErrorClasses = "db.retry.errorClasses"
+	cfgKeyRetryMaxAttempts  = "db.retry.maxAttempts"
+	cfgKeyRetryBaseBackoff  = "db.retry.baseBackoff"
+	cfgKeyRetryMaxBackoff   = "db.retry.maxBackoff"
+	cfgKeyRetryJitter       = "db.retry.jitter"
+
+	cfgKeyMigrationsAutoRun     = "db.migrations.autoRun"
+	cfgKeyMigrationsTableName   = "db.migrations.tableName"
+	cfgKeyMigrationsLockTimeout = "db.migrations.lockTimeout"
 )
 
 // MySQLConfig represents a set of configuration parameters for working with MySQL.
@@ -54,6 +89,32 @@ type MySQLConfig struct {
 	Password         string
 	Database         string
 	TxIsolationLevel sql.IsolationLevel
+	ApplicationName  string
+
+	// InterpolateParams enables interpolation of placeholders into the query string on the client side,
+	// so round trips for preparing statements are avoided.
+	InterpolateParams bool
+	// ClientFoundRows makes UPDATE return a number of matched rows instead of a number of changed ones.
+	ClientFoundRows bool
+	// RejectReadOnly makes driver drop the connection if the server is read-only (e.g. demoted primary after failover),
+	// so it's reconnected to the new primary. It's needed for clean failover with ProxySQL or Aurora.
+	RejectReadOnly bool
+	// MaxAllowedPacket is a maximum packet size in bytes. Driver's default (64 MiB) is used if it's not set.
+	MaxAllowedPacket int
+	// Socket is a path to the Unix domain socket (e.g. of the cloud-sql-proxy sidecar).
+	// If it's set, Host and Port are ignored.
+	Socket string
+	// Autocommit enables autocommit mode of the session (autocommit=true is added to DSN).
+	// For compatibility, it's disabled by default (autocommit=false is added to DSN), so non-transactional statements
+	// executed outside of DoInTx are not committed (and are rolled back when the connection is closed).
+	Autocommit bool
+	// Dialer is a name of the custom dialer registered by RegisterDialer (e.g. GCP Cloud SQL connector or SSH tunnel).
+	// If it's set, connections to Host and Port are established via this dialer.
+	Dialer string
+
+	// AuthTokenProvider (if set) provides a token that is used instead of Password for new connections.
+	// It cannot be read from the configuration file and should be set programmatically.
+	AuthTokenProvider AuthTokenProvider
 }
 
 // MSSQLConfig represents a set of configuration parameters for working with MSSQL.
@@ -64,11 +125,23 @@ type MSSQLConfig struct {
 	Password         string
 	Database         string
 	TxIsolationLevel sql.IsolationLevel
+	ApplicationName  string
+
+	// FedAuth is an Azure Active Directory authentication method.
+	// If it's set, "azuresql" driver is used, so github.com/denisenkom/go-mssqldb/azuread package should be imported.
+	FedAuth MSSQLFedAuth
+	// TenantID is an Azure Active Directory tenant ID (used by ActiveDirectoryServicePrincipal method).
+	TenantID string
+	// ClientID is an Azure Active Directory application (client) ID
+	// or client ID of the user-assigned managed identity (used by ActiveDirectoryMSI method).
+	ClientID string
 }
 
 // SQLiteConfig represents a set of configuration parameters for working with SQLite.
 type SQLiteConfig struct {
 	Path string
+	// TxIsolationLevel is optional. SQLite supports only sql.LevelSerializable.
+	TxIsolationLevel sql.IsolationLevel
 }
 
 // Parameter represent DB connection parameter. Value will be url-encoded before adding into the connection string.
@@ -88,6 +161,53 @@ type PostgresConfig struct {
 	SSLMode              PostgresSSLMode
 	SearchPath           string
 	AdditionalParameters []Parameter
+	ApplicationName      string
+
+	// SSLRootCert is a path to the file with root certificate(s) that is used for verifying
+	// the server certificate (in PostgresSSLModeVerifyCA and PostgresSSLModeVerifyFull modes).
+	SSLRootCert string
+	// SSLCert and SSLKey are paths to the client certificate and its private key files.
+	SSLCert string
+	SSLKey  string
+	// SSLPassword is a password for the client private key. It's supported only by pgx driver (DialectPgx).
+	SSLPassword string
+
+	// Socket is a path to the directory with the Unix domain socket (e.g. "/var/run/postgresql" or cloud-sql-proxy one).
+	// If it's set, Host is ignored, and Port (if set) is used for the socket file name (.s.PGSQL.<port>).
+	Socket string
+
+	// Dialer is a name of the custom dialer registered by RegisterDialer (e.g. GCP Cloud SQL connector or SSH tunnel).
+	// If it's set, connections are established via this dialer, so github.com/acronis/go-dbkit/pgx
+	// or github.com/acronis/go-dbkit/postgres package (depending on the dialect) should be imported.
+	Dialer string
+
+	// AuthTokenProvider (if set) provides a token that is used instead of Password for new connections.
+	// It cannot be read from the configuration file and should be set programmatically.
+	AuthTokenProvider AuthTokenProvider
+}
+
+// RetryPolicyConfig represents a set of configuration parameters for retrying failed SQL queries and transactions.
+// Use NewRetryPolicyFromConfig and NewIsRetryableFromConfig to build retry.Policy and retry.IsRetryable from it.
+type RetryPolicyConfig struct {
+	ErrorClasses []RetryErrorClass
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	Jitter       float64
+}
+
+// MigrationsConfig represents a set of configuration parameters for running migrations on start
+// (see migrate.OpenAndMigrate).
+type MigrationsConfig struct {
+	// AutoRun enables applying migrations on start.
+	AutoRun bool
+
+	// TableName is a name of the table that stores applied migrations (migrate.MigrationsTableName by default).
+	TableName string
+
+	// LockTimeout is a timeout for acquiring the lock (advisory lock in Postgres, named lock in MySQL,
+	// application lock in MSSQL) that prevents concurrent running of migrations by several service instances.
+	LockTimeout time.Duration
 }
 
 // Config represents a set of configuration parameters working with SQL databases.
@@ -100,6 +220,25 @@ type Config struct {
 	MSSQL           MSSQLConfig
 	SQLite          SQLiteConfig
 	Postgres        PostgresConfig
+	Retry           RetryPolicyConfig
+	Migrations      MigrationsConfig
+
+	// ApplicationName is propagated to the database as application_name (Postgres),
+	// app name (MSSQL) and program_name connection attribute (MySQL 8+), so connections can be attributed to the service.
+	// Application name specified in the dialect-specific configuration takes precedence.
+	ApplicationName string
+
+	// ReadOnly contains configuration of the read-only endpoint (e.g. replica).
+	// It's nil if the read-only endpoint is not configured.
+	// Parameters of the read-only endpoint are specified under "db.readOnly.*" keys that mirror the primary ones
+	// (e.g. "db.readOnly.postgres.host"), not specified parameters are inherited from the primary endpoint.
+	ReadOnly *Config
+
+	// Shards contains configurations of the shards (see Sharder) in the order they are specified.
+	// Parameters of the shards are specified as a list under the "db.shards" key,
+	// each element mirrors the primary parameters (e.g. "postgres.host"),
+	// not specified parameters are inherited from the primary endpoint.
+	Shards []*Config
 
 	keyPrefix         string
 	supportedDialects []Dialect
@@ -148,6 +287,13 @@ func (c *Config) SetProviderDefaults(dp config.DataProvider) {
 	dp.SetDefault(cfgKeyPostgresTxLevel, PostgresDefaultTxLevel.String())
 	dp.SetDefault(cfgKeyPostgresSSLMode, string(PostgresDefaultSSLMode))
 	dp.SetDefault(cfgKeyMSSQLTxLevel, MSSQLDefaultTxLevel.String())
+	dp.SetDefault(cfgKeyRetryErrorClasses, []string{string(RetryErrorClassDialect)})
+	dp.SetDefault(cfgKeyRetryMaxAttempts, DefaultRetryMaxAttempts)
+	dp.SetDefault(cfgKeyRetryBaseBackoff, DefaultRetryBaseBackoff)
+	dp.SetDefault(cfgKeyRetryMaxBackoff, DefaultRetryMaxBackoff)
+	dp.SetDefault(cfgKeyRetryJitter, DefaultRetryJitter)
+	dp.SetDefault(cfgKeyMigrationsAutoRun, false)
+	dp.SetDefault(cfgKeyMigrationsLockTimeout, DefaultMigrationsLockTimeout)
 }
 
 // Set sets configuration values from config.DataProvider.
@@ -183,7 +329,42 @@ func (c *Config) Set(dp config.DataProvider) error {
 		return err
 	}
 
-	return nil
+	if c.ApplicationName, err = dp.GetString(cfgKeyApplicationName); err != nil {
+		return err
+	}
+
+	if err = c.setRetryConfig(dp); err != nil {
+		return err
+	}
+
+	if err = c.setMigrationsConfig(dp); err != nil {
+		return err
+	}
+
+	if err = c.setReadOnlyConfig(dp); err != nil {
+		return err
+	}
+
+	return c.setShardsConfig(dp)
+}
+
+// DatabaseName returns name of the database from parsed config for specified dialect.
+// For SQLite, it's a base name of the database file.
+func (c *Config) DatabaseName() string {
+	switch c.Dialect {
+	case DialectMySQL:
+		return c.MySQL.Database
+	case DialectPostgres, DialectPgx:
+		return c.Postgres.Database
+	case DialectMSSQL:
+		return c.MSSQL.Database
+	case DialectSQLite:
+		if c.SQLite.Path == "" {
+			return ""
+		}
+		return filepath.Base(c.SQLite.Path)
+	}
+	return ""
 }
 
 // TxIsolationLevel returns transaction isolation level from parsed config for specified dialect.
@@ -193,6 +374,10 @@ func (c *Config) TxIsolationLevel() sql.IsolationLevel {
 		return c.MySQL.TxIsolationLevel
 	case DialectPostgres, DialectPgx:
 		return c.Postgres.TxIsolationLevel
+	case DialectMSSQL:
+		return c.MSSQL.TxIsolationLevel
+	case DialectSQLite:
+		return c.SQLite.TxIsolationLevel
 	}
 	return sql.LevelDefault
 }
@@ -201,19 +386,49 @@ func (c *Config) TxIsolationLevel() sql.IsolationLevel {
 func (c *Config) DriverNameAndDSN() (driverName, dsn string) {
 	switch c.Dialect {
 	case DialectMySQL:
-		return "mysql", MakeMySQLDSN(&c.MySQL)
+		return "mysql", MakeMySQLDSN(c.mySQLConfig())
 	case DialectSQLite:
 		return "sqlite3", MakeSQLiteDSN(&c.SQLite)
 	case DialectPostgres:
-		return "postgres", MakePostgresDSN(&c.Postgres)
+		return "postgres", MakePostgresDSN(c.postgresConfig())
 	case DialectPgx:
-		return "pgx", MakePostgresDSN(&c.Postgres)
+		return "pgx", MakePgxDSN(c.postgresConfig())
 	case DialectMSSQL:
-		return "mssql", MakeMSSQLDSN(&c.MSSQL)
+		if c.MSSQL.FedAuth != "" {
+			return MSSQLAzureDriverName, MakeMSSQLDSN(c.msSQLConfig())
+		}
+		return "mssql", MakeMSSQLDSN(c.msSQLConfig())
 	}
 	return "", ""
 }
 
+// mySQLConfig returns a copy of MySQL configuration with inherited application name.
+func (c *Config) mySQLConfig() *MySQLConfig {
+	cfg := c.MySQL
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = c.ApplicationName
+	}
+	return &cfg
+}
+
+// postgresConfig returns a copy of Postgres configuration with inherited application name.
+func (c *Config) postgresConfig() *PostgresConfig {
+	cfg := c.Postgres
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = c.ApplicationName
+	}
+	return &cfg
+}
+
+// msSQLConfig returns a copy of MSSQL configuration with inherited application name.
+func (c *Config) msSQLConfig() *MSSQLConfig {
+	cfg := c.MSSQL
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = c.ApplicationName
+	}
+	return &cfg
+}
+
 func (c *Config) setDialectSpecificConfig(dp config.DataProvider) error {
 	var err error
 
@@ -227,19 +442,73 @@ func (c *Config) setDialectSpecificConfig(dp config.DataProvider) error {
 	}
 	c.Dialect = Dialect(dialectStr)
 
+	return c.setEndpointConfig(dp, PgReadWriteParam)
+}
+
+func (c *Config) setEndpointConfig(dp config.DataProvider, pgTargetSessionAttrs string) error {
+	var err error
 	switch c.Dialect {
 	case DialectMySQL:
 		err = c.setMySQLConfig(dp)
 	case DialectSQLite:
 		err = c.setSQLiteConfig(dp)
 	case DialectPostgres, DialectPgx:
-		err = c.setPostgresConfig(dp, c.Dialect)
+		err = c.setPostgresConfig(dp, c.Dialect, pgTargetSessionAttrs)
 	case DialectMSSQL:
 		err = c.setMSSQLConfig(dp)
 	}
 	return err
 }
 
+func (c *Config) setReadOnlyConfig(dp config.DataProvider) error {
+	if !dp.IsSet(cfgKeyReadOnly) {
+		c.ReadOnly = nil
+		return nil
+	}
+	readOnlyCfg := c.newInheritedEndpointConfig()
+	if err := readOnlyCfg.setEndpointConfig(&endpointDataProvider{dp, cfgKeyReadOnly}, PgPreferStandbyParam); err != nil {
+		return err
+	}
+	c.ReadOnly = readOnlyCfg
+	return nil
+}
+
+func (c *Config) setShardsConfig(dp config.DataProvider) error {
+	c.Shards = nil
+	if !dp.IsSet(cfgKeyShards) {
+		return nil
+	}
+	shards, ok := dp.Get(cfgKeyShards).([]interface{})
+	if !ok {
+		return dp.WrapKeyErr(cfgKeyShards, fmt.Errorf("must be a list"))
+	}
+	c.Shards = make([]*Config, 0, len(shards))
+	for i := range shards {
+		shardCfg := c.newInheritedEndpointConfig()
+		shardKey := fmt.Sprintf("%s.%d", cfgKeyShards, i)
+		if err := shardCfg.setEndpointConfig(&endpointDataProvider{dp, shardKey}, PgReadWriteParam); err != nil {
+			return err
+		}
+		c.Shards = append(c.Shards, shardCfg)
+	}
+	return nil
+}
+
+// newInheritedEndpointConfig returns a new Config for the additional endpoint (read-only or shard)
+// that inherits common (not endpoint-specific) parameters.
+func (c *Config) newInheritedEndpointConfig() *Config {
+	return &Config{
+		Dialect:           c.Dialect,
+		MaxOpenConns:      c.MaxOpenConns,
+		MaxIdleConns:      c.MaxIdleConns,
+		ConnMaxLifetime:   c.ConnMaxLifetime,
+		Retry:             c.Retry,
+		ApplicationName:   c.ApplicationName,
+		keyPrefix:         c.keyPrefix,
+		supportedDialects: c.supportedDialects,
+	}
+}
+
 // nolint: dupl
 func (c *Config) setMySQLConfig(dp config.DataProvider) error {
 	var err error
@@ -259,7 +528,33 @@ func (c *Config) setMySQLConfig(dp config.DataProvider) error {
 	if c.MySQL.Database, err = dp.GetString(cfgKeyMySQLDatabase); err != nil {
 		return err
 	}
-	if c.MySQL.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeyMySQLTxLevel); err != nil {
+	if c.MySQL.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeyMySQLTxLevel, DialectMySQL); err != nil {
+		return err
+	}
+	if c.MySQL.InterpolateParams, err = dp.GetBool(cfgKeyMySQLInterpolateParams); err != nil {
+		return err
+	}
+	if c.MySQL.ClientFoundRows, err = dp.GetBool(cfgKeyMySQLClientFoundRows); err != nil {
+		return err
+	}
+	if c.MySQL.RejectReadOnly, err = dp.GetBool(cfgKeyMySQLRejectReadOnly); err != nil {
+		return err
+	}
+	var maxAllowedPacket uint64
+	if maxAllowedPacket, err = dp.GetSizeInBytes(cfgKeyMySQLMaxAllowedPacket); err != nil {
+		return err
+	}
+	if maxAllowedPacket > math.MaxInt32 {
+		return dp.WrapKeyErr(cfgKeyMySQLMaxAllowedPacket, fmt.Errorf("value %d is too large", maxAllowedPacket))
+	}
+	c.MySQL.MaxAllowedPacket = int(maxAllowedPacket)
+	if c.MySQL.Autocommit, err = dp.GetBool(cfgKeyMySQLAutocommit); err != nil {
+		return err
+	}
+	if c.MySQL.Socket, err = dp.GetString(cfgKeyMySQLSocket); err != nil {
+		return err
+	}
+	if c.MySQL.Dialer, err = dp.GetString(cfgKeyMySQLDialer); err != nil {
 		return err
 	}
 
@@ -285,7 +580,24 @@ func (c *Config) setMSSQLConfig(dp config.DataProvider) error {
 	if c.MSSQL.Database, err = dp.GetString(cfgKeyMSSQLDatabase); err != nil {
 		return err
 	}
-	if c.MSSQL.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeyMSSQLTxLevel); err != nil {
+	if c.MSSQL.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeyMSSQLTxLevel, DialectMSSQL); err != nil {
+		return err
+	}
+
+	var fedAuthStr string
+	if fedAuthStr, err = dp.GetString(cfgKeyMSSQLFedAuth); err != nil {
+		return err
+	}
+	switch fedAuth := MSSQLFedAuth(fedAuthStr); fedAuth {
+	case "", MSSQLFedAuthDefault, MSSQLFedAuthMSI, MSSQLFedAuthServicePrincipal, MSSQLFedAuthPassword:
+		c.MSSQL.FedAuth = fedAuth
+	default:
+		return dp.WrapKeyErr(cfgKeyMSSQLFedAuth, fmt.Errorf("unknown value %q", fedAuthStr))
+	}
+	if c.MSSQL.TenantID, err = dp.GetString(cfgKeyMSSQLTenantID); err != nil {
+		return err
+	}
+	if c.MSSQL.ClientID, err = dp.GetString(cfgKeyMSSQLClientID); err != nil {
 		return err
 	}
 
@@ -293,7 +605,7 @@ func (c *Config) setMSSQLConfig(dp config.DataProvider) error {
 }
 
 // nolint: dupl
-func (c *Config) setPostgresConfig(dp config.DataProvider, dialect Dialect) error {
+func (c *Config) setPostgresConfig(dp config.DataProvider, dialect Dialect, targetSessionAttrs string) error {
 	var err error
 
 	if c.Postgres.Host, err = dp.GetString(cfgKeyPostgresHost); err != nil {
@@ -314,7 +626,7 @@ func (c *Config) setPostgresConfig(dp config.DataProvider, dialect Dialect) erro
 	if c.Postgres.SearchPath, err = dp.GetString(cfgKeyPostgresSearchPath); err != nil {
 		return err
 	}
-	if c.Postgres.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeyPostgresTxLevel); err != nil {
+	if c.Postgres.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeyPostgresTxLevel, dialect); err != nil {
 		return err
 	}
 
@@ -334,7 +646,7 @@ func (c *Config) setPostgresConfig(dp config.DataProvider, dialect Dialect) erro
 	if dialect == DialectPgx {
 		if _, ok := dbParams[PgTargetSessionAttrs]; !ok {
 			c.Postgres.AdditionalParameters = append(c.Postgres.AdditionalParameters, Parameter{
-				PgTargetSessionAttrs, PgReadWriteParam})
+				PgTargetSessionAttrs, targetSessionAttrs})
 		}
 	}
 
@@ -350,6 +662,29 @@ func (c *Config) setPostgresConfig(dp config.DataProvider, dialect Dialect) erro
 	}
 	c.Postgres.SSLMode = PostgresSSLMode(gotSSLModeStr)
 
+	if c.Postgres.SSLRootCert, err = dp.GetString(cfgKeyPostgresSSLRootCert); err != nil {
+		return err
+	}
+	if c.Postgres.SSLCert, err = dp.GetString(cfgKeyPostgresSSLCert); err != nil {
+		return err
+	}
+	if c.Postgres.SSLKey, err = dp.GetString(cfgKeyPostgresSSLKey); err != nil {
+		return err
+	}
+	if c.Postgres.SSLPassword, err = dp.GetString(cfgKeyPostgresSSLPassword); err != nil {
+		return err
+	}
+	if c.Postgres.SSLPassword != "" && dialect == DialectPostgres {
+		// lib/pq doesn't support password-protected keys and would send the password to the server as is.
+		return dp.WrapKeyErr(cfgKeyPostgresSSLPassword, fmt.Errorf("is supported only for %q dialect", DialectPgx))
+	}
+	if c.Postgres.Socket, err = dp.GetString(cfgKeyPostgresSocket); err != nil {
+		return err
+	}
+	if c.Postgres.Dialer, err = dp.GetString(cfgKeyPostgresDialer); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -359,10 +694,184 @@ func (c *Config) setSQLiteConfig(dp config.DataProvider) error {
 	if c.SQLite.Path, err = dp.GetString(cfgKeySQLitePath); err != nil {
 		return err
 	}
+	if dp.IsSet(cfgKeySQLiteTxLevel) {
+		if c.SQLite.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeySQLiteTxLevel, DialectSQLite); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) setRetryConfig(dp config.DataProvider) error {
+	var err error
+
+	// Retry parameters are optional, so defaults are used for unset keys
+	// even if SetProviderDefaults wasn't called for the data provider.
+	errClassesStr := []string{string(RetryErrorClassDialect)}
+	if dp.IsSet(cfgKeyRetryErrorClasses) {
+		if errClassesStr, err = dp.GetStringSlice(cfgKeyRetryErrorClasses); err != nil {
+			return err
+		}
+	}
+	errClasses := make([]RetryErrorClass, 0, len(errClassesStr))
+	for _, errClassStr := range errClassesStr {
+		switch errClass := RetryErrorClass(errClassStr); errClass {
+		case RetryErrorClassDialect, RetryErrorClassTransient:
+			errClasses = append(errClasses, errClass)
+		default:
+			return dp.WrapKeyErr(cfgKeyRetryErrorClasses, fmt.Errorf("unknown error class %q", errClassStr))
+		}
+	}
+
+	maxAttempts := DefaultRetryMaxAttempts
+	if dp.IsSet(cfgKeyRetryMaxAttempts) {
+		if maxAttempts, err = dp.GetInt(cfgKeyRetryMaxAttempts); err != nil {
+			return err
+		}
+		if maxAttempts < 0 {
+			return dp.WrapKeyErr(cfgKeyRetryMaxAttempts, fmt.Errorf("cannot be negative"))
+		}
+		if maxAttempts == 0 {
+			maxAttempts = DefaultRetryMaxAttempts
+		}
+	}
+
+	baseBackoff, maxBackoff := DefaultRetryBaseBackoff, DefaultRetryMaxBackoff
+	if dp.IsSet(cfgKeyRetryBaseBackoff) {
+		if baseBackoff, err = dp.GetDuration(cfgKeyRetryBaseBackoff); err != nil {
+			return err
+		}
+		if baseBackoff < 0 {
+			return dp.WrapKeyErr(cfgKeyRetryBaseBackoff, fmt.Errorf("cannot be negative"))
+		}
+	}
+	if dp.IsSet(cfgKeyRetryMaxBackoff) {
+		if maxBackoff, err = dp.GetDuration(cfgKeyRetryMaxBackoff); err != nil {
+			return err
+		}
+	}
+	if maxBackoff < baseBackoff {
+		return dp.WrapKeyErr(cfgKeyRetryMaxBackoff, fmt.Errorf("must not be less than %s", cfgKeyRetryBaseBackoff))
+	}
+
+	jitter := DefaultRetryJitter
+	if dp.IsSet(cfgKeyRetryJitter) {
+		if jitter, err = dp.GetFloat64(cfgKeyRetryJitter); err != nil {
+			return err
+		}
+		if jitter < 0 || jitter > 1 {
+			return dp.WrapKeyErr(cfgKeyRetryJitter, fmt.Errorf("must be in range [0, 1]"))
+		}
+	}
 
+	c.Retry = RetryPolicyConfig{
+		ErrorClasses: errClasses,
+		MaxAttempts:  maxAttempts,
+		BaseBackoff:  baseBackoff,
+		MaxBackoff:   maxBackoff,
+		Jitter:       jitter,
+	}
 	return nil
 }
 
+func (c *Config) setMigrationsConfig(dp config.DataProvider) error {
+	var err error
+	if c.Migrations.AutoRun, err = dp.GetBool(cfgKeyMigrationsAutoRun); err != nil {
+		return err
+	}
+	if c.Migrations.TableName, err = dp.GetString(cfgKeyMigrationsTableName); err != nil {
+		return err
+	}
+	if !dp.IsSet(cfgKeyMigrationsLockTimeout) {
+		c.Migrations.LockTimeout = DefaultMigrationsLockTimeout
+		return nil
+	}
+	if c.Migrations.LockTimeout, err = dp.GetDuration(cfgKeyMigrationsLockTimeout); err != nil {
+		return err
+	}
+	if c.Migrations.LockTimeout <= 0 {
+		return dp.WrapKeyErr(cfgKeyMigrationsLockTimeout, fmt.Errorf("must be positive"))
+	}
+	return nil
+}
+
+// endpointDataProvider is a config.DataProvider that reads parameters of the additional endpoint (read-only or shard).
+// Value of the "<endpointKey>.<key>" (e.g. "db.readOnly.postgres.host" or "db.shards.0.postgres.host") is used if it's set,
+// otherwise the value of the "db.<key>" is used.
+type endpointDataProvider struct {
+	config.DataProvider
+	endpointKey string
+}
+
+func (dp *endpointDataProvider) key(key string) string {
+	endpointKey := dp.endpointKey + "." + strings.TrimPrefix(key, "db.")
+	if dp.DataProvider.IsSet(endpointKey) {
+		return endpointKey
+	}
+	return key
+}
+
+func (dp *endpointDataProvider) IsSet(key string) bool {
+	return dp.DataProvider.IsSet(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetInt(key string) (int, error) {
+	return dp.DataProvider.GetInt(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetString(key string) (string, error) {
+	return dp.DataProvider.GetString(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetStringFromSet(key string, set []string, ignoreCase bool) (string, error) {
+	return dp.DataProvider.GetStringFromSet(dp.key(key), set, ignoreCase)
+}
+
+func (dp *endpointDataProvider) GetStringMapString(key string) (map[string]string, error) {
+	return dp.DataProvider.GetStringMapString(dp.key(key))
+}
+
+func (dp *endpointDataProvider) Get(key string) interface{} {
+	return dp.DataProvider.Get(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetBool(key string) (bool, error) {
+	return dp.DataProvider.GetBool(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetIntSlice(key string) ([]int, error) {
+	return dp.DataProvider.GetIntSlice(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetFloat32(key string) (float32, error) {
+	return dp.DataProvider.GetFloat32(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetFloat64(key string) (float64, error) {
+	return dp.DataProvider.GetFloat64(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetStringSlice(key string) ([]string, error) {
+	return dp.DataProvider.GetStringSlice(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetDuration(key string) (time.Duration, error) {
+	return dp.DataProvider.GetDuration(dp.key(key))
+}
+
+func (dp *endpointDataProvider) GetSizeInBytes(key string) (uint64, error) {
+	return dp.DataProvider.GetSizeInBytes(dp.key(key))
+}
+
+func (dp *endpointDataProvider) UnmarshalKey(key string, rawVal interface{}, opts ...config.DecoderConfigOption) error {
+	return dp.DataProvider.UnmarshalKey(dp.key(key), rawVal, opts...)
+}
+
+func (dp *endpointDataProvider) WrapKeyErr(key string, err error) error {
+	return dp.DataProvider.WrapKeyErr(dp.key(key), err)
+}
+
 var availableTxIsolationLevels = []sql.IsolationLevel{
 	sql.LevelReadUncommitted,
 	sql.LevelReadCommitted,
@@ -370,7 +879,39 @@ var availableTxIsolationLevels = []sql.IsolationLevel{
 	sql.LevelSerializable,
 }
 
-func getIsolationLevel(dp config.DataProvider, key string) (sql.IsolationLevel, error) {
+// ErrUnsupportedTxIsolationLevel is returned when transaction isolation level is not supported by the dialect.
+var ErrUnsupportedTxIsolationLevel = errors.New("transaction isolation level is not supported")
+
+// SupportedTxIsolationLevels returns transaction isolation levels that may be used with the specified dialect.
+// Postgres doesn't support Read Uncommitted (it behaves as Read Committed),
+// and SQLite transactions are always serializable.
+func SupportedTxIsolationLevels(dialect Dialect) []sql.IsolationLevel {
+	switch dialect {
+	case DialectMySQL, DialectMSSQL:
+		return availableTxIsolationLevels
+	case DialectPostgres, DialectPgx:
+		return []sql.IsolationLevel{sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSerializable}
+	case DialectSQLite:
+		return []sql.IsolationLevel{sql.LevelSerializable}
+	}
+	return nil
+}
+
+// ValidateTxIsolationLevel checks that transaction isolation level is supported by the specified dialect.
+// sql.LevelDefault is always valid.
+func ValidateTxIsolationLevel(dialect Dialect, level sql.IsolationLevel) error {
+	if level == sql.LevelDefault {
+		return nil
+	}
+	for _, lvl := range SupportedTxIsolationLevels(dialect) {
+		if lvl == level {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w by %s dialect: %s", ErrUnsupportedTxIsolationLevel, dialect, level)
+}
+
+func getIsolationLevel(dp config.DataProvider, key string, dialect Dialect) (sql.IsolationLevel, error) {
 	availableLevelsStr := make([]string, 0, len(availableTxIsolationLevels))
 	for _, lvl := range availableTxIsolationLevels {
 		availableLevelsStr = append(availableLevelsStr, lvl.String())
@@ -381,6 +922,9 @@ func getIsolationLevel(dp config.DataProvider, key string) (sql.IsolationLevel,
 	}
 	for i, lvlStr := range availableLevelsStr {
 		if gotLevelStr == lvlStr {
+			if err = ValidateTxIsolationLevel(dialect, availableTxIsolationLevels[i]); err != nil {
+				return sql.LevelDefault, dp.WrapKeyErr(key, err)
+			}
 			return availableTxIsolationLevels[i], nil
 		}
 	}
@@ -9,7 +9,9 @@ package dbkit
 import (
 	"bytes"
 	"database/sql"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -40,18 +42,32 @@ db:
     user: mysql-user
     password: mysql-password
     txLevel: Repeatable Read
+    interpolateParams: true
+    clientFoundRows: true
+    rejectReadOnly: true
+    maxAllowedPacket: 16M
+    autocommit: true
+    socket: /var/run/mysqld/mysqld.sock
+    dialer: cloudsql
 `)
 		cfg := NewConfig(allDialects)
 		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
 		require.NoError(t, err)
 		require.Equal(t, DialectMySQL, cfg.Dialect)
 		wantMySQLCfg := MySQLConfig{
-			Host:             "mysql-host",
-			Port:             3307,
-			Database:         "mysql_db",
-			User:             "mysql-user",
-			Password:         "mysql-password",
-			TxIsolationLevel: sql.LevelRepeatableRead,
+			Host:              "mysql-host",
+			Port:              3307,
+			Database:          "mysql_db",
+			User:              "mysql-user",
+			Password:          "mysql-password",
+			TxIsolationLevel:  sql.LevelRepeatableRead,
+			InterpolateParams: true,
+			ClientFoundRows:   true,
+			RejectReadOnly:    true,
+			MaxAllowedPacket:  16 * 1024 * 1024,
+			Autocommit:        true,
+			Socket:            "/var/run/mysqld/mysqld.sock",
+			Dialer:            "cloudsql",
 		}
 		require.Equal(t, wantMySQLCfg, cfg.MySQL)
 	})
@@ -68,6 +84,10 @@ db:
     password: pg-password
     txLevel: Repeatable Read
     sslMode: verify-full
+    sslRootCert: /etc/ssl/root.crt
+    sslCert: /etc/ssl/client.crt
+    sslKey: /etc/ssl/client.key
+    dialer: cloudsql
     searchPath: pg-search
 `)
 		cfg := NewConfig(allDialects)
@@ -82,6 +102,10 @@ db:
 			Password:         "pg-password",
 			TxIsolationLevel: sql.LevelRepeatableRead,
 			SSLMode:          PostgresSSLModeVerifyFull,
+			SSLRootCert:      "/etc/ssl/root.crt",
+			SSLCert:          "/etc/ssl/client.crt",
+			SSLKey:           "/etc/ssl/client.key",
+			Dialer:           "cloudsql",
 			SearchPath:       "pg-search",
 		}
 		require.Equal(t, wantPostgresCfg, cfg.Postgres)
@@ -99,6 +123,8 @@ db:
     password: pg-password
     txLevel: Repeatable Read
     sslMode: verify-full
+    sslKey: /etc/ssl/client.key
+    sslPassword: key-password
     searchPath: pg-search
 `)
 		cfg := NewConfig(allDialects)
@@ -113,10 +139,38 @@ db:
 			Password:             "pg-password",
 			TxIsolationLevel:     sql.LevelRepeatableRead,
 			SSLMode:              PostgresSSLModeVerifyFull,
+			SSLKey:               "/etc/ssl/client.key",
+			SSLPassword:          "key-password",
 			SearchPath:           "pg-search",
 			AdditionalParameters: []Parameter{{Name: "target_session_attrs", Value: "read-write"}},
 		}
 		require.Equal(t, wantPostgresCfg, cfg.Postgres)
+		_, dsn := cfg.DriverNameAndDSN()
+		require.Contains(t, dsn, "&sslpassword=key-password")
+	})
+
+	t.Run("ssl password is not supported by lib/pq", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: postgres
+  postgres:
+    host: pg-host
+    database: pg_db
+    sslMode: verify-full
+    sslKey: /etc/ssl/client.key
+    sslPassword: key-password
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.EqualError(t, err, `db.postgres.sslPassword: is supported only for "pgx" dialect`)
+
+		// DSN for lib/pq doesn't contain the password even if it's set in the config directly.
+		cfg = &Config{Dialect: DialectPostgres, Postgres: PostgresConfig{
+			Host: "pg-host", Port: 5432, Database: "pg_db", SSLKey: "/etc/ssl/client.key", SSLPassword: "key-password"}}
+		driverName, dsn := cfg.DriverNameAndDSN()
+		require.Equal(t, "postgres", driverName)
+		require.NotContains(t, dsn, "sslpassword")
+		require.NotContains(t, dsn, "key-password")
 	})
 
 	t.Run("read postgres (pgx) parameters with overridden target_session_attrs", func(t *testing.T) {
@@ -178,6 +232,50 @@ db:
 			TxIsolationLevel: sql.LevelRepeatableRead,
 		}
 		require.Equal(t, wantMSSQLCfg, cfg.MSSQL)
+		require.Equal(t, sql.LevelRepeatableRead, cfg.TxIsolationLevel())
+	})
+
+	t.Run("read sqlite3 parameters", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+  sqlite3:
+    path: ":memory:"
+    txLevel: Serializable
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+		require.Equal(t, SQLiteConfig{Path: ":memory:", TxIsolationLevel: sql.LevelSerializable}, cfg.SQLite)
+		require.Equal(t, sql.LevelSerializable, cfg.TxIsolationLevel())
+	})
+
+	t.Run("unsupported transaction isolation level", func(t *testing.T) {
+		tests := []struct {
+			dialect Dialect
+			txLevel string
+			wantErr string
+		}{
+			{DialectPostgres, "Read Uncommitted", "db.postgres.txLevel: transaction isolation level is not supported by postgres dialect: Read Uncommitted"},
+			{DialectPgx, "Read Uncommitted", "db.postgres.txLevel: transaction isolation level is not supported by pgx dialect: Read Uncommitted"},
+			{DialectSQLite, "Read Committed", "db.sqlite3.txLevel: transaction isolation level is not supported by sqlite3 dialect: Read Committed"},
+		}
+		for _, tt := range tests {
+			dialectKey := string(tt.dialect)
+			if tt.dialect == DialectPgx {
+				dialectKey = string(DialectPostgres)
+			}
+			cfgData := bytes.NewBufferString(fmt.Sprintf(`
+db:
+  dialect: %s
+  %s:
+    txLevel: %s
+`, tt.dialect, dialectKey, tt.txLevel))
+			cfg := NewConfig(allDialects)
+			err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+			require.ErrorIs(t, err, ErrUnsupportedTxIsolationLevel)
+			require.EqualError(t, err, tt.wantErr)
+		}
 	})
 
 	t.Run("read multiple connection parameters from one source", func(t *testing.T) {
@@ -230,4 +328,327 @@ subsystemB:
 		}
 		require.Equal(t, wantSubSystemBCfg, cfgB.MSSQL)
 	})
+
+	t.Run("read default retry parameters", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+		wantRetryCfg := RetryPolicyConfig{
+			ErrorClasses: []RetryErrorClass{RetryErrorClassDialect},
+			MaxAttempts:  DefaultRetryMaxAttempts,
+			BaseBackoff:  DefaultRetryBaseBackoff,
+			MaxBackoff:   DefaultRetryMaxBackoff,
+			Jitter:       DefaultRetryJitter,
+		}
+		require.Equal(t, wantRetryCfg, cfg.Retry)
+	})
+
+	t.Run("read retry parameters", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+  retry:
+    errorClasses: [dialect, transient]
+    maxAttempts: 5
+    baseBackoff: 50ms
+    maxBackoff: 1s
+    jitter: 0.2
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+		wantRetryCfg := RetryPolicyConfig{
+			ErrorClasses: []RetryErrorClass{RetryErrorClassDialect, RetryErrorClassTransient},
+			MaxAttempts:  5,
+			BaseBackoff:  50 * time.Millisecond,
+			MaxBackoff:   time.Second,
+			Jitter:       0.2,
+		}
+		require.Equal(t, wantRetryCfg, cfg.Retry)
+	})
+
+	t.Run("retry parameters without defaults", func(t *testing.T) {
+		dp := config.NewViperAdapter()
+		dp.Set(cfgKeyDialect, string(DialectSQLite))
+		dp.Set(cfgKeyConnMaxLifetime, DefaultConnMaxLifetime)
+		cfg := NewConfig(allDialects)
+		require.NoError(t, cfg.Set(dp))
+		wantRetryCfg := RetryPolicyConfig{
+			ErrorClasses: []RetryErrorClass{RetryErrorClassDialect},
+			MaxAttempts:  DefaultRetryMaxAttempts,
+			BaseBackoff:  DefaultRetryBaseBackoff,
+			MaxBackoff:   DefaultRetryMaxBackoff,
+			Jitter:       DefaultRetryJitter,
+		}
+		require.Equal(t, wantRetryCfg, cfg.Retry)
+
+		dp.Set(cfgKeyRetryMaxAttempts, 0)
+		cfg = NewConfig(allDialects)
+		require.NoError(t, cfg.Set(dp))
+		require.Equal(t, DefaultRetryMaxAttempts, cfg.Retry.MaxAttempts)
+
+		dp.Set(cfgKeyRetryMaxAttempts, -1)
+		cfg = NewConfig(allDialects)
+		require.EqualError(t, cfg.Set(dp), "db.retry.maxAttempts: cannot be negative")
+	})
+
+	t.Run("unknown retry error class", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+  retry:
+    errorClasses: [fake-class]
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.EqualError(t, err, `db.retry.errorClasses: unknown error class "fake-class"`)
+	})
+
+	t.Run("read migrations parameters", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+`)
+		cfg := NewConfig(allDialects)
+		require.NoError(t, config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg))
+		require.Equal(t, MigrationsConfig{LockTimeout: DefaultMigrationsLockTimeout}, cfg.Migrations)
+
+		cfgData = bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+  migrations:
+    autoRun: true
+    tableName: schema_migrations
+    lockTimeout: 30s
+`)
+		cfg = NewConfig(allDialects)
+		require.NoError(t, config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg))
+		wantMigrationsCfg := MigrationsConfig{AutoRun: true, TableName: "schema_migrations", LockTimeout: 30 * time.Second}
+		require.Equal(t, wantMigrationsCfg, cfg.Migrations)
+
+		cfgData = bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+  migrations:
+    lockTimeout: 0s
+`)
+		cfg = NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.EqualError(t, err, "db.migrations.lockTimeout: must be positive")
+	})
+
+	t.Run("migrations lock timeout without defaults", func(t *testing.T) {
+		dp := config.NewViperAdapter()
+		dp.Set(cfgKeyDialect, string(DialectSQLite))
+		dp.Set(cfgKeyConnMaxLifetime, DefaultConnMaxLifetime)
+		cfg := NewConfig(allDialects)
+		require.NoError(t, cfg.Set(dp))
+		require.Equal(t, DefaultMigrationsLockTimeout, cfg.Migrations.LockTimeout)
+	})
+
+	t.Run("read parameters of read-only endpoint", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: pgx
+  maxOpenConns: 20
+  postgres:
+    host: pg-host
+    port: 5433
+    database: pg_db
+    user: pg-user
+    password: pg-password
+    txLevel: Repeatable Read
+    sslMode: verify-full
+  readOnly:
+    postgres:
+      host: pg-replica-host
+      user: pg-readonly-user
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+		require.NotNil(t, cfg.ReadOnly)
+		require.Equal(t, DialectPgx, cfg.ReadOnly.Dialect)
+		require.Equal(t, 20, cfg.ReadOnly.MaxOpenConns)
+		wantPostgresCfg := PostgresConfig{
+			Host:                 "pg-replica-host",
+			Port:                 5433,
+			Database:             "pg_db",
+			User:                 "pg-readonly-user",
+			Password:             "pg-password",
+			TxIsolationLevel:     sql.LevelRepeatableRead,
+			SSLMode:              PostgresSSLModeVerifyFull,
+			AdditionalParameters: []Parameter{{Name: "target_session_attrs", Value: "prefer-standby"}},
+		}
+		require.Equal(t, wantPostgresCfg, cfg.ReadOnly.Postgres)
+		require.Equal(t, "pg-host", cfg.Postgres.Host)
+	})
+
+	t.Run("read parameters of shards", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: mysql
+  maxOpenConns: 20
+  mysql:
+    host: mysql-host
+    port: 3306
+    database: mysql_db
+    user: mysql-user
+    password: mysql-password
+  shards:
+    - mysql:
+        host: mysql-shard-0
+    - mysql:
+        host: mysql-shard-1
+        database: mysql_db_1
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+		require.Len(t, cfg.Shards, 2)
+		for i, wantDatabase := range []string{"mysql_db", "mysql_db_1"} {
+			require.Equal(t, DialectMySQL, cfg.Shards[i].Dialect)
+			require.Equal(t, 20, cfg.Shards[i].MaxOpenConns)
+			wantMySQLCfg := MySQLConfig{
+				Host:             fmt.Sprintf("mysql-shard-%d", i),
+				Port:             3306,
+				Database:         wantDatabase,
+				User:             "mysql-user",
+				Password:         "mysql-password",
+				TxIsolationLevel: sql.LevelReadCommitted,
+			}
+			require.Equal(t, wantMySQLCfg, cfg.Shards[i].MySQL)
+		}
+		require.Equal(t, "mysql-host", cfg.MySQL.Host)
+
+		cfgData = bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+  shards: foo
+`)
+		cfg = NewConfig(allDialects)
+		err = config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.EqualError(t, err, "db.shards: must be a list")
+	})
+
+	t.Run("read MySQL parameters of endpoints", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: mysql
+  mysql:
+    host: mysql-host
+    database: mysql_db
+    rejectReadOnly: true
+    maxAllowedPacket: 16M
+  readOnly:
+    mysql:
+      host: mysql-replica-host
+      rejectReadOnly: false
+      interpolateParams: true
+  shards:
+    - mysql:
+        host: mysql-shard-0
+        rejectReadOnly: false
+        maxAllowedPacket: 32M
+    - mysql:
+        host: mysql-shard-1
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+
+		require.True(t, cfg.MySQL.RejectReadOnly)
+		require.False(t, cfg.MySQL.InterpolateParams)
+		require.Equal(t, 16<<20, cfg.MySQL.MaxAllowedPacket)
+
+		require.NotNil(t, cfg.ReadOnly)
+		require.False(t, cfg.ReadOnly.MySQL.RejectReadOnly)
+		require.True(t, cfg.ReadOnly.MySQL.InterpolateParams)
+		require.Equal(t, 16<<20, cfg.ReadOnly.MySQL.MaxAllowedPacket)
+
+		require.Len(t, cfg.Shards, 2)
+		require.False(t, cfg.Shards[0].MySQL.RejectReadOnly)
+		require.Equal(t, 32<<20, cfg.Shards[0].MySQL.MaxAllowedPacket)
+		require.True(t, cfg.Shards[1].MySQL.RejectReadOnly)
+		require.Equal(t, 16<<20, cfg.Shards[1].MySQL.MaxAllowedPacket)
+
+		cfgData = bytes.NewBufferString(`
+db:
+  dialect: mysql
+  shards:
+    - mysql:
+        maxAllowedPacket: 8G
+`)
+		cfg = NewConfig(allDialects)
+		err = config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.ErrorContains(t, err, "db.shards.0.mysql.maxAllowedPacket")
+	})
+
+	t.Run("autocommit may be set per endpoint", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: mysql
+  mysql:
+    host: mysql-host
+    database: mysql_db
+  readOnly:
+    mysql:
+      host: mysql-replica-host
+      autocommit: true
+  shards:
+    - mysql:
+        host: mysql-shard-0
+    - mysql:
+        host: mysql-shard-1
+        autocommit: true
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+		require.False(t, cfg.MySQL.Autocommit)
+		require.True(t, cfg.ReadOnly.MySQL.Autocommit)
+		require.Len(t, cfg.Shards, 2)
+		require.False(t, cfg.Shards[0].MySQL.Autocommit)
+		require.True(t, cfg.Shards[1].MySQL.Autocommit)
+
+		_, dsn := cfg.DriverNameAndDSN()
+		require.Contains(t, dsn, "autocommit=false")
+		_, dsn = cfg.ReadOnly.DriverNameAndDSN()
+		require.Contains(t, dsn, "autocommit=true")
+	})
+
+	t.Run("read-only endpoint is not configured", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: sqlite3
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+		require.Nil(t, cfg.ReadOnly)
+	})
+
+	t.Run("read mssql azure ad parameters", func(t *testing.T) {
+		cfgData := bytes.NewBufferString(`
+db:
+  dialect: mssql
+  mssql:
+    host: myserver.database.windows.net
+    port: 1433
+    database: mssql_db
+    fedAuth: ActiveDirectoryMSI
+    clientID: client-id
+`)
+		cfg := NewConfig(allDialects)
+		err := config.NewDefaultLoader("").LoadFromReader(cfgData, config.DataTypeYAML, cfg)
+		require.NoError(t, err)
+		require.Equal(t, MSSQLFedAuthMSI, cfg.MSSQL.FedAuth)
+		require.Equal(t, "client-id", cfg.MSSQL.ClientID)
+		driverName, _ := cfg.DriverNameAndDSN()
+		require.Equal(t, MSSQLAzureDriverName, driverName)
+	})
 }
@@ -0,0 +1,142 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/acronis/go-dbkit/internal/ctxutil"
+)
+
+// ErrInvalidSessionVariable is returned when the name of the session variable is invalid.
+var ErrInvalidSessionVariable = errors.New("invalid session variable")
+
+var sessionVariableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// SessionVariable represents a variable (setting) of the database session.
+// For Postgres, custom variables should be qualified (e.g. "app.tenant_id").
+type SessionVariable struct {
+	Name  string
+	Value string
+}
+
+// ConnOpts represents an options for DoWithConnWithOpts.
+type ConnOpts struct {
+	// Dialect is required if SessionVariables are specified.
+	Dialect Dialect
+
+	// SessionVariables are set right after the connection is acquired and reset to the defaults before it's released,
+	// so they don't leak to other users of the pool. Only Postgres and MySQL are supported.
+	SessionVariables []SessionVariable
+}
+
+// DoWithConn acquires a single connection from the pool, calls passed function and returns the connection back.
+// It's needed for features that require connection affinity (e.g. MySQL GET_LOCK,
+// Postgres session-level advisory locks, temporary tables or session variables).
+func DoWithConn(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	return DoWithConnWithOpts(ctx, db, ConnOpts{}, fn)
+}
+
+// DoWithConnWithOpts is a more configurable version of DoWithConn that allows setting session variables.
+// If session variables cannot be reset, the connection is discarded instead of returning it to the pool.
+func DoWithConnWithOpts(ctx context.Context, db *sql.DB, opts ConnOpts, fn func(conn *sql.Conn) error) (err error) {
+	for _, v := range opts.SessionVariables {
+		if err = validateSessionVariable(opts.Dialect, v.Name); err != nil {
+			return err
+		}
+	}
+
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		return fmt.Errorf("acquire conn: %w", err)
+	}
+	defer func() {
+		resetErr := resetSessionVariables(ctxutil.WithoutCancel(ctx), conn, opts)
+		if resetErr != nil {
+			// Session state is unknown, so the connection should not be reused.
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			if err == nil {
+				err = resetErr
+			}
+		}
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("release conn: %w", closeErr)
+		}
+	}()
+
+	for _, v := range opts.SessionVariables {
+		if err = SetSessionVariable(ctx, conn, opts.Dialect, v.Name, v.Value); err != nil {
+			return err
+		}
+	}
+	return fn(conn)
+}
+
+func resetSessionVariables(ctx context.Context, conn *sql.Conn, opts ConnOpts) error {
+	var errs []error
+	for _, v := range opts.SessionVariables {
+		if err := ResetSessionVariable(ctx, conn, opts.Dialect, v.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetSessionVariable sets variable of the database session. Only Postgres and MySQL are supported.
+// Value is passed as a query parameter, name is validated.
+func SetSessionVariable(ctx context.Context, conn *sql.Conn, dialect Dialect, name, value string) error {
+	if err := validateSessionVariable(dialect, name); err != nil {
+		return err
+	}
+	var err error
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		_, err = conn.ExecContext(ctx, "SELECT set_config($1, $2, false)", name, value)
+	case DialectMySQL:
+		_, err = conn.ExecContext(ctx, "SET SESSION "+name+" = ?", value)
+	}
+	if err != nil {
+		return fmt.Errorf("set session variable %s: %w", name, err)
+	}
+	return nil
+}
+
+// ResetSessionVariable resets variable of the database session to its default value.
+// Only Postgres and MySQL are supported.
+func ResetSessionVariable(ctx context.Context, conn *sql.Conn, dialect Dialect, name string) error {
+	if err := validateSessionVariable(dialect, name); err != nil {
+		return err
+	}
+	var err error
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		_, err = conn.ExecContext(ctx, "RESET "+name)
+	case DialectMySQL:
+		_, err = conn.ExecContext(ctx, "SET SESSION "+name+" = DEFAULT")
+	}
+	if err != nil {
+		return fmt.Errorf("reset session variable %s: %w", name, err)
+	}
+	return nil
+}
+
+func validateSessionVariable(dialect Dialect, name string) error {
+	switch dialect {
+	case DialectPostgres, DialectPgx, DialectMySQL:
+	default:
+		return fmt.Errorf("%w %q: session variables are not supported for dialect %q", ErrInvalidSessionVariable, name, dialect)
+	}
+	if !sessionVariableNameRegexp.MatchString(name) {
+		return fmt.Errorf("%w %q: name must consist of letters, digits, underscores and dots", ErrInvalidSessionVariable, name)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoWithConn(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("function is called with pinned connection", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectExec("CREATE TEMPORARY TABLE tmp").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO tmp").WillReturnResult(sqlmock.NewResult(0, 1))
+		require.NoError(t, DoWithConn(ctx, db, func(conn *sql.Conn) error {
+			if _, execErr := conn.ExecContext(ctx, "CREATE TEMPORARY TABLE tmp"); execErr != nil {
+				return execErr
+			}
+			_, execErr := conn.ExecContext(ctx, "INSERT INTO tmp")
+			return execErr
+		}))
+
+		fnErr := errors.New("fn error")
+		require.ErrorIs(t, DoWithConn(ctx, db, func(conn *sql.Conn) error {
+			return fnErr
+		}), fnErr)
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("session variables are set and reset", func(t *testing.T) {
+		tests := []struct {
+			dialect   Dialect
+			name      string
+			setQuery  string
+			setArgs   []driver.Value
+			execQuery string
+		}{
+			{DialectPgx, "app.tenant_id", "SELECT set_config($1, $2, false)", []driver.Value{"app.tenant_id", "42"}, "RESET app.tenant_id"},
+			{DialectMySQL, "sql_mode", "SET SESSION sql_mode = ?", []driver.Value{"ANSI"}, "SET SESSION sql_mode = DEFAULT"},
+		}
+		for _, tt := range tests {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+
+			mock.ExpectExec(regexp.QuoteMeta(tt.setQuery)).WithArgs(tt.setArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("DELETE FROM users").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(tt.execQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+			opts := ConnOpts{Dialect: tt.dialect, SessionVariables: []SessionVariable{{tt.name, tt.setArgs[len(tt.setArgs)-1].(string)}}}
+			require.NoError(t, DoWithConnWithOpts(ctx, db, opts, func(conn *sql.Conn) error {
+				_, execErr := conn.ExecContext(ctx, "DELETE FROM users")
+				return execErr
+			}))
+
+			mock.ExpectClose()
+			requireNoErrOnClose(t, db)
+			require.NoError(t, mock.ExpectationsWereMet(), tt.dialect)
+		}
+	})
+
+	t.Run("connection is discarded if session variables cannot be reset", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		resetErr := errors.New("connection is broken")
+		mock.ExpectExec(regexp.QuoteMeta("SELECT set_config($1, $2, false)")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RESET app.tenant_id").WillReturnError(resetErr)
+		mock.ExpectClose()
+		opts := ConnOpts{Dialect: DialectPostgres, SessionVariables: []SessionVariable{{"app.tenant_id", "42"}}}
+		err = DoWithConnWithOpts(ctx, db, opts, func(conn *sql.Conn) error {
+			return nil
+		})
+		require.ErrorIs(t, err, resetErr)
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Equal(t, 0, db.Stats().OpenConnections)
+		requireNoErrOnClose(t, db)
+	})
+
+	t.Run("invalid session variables", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		fn := func(conn *sql.Conn) error {
+			t.Fatalf("fn must not be called")
+			return nil
+		}
+		for _, opts := range []ConnOpts{
+			{Dialect: DialectPostgres, SessionVariables: []SessionVariable{{"app.tenant_id; DROP TABLE users", "42"}}},
+			{Dialect: DialectMySQL, SessionVariables: []SessionVariable{{"", "42"}}},
+			{Dialect: DialectSQLite, SessionVariables: []SessionVariable{{"foreign_keys", "ON"}}},
+		} {
+			require.ErrorIs(t, DoWithConnWithOpts(ctx, db, opts, fn), ErrInvalidSessionVariable)
+		}
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
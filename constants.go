@@ -18,6 +18,17 @@ const (
 	DefaultConnMaxLifetime = 10 * time.Minute // Official recommendation from the DBA team
 )
 
+// Default values of retry policy parameters.
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBaseBackoff = 100 * time.Millisecond
+	DefaultRetryMaxBackoff  = 5 * time.Second
+	DefaultRetryJitter      = 0.5
+)
+
+// DefaultMigrationsLockTimeout is a default timeout for acquiring the lock that guards running migrations on start.
+const DefaultMigrationsLockTimeout = time.Minute
+
 // MSSQLDefaultTxLevel contains transaction isolation level which will be used by default for MSSQL.
 const MSSQLDefaultTxLevel = sql.LevelReadCommitted
 
@@ -36,6 +47,9 @@ const PgTargetSessionAttrs = "target_session_attrs"
 // PgReadWriteParam read-write session attribute value name
 const PgReadWriteParam = "read-write"
 
+// PgPreferStandbyParam prefer-standby session attribute value name
+const PgPreferStandbyParam = "prefer-standby"
+
 // Dialect defines possible values for planned supported SQL dialects.
 type Dialect string
 
@@ -48,6 +62,34 @@ const (
 	DialectMSSQL    Dialect = "mssql"
 )
 
+// RetryErrorClass defines possible classes of errors that may be retried.
+type RetryErrorClass string
+
+// Retry error classes.
+const (
+	// RetryErrorClassDialect is a class of errors that are classified as retryable by functions registered
+	// for the driver (see RegisterIsRetryableFunc), e.g. deadlocks and serialization failures.
+	RetryErrorClassDialect RetryErrorClass = "dialect"
+
+	// RetryErrorClassTransient is a class of transient connection errors (see IsTransientConnectionError).
+	RetryErrorClassTransient RetryErrorClass = "transient"
+)
+
+// QueryErrorClass defines possible classes of errors that occur during executing SQL queries.
+// It's used as a value of the metrics label (see MetricsCollector.QueryErrors).
+type QueryErrorClass string
+
+// Query error classes.
+const (
+	QueryErrorClassDeadlock        QueryErrorClass = "deadlock"
+	QueryErrorClassUniqueViolation QueryErrorClass = "unique_violation"
+	QueryErrorClassDuplicateTable  QueryErrorClass = "duplicate_table"
+	QueryErrorClassTimeout         QueryErrorClass = "timeout"
+	QueryErrorClassCanceled        QueryErrorClass = "canceled"
+	QueryErrorClassConnection      QueryErrorClass = "connection"
+	QueryErrorClassOther           QueryErrorClass = "other"
+)
+
 // PostgresErrCode defines the type for Postgres error codes.
 type PostgresErrCode string
 
@@ -57,14 +99,31 @@ const (
 	PgxErrCodeDeadlockDetected     PostgresErrCode = "40P01"
 	PgxErrCodeSerializationFailure PostgresErrCode = "40001"
 	PgxErrFeatureNotSupported      PostgresErrCode = "0A000"
+	PgxErrCodeQueryCanceled        PostgresErrCode = "57014"
+	PgxErrCodeLockNotAvailable     PostgresErrCode = "55P03"
+	PgxErrCodeAdminShutdown        PostgresErrCode = "57P01"
+	PgxErrCodeCannotConnectNow     PostgresErrCode = "57P03"
+	PgxErrCodeConnectionFailure    PostgresErrCode = "08006"
+	PgxErrCodeTooManyConnections   PostgresErrCode = "53300"
+	PgxErrCodeDuplicateTable       PostgresErrCode = "42P07"
 
 	// nolint: staticcheck // lib/pq using is deprecated. Use pgx Postgres driver.
 	PostgresErrCodeUniqueViolation PostgresErrCode = "unique_violation"
 	// nolint: staticcheck // lib/pq using is deprecated. Use pgx Postgres driver.
 	PostgresErrCodeDeadlockDetected     PostgresErrCode = "deadlock_detected"
 	PostgresErrCodeSerializationFailure PostgresErrCode = "serialization_failure"
+	PostgresErrCodeQueryCanceled        PostgresErrCode = "query_canceled"
+	PostgresErrCodeLockNotAvailable     PostgresErrCode = "lock_not_available"
+	PostgresErrCodeAdminShutdown        PostgresErrCode = "admin_shutdown"
+	PostgresErrCodeCannotConnectNow     PostgresErrCode = "cannot_connect_now"
+	PostgresErrCodeConnectionFailure    PostgresErrCode = "connection_failure"
+	PostgresErrCodeTooManyConnections   PostgresErrCode = "too_many_connections"
+	PostgresErrCodeDuplicateTable       PostgresErrCode = "duplicate_table"
 )
 
+// PostgresErrClassConnectionException is a class (first two characters of the code) of Postgres connection errors.
+const PostgresErrClassConnectionException = "08"
+
 // PostgresSSLMode defines possible values for Postgres sslmode connection parameter.
 type PostgresSSLMode string
 
@@ -75,3 +134,17 @@ const (
 	PostgresSSLModeVerifyCA   PostgresSSLMode = "verify-ca"
 	PostgresSSLModeVerifyFull PostgresSSLMode = "verify-full"
 )
+
+// MSSQLFedAuth defines possible values for MSSQL fedauth connection parameter (Azure Active Directory authentication).
+type MSSQLFedAuth string
+
+// MSSQL Azure Active Directory authentication methods.
+const (
+	MSSQLFedAuthDefault          MSSQLFedAuth = "ActiveDirectoryDefault"
+	MSSQLFedAuthMSI              MSSQLFedAuth = "ActiveDirectoryMSI"
+	MSSQLFedAuthServicePrincipal MSSQLFedAuth = "ActiveDirectoryServicePrincipal"
+	MSSQLFedAuthPassword         MSSQLFedAuth = "ActiveDirectoryPassword"
+)
+
+// MSSQLAzureDriverName is a name of the driver registered by the github.com/denisenkom/go-mssqldb/azuread package.
+const MSSQLAzureDriverName = "azuresql"
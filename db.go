@@ -9,9 +9,58 @@ package dbkit
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 )
 
+// Open opens database with specified configuration parameters
+// and verifies (if ping argument is true) that connection can be established.
+// If AuthTokenProvider is specified in the MySQL or Postgres configuration,
+// the token is requested (and refreshed before its expiration) for establishing new connections.
+// If Dialer is specified there, connections are established via the custom dialer registered by RegisterDialer.
+func Open(cfg *Config, ping bool) (*sql.DB, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err = InitOpenedDB(db, cfg, ping); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func openDB(cfg *Config) (*sql.DB, error) {
+	driverName, dsn := cfg.DriverNameAndDSN()
+	openConnector, err := cfg.makeDialerConnectorOpener(driverName)
+	if err != nil {
+		return nil, err
+	}
+	tokenProvider := cfg.AuthTokenProvider()
+	if tokenProvider == nil {
+		if openConnector == nil {
+			db, openErr := sql.Open(driverName, dsn)
+			if openErr != nil {
+				return nil, openErr
+			}
+			rememberDriverName(driverName, db.Driver())
+			return db, nil
+		}
+		connector, connectorErr := openConnector(dsn)
+		if connectorErr != nil {
+			return nil, connectorErr
+		}
+		rememberDriverName(driverName, connector.Driver())
+		return sql.OpenDB(connector), nil
+	}
+	d, err := getDriverByName(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	tokenConnector := newAuthTokenConnector(d, tokenProvider, cfg.makeDSNWithPassword)
+	tokenConnector.openConnector = openConnector
+	return sql.OpenDB(tokenConnector), nil
+}
+
 // InitOpenedDB initializes early opened *sql.DB instance.
 func InitOpenedDB(db *sql.DB, cfg *Config, ping bool) error {
 	db.SetMaxOpenConns(cfg.MaxOpenConns)
@@ -34,22 +83,44 @@ func DoInTx(ctx context.Context, dbConn *sql.DB, fn func(tx *sql.Tx) error) (err
 }
 
 // DoInTxWithOpts is a bit more configurable version of DoInTx that allows passing tx options.
+// Errors of beginning and committing the transaction are returned as *TxBeginError and *TxCommitError respectively.
+// If the context contains TxAuditor (see NewContextWithTxAuditor), it's called before the commit
+// with the audit entries recorded during the transaction.
+// If the rollback fails, its error is attached to the returned one (it may be obtained via errors.As as *TxRollbackError),
+// while errors.Unwrap still returns the original error, so retry and error classification are not affected.
 func DoInTxWithOpts(ctx context.Context, dbConn *sql.DB, txOpts *sql.TxOptions, fn func(tx *sql.Tx) error) (err error) {
+	return doInTx(ctx, dbConn, txOpts, fn)
+}
+
+// txBeginner is implemented by *sql.DB and *sql.Conn.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+func doInTx(ctx context.Context, beginner txBeginner, txOpts *sql.TxOptions, fn func(tx *sql.Tx) error) (err error) {
 	var tx *sql.Tx
-	if tx, err = dbConn.BeginTx(ctx, txOpts); err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+	if tx, err = beginner.BeginTx(ctx, txOpts); err != nil {
+		return &TxBeginError{Inner: err}
 	}
+	defer WatchTx(ctx)()
 	defer func() {
 		if p := recover(); p != nil {
+			_, _ = takeAuditEntries(ctx)
 			_ = tx.Rollback()
 			panic(p)
 		}
+		if err == nil {
+			err = auditTx(ctx, tx)
+		}
 		if err != nil {
-			_ = tx.Rollback()
+			_, _ = takeAuditEntries(ctx)
+			if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+				err = &txRollbackFailedError{err: err, rollbackErr: &TxRollbackError{Inner: rollbackErr}}
+			}
 			return
 		}
 		if err = tx.Commit(); err != nil {
-			err = fmt.Errorf("commit tx: %w", err)
+			err = &TxCommitError{Inner: err}
 		}
 	}()
 
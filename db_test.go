@@ -9,6 +9,7 @@ package dbkit
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"testing"
@@ -43,7 +44,7 @@ func TestDoInTx(t *testing.T) {
 			Fn: func(tx *sql.Tx) error {
 				return nil
 			},
-			WantErr: fmt.Errorf("begin tx: begin error"),
+			WantErr: &TxBeginError{Inner: fmt.Errorf("begin error")},
 		},
 		{
 			Name: "error on commit",
@@ -54,7 +55,7 @@ func TestDoInTx(t *testing.T) {
 			Fn: func(tx *sql.Tx) error {
 				return nil
 			},
-			WantErr: fmt.Errorf("commit tx: commit error"),
+			WantErr: &TxCommitError{Inner: fmt.Errorf("commit error")},
 		},
 		{
 			Name: "error in func",
@@ -104,10 +105,36 @@ func TestDoInTx(t *testing.T) {
 				return
 			}
 			require.EqualError(t, err, tt.WantErr.Error())
+			require.IsType(t, tt.WantErr, err)
 		})
 	}
 }
 
+func TestDoInTx_RollbackError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	errFn := errors.New("fn error")
+	errRollback := errors.New("rollback error")
+	mock.ExpectBegin()
+	mock.ExpectRollback().WillReturnError(errRollback)
+	mock.ExpectClose()
+
+	err = DoInTx(context.Background(), db, func(tx *sql.Tx) error {
+		return errFn
+	})
+	require.EqualError(t, err, "fn error; rollback tx: rollback error")
+	require.ErrorIs(t, err, errFn)
+	require.Equal(t, errFn, errors.Unwrap(err))
+	var rollbackErr *TxRollbackError
+	require.ErrorAs(t, err, &rollbackErr)
+	require.Equal(t, errRollback, rollbackErr.Inner)
+}
+
 func requireNoErrOnClose(t *testing.T, closer io.Closer) {
 	t.Helper()
 	require.NoError(t, closer.Close())
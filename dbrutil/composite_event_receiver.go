@@ -7,14 +7,19 @@ Released under MIT license.
 package dbrutil
 
 import (
+	"context"
+
 	"github.com/gocraft/dbr/v2"
 )
 
 // CompositeEventReceiver represents a composition of event receivers from dbr package and implements Composite design pattern.
+// Span methods (dbr.TracingEventReceiver interface) are propagated to the receivers that implement them.
 type CompositeEventReceiver struct {
 	Receivers []dbr.EventReceiver
 }
 
+var _ dbr.TracingEventReceiver = (*CompositeEventReceiver)(nil)
+
 // NewCompositeReceiver creates a new CompositeEventReceiver.
 func NewCompositeReceiver(receivers []dbr.EventReceiver) *CompositeEventReceiver {
 	return &CompositeEventReceiver{receivers}
@@ -66,3 +71,34 @@ func (r *CompositeEventReceiver) TimingKv(eventName string, nanoseconds int64, k
 		recv.TimingKv(eventName, nanoseconds, kvs)
 	}
 }
+
+// SpanStart is called when SQL query is started and calls SpanStart for each receiver in composition
+// that implements dbr.TracingEventReceiver interface. Context returned by one receiver is passed to the next one.
+func (r *CompositeEventReceiver) SpanStart(ctx context.Context, eventName, query string) context.Context {
+	for _, recv := range r.Receivers {
+		if tracingRecv, ok := recv.(dbr.TracingEventReceiver); ok {
+			ctx = tracingRecv.SpanStart(ctx, eventName, query)
+		}
+	}
+	return ctx
+}
+
+// SpanError is called when SQL query fails and calls SpanError for each receiver in composition
+// that implements dbr.TracingEventReceiver interface.
+func (r *CompositeEventReceiver) SpanError(ctx context.Context, err error) {
+	for _, recv := range r.Receivers {
+		if tracingRecv, ok := recv.(dbr.TracingEventReceiver); ok {
+			tracingRecv.SpanError(ctx, err)
+		}
+	}
+}
+
+// SpanFinish is called when SQL query is finished and calls SpanFinish for each receiver in composition
+// that implements dbr.TracingEventReceiver interface.
+func (r *CompositeEventReceiver) SpanFinish(ctx context.Context) {
+	for _, recv := range r.Receivers {
+		if tracingRecv, ok := recv.(dbr.TracingEventReceiver); ok {
+			tracingRecv.SpanFinish(ctx)
+		}
+	}
+}
@@ -7,12 +7,10 @@ Released under MIT license.
 package dbrutil
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/acronis/go-appkit/retry"
@@ -40,6 +38,7 @@ func Open(cfg *dbkit.Config, ping bool, eventReceiver dbr.EventReceiver) (*dbr.C
 }
 
 // TxCommitError is a error that may occur when committing transaction is failed.
+// It may be obtained via errors.As as *dbkit.TxCommitError too.
 type TxCommitError struct {
 	Inner error
 }
@@ -49,12 +48,22 @@ func (e *TxCommitError) Unwrap() error {
 	return e.Inner
 }
 
+// As allows to obtain the error via errors.As as *dbkit.TxCommitError.
+func (e *TxCommitError) As(target interface{}) bool {
+	if commitErr, ok := target.(**dbkit.TxCommitError); ok {
+		*commitErr = &dbkit.TxCommitError{Inner: e.Inner}
+		return true
+	}
+	return false
+}
+
 // Error returns a string representation of TxCommitError.
 func (e *TxCommitError) Error() string {
 	return fmt.Sprintf("error while committing transaction: %s", e.Inner)
 }
 
 // TxRollbackError is an error that may occur when rollback has failed.
+// It may be obtained via errors.As as *dbkit.TxRollbackError too.
 type TxRollbackError struct {
 	Inner error
 }
@@ -64,12 +73,22 @@ func (e *TxRollbackError) Unwrap() error {
 	return e.Inner
 }
 
+// As allows to obtain the error via errors.As as *dbkit.TxRollbackError.
+func (e *TxRollbackError) As(target interface{}) bool {
+	if rollbackErr, ok := target.(**dbkit.TxRollbackError); ok {
+		*rollbackErr = &dbkit.TxRollbackError{Inner: e.Inner}
+		return true
+	}
+	return false
+}
+
 // Error returns a string representation of TxRollbackError.
 func (e *TxRollbackError) Error() string {
 	return fmt.Sprintf("error while transaction rollback: %s", e.Inner)
 }
 
 // TxBeginError is a error that may occur when begging transaction is failed.
+// It may be obtained via errors.As as *dbkit.TxBeginError too.
 type TxBeginError struct {
 	Inner error
 }
@@ -79,6 +98,15 @@ func (e *TxBeginError) Unwrap() error {
 	return e.Inner
 }
 
+// As allows to obtain the error via errors.As as *dbkit.TxBeginError.
+func (e *TxBeginError) As(target interface{}) bool {
+	if beginErr, ok := target.(**dbkit.TxBeginError); ok {
+		*beginErr = &dbkit.TxBeginError{Inner: e.Inner}
+		return true
+	}
+	return false
+}
+
 // Error returns a string representation of TxBeginError.
 func (e *TxBeginError) Error() string {
 	return fmt.Sprintf("error while begging transaction: %s", e.Inner)
@@ -91,11 +119,33 @@ type TxRunner interface {
 	DoInTx(ctx context.Context, fn func(runner dbr.SessionRunner) error) error
 }
 
+// TxRunnerWithOpts is a TxRunner that allows overriding transaction options per call
+// (e.g. escalating isolation level to Serializable for a specific operation).
+// Both TxSession and RetryableTxSession implement it.
+type TxRunnerWithOpts interface {
+	TxRunner
+	BeginTxWithOpts(ctx context.Context, txOpts *sql.TxOptions) (*dbr.Tx, error)
+	DoInTxWithOpts(ctx context.Context, txOpts *sql.TxOptions, fn func(runner dbr.SessionRunner) error) error
+}
+
+var (
+	_ TxRunnerWithOpts = (*TxSession)(nil)
+	_ TxRunnerWithOpts = (*RetryableTxSession)(nil)
+)
+
 // TxSession contains Session form dbr query builder (represents a business unit of execution (e.g. a web request or some worker's job))
 // and options for starting transactions.
 type TxSession struct {
 	*dbr.Session
 	TxOpts *sql.TxOptions
+
+	// SlowTxLogger (if set) is used for logging transactions started by DoInTx that take too long.
+	SlowTxLogger *SlowTxLogger
+
+	// DisableSQLiteContextWorkaround disables beginning SQLite transactions in DoInTx without the caller's context
+	// (see https://github.com/mattn/go-sqlite3/pull/765).
+	// It may be set when the linked driver doesn't have this bug.
+	DisableSQLiteContextWorkaround bool
 }
 
 // NewTxSession creates a new TxSession.
@@ -116,20 +166,40 @@ func NewTxRunner(conn *dbr.Connection, opts *sql.TxOptions, eventReceiver dbr.Ev
 
 // BeginTx begins a new transaction.
 func (s *TxSession) BeginTx(ctx context.Context) (*dbr.Tx, error) {
-	return s.Session.BeginTx(ctx, s.TxOpts)
+	return s.BeginTxWithOpts(ctx, s.TxOpts)
+}
+
+// BeginTxWithOpts begins a new transaction with the passed options instead of the session's ones.
+func (s *TxSession) BeginTxWithOpts(ctx context.Context, txOpts *sql.TxOptions) (*dbr.Tx, error) {
+	return s.Session.BeginTx(ctx, txOpts)
 }
 
 // DoInTx begins a new transaction, calls passed function and do commit or rollback
 // depending on whether the function returns an error or not.
 func (s *TxSession) DoInTx(ctx context.Context, fn func(runner dbr.SessionRunner) error) error {
-	if s.Connection.Dialect == dialect.SQLite3 {
+	return s.DoInTxWithOpts(ctx, s.TxOpts, fn)
+}
+
+// DoInTxWithOpts is the same as DoInTx, but the transaction is begun with the passed options instead of the session's ones.
+func (s *TxSession) DoInTxWithOpts(ctx context.Context, txOpts *sql.TxOptions, fn func(runner dbr.SessionRunner) error) error {
+	stopWatchTx := dbkit.WatchTx(ctx)
+	defer stopWatchTx()
+
+	beginCtx := ctx
+	sqliteCtxWorkaround := s.Connection.Dialect == dialect.SQLite3 && !s.DisableSQLiteContextWorkaround
+	if sqliteCtxWorkaround {
 		// race of ctx cancel with transaction begin leads to 'cannot start a transaction within a transaction'
 		// https://github.com/mattn/go-sqlite3/pull/765
-		ctx = context.TODO()
+		beginCtx = context.TODO()
 	}
-	tx, err := s.BeginTx(ctx)
+	tx, err := s.BeginTxWithOpts(beginCtx, txOpts)
 	if err != nil {
-		return &TxBeginError{err}
+		return &TxBeginError{Inner: err}
+	}
+	committed := false
+	if s.SlowTxLogger != nil {
+		finishTx := s.SlowTxLogger.trackTx(tx)
+		defer func() { finishTx(committed) }()
 	}
 
 	defer tx.RollbackUnlessCommitted()
@@ -137,9 +207,15 @@ func (s *TxSession) DoInTx(ctx context.Context, fn func(runner dbr.SessionRunner
 		return err
 	}
 
+	if sqliteCtxWorkaround && ctx.Err() != nil {
+		// Transaction was begun without the caller's context, so it isn't rolled back by database/sql
+		// when the context is done. Do it here (via deferred rollback) instead of committing.
+		return &TxCommitError{Inner: ctx.Err()}
+	}
 	if err := tx.Commit(); err != nil {
-		return &TxCommitError{err}
+		return &TxCommitError{Inner: err}
 	}
+	committed = true
 
 	return nil
 }
@@ -171,54 +247,54 @@ func NewRetryableTxRunner(conn *dbr.Connection, opts *sql.TxOptions, eventReceiv
 // RetryableTxSession is a wrapper around TxSession that makes transaction executed with DoInTx retryable.
 type RetryableTxSession struct {
 	TxSession
+
+	// MetricsCollector (if set) is used for observing numbers of attempts that transactions needed.
+	// Transaction annotation from the context (see dbkit.NewContextWithTxAnnotation) is used as a query label.
+	// It's implemented by dbkit.MetricsCollector (Prometheus) and by otelmetrics.MetricsCollector (OpenTelemetry).
+	MetricsCollector dbkit.TxAttemptsMetrics
+
+	// DeadlockDiagnostics (if set) is notified about retryable errors (e.g. deadlocks and serialization failures),
+	// so diagnostic info may be captured and logged.
+	DeadlockDiagnostics *dbkit.DeadlockDiagnostics
+
 	policy retry.Policy
 	log    dbr.EventReceiver
 }
 
 // DoInTx implements TxRunner.
 func (s *RetryableTxSession) DoInTx(ctx context.Context, fn func(runner dbr.SessionRunner) error) error {
+	return s.DoInTxWithOpts(ctx, s.TxOpts, fn)
+}
+
+// DoInTxWithOpts implements TxRunnerWithOpts.
+func (s *RetryableTxSession) DoInTxWithOpts(
+	ctx context.Context, txOpts *sql.TxOptions, fn func(runner dbr.SessionRunner) error,
+) error {
 	var notify backoff.Notify
-	if s.log != nil {
+	if s.log != nil || s.DeadlockDiagnostics != nil {
 		notify = func(err error, d time.Duration) {
-			_ = s.log.EventErrKv("backoff", err, map[string]string{"duration_ms": strconv.Itoa(int(d.Milliseconds()))})
+			if s.log != nil {
+				_ = s.log.EventErrKv("backoff", err, map[string]string{"duration_ms": strconv.Itoa(int(d.Milliseconds()))})
+			}
+			if s.DeadlockDiagnostics != nil {
+				s.DeadlockDiagnostics.Notify(err)
+			}
 		}
 	}
-	return retry.DoWithRetry(ctx, s.policy, dbkit.GetIsRetryable(s.Driver()), notify, func(ctx context.Context) error {
-		return s.TxSession.DoInTx(ctx, fn)
+	var attempts int
+	err := retry.DoWithRetry(ctx, s.policy, dbkit.GetIsRetryable(s.Driver()), notify, func(ctx context.Context) error {
+		attempts++
+		return s.TxSession.DoInTxWithOpts(ctx, txOpts, fn)
 	})
+	if s.MetricsCollector != nil && attempts != 0 {
+		s.MetricsCollector.ObserveTxAttempts(dbkit.GetTxAnnotationFromContext(ctx), attempts, err)
+	}
+	return err
 }
 
 // ParseAnnotationInQuery parses annotation from comments in SQL query with specified prefix.
 // If SQL query contains multiple annotations, they will be concatenated with "|" character.
+// It's kept for compatibility, dbkit.ParseAnnotationInQuery should be used instead.
 func ParseAnnotationInQuery(query, prefix string, modifier func(string) string) string {
-	var left int
-	var buf bytes.Buffer
-	for left < len(query) {
-		if !strings.HasPrefix(query[left:], "/*") {
-			break
-		}
-		left += 2
-		r := strings.Index(query[left:], "*/")
-		if r == -1 {
-			break
-		}
-		right := left + r
-		annotation := strings.TrimSpace(query[left:right])
-		if annotation != "" && strings.HasPrefix(annotation, prefix) {
-			if modifier != nil {
-				annotation = modifier(annotation)
-			}
-			if annotation != "" {
-				if buf.Len() != 0 {
-					buf.WriteString("|") // nolint: gosec
-				}
-				buf.WriteString(annotation) // nolint: gosec
-			}
-		}
-		left = right + 2
-		for left < len(query) && (query[left] == ' ' || query[left] == '\n') {
-			left++
-		}
-	}
-	return buf.String()
+	return dbkit.ParseAnnotationInQuery(query, prefix, modifier)
 }
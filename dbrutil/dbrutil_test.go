@@ -9,19 +9,27 @@ package dbrutil
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/acronis/go-appkit/retry"
 	"github.com/acronis/go-appkit/testutil"
 	"github.com/gocraft/dbr/v2"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/gocraft/dbr/v2/dialect"
+	"github.com/mattn/go-sqlite3"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/otelmetrics"
 )
 
 const sqlCreateAndSeedTestUsersTable = `
@@ -88,6 +96,164 @@ func TestDbrBegTxContextCancel(t *testing.T) {
 	wg.Wait()
 }
 
+func TestTxErrors(t *testing.T) {
+	innerErr := errors.New("inner error")
+
+	var beginErr *dbkit.TxBeginError
+	err := error(&TxBeginError{Inner: innerErr})
+	require.EqualError(t, err, "error while begging transaction: inner error")
+	require.ErrorAs(t, err, &beginErr)
+	require.Equal(t, innerErr, beginErr.Inner)
+
+	var commitErr *dbkit.TxCommitError
+	err = &TxCommitError{Inner: innerErr}
+	require.EqualError(t, err, "error while committing transaction: inner error")
+	require.ErrorAs(t, err, &commitErr)
+	require.Equal(t, innerErr, commitErr.Inner)
+
+	var rollbackErr *dbkit.TxRollbackError
+	err = &TxRollbackError{Inner: innerErr}
+	require.EqualError(t, err, "error while transaction rollback: inner error")
+	require.ErrorAs(t, err, &rollbackErr)
+	require.Equal(t, innerErr, rollbackErr.Inner)
+	require.ErrorIs(t, err, innerErr)
+}
+
+func TestTxSession_DoInTx_SQLiteContextDeadline(t *testing.T) {
+	dbConn := openAndSeedDB(t)
+	defer func() {
+		require.NoError(t, dbConn.Close())
+	}()
+
+	for _, disableWorkaround := range []bool{false, true} {
+		txSess := NewTxSession(dbConn, nil)
+		txSess.DisableSQLiteContextWorkaround = disableWorkaround
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := txSess.DoInTx(ctx, func(runner dbr.SessionRunner) error {
+			_, execErr := runner.DeleteFrom("users").Where(dbr.Eq("name", "Bob")).Exec()
+			require.NoError(t, execErr)
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			if !disableWorkaround {
+				// Transaction is not rolled back concurrently with fn, so it still may be used.
+				_, execErr = runner.DeleteFrom("users").Where(dbr.Eq("name", "Sam")).Exec()
+				require.NoError(t, execErr)
+			}
+			return nil
+		})
+		cancel()
+		var commitErr *TxCommitError
+		require.ErrorAs(t, err, &commitErr)
+		if !disableWorkaround {
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			countUsersByName(t, dbConn.NewSession(nil), "", "Bob", 1)
+			countUsersByName(t, dbConn.NewSession(nil), "", "Sam", 2)
+		}
+		// Without the workaround, the interrupted connection may be discarded,
+		// and the shared in-memory database is dropped with it, so its content is not checked.
+	}
+}
+
+type txOptsRecordingConnector struct {
+	mu     sync.Mutex
+	txOpts []driver.TxOptions
+}
+
+func (c *txOptsRecordingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return &txOptsRecordingConn{Conn: conn, connector: c}, nil
+}
+
+func (c *txOptsRecordingConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+type txOptsRecordingConn struct {
+	driver.Conn
+	connector *txOptsRecordingConnector
+}
+
+func (c *txOptsRecordingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.connector.mu.Lock()
+	c.connector.txOpts = append(c.connector.txOpts, opts)
+	c.connector.mu.Unlock()
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func TestTxSession_DoInTxWithOpts(t *testing.T) {
+	connector := &txOptsRecordingConnector{}
+	dbConn := &dbr.Connection{DB: sql.OpenDB(connector), Dialect: dialect.SQLite3, EventReceiver: &dbr.NullEventReceiver{}}
+	defer func() {
+		require.NoError(t, dbConn.Close())
+	}()
+
+	runners := []TxRunnerWithOpts{
+		NewTxSession(dbConn, &sql.TxOptions{Isolation: sql.LevelReadCommitted}),
+		NewRetryableTxSession(dbConn, &sql.TxOptions{Isolation: sql.LevelReadCommitted},
+			retry.NewConstantBackoffPolicy(time.Millisecond, 1)),
+	}
+	for _, runner := range runners {
+		connector.txOpts = nil
+		noopFn := func(runner dbr.SessionRunner) error { return nil }
+		require.NoError(t, runner.DoInTx(context.Background(), noopFn))
+		require.NoError(t, runner.DoInTxWithOpts(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable}, noopFn))
+		tx, err := runner.BeginTxWithOpts(context.Background(), &sql.TxOptions{ReadOnly: true})
+		require.NoError(t, err)
+		require.NoError(t, tx.Rollback())
+
+		require.Equal(t, []driver.TxOptions{
+			{Isolation: driver.IsolationLevel(sql.LevelReadCommitted)},
+			{Isolation: driver.IsolationLevel(sql.LevelSerializable)},
+			{ReadOnly: true},
+		}, connector.txOpts)
+	}
+}
+
+func TestRetryableTxSession_TxAttemptsMetrics(t *testing.T) {
+	dbConn := openAndSeedDB(t)
+	defer func() {
+		require.NoError(t, dbConn.Close())
+	}()
+
+	retryableErr := errors.New("retryable error")
+	defer dbkit.WithIsRetryable(dbConn.Driver(), func(err error) bool { return errors.Is(err, retryableErr) })()
+
+	mc := dbkit.NewMetricsCollector()
+	txSess := NewRetryableTxSession(dbConn, nil, retry.NewConstantBackoffPolicy(time.Millisecond, 3))
+	txSess.MetricsCollector = mc
+
+	ctx := dbkit.NewContextWithTxAnnotation(context.Background(), "tx_update_users")
+
+	var calls int
+	require.NoError(t, txSess.DoInTx(ctx, func(runner dbr.SessionRunner) error {
+		if calls++; calls < 3 {
+			return retryableErr
+		}
+		return nil
+	}))
+	require.ErrorIs(t, txSess.DoInTx(ctx, func(runner dbr.SessionRunner) error {
+		return retryableErr
+	}), retryableErr)
+
+	for _, tt := range []struct {
+		outcome       string
+		wantSampleSum float64
+	}{
+		{outcome: dbkit.MetricsTxOutcomeCommitted, wantSampleSum: 3},
+		{outcome: dbkit.MetricsTxOutcomeFailed, wantSampleSum: 4},
+	} {
+		labels := prometheus.Labels{dbkit.MetricsLabelQuery: "tx_update_users", dbkit.MetricsLabelTxOutcome: tt.outcome}
+		var metric dto.Metric
+		require.NoError(t, mc.TxAttempts.With(labels).(prometheus.Histogram).Write(&metric))
+		require.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount(), tt.outcome)
+		require.Equal(t, tt.wantSampleSum, metric.GetHistogram().GetSampleSum(), tt.outcome)
+	}
+}
+
 func TestDbrOpen(t *testing.T) {
 	dbConn := openAndSeedDB(t)
 	defer func() {
@@ -139,6 +305,49 @@ func TestDbrSlowQueryLogEventReceiver_TimingKv(t *testing.T) {
 	})
 }
 
+func TestTxSession_SlowTxLogger(t *testing.T) {
+	dbConn := openAndSeedDB(t)
+	defer func() {
+		require.NoError(t, dbConn.Close())
+	}()
+
+	t.Run("fast transaction is not logged", func(t *testing.T) {
+		logRecorder := logtest.NewRecorder()
+		txSess := NewTxSession(dbConn, nil)
+		txSess.SlowTxLogger = NewSlowTxLogger(logRecorder, time.Minute, "query_")
+		require.NoError(t, txSess.DoInTx(context.Background(), func(runner dbr.SessionRunner) error {
+			countUsersByName(t, runner, "query_count_users_by_name", "Bob", 1)
+			return nil
+		}))
+		require.Equal(t, 0, len(logRecorder.Entries()))
+	})
+
+	t.Run("slow transaction is logged", func(t *testing.T) {
+		logRecorder := logtest.NewRecorder()
+		txSess := NewTxSession(dbConn, nil)
+		txSess.SlowTxLogger = NewSlowTxLogger(logRecorder, 0, "query_")
+		require.NoError(t, txSess.DoInTx(context.Background(), func(runner dbr.SessionRunner) error {
+			countUsersByName(t, runner, "query_count_users_by_name", "Bob", 1)
+			countUsersByName(t, runner, "", "Sam", 2)
+			countUsersByName(t, runner, "query_count_users_by_name_2", "John", 1)
+			return nil
+		}))
+
+		require.Equal(t, 1, len(logRecorder.Entries()))
+		logRecEntry := logRecorder.Entries()[0]
+		require.Equal(t, "slow SQL transaction", logRecEntry.Text)
+		logField, found := logRecEntry.FindField("statements")
+		require.True(t, found)
+		require.Equal(t, int64(3), logField.Int)
+		logField, found = logRecEntry.FindField("first_annotation")
+		require.True(t, found)
+		require.Equal(t, "query_count_users_by_name", string(logField.Bytes))
+		logField, found = logRecEntry.FindField("last_annotation")
+		require.True(t, found)
+		require.Equal(t, "query_count_users_by_name_2", string(logField.Bytes))
+	})
+}
+
 func TestDbrQueryMetricsEventReceiver_TimingKv(t *testing.T) {
 	dbConn := openAndSeedDB(t)
 	defer func() {
@@ -168,6 +377,107 @@ func TestDbrQueryMetricsEventReceiver_TimingKv(t *testing.T) {
 		hist := mc.QueryDurations.With(labels).(prometheus.Histogram)
 		testutil.RequireSamplesCountInHistogram(t, hist, 1)
 	})
+
+	t.Run("metrics are collected only for allowed annotations", func(t *testing.T) {
+		mc := dbkit.NewMetricsCollector()
+		metricsEventReceiver := NewQueryMetricsEventReceiverWithOpts(mc, QueryMetricsEventReceiverOpts{
+			AnnotationPrefix:   "query_",
+			AllowedAnnotations: []string{"query_count_users_by_name", "query_count_users_by_id"},
+			DeniedAnnotations:  []string{"query_count_users_by_id"},
+		})
+		dbSess := dbConn.NewSession(metricsEventReceiver)
+
+		countUsersByName(t, dbSess, "query_count_users_by_name", "Sam", 2)
+		countUsersByName(t, dbSess, "query_count_users_by_id", "Sam", 2)
+		countUsersByName(t, dbSess, "query_count_users_by_name_42", "Sam", 2)
+
+		for annotation, wantCount := range map[string]int{
+			"query_count_users_by_name":    1,
+			"query_count_users_by_id":      0,
+			"query_count_users_by_name_42": 0,
+		} {
+			hist := mc.QueryDurations.With(prometheus.Labels{dbkit.MetricsLabelQuery: annotation}).(prometheus.Histogram)
+			testutil.RequireSamplesCountInHistogram(t, hist, wantCount)
+		}
+	})
+
+	t.Run("filtered annotations are mapped", func(t *testing.T) {
+		mc := dbkit.NewMetricsCollector()
+		metricsEventReceiver := NewQueryMetricsEventReceiverWithOpts(mc, QueryMetricsEventReceiverOpts{
+			AnnotationPrefix: "query_",
+			AnnotationMapper: func(annotation string) string {
+				return strings.TrimRight(annotation, "_0123456789")
+			},
+			AllowedAnnotations: []string{"query_count_users_by_name"},
+			FilteredAnnotation: "query_other",
+		})
+		dbSess := dbConn.NewSession(metricsEventReceiver)
+
+		countUsersByName(t, dbSess, "query_count_users_by_name_42", "Sam", 2)
+		countUsersByName(t, dbSess, "query_count_users_by_name_43", "Sam", 2)
+		countUsersByName(t, dbSess, "query_count_users_tmp_1", "Sam", 2)
+
+		for annotation, wantCount := range map[string]int{
+			"query_count_users_by_name": 2,
+			"query_other":               1,
+		} {
+			hist := mc.QueryDurations.With(prometheus.Labels{dbkit.MetricsLabelQuery: annotation}).(prometheus.Histogram)
+			testutil.RequireSamplesCountInHistogram(t, hist, wantCount)
+		}
+	})
+
+	t.Run("errors of query are counted", func(t *testing.T) {
+		mc := dbkit.NewMetricsCollector()
+		metricsEventReceiver := NewQueryMetricsEventReceiver(mc, "query_")
+		dbSess := dbConn.NewSession(metricsEventReceiver)
+
+		var usersCount int
+		require.Error(t, dbSess.Select("COUNT(*)").From("unknown_table").Comment("query_count_unknown").LoadOne(&usersCount))
+
+		labels := prometheus.Labels{
+			dbkit.MetricsLabelQuery:      "query_count_unknown",
+			dbkit.MetricsLabelErrorClass: string(dbkit.QueryErrorClassOther),
+		}
+		var metric dto.Metric
+		require.NoError(t, mc.QueryErrors.With(labels).Write(&metric))
+		require.Equal(t, 1.0, metric.GetCounter().GetValue())
+	})
+
+	t.Run("metrics for query are collected in spans with exemplars", func(t *testing.T) {
+		mc := dbkit.NewMetricsCollectorWithOpts(dbkit.MetricsCollectorOpts{ExemplarLabels: otelmetrics.TraceExemplarLabels})
+		metricsEventReceiver := NewQueryMetricsEventReceiverWithOpts(mc, QueryMetricsEventReceiverOpts{
+			AnnotationPrefix: "query_",
+			ObserveInSpans:   true,
+		})
+		// Receiver may be used via CompositeEventReceiver as well.
+		dbSess := dbConn.NewSession(NewCompositeReceiver([]dbr.EventReceiver{metricsEventReceiver}))
+
+		traceID := trace.TraceID{0x01, 0x02, 0x03}
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{0x01},
+			TraceFlags: trace.FlagsSampled,
+		}))
+		var usersCount int
+		require.NoError(t, dbSess.Select("COUNT(*)").From("users").Comment("query_count_users").LoadOneContext(ctx, &usersCount))
+		require.Equal(t, 5, usersCount)
+
+		labels := prometheus.Labels{dbkit.MetricsLabelQuery: "query_count_users"}
+		hist := mc.QueryDurations.With(labels).(prometheus.Histogram)
+		testutil.RequireSamplesCountInHistogram(t, hist, 1)
+
+		var metric dto.Metric
+		require.NoError(t, hist.(prometheus.Metric).Write(&metric))
+		var exemplars []*dto.Exemplar
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			if bucket.GetExemplar() != nil {
+				exemplars = append(exemplars, bucket.GetExemplar())
+			}
+		}
+		require.Len(t, exemplars, 1)
+		require.Equal(t, otelmetrics.ExemplarLabelTraceID, exemplars[0].GetLabel()[0].GetName())
+		require.Equal(t, traceID.String(), exemplars[0].GetLabel()[0].GetValue())
+	})
 }
 
 func addExclamation(s string) string {
@@ -5,4 +5,9 @@ Released under MIT license.
 */
 
 // Package dbrutil provides utilities and helpers for dbr query builder.
+//
+// RetryableTxSession may observe numbers of attempts that transactions needed
+// in the db_tx_attempts histogram of dbkit.MetricsCollector (labeled by transaction annotation and outcome).
+// QueryMetricsEventReceiver supports allowlist/denylist and mapping of annotations (see QueryMetricsEventReceiverOpts)
+// to keep cardinality of the metrics labels under control.
 package dbrutil
@@ -7,10 +7,10 @@ Released under MIT license.
 package dbrutil
 
 import (
+	"context"
 	"time"
 
 	"github.com/gocraft/dbr/v2"
-	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/acronis/go-dbkit"
 )
@@ -19,28 +19,71 @@ import (
 type QueryMetricsEventReceiverOpts struct {
 	AnnotationPrefix   string
 	AnnotationModifier func(string) string
+
+	// ObserveInSpans enables observing query durations in the span methods (dbr.TracingEventReceiver interface)
+	// instead of TimingKv. Query context is available there, so exemplars (e.g. trace IDs) may be attached
+	// to the observations (see dbkit.MetricsCollector.ObserveQueryDuration).
+	// In this mode, receiver should be passed to dbr directly or via CompositeEventReceiver.
+	ObserveInSpans bool
+
+	// AnnotationMapper (if set) is applied to the parsed annotation before filtering.
+	// It may be used for normalizing dynamically generated annotations (e.g. trimming IDs).
+	// Empty string returned by the mapper means that query is not observed.
+	AnnotationMapper func(annotation string) string
+
+	// AllowedAnnotations (if not empty) restricts observed annotations to the configured set.
+	// It protects metrics from the cardinality explosion when annotations are generated dynamically.
+	AllowedAnnotations []string
+
+	// DeniedAnnotations contains annotations that are never observed.
+	DeniedAnnotations []string
+
+	// FilteredAnnotation (if set) is used as annotation for queries which annotations are not allowed
+	// (see AllowedAnnotations and DeniedAnnotations), so such queries are observed under this single label value.
+	// By default, such queries are not observed at all.
+	FilteredAnnotation string
 }
 
 // QueryMetricsEventReceiver implements the dbr.EventReceiver interface and collects metrics about SQL queries.
+// Metrics are collected via dbkit.QueryMetrics (Prometheus-based dbkit.MetricsCollector or otelmetrics.MetricsCollector).
 // To be collected SQL query should be annotated (comment starting with specified prefix).
 type QueryMetricsEventReceiver struct {
 	*dbr.NullEventReceiver
-	metricsCollector   *dbkit.MetricsCollector
+	metricsCollector   dbkit.QueryMetrics
 	annotationPrefix   string
 	annotationModifier func(string) string
+	observeInSpans     bool
+	annotationMapper   func(string) string
+	allowedAnnotations map[string]struct{}
+	deniedAnnotations  map[string]struct{}
+	filteredAnnotation string
+}
+
+var _ dbr.TracingEventReceiver = (*QueryMetricsEventReceiver)(nil)
+
+type queryMetricsSpanCtxKey struct{}
+
+type queryMetricsSpan struct {
+	annotation string
+	startTime  time.Time
 }
 
 // NewQueryMetricsEventReceiverWithOpts creates a new QueryMetricsEventReceiver with additinal options.
-func NewQueryMetricsEventReceiverWithOpts(mc *dbkit.MetricsCollector, options QueryMetricsEventReceiverOpts) *QueryMetricsEventReceiver {
+func NewQueryMetricsEventReceiverWithOpts(mc dbkit.QueryMetrics, options QueryMetricsEventReceiverOpts) *QueryMetricsEventReceiver {
 	return &QueryMetricsEventReceiver{
 		metricsCollector:   mc,
 		annotationPrefix:   options.AnnotationPrefix,
 		annotationModifier: options.AnnotationModifier,
+		observeInSpans:     options.ObserveInSpans,
+		annotationMapper:   options.AnnotationMapper,
+		allowedAnnotations: makeAnnotationsSet(options.AllowedAnnotations),
+		deniedAnnotations:  makeAnnotationsSet(options.DeniedAnnotations),
+		filteredAnnotation: options.FilteredAnnotation,
 	}
 }
 
 // NewQueryMetricsEventReceiver creates a new QueryMetricsEventReceiver.
-func NewQueryMetricsEventReceiver(mc *dbkit.MetricsCollector, annotationPrefix string) *QueryMetricsEventReceiver {
+func NewQueryMetricsEventReceiver(mc dbkit.QueryMetrics, annotationPrefix string) *QueryMetricsEventReceiver {
 	options := QueryMetricsEventReceiverOpts{
 		AnnotationPrefix: annotationPrefix,
 	}
@@ -50,10 +93,88 @@ func NewQueryMetricsEventReceiver(mc *dbkit.MetricsCollector, annotationPrefix s
 // TimingKv is called when SQL query is executed. It receives the duration of how long the query takes,
 // parses annotation from SQL comment and collects metrics.
 func (er *QueryMetricsEventReceiver) TimingKv(eventName string, nanoseconds int64, kvs map[string]string) {
-	annotation := ParseAnnotationInQuery(kvs["sql"], er.annotationPrefix, er.annotationModifier)
+	if er.observeInSpans {
+		return
+	}
+	annotation := er.parseAnnotation(kvs["sql"])
 	if annotation == "" {
 		return
 	}
-	labels := prometheus.Labels{dbkit.MetricsLabelQuery: annotation}
-	er.metricsCollector.QueryDurations.With(labels).Observe(time.Duration(nanoseconds).Seconds())
+	er.metricsCollector.ObserveQueryDuration(context.Background(), annotation, time.Duration(nanoseconds))
+}
+
+// EventErrKv is called when SQL query fails. It parses annotation from SQL comment and counts the error by its class.
+func (er *QueryMetricsEventReceiver) EventErrKv(eventName string, err error, kvs map[string]string) error {
+	annotation := er.parseAnnotation(kvs["sql"])
+	if annotation == "" {
+		return err
+	}
+	er.metricsCollector.ObserveQueryError(annotation, err)
+	return err
+}
+
+// SpanStart is called when SQL query is started (if ObserveInSpans option is enabled).
+// It parses annotation from SQL comment and saves it with the start time in the returned context.
+func (er *QueryMetricsEventReceiver) SpanStart(ctx context.Context, eventName, query string) context.Context {
+	if !er.observeInSpans {
+		return ctx
+	}
+	annotation := er.parseAnnotation(query)
+	if annotation == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, queryMetricsSpanCtxKey{}, &queryMetricsSpan{annotation: annotation, startTime: time.Now()})
+}
+
+// SpanError is called when SQL query fails. It does nothing.
+func (er *QueryMetricsEventReceiver) SpanError(ctx context.Context, err error) {}
+
+// SpanFinish is called when SQL query is finished (if ObserveInSpans option is enabled).
+// It collects metrics using the query context, so exemplars may be attached to the observations.
+func (er *QueryMetricsEventReceiver) SpanFinish(ctx context.Context) {
+	span, ok := ctx.Value(queryMetricsSpanCtxKey{}).(*queryMetricsSpan)
+	if !ok {
+		return
+	}
+	er.metricsCollector.ObserveQueryDuration(ctx, span.annotation, time.Since(span.startTime))
+}
+
+// parseAnnotation parses annotation from SQL query and applies mapping and filtering to it.
+// Empty string is returned if query should not be observed.
+func (er *QueryMetricsEventReceiver) parseAnnotation(query string) string {
+	annotation := ParseAnnotationInQuery(query, er.annotationPrefix, er.annotationModifier)
+	if annotation == "" {
+		return ""
+	}
+	if er.annotationMapper != nil {
+		if annotation = er.annotationMapper(annotation); annotation == "" {
+			return ""
+		}
+	}
+	if er.isAnnotationAllowed(annotation) {
+		return annotation
+	}
+	return er.filteredAnnotation
+}
+
+func (er *QueryMetricsEventReceiver) isAnnotationAllowed(annotation string) bool {
+	if _, denied := er.deniedAnnotations[annotation]; denied {
+		return false
+	}
+	if len(er.allowedAnnotations) == 0 {
+		return true
+	}
+	_, allowed := er.allowedAnnotations[annotation]
+	return allowed
+}
+
+func makeAnnotationsSet(annotations []string) map[string]struct{} {
+	if len(annotations) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(annotations))
+	for _, annotation := range annotations {
+		set[annotation] = struct{}{}
+	}
+	return set
 }
@@ -10,10 +10,15 @@ import (
 	"context"
 	"database/sql"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/acronis/go-appkit/httpserver/middleware"
+	"github.com/acronis/go-appkit/log"
 	"github.com/gocraft/dbr/v2"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type ctxKey int
@@ -31,6 +36,17 @@ type TxRunnerMiddlewareOpts struct {
 		AnnotationPrefix string
 	}
 	NewTxRunner NewTxRunnerFunc
+
+	// QueryBudget allows detecting requests (e.g. N+1 regressions) that execute too many SQL queries
+	// or spend too much time in the database. When MaxQueries or MaxTime (zero values mean no limit) is exceeded,
+	// warning with annotations (comments starting with AnnotationPrefix) of the executed queries is logged
+	// and ExceededCounter (if set) is incremented after the request is handled.
+	QueryBudget struct {
+		MaxQueries       int
+		MaxTime          time.Duration
+		AnnotationPrefix string
+		ExceededCounter  prometheus.Counter
+	}
 }
 
 type txRunnerHandler struct {
@@ -84,18 +100,102 @@ func (m *txRunnerHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	reqCtx := r.Context()
 
 	dbEventReceiver := m.dbConn.EventReceiver
-	if m.opts.SlowQueryLog.MinTime > 0 {
-		slowLogEventReceiver := NewSlowQueryLogEventReceiver(
-			middleware.GetLoggerFromContext(reqCtx), m.opts.SlowQueryLog.MinTime, m.opts.SlowQueryLog.AnnotationPrefix)
+	addEventReceiver := func(eventReceiver dbr.EventReceiver) {
 		if dbEventReceiver != nil {
-			dbEventReceiver = NewCompositeReceiver([]dbr.EventReceiver{dbEventReceiver, slowLogEventReceiver})
+			dbEventReceiver = NewCompositeReceiver([]dbr.EventReceiver{dbEventReceiver, eventReceiver})
 		} else {
-			dbEventReceiver = slowLogEventReceiver
+			dbEventReceiver = eventReceiver
 		}
 	}
+	if m.opts.SlowQueryLog.MinTime > 0 {
+		addEventReceiver(NewSlowQueryLogEventReceiver(
+			middleware.GetLoggerFromContext(reqCtx), m.opts.SlowQueryLog.MinTime, m.opts.SlowQueryLog.AnnotationPrefix))
+	}
+	var budgetEventReceiver *queryBudgetEventReceiver
+	if m.opts.QueryBudget.MaxQueries > 0 || m.opts.QueryBudget.MaxTime > 0 {
+		budgetEventReceiver = newQueryBudgetEventReceiver(m.opts.QueryBudget.AnnotationPrefix)
+		addEventReceiver(budgetEventReceiver)
+	}
 
 	dbSess := m.opts.NewTxRunner(m.dbConn, m.txOpts, dbEventReceiver)
 	m.next.ServeHTTP(rw, r.WithContext(NewContextWithTxRunnerByKey(reqCtx, dbSess, m.opts.ContextKey)))
+
+	if budgetEventReceiver != nil {
+		m.checkQueryBudget(reqCtx, budgetEventReceiver)
+	}
+}
+
+func (m *txRunnerHandler) checkQueryBudget(reqCtx context.Context, budgetEventReceiver *queryBudgetEventReceiver) {
+	queries, dbTime, annotations := budgetEventReceiver.stats()
+	budget := m.opts.QueryBudget
+	if (budget.MaxQueries <= 0 || queries <= budget.MaxQueries) && (budget.MaxTime <= 0 || dbTime <= budget.MaxTime) {
+		return
+	}
+	if budget.ExceededCounter != nil {
+		budget.ExceededCounter.Inc()
+	}
+	logger := middleware.GetLoggerFromContext(reqCtx)
+	if logger == nil {
+		return
+	}
+	logger.Warn("SQL query budget exceeded",
+		log.Int("queries", queries),
+		log.Int64("db_time_ms", dbTime.Milliseconds()),
+		log.Int("max_queries", budget.MaxQueries),
+		log.Int64("max_db_time_ms", budget.MaxTime.Milliseconds()),
+		log.String("annotations", annotations),
+	)
+}
+
+// queryBudgetEventReceiver counts SQL queries executed within the request and cumulative time spent on them.
+type queryBudgetEventReceiver struct {
+	*dbr.NullEventReceiver
+	annotationPrefix string
+
+	mu                sync.Mutex
+	queries           int
+	dbTime            time.Duration
+	annotations       []string
+	annotationsCounts map[string]int
+}
+
+func newQueryBudgetEventReceiver(annotationPrefix string) *queryBudgetEventReceiver {
+	return &queryBudgetEventReceiver{
+		NullEventReceiver: &dbr.NullEventReceiver{},
+		annotationPrefix:  annotationPrefix,
+		annotationsCounts: make(map[string]int),
+	}
+}
+
+// TimingKv is called when SQL query is executed.
+func (er *queryBudgetEventReceiver) TimingKv(eventName string, nanoseconds int64, kvs map[string]string) {
+	annotation := ParseAnnotationInQuery(kvs["sql"], er.annotationPrefix, nil)
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	er.queries++
+	er.dbTime += time.Duration(nanoseconds)
+	if annotation == "" {
+		return
+	}
+	if _, ok := er.annotationsCounts[annotation]; !ok {
+		er.annotations = append(er.annotations, annotation)
+	}
+	er.annotationsCounts[annotation]++
+}
+
+// stats returns number of executed queries, cumulative time spent on them,
+// and annotations with numbers of executions in order of first occurrence (e.g. "query_list_users(1),query_get_user(12)").
+func (er *queryBudgetEventReceiver) stats() (queries int, dbTime time.Duration, annotations string) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	var sb strings.Builder
+	for i, annotation := range er.annotations {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(annotation + "(" + strconv.Itoa(er.annotationsCounts[annotation]) + ")")
+	}
+	return er.queries, er.dbTime, sb.String()
 }
 
 // NewContextWithTxRunner creates a new context with TxRunner.
@@ -14,8 +14,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/acronis/go-appkit/httpserver/middleware"
+	"github.com/acronis/go-appkit/log/logtest"
 	"github.com/acronis/go-appkit/retry"
 	"github.com/gocraft/dbr/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -59,3 +63,60 @@ func TestTxRunnerMiddlewareUsesSessionFactory(t *testing.T) {
 	middleware.ServeHTTP(resp, req)
 	require.True(t, passed, "Implementation of middleware.ServeHTTP must use opts.NewSession!")
 }
+
+func TestTxRunnerMiddlewareQueryBudget(t *testing.T) {
+	dbConn := openAndSeedDB(t)
+	defer func() {
+		require.NoError(t, dbConn.Close())
+	}()
+
+	tests := []struct {
+		name         string
+		maxQueries   int
+		maxTime      time.Duration
+		wantExceeded bool
+	}{
+		{name: "budget is not exceeded", maxQueries: 3, maxTime: time.Minute, wantExceeded: false},
+		{name: "max queries is exceeded", maxQueries: 2, wantExceeded: true},
+		{name: "max time is exceeded", maxTime: time.Nanosecond, wantExceeded: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			exceededCounter := prometheus.NewCounter(prometheus.CounterOpts{Name: "query_budget_exceeded_total"})
+			opts := TxRunnerMiddlewareOpts{}
+			opts.QueryBudget.MaxQueries = tt.maxQueries
+			opts.QueryBudget.MaxTime = tt.maxTime
+			opts.QueryBudget.AnnotationPrefix = "query_"
+			opts.QueryBudget.ExceededCounter = exceededCounter
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				txRunner := GetTxRunnerFromContext(r.Context())
+				require.NoError(t, txRunner.DoInTx(r.Context(), func(runner dbr.SessionRunner) error {
+					countUsersByName(t, runner, "query_count_users_by_name", "Bob", 1)
+					countUsersByName(t, runner, "query_count_users_by_name", "Sam", 2)
+					countUsersByName(t, runner, "query_count_all_users", "John", 1)
+					return nil
+				}))
+			})
+
+			logRecorder := logtest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req = req.WithContext(middleware.NewContextWithLogger(req.Context(), logRecorder))
+			TxRunnerMiddlewareWithOpts(dbConn, sql.LevelDefault, opts)(next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if !tt.wantExceeded {
+				require.Equal(t, 0, len(logRecorder.Entries()))
+				require.Equal(t, 0.0, testutil.ToFloat64(exceededCounter))
+				return
+			}
+			require.Equal(t, 1.0, testutil.ToFloat64(exceededCounter))
+			require.Equal(t, 1, len(logRecorder.Entries()))
+			logRecEntry := logRecorder.Entries()[0]
+			require.Equal(t, "SQL query budget exceeded", logRecEntry.Text)
+			logField, found := logRecEntry.FindField("annotations")
+			require.True(t, found)
+			require.Equal(t, "query_count_users_by_name(2),query_count_all_users(1)", string(logField.Bytes))
+		})
+	}
+}
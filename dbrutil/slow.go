@@ -7,6 +7,7 @@ Released under MIT license.
 package dbrutil
 
 import (
+	"sync"
 	"time"
 
 	"github.com/acronis/go-appkit/log"
@@ -65,3 +66,83 @@ func (er *SlowQueryLogEventReceiver) TimingKv(eventName string, nanoseconds int6
 		log.Int64("duration_ms", nanoseconds/int64(time.Millisecond)),
 	)
 }
+
+// SlowTxLoggerOpts consists options for SlowTxLogger.
+type SlowTxLoggerOpts struct {
+	AnnotationPrefix   string
+	AnnotationModifier func(string) string
+}
+
+// SlowTxLogger logs SQL transactions (not queries) that take longer than the specified threshold.
+// It helps to catch handlers that hold transactions across external calls.
+// It should be set in TxSession.SlowTxLogger, number of executed statements
+// and the first/last annotations (comment starting with specified prefix) of them are logged.
+type SlowTxLogger struct {
+	logger             log.FieldLogger
+	longTxTime         time.Duration
+	annotationPrefix   string
+	annotationModifier func(string) string
+}
+
+// NewSlowTxLogger creates a new SlowTxLogger.
+func NewSlowTxLogger(logger log.FieldLogger, longTxTime time.Duration, annotationPrefix string) *SlowTxLogger {
+	return NewSlowTxLoggerWithOpts(logger, longTxTime, SlowTxLoggerOpts{AnnotationPrefix: annotationPrefix})
+}
+
+// NewSlowTxLoggerWithOpts creates a new SlowTxLogger with additional options.
+func NewSlowTxLoggerWithOpts(logger log.FieldLogger, longTxTime time.Duration, options SlowTxLoggerOpts) *SlowTxLogger {
+	return &SlowTxLogger{
+		logger:             logger,
+		longTxTime:         longTxTime,
+		annotationPrefix:   options.AnnotationPrefix,
+		annotationModifier: options.AnnotationModifier,
+	}
+}
+
+// trackTx replaces event receiver of the transaction for counting executed statements.
+// Returned function should be called when transaction is finished (committed or rolled back).
+func (l *SlowTxLogger) trackTx(tx *dbr.Tx) (finish func(committed bool)) {
+	startTime := time.Now()
+	tracker := &slowTxTracker{NullEventReceiver: &dbr.NullEventReceiver{}, logger: l}
+	tx.EventReceiver = NewCompositeReceiver([]dbr.EventReceiver{tx.EventReceiver, tracker})
+	return func(committed bool) {
+		elapsed := time.Since(startTime)
+		if elapsed < l.longTxTime {
+			return
+		}
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		l.logger.Warn("slow SQL transaction",
+			log.Int64("duration_ms", elapsed.Milliseconds()),
+			log.Int("statements", tracker.statements),
+			log.String("first_annotation", tracker.firstAnnotation),
+			log.String("last_annotation", tracker.lastAnnotation),
+			log.Bool("committed", committed),
+		)
+	}
+}
+
+type slowTxTracker struct {
+	*dbr.NullEventReceiver
+	logger *SlowTxLogger
+
+	mu              sync.Mutex
+	statements      int
+	firstAnnotation string
+	lastAnnotation  string
+}
+
+// TimingKv is called when SQL query is executed within the transaction.
+func (t *slowTxTracker) TimingKv(eventName string, nanoseconds int64, kvs map[string]string) {
+	annotation := ParseAnnotationInQuery(kvs["sql"], t.logger.annotationPrefix, t.logger.annotationModifier)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.statements++
+	if annotation == "" {
+		return
+	}
+	if t.firstAnnotation == "" {
+		t.firstAnnotation = annotation
+	}
+	t.lastAnnotation = annotation
+}
@@ -0,0 +1,343 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package dbtest provides helpers for running databases in Docker containers (via testcontainers) in tests.
+// Postgres (lib/pq and pgx drivers), MySQL (MariaDB) and MSSQL are currently supported.
+// SQL drivers are not registered by this package and should be imported explicitly (e.g. via dialect packages of dbkit):
+//
+//	import _ "github.com/acronis/go-dbkit/postgres"
+//
+// RunAndOpen returns both an opened *sql.DB and a dbkit.Config, so application code under the test may reuse the same container.
+// Setting DBKIT_TEST_REUSE_CONTAINERS=1 (together with TESTCONTAINERS_RYUK_DISABLED=true) makes containers long-lived
+// and shared between test runs, each run works with its own freshly created database.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mariadb"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// Default Docker images for the test databases.
+const (
+	DefaultPostgresImage = "postgres:16-alpine"
+	DefaultMariaDBImage  = "mariadb:11.0.3"
+	DefaultMSSQLImage    = "mcr.microsoft.com/mssql/server:2022-latest"
+)
+
+// Credentials and database name used in the test databases.
+const (
+	DBUser     = "root"
+	DBPassword = "password"
+	DBName     = "testdb"
+)
+
+// Credentials used in the MSSQL test database (MSSQL requires a strong password for the system administrator).
+const (
+	MSSQLDBUser     = "sa"
+	MSSQLDBPassword = "Passw0rd-Test"
+)
+
+const mssqlStartupTimeout = 2 * time.Minute
+
+const (
+	defaultTestConnMaxLifetime = 3 * time.Minute
+	defaultTestMaxOpenConns    = 16
+	defaultTestMaxIdleConns    = 16
+)
+
+// Opts represents an options for running the test database.
+type Opts struct {
+	// Image overrides the default Docker image (e.g. "postgres:15-alpine").
+	// Image should be compatible with the default one (Postgres or MariaDB).
+	Image string
+
+	// Env contains additional environment variables for the container.
+	Env map[string]string
+
+	// InitSQL contains SQL statements that are executed (in a single transaction) right after the database is started.
+	InitSQL []string
+
+	// ReuseContainer enables reusing of the container with the test database.
+	// It may be also enabled via the environment variable (see ReuseContainersEnvVar for details).
+	ReuseContainer bool
+}
+
+// TestDB represents a database running in a Docker container.
+type TestDB struct {
+	// DB is a connection to the test database.
+	DB *sql.DB
+
+	// Config contains configuration that may be used for opening additional connections to the test database
+	// (e.g. by the application code under the test).
+	Config *dbkit.Config
+
+	terminate func(ctx context.Context) error
+}
+
+// MustRunAndOpen does the same as RunAndOpen but panics on error.
+func MustRunAndOpen(ctx context.Context, dialect dbkit.Dialect, opts Opts) *TestDB {
+	testDB, err := RunAndOpen(ctx, dialect, opts)
+	if err != nil {
+		panic(fmt.Errorf("run and open test db: %w", err))
+	}
+	return testDB
+}
+
+// RunAndOpen creates a container with a test database and opens a connection to it.
+// TestDB.Stop should be called for closing connection and terminating the container.
+func RunAndOpen(ctx context.Context, dialect dbkit.Dialect, opts Opts) (testDB *TestDB, err error) {
+	cfg := &dbkit.Config{
+		Dialect:         dialect,
+		MaxOpenConns:    defaultTestMaxOpenConns,
+		MaxIdleConns:    defaultTestMaxIdleConns,
+		ConnMaxLifetime: defaultTestConnMaxLifetime,
+	}
+
+	reuse := isContainerReuseEnabled(opts)
+	var terminate func(ctx context.Context) error
+	switch dialect {
+	case dbkit.DialectPgx, dbkit.DialectPostgres:
+		if cfg.Postgres, terminate, err = startPostgresContainer(ctx, opts, reuse); err != nil {
+			return nil, fmt.Errorf("start postgres container: %w", err)
+		}
+	case dbkit.DialectMySQL:
+		if cfg.MySQL, terminate, err = startMariaDBContainer(ctx, opts, reuse); err != nil {
+			return nil, fmt.Errorf("start mariadb container: %w", err)
+		}
+	case dbkit.DialectMSSQL:
+		if cfg.MSSQL, terminate, err = startMSSQLContainer(ctx, opts, reuse); err != nil {
+			return nil, fmt.Errorf("start mssql container: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+	defer func() {
+		if err != nil {
+			_ = terminate(ctx)
+		}
+	}()
+
+	if reuse {
+		// The reused container may be shared with other test runs, so each run works with its own database.
+		dropRunDB, createErr := createRunDatabase(ctx, cfg)
+		if createErr != nil {
+			return nil, fmt.Errorf("create database for the test run: %w", createErr)
+		}
+		terminate = dropRunDB
+	}
+
+	dbConn, err := dbkit.Open(cfg, true)
+	if err != nil {
+		return nil, fmt.Errorf("open db: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = dbConn.Close()
+		}
+	}()
+
+	if len(opts.InitSQL) != 0 {
+		if err = dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			for _, query := range opts.InitSQL {
+				if _, execErr := tx.ExecContext(ctx, query); execErr != nil {
+					return fmt.Errorf("exec %q: %w", query, execErr)
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("init db: %w", err)
+		}
+	}
+
+	return &TestDB{DB: dbConn, Config: cfg, terminate: terminate}, nil
+}
+
+// Stop closes connection to the test database and terminates its container.
+// If the container is reused, it's not terminated, and the database created for the run is dropped instead.
+func (tdb *TestDB) Stop(ctx context.Context) error {
+	var errs []error
+	if closeDBErr := tdb.DB.Close(); closeDBErr != nil {
+		errs = append(errs, fmt.Errorf("close db: %w", closeDBErr))
+	}
+	if stopErr := tdb.terminate(ctx); stopErr != nil {
+		errs = append(errs, fmt.Errorf("stop db container: %w", stopErr))
+	}
+	return errors.Join(errs...)
+}
+
+func startPostgresContainer(
+	ctx context.Context, opts Opts, reuse bool,
+) (cfg dbkit.PostgresConfig, terminate func(ctx context.Context) error, err error) {
+	image := opts.Image
+	if image == "" {
+		image = DefaultPostgresImage
+	}
+	customizers := []testcontainers.ContainerCustomizer{
+		postgres.WithDatabase(DBName),
+		postgres.WithUsername(DBUser),
+		postgres.WithPassword(DBPassword),
+		testcontainers.WithEnv(opts.Env),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(10 * time.Second)),
+	}
+	if reuse {
+		customizers = append(customizers, withReusableContainer(makeReusableContainerName("postgres", image, opts.Env)))
+	}
+	postgresContainer, err := postgres.Run(ctx, image, customizers...)
+	if err != nil {
+		return cfg, nil, fmt.Errorf("create container: %w", err)
+	}
+	terminate = makeContainerTerminate(postgresContainer, reuse)
+	defer func() {
+		if err != nil {
+			_ = terminate(ctx)
+		}
+	}()
+	host, port, err := getContainerHostAndPort(ctx, postgresContainer, "5432/tcp")
+	if err != nil {
+		return cfg, nil, err
+	}
+	return dbkit.PostgresConfig{
+		Host:             host,
+		Port:             port,
+		User:             DBUser,
+		Password:         DBPassword,
+		Database:         DBName,
+		TxIsolationLevel: dbkit.PostgresDefaultTxLevel,
+		SSLMode:          dbkit.PostgresSSLModeDisable,
+	}, terminate, nil
+}
+
+func startMariaDBContainer(
+	ctx context.Context, opts Opts, reuse bool,
+) (cfg dbkit.MySQLConfig, terminate func(ctx context.Context) error, err error) {
+	image := opts.Image
+	if image == "" {
+		image = DefaultMariaDBImage
+	}
+	customizers := []testcontainers.ContainerCustomizer{
+		mariadb.WithDatabase(DBName),
+		mariadb.WithUsername(DBUser),
+		mariadb.WithPassword(DBPassword),
+		testcontainers.WithEnv(opts.Env),
+	}
+	if reuse {
+		customizers = append(customizers, withReusableContainer(makeReusableContainerName("mariadb", image, opts.Env)))
+	}
+	mariaDBContainer, err := mariadb.Run(ctx, image, customizers...)
+	if err != nil {
+		return cfg, nil, fmt.Errorf("create container: %w", err)
+	}
+	terminate = makeContainerTerminate(mariaDBContainer, reuse)
+	defer func() {
+		if err != nil {
+			_ = terminate(ctx)
+		}
+	}()
+	host, port, err := getContainerHostAndPort(ctx, mariaDBContainer, "3306/tcp")
+	if err != nil {
+		return cfg, nil, err
+	}
+	return dbkit.MySQLConfig{
+		Host:             host,
+		Port:             port,
+		User:             DBUser,
+		Password:         DBPassword,
+		Database:         DBName,
+		TxIsolationLevel: dbkit.MySQLDefaultTxLevel,
+	}, terminate, nil
+}
+
+func startMSSQLContainer(
+	ctx context.Context, opts Opts, reuse bool,
+) (cfg dbkit.MSSQLConfig, terminate func(ctx context.Context) error, err error) {
+	const port = "1433/tcp"
+	image := opts.Image
+	if image == "" {
+		image = DefaultMSSQLImage
+	}
+	env := map[string]string{"ACCEPT_EULA": "Y", "MSSQL_SA_PASSWORD": MSSQLDBPassword}
+	for k, v := range opts.Env {
+		env[k] = v
+	}
+	req := testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        image,
+			ExposedPorts: []string{port},
+			Env:          env,
+			WaitingFor: wait.ForAll(
+				wait.ForLog("Recovery is complete"),
+				wait.ForListeningPort(port),
+			).WithDeadline(mssqlStartupTimeout),
+		},
+		Started: true,
+	}
+	if reuse {
+		req.Name = makeReusableContainerName("mssql", image, opts.Env)
+		req.Reuse = true
+	}
+	mssqlContainer, err := testcontainers.GenericContainer(ctx, req)
+	if err != nil {
+		return cfg, nil, fmt.Errorf("create container: %w", err)
+	}
+	terminate = makeContainerTerminate(mssqlContainer, reuse)
+	defer func() {
+		if err != nil {
+			_ = terminate(ctx)
+		}
+	}()
+	host, mappedPort, err := getContainerHostAndPort(ctx, mssqlContainer, port)
+	if err != nil {
+		return cfg, nil, err
+	}
+	cfg = dbkit.MSSQLConfig{
+		Host:             host,
+		Port:             mappedPort,
+		User:             MSSQLDBUser,
+		Password:         MSSQLDBPassword,
+		Database:         "master",
+		TxIsolationLevel: dbkit.MSSQLDefaultTxLevel,
+	}
+
+	// MSSQL container doesn't allow to specify a database that should be created on startup.
+	masterDB, err := dbkit.Open(&dbkit.Config{Dialect: dbkit.DialectMSSQL, MSSQL: cfg}, true)
+	if err != nil {
+		return cfg, nil, fmt.Errorf("open master db: %w", err)
+	}
+	defer func() { _ = masterDB.Close() }()
+	// Database may already exist if the container is reused.
+	if _, err = masterDB.ExecContext(ctx, "IF DB_ID('"+DBName+"') IS NULL CREATE DATABASE "+DBName); err != nil {
+		return cfg, nil, fmt.Errorf("create database: %w", err)
+	}
+	cfg.Database = DBName
+
+	return cfg, terminate, nil
+}
+
+func getContainerHostAndPort(ctx context.Context, container testcontainers.Container, port nat.Port) (string, int, error) {
+	host, err := container.Host(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf("get container host: %w", err)
+	}
+	mappedPort, err := container.MappedPort(ctx, port)
+	if err != nil {
+		return "", 0, fmt.Errorf("get container mapped port: %w", err)
+	}
+	return host, mappedPort.Int(), nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	_ "github.com/acronis/go-dbkit/mssql"
+	_ "github.com/acronis/go-dbkit/mysql"
+	_ "github.com/acronis/go-dbkit/pgx"
+	_ "github.com/acronis/go-dbkit/postgres"
+)
+
+func TestRunAndOpen(t *testing.T) {
+	for _, dialect := range []dbkit.Dialect{dbkit.DialectPostgres, dbkit.DialectPgx, dbkit.DialectMySQL, dbkit.DialectMSSQL} {
+		dialect := dialect
+		t.Run(string(dialect), func(t *testing.T) {
+			ctx, ctxCancel := context.WithTimeout(context.Background(), 3*time.Minute)
+			defer ctxCancel()
+
+			testDB, err := RunAndOpen(ctx, dialect, Opts{InitSQL: []string{
+				"CREATE TABLE users (id INT PRIMARY KEY, name VARCHAR(64) NOT NULL)",
+				"INSERT INTO users (id, name) VALUES (1, 'Bob')",
+			}})
+			require.NoError(t, err)
+			defer func() { require.NoError(t, testDB.Stop(ctx)) }()
+
+			require.Equal(t, dialect, testDB.Config.Dialect)
+
+			// The returned config allows opening additional connections to the same database.
+			dbConn, err := dbkit.Open(testDB.Config, true)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, dbConn.Close()) }()
+
+			var name string
+			require.NoError(t, dbConn.QueryRowContext(ctx, "SELECT name FROM users WHERE id = 1").Scan(&name))
+			require.Equal(t, "Bob", name)
+		})
+	}
+}
+
+func TestRunAndOpen_UnsupportedDialect(t *testing.T) {
+	_, err := RunAndOpen(context.Background(), dbkit.DialectSQLite, Opts{})
+	require.EqualError(t, err, `unsupported sql dialect "sqlite3"`)
+}
+
+func TestTestDB_Stop(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	closeErr := errors.New("close error")
+	mock.ExpectClose().WillReturnError(closeErr)
+	terminateErr := errors.New("terminate error")
+
+	testDB := &TestDB{DB: db, terminate: func(ctx context.Context) error { return terminateErr }}
+	err = testDB.Stop(context.Background())
+	require.ErrorIs(t, err, closeErr)
+	require.ErrorIs(t, err, terminateErr)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
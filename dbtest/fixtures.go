@@ -0,0 +1,275 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// FixturesOpts represents an options for LoadFixturesWithOpts.
+type FixturesOpts struct {
+	// Truncate enables deleting all existing rows from the tables before inserting fixtures.
+	Truncate bool
+}
+
+// LoadFixtures inserts rows from fixture files into the database.
+// See LoadFixturesWithOpts for details.
+func LoadFixtures(ctx context.Context, db *sql.DB, dialect dbkit.Dialect, fsys fs.FS, dir string) error {
+	return LoadFixturesWithOpts(ctx, db, dialect, fsys, dir, FixturesOpts{})
+}
+
+// LoadFixturesWithOpts inserts rows from fixture files (*.yml, *.yaml or *.json) located in the passed directory.
+// Each file contains rows for a single table, table name is the file name without extension.
+// File content is a list of rows, each row is a map from column name to value:
+//
+//	# users.yml
+//	- id: 1
+//	  name: Bob
+//	- id: 2
+//	  name: Alice
+//
+// Nested maps and lists are inserted as JSON strings.
+// Tables are filled in the order determined by foreign keys (referenced tables first),
+// and cleared (if FixturesOpts.Truncate is enabled) in the reverse order.
+// All changes are made in a single transaction.
+func LoadFixturesWithOpts(
+	ctx context.Context, db *sql.DB, dialect dbkit.Dialect, fsys fs.FS, dir string, opts FixturesOpts,
+) error {
+	fixtures, err := readFixtures(fsys, dir)
+	if err != nil {
+		return err
+	}
+	if len(fixtures) == 0 {
+		return nil
+	}
+	tables := make([]string, 0, len(fixtures))
+	for table := range fixtures {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	deps, err := getForeignKeyDependencies(ctx, db, dialect, tables)
+	if err != nil {
+		return fmt.Errorf("get foreign keys: %w", err)
+	}
+	if tables, err = sortTablesByDependencies(tables, deps); err != nil {
+		return err
+	}
+
+	return dbkit.DoInTx(ctx, db, func(tx *sql.Tx) error {
+		if opts.Truncate {
+			for i := len(tables) - 1; i >= 0; i-- {
+				if _, execErr := tx.ExecContext(ctx, "DELETE FROM "+dbkit.QuoteIdentifier(dialect, tables[i])); execErr != nil {
+					return fmt.Errorf("delete rows from %q table: %w", tables[i], execErr)
+				}
+			}
+		}
+		for _, table := range tables {
+			for i, row := range fixtures[table] {
+				query, args, buildErr := buildInsertQuery(dialect, table, row)
+				if buildErr != nil {
+					return fmt.Errorf("build insert query for row #%d of %q table: %w", i+1, table, buildErr)
+				}
+				if _, execErr := tx.ExecContext(ctx, query, args...); execErr != nil {
+					return fmt.Errorf("insert row #%d into %q table: %w", i+1, table, execErr)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func readFixtures(fsys fs.FS, dir string) (map[string][]map[string]interface{}, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read fixtures directory: %w", err)
+	}
+	fixtures := make(map[string][]map[string]interface{})
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := path.Ext(entry.Name())
+		switch ext {
+		case ".yml", ".yaml", ".json":
+		default:
+			continue
+		}
+		table := strings.TrimSuffix(entry.Name(), ext)
+		if _, exists := fixtures[table]; exists {
+			return nil, fmt.Errorf("more than one fixture file for %q table", table)
+		}
+		data, readErr := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if readErr != nil {
+			return nil, fmt.Errorf("read fixture file %q: %w", entry.Name(), readErr)
+		}
+		var rows []map[string]interface{}
+		// JSON is a subset of YAML, so both formats may be parsed by the YAML decoder.
+		if unmarshalErr := yaml.Unmarshal(data, &rows); unmarshalErr != nil {
+			return nil, fmt.Errorf("parse fixture file %q: %w", entry.Name(), unmarshalErr)
+		}
+		fixtures[table] = rows
+	}
+	return fixtures, nil
+}
+
+func buildInsertQuery(dialect dbkit.Dialect, table string, row map[string]interface{}) (string, []interface{}, error) {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	quotedColumns := make([]string, 0, len(columns))
+	placeholders := make([]string, 0, len(columns))
+	args := make([]interface{}, 0, len(columns))
+	for i, column := range columns {
+		quotedColumns = append(quotedColumns, dbkit.QuoteIdentifier(dialect, column))
+		placeholders = append(placeholders, dbkit.MakePlaceholder(dialect, i+1))
+		val := row[column]
+		switch val.(type) {
+		case map[string]interface{}, []interface{}:
+			data, err := json.Marshal(val)
+			if err != nil {
+				return "", nil, fmt.Errorf("marshal value of %q column to JSON: %w", column, err)
+			}
+			val = string(data)
+		}
+		args = append(args, val)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		dbkit.QuoteIdentifier(dialect, table), strings.Join(quotedColumns, ", "), strings.Join(placeholders, ", "))
+	return query, args, nil
+}
+
+// getForeignKeyDependencies returns map from table name to names of tables it references.
+func getForeignKeyDependencies(
+	ctx context.Context, db *sql.DB, dialect dbkit.Dialect, tables []string,
+) (map[string][]string, error) {
+	deps := make(map[string][]string)
+	addDep := func(table, refTable string) {
+		if table != refTable {
+			deps[table] = append(deps[table], refTable)
+		}
+	}
+
+	if dialect == dbkit.DialectSQLite {
+		for _, table := range tables {
+			refTables, err := queryStrings(ctx, db, `SELECT "table" FROM pragma_foreign_key_list(?)`, table)
+			if err != nil {
+				return nil, err
+			}
+			for _, refTable := range refTables {
+				addDep(table, refTable)
+			}
+		}
+		return deps, nil
+	}
+
+	var query string
+	switch dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		query = `SELECT tc.table_name, ccu.table_name FROM information_schema.table_constraints tc
+JOIN information_schema.constraint_column_usage ccu
+ON tc.constraint_name = ccu.constraint_name AND tc.constraint_schema = ccu.constraint_schema
+WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema()`
+	case dbkit.DialectMySQL:
+		query = `SELECT table_name, referenced_table_name FROM information_schema.key_column_usage
+WHERE table_schema = DATABASE() AND referenced_table_name IS NOT NULL`
+	case dbkit.DialectMSSQL:
+		query = `SELECT OBJECT_NAME(parent_object_id), OBJECT_NAME(referenced_object_id) FROM sys.foreign_keys`
+	default:
+		return nil, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var table, refTable string
+		if err = rows.Scan(&table, &refTable); err != nil {
+			return nil, err
+		}
+		addDep(table, refTable)
+	}
+	return deps, rows.Err()
+}
+
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryStrings(ctx context.Context, db sqlQuerier, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var result []string
+	for rows.Next() {
+		var s string
+		if err = rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// sortTablesByDependencies sorts tables topologically, so referenced tables go first.
+// Dependencies on tables that are not in the list are ignored.
+func sortTablesByDependencies(tables []string, deps map[string][]string) ([]string, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	inList := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		inList[table] = true
+	}
+	states := make(map[string]int, len(tables))
+	sorted := make([]string, 0, len(tables))
+	var visit func(table string) error
+	visit = func(table string) error {
+		switch states[table] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("cyclic foreign key dependency between fixture tables (%q table)", table)
+		}
+		states[table] = visiting
+		for _, refTable := range deps[table] {
+			if !inList[refTable] {
+				continue
+			}
+			if err := visit(refTable); err != nil {
+				return err
+			}
+		}
+		states[table] = visited
+		sorted = append(sorted, table)
+		return nil
+	}
+	for _, table := range tables {
+		if err := visit(table); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
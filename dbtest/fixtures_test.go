@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	_ "github.com/acronis/go-dbkit/sqlite"
+)
+
+func TestLoadFixtures(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "fixtures.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL)`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `CREATE TABLE notes (
+id INTEGER NOT NULL PRIMARY KEY, content TEXT, meta TEXT, user_id INTEGER NOT NULL REFERENCES users(id))`)
+	require.NoError(t, err)
+
+	// Notes are read before users (alphabetical order), but users should be inserted first.
+	fsys := fstest.MapFS{
+		"fixtures/notes.json": {Data: []byte(`[{"id": 1, "content": "first-note", "user_id": 2, "meta": {"tags": ["a", "b"]}}]`)},
+		"fixtures/users.yml": {Data: []byte(`
+- id: 1
+  name: Albert
+- id: 2
+  name: Bob
+`)},
+		"fixtures/README.md": {Data: []byte(`Not a fixture.`)},
+	}
+	db.SetMaxOpenConns(1) // PRAGMA foreign_keys is set per connection.
+	_, err = db.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	require.NoError(t, err)
+
+	require.NoError(t, LoadFixtures(ctx, db, dbkit.DialectSQLite, fsys, "fixtures"))
+
+	var usersCount int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&usersCount))
+	require.Equal(t, 2, usersCount)
+	var content, meta string
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT content, meta FROM notes WHERE user_id = 2").Scan(&content, &meta))
+	require.Equal(t, "first-note", content)
+	require.JSONEq(t, `{"tags": ["a", "b"]}`, meta)
+
+	// Loading the same fixtures fails without truncation because of duplicated primary keys.
+	require.Error(t, LoadFixtures(ctx, db, dbkit.DialectSQLite, fsys, "fixtures"))
+	require.NoError(t, LoadFixturesWithOpts(ctx, db, dbkit.DialectSQLite, fsys, "fixtures", FixturesOpts{Truncate: true}))
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&usersCount))
+	require.Equal(t, 2, usersCount)
+}
+
+func TestSortTablesByDependencies(t *testing.T) {
+	sorted, err := sortTablesByDependencies([]string{"a", "b", "c"}, map[string][]string{"a": {"b"}, "b": {"c", "external"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b", "a"}, sorted)
+
+	_, err = sortTablesByDependencies([]string{"a", "b"}, map[string][]string{"a": {"b"}, "b": {"a"}})
+	require.Error(t, err)
+}
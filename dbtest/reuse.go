@@ -0,0 +1,123 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/testcontainers/testcontainers-go"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// ReuseContainersEnvVar is a name of the environment variable that enables reusing of containers with test databases.
+// If it's set to true (e.g. DBKIT_TEST_REUSE_CONTAINERS=1), containers are not terminated on TestDB.Stop
+// and are reused by the following RunAndOpen calls (including ones from other test binaries and test runs).
+// Each RunAndOpen call creates a separate database with a unique name inside the reused container,
+// so test runs are isolated from each other. This database is dropped on TestDB.Stop.
+//
+// Note that testcontainers terminates all started containers at the end of the test session by default
+// (via the Ryuk container). Set TESTCONTAINERS_RYUK_DISABLED=true for keeping containers between test runs.
+const ReuseContainersEnvVar = "DBKIT_TEST_REUSE_CONTAINERS"
+
+func isContainerReuseEnabled(opts Opts) bool {
+	if opts.ReuseContainer {
+		return true
+	}
+	reuse, _ := strconv.ParseBool(os.Getenv(ReuseContainersEnvVar))
+	return reuse
+}
+
+// makeReusableContainerName returns the name of the reusable container.
+// The name depends on the image and environment variables, so containers with different settings are not mixed up.
+func makeReusableContainerName(kind string, image string, env map[string]string) string {
+	envKeys := make([]string, 0, len(env))
+	for k := range env {
+		envKeys = append(envKeys, k)
+	}
+	sort.Strings(envKeys)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(image))
+	for _, k := range envKeys {
+		_, _ = h.Write([]byte("\x00" + k + "=" + env[k]))
+	}
+	return fmt.Sprintf("dbkit-test-%s-%08x", kind, h.Sum32())
+}
+
+func withReusableContainer(name string) testcontainers.CustomizeRequestOption {
+	return func(req *testcontainers.GenericContainerRequest) error {
+		req.Name = name
+		req.Reuse = true
+		return nil
+	}
+}
+
+func makeContainerTerminate(container testcontainers.Container, reuse bool) func(ctx context.Context) error {
+	if reuse {
+		return func(ctx context.Context) error { return nil }
+	}
+	return container.Terminate
+}
+
+// createRunDatabase creates a database with a unique name for the current run in the (reused) container
+// and switches the passed configuration to it. Returned function drops this database.
+func createRunDatabase(ctx context.Context, cfg *dbkit.Config) (drop func(ctx context.Context) error, err error) {
+	adminCfg := *cfg
+	dbName := DBName + "_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if err = execAdminQueries(ctx, &adminCfg, "CREATE DATABASE "+dbName); err != nil {
+		return nil, err
+	}
+	setConfigDatabase(cfg, dbName)
+
+	return func(ctx context.Context) error {
+		var dropQueries []string
+		switch adminCfg.Dialect {
+		case dbkit.DialectPostgres, dbkit.DialectPgx:
+			dropQueries = []string{"DROP DATABASE IF EXISTS " + dbName + " WITH (FORCE)"}
+		case dbkit.DialectMSSQL:
+			dropQueries = []string{
+				"ALTER DATABASE " + dbName + " SET SINGLE_USER WITH ROLLBACK IMMEDIATE",
+				"DROP DATABASE IF EXISTS " + dbName,
+			}
+		default:
+			dropQueries = []string{"DROP DATABASE IF EXISTS " + dbName}
+		}
+		return execAdminQueries(ctx, &adminCfg, dropQueries...)
+	}, nil
+}
+
+func execAdminQueries(ctx context.Context, cfg *dbkit.Config, queries ...string) error {
+	adminDB, err := dbkit.Open(cfg, true)
+	if err != nil {
+		return fmt.Errorf("open db: %w", err)
+	}
+	defer func() { _ = adminDB.Close() }()
+	for _, query := range queries {
+		if _, err = adminDB.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("exec %q: %w", query, err)
+		}
+	}
+	return nil
+}
+
+func setConfigDatabase(cfg *dbkit.Config, dbName string) {
+	switch cfg.Dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		cfg.Postgres.Database = dbName
+	case dbkit.DialectMySQL:
+		cfg.MySQL.Database = dbName
+	case dbkit.DialectMSSQL:
+		cfg.MSSQL.Database = dbName
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func TestIsContainerReuseEnabled(t *testing.T) {
+	t.Setenv(ReuseContainersEnvVar, "")
+	require.False(t, isContainerReuseEnabled(Opts{}))
+	require.True(t, isContainerReuseEnabled(Opts{ReuseContainer: true}))
+
+	t.Setenv(ReuseContainersEnvVar, "true")
+	require.True(t, isContainerReuseEnabled(Opts{}))
+
+	t.Setenv(ReuseContainersEnvVar, "invalid")
+	require.False(t, isContainerReuseEnabled(Opts{}))
+}
+
+func TestMakeReusableContainerName(t *testing.T) {
+	name := makeReusableContainerName("postgres", DefaultPostgresImage, map[string]string{"A": "1", "B": "2"})
+	require.Regexp(t, `^dbkit-test-postgres-[0-9a-f]{8}$`, name)
+	require.Equal(t, name, makeReusableContainerName("postgres", DefaultPostgresImage, map[string]string{"B": "2", "A": "1"}))
+	require.NotEqual(t, name, makeReusableContainerName("postgres", DefaultPostgresImage, map[string]string{"A": "1"}))
+	require.NotEqual(t, name, makeReusableContainerName("postgres", "postgres:15-alpine", map[string]string{"A": "1", "B": "2"}))
+}
+
+func TestSetConfigDatabase(t *testing.T) {
+	cfg := &dbkit.Config{Dialect: dbkit.DialectMySQL}
+	setConfigDatabase(cfg, "testdb_run")
+	require.Equal(t, "testdb_run", cfg.MySQL.Database)
+}
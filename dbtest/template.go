@@ -0,0 +1,160 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/acronis/go-appkit/log"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+// DefaultTemplateDBName is a default name of the template database.
+const DefaultTemplateDBName = "testdb_template"
+
+// TemplatePoolOpts represents an options for TemplatePool.
+type TemplatePoolOpts struct {
+	// TemplateDBName is a name of the template database. DefaultTemplateDBName is used if it's empty.
+	// Names of the databases created from the template are prefixed with it.
+	TemplateDBName string
+
+	// Migrations are applied to the template database once.
+	Migrations []migrate.Migration
+
+	// Prepare (optional) is called after applying migrations to the template database (e.g. for seeding data).
+	Prepare func(ctx context.Context, db *sql.DB) error
+}
+
+// TemplatePool creates isolated Postgres databases for tests from the single migrated template database.
+// Creating a database from the template (CREATE DATABASE ... TEMPLATE) is much faster than applying migrations,
+// so each test (including parallel ones) may have its own database.
+type TemplatePool struct {
+	testDB         *TestDB
+	templateDBName string
+	counter        atomic.Uint64
+	createMu       sync.Mutex
+}
+
+// NewTemplatePool creates the template database in the container of the passed test database,
+// applies migrations to it and returns a pool that creates new databases from this template.
+func NewTemplatePool(ctx context.Context, testDB *TestDB, opts TemplatePoolOpts) (*TemplatePool, error) {
+	if testDB.Config.Dialect != dbkit.DialectPostgres && testDB.Config.Dialect != dbkit.DialectPgx {
+		return nil, fmt.Errorf("template databases are not supported for %q dialect", testDB.Config.Dialect)
+	}
+	templateDBName := opts.TemplateDBName
+	if templateDBName == "" {
+		templateDBName = DefaultTemplateDBName
+	}
+	if _, err := testDB.DB.ExecContext(ctx, "CREATE DATABASE "+dbkit.QuoteIdentifier(dbkit.DialectPostgres, templateDBName)); err != nil {
+		return nil, fmt.Errorf("create template database: %w", err)
+	}
+	p := &TemplatePool{testDB: testDB, templateDBName: templateDBName}
+	if err := p.prepareTemplate(ctx, opts); err != nil {
+		_ = p.dropDB(ctx, templateDBName)
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *TemplatePool) prepareTemplate(ctx context.Context, opts TemplatePoolOpts) error {
+	templateDB, err := dbkit.Open(p.makeConfig(p.templateDBName), true)
+	if err != nil {
+		return fmt.Errorf("open template database: %w", err)
+	}
+	// No connections to the template database should exist, otherwise CREATE DATABASE ... TEMPLATE fails.
+	defer func() { _ = templateDB.Close() }()
+
+	if len(opts.Migrations) != 0 {
+		migMngr, migErr := migrate.NewMigrationsManager(templateDB, p.testDB.Config.Dialect, log.NewDisabledLogger())
+		if migErr != nil {
+			return migErr
+		}
+		if migErr = migMngr.Run(opts.Migrations, migrate.MigrationsDirectionUp); migErr != nil {
+			return fmt.Errorf("apply migrations to template database: %w", migErr)
+		}
+	}
+	if opts.Prepare != nil {
+		if err = opts.Prepare(ctx, templateDB); err != nil {
+			return fmt.Errorf("prepare template database: %w", err)
+		}
+	}
+	return nil
+}
+
+// CreateDB creates a new database from the template and opens a connection to it.
+// Returned drop function closes the connection and drops the database.
+func (p *TemplatePool) CreateDB(
+	ctx context.Context,
+) (db *sql.DB, cfg *dbkit.Config, drop func(ctx context.Context) error, err error) {
+	dbName := fmt.Sprintf("%s_%d", p.templateDBName, p.counter.Add(1))
+	if err = p.createDBFromTemplate(ctx, dbName); err != nil {
+		return nil, nil, nil, err
+	}
+	cfg = p.makeConfig(dbName)
+	if db, err = dbkit.Open(cfg, true); err != nil {
+		_ = p.dropDB(ctx, dbName)
+		return nil, nil, nil, fmt.Errorf("open database %q: %w", dbName, err)
+	}
+	return db, cfg, func(ctx context.Context) error {
+		_ = db.Close()
+		return p.dropDB(ctx, dbName)
+	}, nil
+}
+
+// NewDB creates a new database from the template for the test.
+// The database is dropped automatically when the test and all its subtests complete.
+func (p *TemplatePool) NewDB(t testing.TB) (*sql.DB, *dbkit.Config) {
+	t.Helper()
+	db, cfg, drop, err := p.CreateDB(context.Background())
+	if err != nil {
+		t.Fatalf("create database from template: %v", err)
+	}
+	t.Cleanup(func() {
+		if dropErr := drop(context.Background()); dropErr != nil {
+			t.Errorf("drop database created from template: %v", dropErr)
+		}
+	})
+	return db, cfg
+}
+
+// Close drops the template database.
+func (p *TemplatePool) Close(ctx context.Context) error {
+	return p.dropDB(ctx, p.templateDBName)
+}
+
+func (p *TemplatePool) createDBFromTemplate(ctx context.Context, dbName string) error {
+	// Concurrent creation of databases from the same template may fail, so it's serialized.
+	p.createMu.Lock()
+	defer p.createMu.Unlock()
+	query := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+		dbkit.QuoteIdentifier(dbkit.DialectPostgres, dbName), dbkit.QuoteIdentifier(dbkit.DialectPostgres, p.templateDBName))
+	if _, err := p.testDB.DB.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("create database %q from template: %w", dbName, err)
+	}
+	return nil
+}
+
+func (p *TemplatePool) dropDB(ctx context.Context, dbName string) error {
+	query := fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", dbkit.QuoteIdentifier(dbkit.DialectPostgres, dbName))
+	if _, err := p.testDB.DB.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("drop database %q: %w", dbName, err)
+	}
+	return nil
+}
+
+func (p *TemplatePool) makeConfig(dbName string) *dbkit.Config {
+	cfg := *p.testDB.Config
+	cfg.Postgres.Database = dbName
+	return &cfg
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+func TestTemplatePool(t *testing.T) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer ctxCancel()
+
+	testDB, err := RunAndOpen(ctx, dbkit.DialectPostgres, Opts{})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, testDB.Stop(ctx)) }()
+
+	pool, err := NewTemplatePool(ctx, testDB, TemplatePoolOpts{
+		Migrations: []migrate.Migration{migrate.NewCustomMigration("00001_create_users",
+			[]string{"CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT NOT NULL)"},
+			[]string{"DROP TABLE users"}, nil, nil)},
+		Prepare: func(ctx context.Context, db *sql.DB) error {
+			_, execErr := db.ExecContext(ctx, "INSERT INTO users (name) VALUES ('admin')")
+			return execErr
+		},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, pool.Close(ctx)) }()
+
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			i := i
+			t.Run(fmt.Sprintf("isolated db #%d", i), func(t *testing.T) {
+				t.Parallel()
+				db, cfg := pool.NewDB(t)
+				require.NotEqual(t, testDB.Config.Postgres.Database, cfg.Postgres.Database)
+
+				_, err := db.ExecContext(ctx, "INSERT INTO users (name) VALUES ($1)", fmt.Sprintf("user-%d", i))
+				require.NoError(t, err)
+				var usersCount int
+				require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&usersCount))
+				require.Equal(t, 2, usersCount) // Seeded admin and the inserted user.
+			})
+		}
+	})
+}
+
+func TestNewTemplatePool_UnsupportedDialect(t *testing.T) {
+	_, err := NewTemplatePool(context.Background(), &TestDB{Config: &dbkit.Config{Dialect: dbkit.DialectMySQL}}, TemplatePoolOpts{})
+	require.EqualError(t, err, `template databases are not supported for "mysql" dialect`)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// TruncateAll deletes all rows from all user tables (in the current schema/database) except the passed ones.
+// Foreign keys are handled per dialect (TRUNCATE ... CASCADE for Postgres, disabling foreign key checks
+// for MySQL, SQLite and MSSQL), so tables may be cleared in any order. Auto-increment (IDENTITY) counters are reset too.
+// Usually, the table that stores applied migrations (see migrate.MigrationsTableName) should be passed as an exception.
+func TruncateAll(ctx context.Context, db *sql.DB, dialect dbkit.Dialect, except ...string) error {
+	// Foreign key checks are switched per session, so all statements should be executed within the same connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	tables, err := listTables(ctx, conn, dialect, except)
+	if err != nil {
+		return fmt.Errorf("get user tables: %w", err)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	quotedTables := make([]string, 0, len(tables))
+	for _, table := range tables {
+		quotedTables = append(quotedTables, dbkit.QuoteIdentifier(dialect, table))
+	}
+
+	switch dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		return execStatements(ctx, conn, "TRUNCATE TABLE "+strings.Join(quotedTables, ", ")+" RESTART IDENTITY CASCADE")
+
+	case dbkit.DialectMySQL:
+		stmts := []string{"SET FOREIGN_KEY_CHECKS = 0"}
+		for _, table := range quotedTables {
+			stmts = append(stmts, "TRUNCATE TABLE "+table)
+		}
+		err = execStatements(ctx, conn, stmts...)
+		if restoreErr := execStatements(ctx, conn, "SET FOREIGN_KEY_CHECKS = 1"); restoreErr != nil && err == nil {
+			err = restoreErr
+		}
+		return err
+
+	case dbkit.DialectSQLite:
+		var fkEnabled bool
+		if err = conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fkEnabled); err != nil {
+			return fmt.Errorf("get foreign_keys pragma: %w", err)
+		}
+		stmts := []string{"PRAGMA foreign_keys = OFF"}
+		for _, table := range quotedTables {
+			stmts = append(stmts, "DELETE FROM "+table)
+		}
+		err = execStatements(ctx, conn, stmts...)
+		if err == nil {
+			err = resetSQLiteSequences(ctx, conn, tables)
+		}
+		if fkEnabled {
+			if restoreErr := execStatements(ctx, conn, "PRAGMA foreign_keys = ON"); restoreErr != nil && err == nil {
+				err = restoreErr
+			}
+		}
+		return err
+
+	case dbkit.DialectMSSQL:
+		// TRUNCATE cannot be used for tables referenced by foreign keys in MSSQL even if constraints are disabled.
+		var stmts []string
+		for _, table := range quotedTables {
+			stmts = append(stmts, "ALTER TABLE "+table+" NOCHECK CONSTRAINT ALL")
+		}
+		for _, table := range quotedTables {
+			stmts = append(stmts, "DELETE FROM "+table)
+		}
+		err = execStatements(ctx, conn, stmts...)
+		if err == nil {
+			err = resetMSSQLIdentities(ctx, conn, tables)
+		}
+		stmts = stmts[:0]
+		for _, table := range quotedTables {
+			stmts = append(stmts, "ALTER TABLE "+table+" WITH CHECK CHECK CONSTRAINT ALL")
+		}
+		if restoreErr := execStatements(ctx, conn, stmts...); restoreErr != nil && err == nil {
+			err = restoreErr
+		}
+		return err
+	}
+
+	return fmt.Errorf("unsupported sql dialect %q", dialect)
+}
+
+// ListTables returns names of all user tables (in the current schema/database) except the passed ones ordered by name.
+func ListTables(ctx context.Context, db *sql.DB, dialect dbkit.Dialect, except ...string) ([]string, error) {
+	return listTables(ctx, db, dialect, except)
+}
+
+func listTables(ctx context.Context, querier sqlQuerier, dialect dbkit.Dialect, except []string) ([]string, error) {
+	var query string
+	switch dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		query = "SELECT tablename FROM pg_tables WHERE schemaname = current_schema() ORDER BY tablename"
+	case dbkit.DialectMySQL:
+		query = "SELECT table_name FROM information_schema.tables " +
+			"WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name"
+	case dbkit.DialectSQLite:
+		query = "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+	case dbkit.DialectMSSQL:
+		query = "SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES " +
+			"WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_SCHEMA = SCHEMA_NAME() ORDER BY TABLE_NAME"
+	default:
+		return nil, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+	allTables, err := queryStrings(ctx, querier, query)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool, len(except))
+	for _, table := range except {
+		excluded[table] = true
+	}
+	tables := make([]string, 0, len(allTables))
+	for _, table := range allTables {
+		if !excluded[table] {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+func resetSQLiteSequences(ctx context.Context, conn *sql.Conn, tables []string) error {
+	var seqTableExists bool
+	if err := conn.QueryRowContext(ctx,
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'sqlite_sequence'").Scan(&seqTableExists); err != nil {
+		return err
+	}
+	if !seqTableExists {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tables)), ", ")
+	args := make([]interface{}, 0, len(tables))
+	for _, table := range tables {
+		args = append(args, table)
+	}
+	_, err := conn.ExecContext(ctx, "DELETE FROM sqlite_sequence WHERE name IN ("+placeholders+")", args...)
+	return err
+}
+
+// resetMSSQLIdentities reseeds IDENTITY columns of the passed tables, so the next inserted row gets 1 again.
+// Tables which IDENTITY has never been used are skipped, since for them the reseed value itself would be used next.
+func resetMSSQLIdentities(ctx context.Context, conn *sql.Conn, tables []string) error {
+	usedIdentityTables, err := queryStrings(ctx, conn, "SELECT OBJECT_NAME(object_id) FROM sys.identity_columns "+
+		"WHERE last_value IS NOT NULL AND OBJECT_SCHEMA_NAME(object_id) = SCHEMA_NAME()")
+	if err != nil {
+		return fmt.Errorf("get identity columns: %w", err)
+	}
+	truncated := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		truncated[table] = true
+	}
+	var stmts []string
+	for _, table := range usedIdentityTables {
+		if truncated[table] {
+			quotedTable := strings.ReplaceAll(dbkit.QuoteIdentifier(dbkit.DialectMSSQL, table), "'", "''")
+			stmts = append(stmts, "DBCC CHECKIDENT ('"+quotedTable+"', RESEED, 0)")
+		}
+	}
+	return execStatements(ctx, conn, stmts...)
+}
+
+func execStatements(ctx context.Context, conn *sql.Conn, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	_ "github.com/acronis/go-dbkit/sqlite"
+)
+
+func TestTruncateAll(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "truncate.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	db.SetMaxOpenConns(1) // PRAGMA foreign_keys is set per connection.
+
+	for _, stmt := range []string{
+		`PRAGMA foreign_keys = ON`,
+		`CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)`,
+		`CREATE TABLE notes (id INTEGER NOT NULL PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users(id))`,
+		`CREATE TABLE migrations (id TEXT NOT NULL PRIMARY KEY)`,
+		`INSERT INTO users (name) VALUES ('Albert'), ('Bob')`,
+		`INSERT INTO notes (id, user_id) VALUES (1, 1), (2, 2)`,
+		`INSERT INTO migrations (id) VALUES ('00001_init')`,
+	} {
+		_, err = db.ExecContext(ctx, stmt)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, TruncateAll(ctx, db, dbkit.DialectSQLite, "migrations"))
+
+	countRows := func(table string) int {
+		var n int
+		require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n))
+		return n
+	}
+	require.Equal(t, 0, countRows("users"))
+	require.Equal(t, 0, countRows("notes"))
+	require.Equal(t, 1, countRows("migrations"))
+
+	// Foreign keys are enabled back.
+	var fkEnabled bool
+	require.NoError(t, db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fkEnabled))
+	require.True(t, fkEnabled)
+
+	// Autoincrement sequence is reset.
+	_, err = db.ExecContext(ctx, `INSERT INTO users (name) VALUES ('Sam')`)
+	require.NoError(t, err)
+	var userID int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT id FROM users").Scan(&userID))
+	require.Equal(t, 1, userID)
+}
+
+func TestTruncateAll_MSSQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		mock.ExpectClose()
+		require.NoError(t, db.Close())
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES")).
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME"}).AddRow("migrations").AddRow("notes").AddRow("users"))
+	for _, stmt := range []string{
+		"ALTER TABLE [notes] NOCHECK CONSTRAINT ALL",
+		"ALTER TABLE [users] NOCHECK CONSTRAINT ALL",
+		"DELETE FROM [notes]",
+		"DELETE FROM [users]",
+	} {
+		mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// Only tables which IDENTITY was used are reseeded.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT OBJECT_NAME(object_id) FROM sys.identity_columns")).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("migrations").AddRow("users"))
+	for _, stmt := range []string{
+		"DBCC CHECKIDENT ('[users]', RESEED, 0)",
+		"ALTER TABLE [notes] WITH CHECK CHECK CONSTRAINT ALL",
+		"ALTER TABLE [users] WITH CHECK CHECK CONSTRAINT ALL",
+	} {
+		mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	require.NoError(t, TruncateAll(context.Background(), db, dbkit.DialectMSSQL, "migrations"))
+}
@@ -0,0 +1,188 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+)
+
+// Default values of DeadlockDiagnosticsOpts.
+const (
+	DefaultDeadlockDiagnosticsInterval       = time.Minute
+	DefaultDeadlockDiagnosticsCaptureTimeout = 5 * time.Second
+	DefaultDeadlockDiagnosticsMaxLength      = 8 * 1024
+)
+
+const postgresDeadlockDiagnosticsQuery = `SELECT COALESCE(string_agg(format(
+	'pid=%s state=%s wait_event=%s:%s blocked_by=%s xact_age=%s query=%s',
+	pid, state, wait_event_type, wait_event, pg_blocking_pids(pid), now() - xact_start, left(query, 256)), E'\n'), '')
+FROM pg_stat_activity
+WHERE datname = current_database() AND pid <> pg_backend_pid() AND state <> 'idle'`
+
+const mySQLInnoDBStatusQuery = "SHOW ENGINE INNODB STATUS"
+
+// DeadlockDiagnosticsOpts represents an options for DeadlockDiagnostics.
+type DeadlockDiagnosticsOpts struct {
+	// Interval is a minimal interval between captures. DefaultDeadlockDiagnosticsInterval is used by default.
+	Interval time.Duration
+
+	// CaptureTimeout limits the time of capturing diagnostic info. DefaultDeadlockDiagnosticsCaptureTimeout is used by default.
+	CaptureTimeout time.Duration
+
+	// MaxLength limits the length of the logged diagnostic info. DefaultDeadlockDiagnosticsMaxLength is used by default.
+	MaxLength int
+}
+
+// DeadlockDiagnostics captures diagnostic info (Postgres pg_stat_activity or MySQL SHOW ENGINE INNODB STATUS excerpt)
+// on a side connection when a deadlock or serialization failure is detected by the retry machinery
+// (see StatementRetryOpts.DeadlockDiagnostics and dbrutil.RetryableTxSession) and logs it once per interval,
+// so recurring deadlocks may be debugged from service logs.
+type DeadlockDiagnostics struct {
+	db             *sql.DB
+	dialect        Dialect
+	logger         log.FieldLogger
+	interval       time.Duration
+	captureTimeout time.Duration
+	maxLength      int
+
+	mu          sync.Mutex
+	lastCapture time.Time
+}
+
+// NewDeadlockDiagnostics creates a new DeadlockDiagnostics.
+func NewDeadlockDiagnostics(db *sql.DB, dialect Dialect, logger log.FieldLogger) *DeadlockDiagnostics {
+	return NewDeadlockDiagnosticsWithOpts(db, dialect, logger, DeadlockDiagnosticsOpts{})
+}
+
+// NewDeadlockDiagnosticsWithOpts is a more configurable version of the NewDeadlockDiagnostics.
+func NewDeadlockDiagnosticsWithOpts(
+	db *sql.DB, dialect Dialect, logger log.FieldLogger, opts DeadlockDiagnosticsOpts,
+) *DeadlockDiagnostics {
+	if logger == nil {
+		logger = log.NewDisabledLogger()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultDeadlockDiagnosticsInterval
+	}
+	if opts.CaptureTimeout <= 0 {
+		opts.CaptureTimeout = DefaultDeadlockDiagnosticsCaptureTimeout
+	}
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = DefaultDeadlockDiagnosticsMaxLength
+	}
+	return &DeadlockDiagnostics{
+		db:             db,
+		dialect:        dialect,
+		logger:         logger,
+		interval:       opts.Interval,
+		captureTimeout: opts.CaptureTimeout,
+		maxLength:      opts.MaxLength,
+	}
+}
+
+// Notify is called with the retryable error. If the error may be caused by the concurrent transactions
+// (i.e. it's not a connection error, timeout or cancellation) and the interval since the last capture has passed,
+// diagnostic info is captured and logged in a separate goroutine, so retries are not delayed.
+func (d *DeadlockDiagnostics) Notify(err error) {
+	if err == nil || !d.dialectSupported() {
+		return
+	}
+	switch ClassifyQueryError(err) {
+	case QueryErrorClassConnection, QueryErrorClassTimeout, QueryErrorClassCanceled:
+		return
+	}
+	if !d.tryStartCapture() {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), d.captureTimeout)
+		defer cancel()
+		d.captureAndLog(ctx, err)
+	}()
+}
+
+// Capture captures diagnostic info on a side connection from the pool.
+func (d *DeadlockDiagnostics) Capture(ctx context.Context) (string, error) {
+	var info string
+	var err error
+	switch d.dialect {
+	case DialectPostgres, DialectPgx:
+		err = d.db.QueryRowContext(ctx, postgresDeadlockDiagnosticsQuery).Scan(&info)
+	case DialectMySQL:
+		var typ, name string
+		if err = d.db.QueryRowContext(ctx, mySQLInnoDBStatusQuery).Scan(&typ, &name, &info); err == nil {
+			info = extractLatestDeadlock(info)
+		}
+	default:
+		return "", fmt.Errorf("deadlock diagnostics is not supported for dialect %q", d.dialect)
+	}
+	if err != nil {
+		return "", fmt.Errorf("capture deadlock diagnostics: %w", err)
+	}
+	if len(info) > d.maxLength {
+		info = info[:d.maxLength] + "..."
+	}
+	return info, nil
+}
+
+func (d *DeadlockDiagnostics) captureAndLog(ctx context.Context, retryableErr error) {
+	info, err := d.Capture(ctx)
+	if err != nil {
+		d.logger.Warn("failed to capture deadlock diagnostics", log.Error(err))
+		return
+	}
+	d.logger.Warn("deadlock diagnostics",
+		log.String("dialect", string(d.dialect)),
+		log.String("retryable_error", retryableErr.Error()),
+		log.String("diagnostics", info),
+	)
+}
+
+func (d *DeadlockDiagnostics) tryStartCapture() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if !d.lastCapture.IsZero() && now.Sub(d.lastCapture) < d.interval {
+		return false
+	}
+	d.lastCapture = now
+	return true
+}
+
+func (d *DeadlockDiagnostics) dialectSupported() bool {
+	switch d.dialect {
+	case DialectPostgres, DialectPgx, DialectMySQL:
+		return true
+	}
+	return false
+}
+
+// extractLatestDeadlock returns "LATEST DETECTED DEADLOCK" section of the InnoDB status
+// or the whole status if there is no such section.
+func extractLatestDeadlock(status string) string {
+	const sectionTitle = "LATEST DETECTED DEADLOCK"
+	start := strings.Index(status, sectionTitle)
+	if start == -1 {
+		return status
+	}
+	section := status[start:]
+	if end := strings.Index(section, "\nTRANSACTIONS\n"); end != -1 {
+		section = section[:end]
+		// Drop the dashes line that precedes the next section title.
+		if i := strings.LastIndex(section, "\n"); i != -1 && strings.Trim(section[i+1:], "-") == "" {
+			section = section[:i]
+		}
+	}
+	return section
+}
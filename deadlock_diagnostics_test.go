@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+)
+
+const testInnoDBStatus = `
+=====================================
+2024-05-01 10:00:00 INNODB MONITOR OUTPUT
+=====================================
+------------------------
+LATEST DETECTED DEADLOCK
+------------------------
+*** (1) TRANSACTION:
+TRANSACTION 1001, ACTIVE 1 sec starting index read
+*** (2) TRANSACTION:
+TRANSACTION 1002, ACTIVE 1 sec starting index read
+*** WE ROLL BACK TRANSACTION (2)
+------------
+TRANSACTIONS
+------------
+Trx id counter 1003
+`
+
+func TestDeadlockDiagnostics_Capture(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("postgres", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		diag := NewDeadlockDiagnosticsWithOpts(db, DialectPgx, nil, DeadlockDiagnosticsOpts{MaxLength: 10})
+
+		mock.ExpectQuery(regexp.QuoteMeta(postgresDeadlockDiagnosticsQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"info"}).AddRow("pid=42 state=active"))
+		info, err := diag.Capture(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "pid=42 sta...", info)
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		diag := NewDeadlockDiagnostics(db, DialectMySQL, nil)
+
+		mock.ExpectQuery(mySQLInnoDBStatusQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"Type", "Name", "Status"}).AddRow("InnoDB", "", testInnoDBStatus))
+		info, err := diag.Capture(ctx)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(info, "LATEST DETECTED DEADLOCK\n"))
+		require.True(t, strings.HasSuffix(info, "*** WE ROLL BACK TRANSACTION (2)"))
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unsupported dialect", func(t *testing.T) {
+		_, err := NewDeadlockDiagnostics(nil, DialectSQLite, nil).Capture(ctx)
+		require.EqualError(t, err, `deadlock diagnostics is not supported for dialect "sqlite3"`)
+	})
+}
+
+func TestDeadlockDiagnostics_Notify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	logRecorder := logtest.NewRecorder()
+	diag := NewDeadlockDiagnosticsWithOpts(db, DialectPgx, logRecorder, DeadlockDiagnosticsOpts{Interval: time.Hour})
+
+	// Connection errors are not caused by concurrent transactions.
+	diag.Notify(driver.ErrBadConn)
+
+	mock.ExpectQuery(regexp.QuoteMeta(postgresDeadlockDiagnosticsQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"info"}).AddRow("pid=42 state=active"))
+	diag.Notify(errors.New("deadlock detected"))
+	diag.Notify(errors.New("deadlock detected")) // Interval has not passed yet.
+
+	require.Eventually(t, func() bool {
+		_, found := logRecorder.FindEntry("deadlock diagnostics")
+		return found
+	}, time.Second, 10*time.Millisecond)
+	entry, _ := logRecorder.FindEntry("deadlock diagnostics")
+	field, found := entry.FindField("diagnostics")
+	require.True(t, found)
+	require.Equal(t, "pid=42 state=active", string(field.Bytes))
+	require.Len(t, logRecorder.Entries(), 1)
+
+	mock.ExpectClose()
+	requireNoErrOnClose(t, db)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DialFunc is a function that establishes network connections to the database server
+// (e.g. via GCP Cloud SQL connector, AWS RDS proxy with custom TLS or SSH tunnel).
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialerConnectorFactory creates driver.Connector that uses the passed DialFunc for establishing connections.
+// It's registered by the driver-specific packages (e.g. github.com/acronis/go-dbkit/pgx) for drivers
+// that don't support custom dialers via DSN.
+type DialerConnectorFactory func(dsn string, dial DialFunc) (driver.Connector, error)
+
+var dialers = struct {
+	sync.RWMutex
+	funcs     map[string]DialFunc
+	factories map[string]DialerConnectorFactory
+}{
+	funcs:     make(map[string]DialFunc),
+	factories: make(map[string]DialerConnectorFactory),
+}
+
+// RegisterDialer registers DialFunc with the given name, so it may be used for opening MySQL and Postgres connections
+// by specifying this name in MySQLConfig.Dialer or PostgresConfig.Dialer ("db.mysql.dialer" and "db.postgres.dialer" keys).
+// For MySQL, dialer is registered in the driver as a custom network, so the name should not be "tcp" or "unix".
+// Usually it's called once on the service start before opening databases.
+func RegisterDialer(name string, dial DialFunc) {
+	dialers.Lock()
+	defer dialers.Unlock()
+	dialers.funcs[name] = dial
+	mysql.RegisterDialContext(name, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dial(ctx, "tcp", addr)
+	})
+}
+
+// LookupDialer returns DialFunc registered with the given name.
+func LookupDialer(name string) (DialFunc, bool) {
+	dialers.RLock()
+	defer dialers.RUnlock()
+	dial, ok := dialers.funcs[name]
+	return dial, ok
+}
+
+// RegisterDialerConnectorFactory registers DialerConnectorFactory for the driver with the given name.
+func RegisterDialerConnectorFactory(driverName string, factory DialerConnectorFactory) {
+	dialers.Lock()
+	defer dialers.Unlock()
+	dialers.factories[driverName] = factory
+}
+
+func lookupDialerConnectorFactory(driverName string) (DialerConnectorFactory, bool) {
+	dialers.RLock()
+	defer dialers.RUnlock()
+	factory, ok := dialers.factories[driverName]
+	return factory, ok
+}
+
+// Dialer returns name of the custom dialer specified for the configured dialect.
+func (c *Config) Dialer() string {
+	switch c.Dialect {
+	case DialectMySQL:
+		return c.MySQL.Dialer
+	case DialectPostgres, DialectPgx:
+		return c.Postgres.Dialer
+	}
+	return ""
+}
+
+// makeDialerConnectorOpener returns a function that opens driver.Connector with the custom dialer
+// or nil if there is no need in it (dialer is not specified, or it's passed via DSN as for MySQL).
+func (c *Config) makeDialerConnectorOpener(driverName string) (func(dsn string) (driver.Connector, error), error) {
+	dialerName := c.Dialer()
+	if dialerName == "" {
+		return nil, nil
+	}
+	dial, ok := LookupDialer(dialerName)
+	if !ok {
+		return nil, fmt.Errorf("dialer %q is not registered", dialerName)
+	}
+	if c.Dialect == DialectMySQL {
+		return nil, nil
+	}
+	factory, ok := lookupDialerConnectorFactory(driverName)
+	if !ok {
+		return nil, fmt.Errorf("custom dialers are not supported by %q driver "+
+			"(driver-specific package of dbkit should be imported)", driverName)
+	}
+	return func(dsn string) (driver.Connector, error) {
+		return factory(dsn, dial)
+	}, nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen_WithDialer(t *testing.T) {
+	errDial := errors.New("dial error")
+	var dialedNetwork, dialedAddr string
+	RegisterDialer("test-mysql-dialer", func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialedNetwork, dialedAddr = network, addr
+		return nil, errDial
+	})
+
+	cfg := &Config{Dialect: DialectMySQL, MySQL: MySQLConfig{
+		Host: "db.internal", Port: 3306, User: "user", Database: "mydb", Dialer: "test-mysql-dialer",
+	}}
+	require.Equal(t, "user@test-mysql-dialer(db.internal:3306)/mydb?multiStatements=true&parseTime=true&autocommit=false",
+		MakeMySQLDSN(&cfg.MySQL))
+
+	db, err := Open(cfg, false)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.ErrorIs(t, db.Ping(), errDial)
+	require.Equal(t, "tcp", dialedNetwork)
+	require.Equal(t, "db.internal:3306", dialedAddr)
+}
+
+func TestOpen_WithUnknownDialer(t *testing.T) {
+	cfg := &Config{Dialect: DialectMySQL, MySQL: MySQLConfig{Host: "db.internal", Port: 3306, Dialer: "unknown-dialer"}}
+	_, err := Open(cfg, false)
+	require.EqualError(t, err, `dialer "unknown-dialer" is not registered`)
+}
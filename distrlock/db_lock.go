@@ -9,7 +9,6 @@ package distrlock
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"time"
 
@@ -17,6 +16,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/internal/ctxutil"
 	"github.com/acronis/go-dbkit/migrate"
 )
 
@@ -25,11 +25,55 @@ const defaultTableName = "distributed_locks"
 // DBManager provides management functionality for distributed locks based on the SQL database.
 type DBManager struct {
 	queries dbQueries
+	owner   sql.NullString
+	logger  log.FieldLogger
 }
 
 // DBManagerOpts represents an options for DBManager.
 type DBManagerOpts struct {
 	TableName string
+
+	// SchemaName (optional) is a name of the schema (database in MySQL) where the locks table is placed.
+	// It allows keeping locks in a separate schema with restricted privileges. The schema should already exist.
+	SchemaName string
+
+	// ColumnTypes (optional) customizes definitions of the table columns in the migration that creates the table.
+	ColumnTypes DBColumnTypes
+
+	// PostgresLegacyTimestamp enables compatibility mode for the existing Postgres tables
+	// where expire_at column is timestamp without time zone. In this mode, the migration that converts it
+	// to timestamptz is not returned by Migrations, and queries convert the column using the session time zone,
+	// so all services working with the table should use the same time zone settings.
+	// The migration is not returned also if ColumnTypes.ExpireAt is specified.
+	PostgresLegacyTimestamp bool
+
+	// Now returns the current time that is used for computing and checking expiration of locks.
+	// By default (nil), the current time of the database server is used.
+	// It's supposed to be set in tests only for controlling locks expiration deterministically
+	// (see distrlocktest.Clock). All managers working with the same table should use the same time source.
+	Now func() time.Time
+
+	// Owner (optional) identifies the lock holder (e.g. "<hostname>/<worker name>").
+	// It's stored on acquisition and returned in LockInfo, so it may be seen who holds the lock.
+	Owner string
+
+	// Logger (if set) is used for logging outcomes of the lock operations (acquire, release, extend, force release)
+	// at the debug level with key, token, duration and number of affected rows.
+	// It may be helpful for debugging lock contention in production.
+	Logger log.FieldLogger
+}
+
+// DBColumnTypes represents SQL types of the locks table columns. Empty fields mean default types:
+// varchar(40), uuid and timestamp (lock_key, token and expire_at respectively) for Postgres,
+// and VARCHAR(40), VARCHAR(36) and BIGINT for MySQL.
+// For example, Postgres table may use citext keys and timestamptz expiration time
+// (that is not affected by the session time zone and DST transitions).
+// LockKey type should fit keys up to 40 bytes, Token type should accept UUID strings,
+// and ExpireAt type should be a timestamp type for Postgres and an integer type for MySQL, since queries depend on it.
+type DBColumnTypes struct {
+	LockKey  string
+	Token    string
+	ExpireAt string
 }
 
 // NewDBManager creates new distributed lock manager that uses SQL database as a backend.
@@ -39,16 +83,25 @@ func NewDBManager(dialect dbkit.Dialect) (*DBManager, error) {
 
 // NewDBManagerWithOpts is a more configurable version of the NewDBManager.
 func NewDBManagerWithOpts(dialect dbkit.Dialect, opts DBManagerOpts) (*DBManager, error) {
-	q, err := newDBQueries(dialect, opts.TableName)
+	if opts.TableName == "" {
+		opts.TableName = defaultTableName
+	}
+	q, err := newDBQueries(dialect, opts)
 	if err != nil {
 		return nil, err
 	}
-	return &DBManager{q}, nil
+	return &DBManager{queries: q, owner: sql.NullString{String: opts.Owner, Valid: opts.Owner != ""}, logger: opts.Logger}, nil
 }
 
 // Migrations returns set of migrations that must be applied before creating new locks.
+// Migrations are versioned (distrlock_00001_*, distrlock_00002_*, ...), so existing installations
+// are upgraded in place by applying only the new ones. Columns added by follow-up migrations (owner and fence)
+// are nullable or have default values, so queries of the previous versions keep working during rolling upgrades
+// (but all migrations should be applied before the current version starts working with the table).
+// For Postgres, expire_at column is converted to timestamptz (see DBManagerOpts.PostgresLegacyTimestamp),
+// so expiration is not affected by time zone settings of the database and clients.
 func (m *DBManager) Migrations() []migrate.Migration {
-	return []migrate.Migration{
+	migrations := []migrate.Migration{
 		migrate.NewCustomMigration(
 			createTableMigrationID,
 			[]string{m.queries.createTable},
@@ -56,16 +109,95 @@ func (m *DBManager) Migrations() []migrate.Migration {
 			nil,
 			nil,
 		),
+		migrate.NewCustomMigration(
+			addOwnerColumnMigrationID,
+			[]string{m.queries.addOwnerColumn},
+			[]string{m.queries.dropOwnerColumn},
+			nil,
+			nil,
+		),
+		migrate.NewCustomMigration(
+			addFenceColumnMigrationID,
+			[]string{m.queries.addFenceColumn},
+			[]string{m.queries.dropFenceColumn},
+			nil,
+			nil,
+		),
 	}
+	if m.queries.convertExpireAt != "" {
+		migrations = append(migrations, migrate.NewCustomMigration(
+			convertExpireAtMigrationID,
+			[]string{m.queries.convertExpireAt},
+			[]string{m.queries.revertExpireAt},
+			nil,
+			nil,
+		))
+	}
+	return migrations
 }
 
-// NewLock creates new initialized (but not acquired) distributed lock.
-func (m *DBManager) NewLock(ctx context.Context, executor sqlExecutor, key string) (DBLock, error) {
-	if key == "" {
-		return DBLock{}, fmt.Errorf("lock key cannot be empty")
+// LockInfo represents a state of the distributed lock stored in the database.
+type LockInfo struct {
+	Key      string
+	Token    string
+	Acquired bool
+	ExpireAt time.Time // Zero if lock is released.
+
+	// Owner is the identity of the last holder (see DBManagerOpts.Owner). Empty if it's not specified.
+	Owner string
+
+	// Fence is a fencing token that is incremented on each acquisition of the lock.
+	// Holder may pass it to the protected resource, so writes of the previous (e.g. paused or expired) holders
+	// with smaller tokens may be rejected.
+	Fence int64
+}
+
+// ListLocks returns all locks stored in the database ordered by key.
+func (m *DBManager) ListLocks(ctx context.Context, querier SQLQuerier) ([]LockInfo, error) {
+	rows, err := querier.QueryContext(ctx, m.queries.listLocks, m.queries.withNow()...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var locks []LockInfo
+	for rows.Next() {
+		var lock LockInfo
+		if lock, err = scanLockInfo(rows); err != nil {
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, rows.Err()
+}
+
+func scanLockInfo(rows *sql.Rows) (LockInfo, error) {
+	var lock LockInfo
+	var token, owner sql.NullString
+	var expireAtMicro sql.NullInt64
+	if err := rows.Scan(&lock.Key, &token, &expireAtMicro, &lock.Acquired, &owner, &lock.Fence); err != nil {
+		return LockInfo{}, err
 	}
-	if len(key) > 40 {
-		return DBLock{}, fmt.Errorf("lock key cannot be longer than 40 symbols")
+	lock.Token = token.String
+	lock.Owner = owner.String
+	if expireAtMicro.Valid {
+		lock.ExpireAt = time.UnixMicro(expireAtMicro.Int64)
+	}
+	return lock, nil
+}
+
+// ForceRelease releases lock for the key in the database regardless of its token.
+// It's supposed to be used by operators for releasing locks held by crashed or hung processes.
+// ErrLockAlreadyReleased error will be returned if lock is not acquired.
+func (m *DBManager) ForceRelease(ctx context.Context, executor SQLExecutor, key string) error {
+	return m.execLockQuery(ctx, executor, "force_release", key, "",
+		m.queries.forceReleaseLock, m.queries.withNow(key), ErrLockAlreadyReleased)
+}
+
+// NewLock creates new initialized (but not acquired) distributed lock.
+func (m *DBManager) NewLock(ctx context.Context, executor SQLExecutor, key string) (DBLock, error) {
+	if err := validateLockKey(key); err != nil {
+		return DBLock{}, err
 	}
 	if _, err := executor.ExecContext(ctx, m.queries.initLock, key); err != nil {
 		return DBLock{}, err
@@ -82,7 +214,8 @@ type DBLock struct {
 }
 
 // Acquire acquires lock for the key in the database.
-func (l *DBLock) Acquire(ctx context.Context, executor sqlExecutor, lockTTL time.Duration) error {
+// Fencing token of the lock is incremented and owner is stored (see LockInfo).
+func (l *DBLock) Acquire(ctx context.Context, executor SQLExecutor, lockTTL time.Duration) error {
 	return l.AcquireWithStaticToken(ctx, executor, uuid.NewString(), lockTTL)
 }
 
@@ -93,10 +226,9 @@ func (l *DBLock) Acquire(ctx context.Context, executor sqlExecutor, lockTTL time
 //  2. When you need several processes to acquire the same lock.
 //
 // Please use Acquire instead of this method unless you have a good reason to use it.
-func (l *DBLock) AcquireWithStaticToken(ctx context.Context, executor sqlExecutor, token string, lockTTL time.Duration) error {
-	interval := l.manager.queries.intervalMaker(lockTTL)
-	err := execQueryAndCheck(ctx, executor, l.manager.queries.acquireLock,
-		[]interface{}{interval, token, l.Key, token}, ErrLockAlreadyAcquired)
+func (l *DBLock) AcquireWithStaticToken(ctx context.Context, executor SQLExecutor, token string, lockTTL time.Duration) error {
+	err := l.manager.execLockQuery(ctx, executor, "acquire", l.Key, token, l.manager.queries.acquireLock,
+		l.manager.queries.withExpiration(lockTTL, token, l.manager.owner, l.Key, token), ErrLockAlreadyAcquired)
 	if err != nil {
 		return err
 	}
@@ -105,18 +237,92 @@ func (l *DBLock) AcquireWithStaticToken(ctx context.Context, executor sqlExecuto
 	return nil
 }
 
+// TryAcquireOrInfo tries to acquire lock for the key in the database.
+// If lock is held by someone else, ErrLockAlreadyAcquired error is returned together with the information
+// about the current holder (its token, owner and expiration time), so the caller may log who holds the lock and until when.
+// Two statements are executed: the lock row is read with SELECT ... FOR UPDATE and then it's updated if the lock is free.
+// Since the row is locked by the first statement, it cannot be changed by concurrent transactions in between.
+// Executor should be a transaction (e.g. *sql.Tx), otherwise the row lock is released right after reading.
+// On successful acquisition, information about the acquired lock (including its fencing token) is returned,
+// except for ExpireAt that is computed by the database.
+func (l *DBLock) TryAcquireOrInfo(ctx context.Context, executor SQLExecQuerier, lockTTL time.Duration) (LockInfo, error) {
+	rows, err := executor.QueryContext(ctx, l.manager.queries.getLockForUpdate, l.manager.queries.withNow(l.Key)...)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	var current LockInfo
+	found := rows.Next()
+	if found {
+		current, err = scanLockInfo(rows)
+	} else {
+		err = rows.Err()
+	}
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return LockInfo{}, err
+	}
+	if current.Acquired {
+		return current, ErrLockAlreadyAcquired
+	}
+	if err = l.Acquire(ctx, executor, lockTTL); err != nil {
+		return LockInfo{Key: l.Key}, err
+	}
+	return LockInfo{
+		Key:      l.Key,
+		Token:    l.token,
+		Acquired: true,
+		Owner:    l.manager.owner.String,
+		Fence:    current.Fence + 1,
+	}, nil
+}
+
 // Release releases lock for the key in the database.
-func (l *DBLock) Release(ctx context.Context, executor sqlExecutor) error {
-	return execQueryAndCheck(ctx, executor,
-		l.manager.queries.releaseLock, []interface{}{l.Key, l.token}, ErrLockAlreadyReleased)
+func (l *DBLock) Release(ctx context.Context, executor SQLExecutor) error {
+	return l.manager.execLockQuery(ctx, executor, "release", l.Key, l.token,
+		l.manager.queries.releaseLock, l.manager.queries.withNow(l.Key, l.token), ErrLockAlreadyReleased)
 }
 
 // Extend resets expiration timeout for already acquired lock.
 // ErrLockAlreadyReleased error will be returned if lock is already released, in this case lock should be acquired again.
-func (l *DBLock) Extend(ctx context.Context, executor sqlExecutor) error {
-	interval := l.manager.queries.intervalMaker(l.TTL)
-	return execQueryAndCheck(ctx, executor,
-		l.manager.queries.extendLock, []interface{}{interval, l.Key, l.token}, ErrLockAlreadyReleased)
+func (l *DBLock) Extend(ctx context.Context, executor SQLExecutor) error {
+	return l.manager.execLockQuery(ctx, executor, "extend", l.Key, l.token,
+		l.manager.queries.extendLock, l.manager.queries.withExpiration(l.TTL, l.Key, l.token), ErrLockAlreadyReleased)
+}
+
+// RemainingTTL returns time left until the lock expires, computed relative to the database server time
+// (or to DBManagerOpts.Now if it's set). It allows long-running jobs to decide whether to extend the lock
+// or to checkpoint their work before it's too late.
+// ErrLockAlreadyReleased error will be returned if lock is released, expired, or acquired by someone else.
+func (l *DBLock) RemainingTTL(ctx context.Context, querier SQLQuerier) (time.Duration, error) {
+	if l.token == "" {
+		return 0, ErrLockAlreadyReleased
+	}
+	rows, err := querier.QueryContext(ctx, l.manager.queries.remainingTTL, l.Key, l.token)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, ErrLockAlreadyReleased
+	}
+	var expireAtMicro sql.NullInt64
+	var nowMicro int64
+	if err = rows.Scan(&expireAtMicro, &nowMicro); err != nil {
+		return 0, err
+	}
+	if l.manager.queries.now != nil {
+		nowMicro = l.manager.queries.now().UnixMicro()
+	}
+	if !expireAtMicro.Valid || expireAtMicro.Int64 < nowMicro {
+		return 0, ErrLockAlreadyReleased
+	}
+	return time.Duration(expireAtMicro.Int64-nowMicro) * time.Microsecond, nil
 }
 
 // Token returns token of the last acquired lock.
@@ -125,6 +331,47 @@ func (l *DBLock) Token() string {
 	return l.token
 }
 
+// AcquireDB acquires lock for the key in the database within an internally managed transaction,
+// so the caller doesn't need to wrap the call into dbkit.DoInTx.
+// The transaction is bounded by lockTTL, since the lock acquired after its TTL would be already expired.
+func (l *DBLock) AcquireDB(ctx context.Context, dbConn *sql.DB, lockTTL time.Duration) error {
+	opCtx, opCtxCancel := newLockOpContext(ctx, lockTTL)
+	defer opCtxCancel()
+	return dbkit.DoInTx(opCtx, dbConn, func(tx *sql.Tx) error {
+		return l.Acquire(opCtx, tx, lockTTL)
+	})
+}
+
+// ReleaseDB releases lock for the key in the database within an internally managed transaction.
+// Cancellation of the passed context is ignored (e.g. for releasing the lock on shutdown),
+// but the transaction is bounded by the lock TTL, since the lock is expired after it anyway.
+func (l *DBLock) ReleaseDB(ctx context.Context, dbConn *sql.DB) error {
+	opCtx, opCtxCancel := newLockOpContext(ctxutil.WithoutCancel(ctx), l.TTL)
+	defer opCtxCancel()
+	return dbkit.DoInTx(opCtx, dbConn, func(tx *sql.Tx) error {
+		return l.Release(opCtx, tx)
+	})
+}
+
+// ExtendDB resets expiration timeout for already acquired lock within an internally managed transaction.
+// The transaction is bounded by the lock TTL, since the lock is expired after it anyway.
+// ErrLockAlreadyReleased error will be returned if lock is already released, in this case lock should be acquired again.
+func (l *DBLock) ExtendDB(ctx context.Context, dbConn *sql.DB) error {
+	opCtx, opCtxCancel := newLockOpContext(ctx, l.TTL)
+	defer opCtxCancel()
+	return dbkit.DoInTx(opCtx, dbConn, func(tx *sql.Tx) error {
+		return l.Extend(opCtx, tx)
+	})
+}
+
+// newLockOpContext returns a context for the lock operation that is bounded by the lock TTL (if it's set).
+func newLockOpContext(ctx context.Context, lockTTL time.Duration) (context.Context, context.CancelFunc) {
+	if lockTTL <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, lockTTL)
+}
+
 // DoExclusively acquires distributed lock, starts a separate goroutine that periodical extends it and calls passed function.
 // When function is finished, acquired lock is released.
 func (l *DBLock) DoExclusively(
@@ -136,63 +383,119 @@ func (l *DBLock) DoExclusively(
 	logger log.FieldLogger,
 	fn func(ctx context.Context) error,
 ) error {
-	if acquireLockErr := dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
-		return l.Acquire(ctx, tx, lockTTL)
-	}); acquireLockErr != nil {
-		return acquireLockErr
-	}
-
-	logger = logger.With(log.String("distrlock_key", l.Key), log.String("distrlock_token", l.token))
-
-	defer func() {
-		// If the ctx is canceled, we should be able to release the lock.
-		releaseCtx, releaseCtxCancel := context.WithTimeout(context.Background(), releaseTimeout)
-		defer releaseCtxCancel()
-		if releaseLockErr := dbkit.DoInTx(releaseCtx, dbConn, func(tx *sql.Tx) error {
-			return l.Release(releaseCtx, tx)
-		}); releaseLockErr != nil {
-			logger.Error("failed to release db lock", log.Error(releaseLockErr))
-		}
-	}()
-
-	newCtx, newCtxCancel := context.WithCancel(ctx)
-	defer newCtxCancel()
-	periodicalExtensionExit := make(chan struct{})
-	periodicalExtensionDone := make(chan struct{})
-	defer func() {
-		close(periodicalExtensionDone)
-		<-periodicalExtensionExit
-	}()
-	go func() {
-		defer func() { close(periodicalExtensionExit) }()
-		ticker := time.NewTicker(periodicExtendInterval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-periodicalExtensionDone:
-				return
-			case <-ticker.C:
-				if extendLockErr := dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
-					return l.Extend(ctx, tx)
-				}); extendLockErr != nil {
-					logger.Error("failed to extend db lock", log.Error(extendLockErr))
-					if errors.Is(extendLockErr, ErrLockAlreadyReleased) {
-						newCtxCancel() // If lock was already released, let's try to stop exclusive job asap.
-						return
-					}
-				}
-			}
-		}
-	}()
+	return l.DoExclusivelyWithOpts(ctx, dbConn, DoExclusivelyOpts{
+		LockTTL:                lockTTL,
+		PeriodicExtendInterval: periodicExtendInterval,
+		ReleaseTimeout:         releaseTimeout,
+		Logger:                 logger,
+	}, fn)
+}
 
-	return fn(newCtx)
+// DoExclusivelyWithOpts is a more configurable version of DoExclusively.
+func (l *DBLock) DoExclusivelyWithOpts(
+	ctx context.Context, dbConn *sql.DB, opts DoExclusivelyOpts, fn func(ctx context.Context) error,
+) error {
+	return l.DoExclusivelyWithTxRunner(ctx, NewSQLTxRunner(dbConn), opts, fn)
 }
 
-func execQueryAndCheck(ctx context.Context, executor sqlExecutor, query string, args []interface{}, errOnNoAffectedRows error) error {
-	result, err := executor.ExecContext(ctx, query, args...)
+// DoExclusivelyWithTxRunner does the same as DoExclusivelyWithOpts, but all lock queries are executed
+// in transactions started by the passed TxRunner. It allows using the lock without keeping a raw *sql.DB around
+// (e.g. with dbr, goqu or pgxpool wrappers).
+func (l *DBLock) DoExclusivelyWithTxRunner(
+	ctx context.Context, txRunner TxRunner, opts DoExclusivelyOpts, fn func(ctx context.Context) error,
+) error {
+	if err := validateJitter(opts); err != nil {
+		return err
+	}
+	lockTTL := applyJitter(opts.LockTTL, opts.LockTTLJitter)
+	acquire := func(ctx context.Context) (string, error) {
+		err := txRunner.DoInTx(ctx, func(executor SQLExecutor) error {
+			return l.Acquire(ctx, executor, lockTTL)
+		})
+		return l.token, err
+	}
+	extend := func(ctx context.Context) error {
+		return txRunner.DoInTx(ctx, func(executor SQLExecutor) error {
+			return l.Extend(ctx, executor)
+		})
+	}
+	release := func(ctx context.Context) error {
+		return txRunner.DoInTx(ctx, func(executor SQLExecutor) error {
+			return l.Release(ctx, executor)
+		})
+	}
+	return doExclusively(ctx, l.Key, acquire, extend, release, opts, fn)
+}
+
+// TxRunner is an interface for running the function within a transaction.
+// SQLExecutor passed to the function should execute queries within this transaction.
+type TxRunner interface {
+	DoInTx(ctx context.Context, fn func(executor SQLExecutor) error) error
+}
+
+// TxRunnerFunc is an adapter to allow the use of ordinary functions as TxRunner.
+// For example, dbrutil.TxSession may be adapted in the following way:
+//
+//	distrlock.TxRunnerFunc(func(ctx context.Context, fn func(executor distrlock.SQLExecutor) error) error {
+//		return txSession.DoInTx(ctx, func(runner dbr.SessionRunner) error {
+//			return fn(runner.(*dbr.Tx))
+//		})
+//	})
+type TxRunnerFunc func(ctx context.Context, fn func(executor SQLExecutor) error) error
+
+// DoInTx calls f(ctx, fn).
+func (f TxRunnerFunc) DoInTx(ctx context.Context, fn func(executor SQLExecutor) error) error {
+	return f(ctx, fn)
+}
+
+// NewSQLTxRunner returns TxRunner that runs transactions on *sql.DB using dbkit.DoInTx.
+func NewSQLTxRunner(dbConn *sql.DB) TxRunner {
+	return TxRunnerFunc(func(ctx context.Context, fn func(executor SQLExecutor) error) error {
+		return dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return fn(tx)
+		})
+	})
+}
+
+// execLockQuery executes the lock operation query and logs its outcome if the manager has a logger.
+func (m *DBManager) execLockQuery(
+	ctx context.Context,
+	executor SQLExecutor,
+	operation string,
+	key string,
+	token string,
+	query string,
+	args []interface{},
+	errOnNoAffectedRows error,
+) error {
+	if m.logger == nil {
+		_, err := execQueryAndCheck(ctx, executor, query, args, errOnNoAffectedRows)
+		return err
+	}
+	startedAt := time.Now()
+	affected, err := execQueryAndCheck(ctx, executor, query, args, errOnNoAffectedRows)
+	fields := []log.Field{
+		log.String("distrlock_operation", operation),
+		log.String("distrlock_key", key),
+		log.String("distrlock_token", token),
+		log.Int64("duration_ms", time.Since(startedAt).Milliseconds()),
+		log.Int64("rows_affected", affected),
+	}
 	if err != nil {
+		m.logger.Debug("db lock operation failed", append(fields, log.Error(err))...)
 		return err
 	}
+	m.logger.Debug("db lock operation succeeded", fields...)
+	return nil
+}
+
+func execQueryAndCheck(
+	ctx context.Context, executor SQLExecutor, query string, args []interface{}, errOnNoAffectedRows error,
+) (affected int64, err error) {
+	result, err := executor.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
 
 	// If the same context object is used in BeginTx() and in ExecContext() methods and it's canceled,
 	// "context deadline exceeded" or "canceling statement due to user request" errors are not returned from the ExecContext().
@@ -202,84 +505,238 @@ func execQueryAndCheck(ctx context.Context, executor sqlExecutor, query string,
 	// (https://github.com/cockroachdb/cockroach/pull/39525/files#diff-f3aa9f413e52eca7d64bf33c9493ec426a0c54aa4dca7a9d948721aa365e96c0).
 	// We have a separate sub-test for this case ("all contexts are canceled" in suffix).
 	if ctx.Err() != nil {
-		return ctx.Err()
+		return 0, ctx.Err()
 	}
 
-	if affected, err := result.RowsAffected(); err != nil {
-		return err
-	} else if affected != 1 {
-		return errOnNoAffectedRows
+	if affected, err = result.RowsAffected(); err != nil {
+		return 0, err
 	}
-	return nil
+	if affected != 1 {
+		return affected, errOnNoAffectedRows
+	}
+	return affected, nil
 }
 
 type dbQueries struct {
-	createTable   string
-	dropTable     string
-	initLock      string
-	acquireLock   string
-	releaseLock   string
-	extendLock    string
-	intervalMaker func(interval time.Duration) string
+	createTable      string
+	dropTable        string
+	addOwnerColumn   string
+	dropOwnerColumn  string
+	addFenceColumn   string
+	dropFenceColumn  string
+	convertExpireAt  string // empty if the conversion is not needed
+	revertExpireAt   string
+	initLock         string
+	acquireLock      string
+	releaseLock      string
+	extendLock       string
+	listLocks        string
+	getLockForUpdate string
+	forceReleaseLock string
+	remainingTTL     string
+	intervalMaker    func(interval time.Duration) string
+	now              func() time.Time              // nil if the database server time is used
+	timeArgMaker     func(t time.Time) interface{} // used only if now is not nil
 }
 
-func newDBQueries(dialect dbkit.Dialect, tableName string) (dbQueries, error) {
+func newDBQueries(dialect dbkit.Dialect, opts DBManagerOpts) (dbQueries, error) {
+	if err := dbkit.ValidateIdentifier(dialect, opts.TableName); err != nil {
+		return dbQueries{}, fmt.Errorf("table name: %w", err)
+	}
+	if opts.SchemaName != "" {
+		if err := dbkit.ValidateIdentifier(dialect, opts.SchemaName); err != nil {
+			return dbQueries{}, fmt.Errorf("schema name: %w", err)
+		}
+	}
+	tableName, _ := dbkit.QuoteQualified(dialect, opts.SchemaName, opts.TableName)
+	now := opts.Now
 	switch dialect {
 	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		// Column of the legacy or custom type is converted explicitly for the epoch extraction.
+		expireAtExpr := "expire_at::timestamptz"
+		var convertExpireAt, revertExpireAt string
+		if !opts.PostgresLegacyTimestamp && opts.ColumnTypes.ExpireAt == "" {
+			expireAtExpr = "expire_at"
+			convertExpireAt = fmt.Sprintf(postgresConvertExpireAtQuery, tableName)
+			revertExpireAt = fmt.Sprintf(postgresRevertExpireAtQuery, tableName)
+		}
+		expireExpr := "NOW() + $1::interval"
+		nowExpr := func(int) string { return "NOW()" }
+		if now != nil {
+			expireExpr = "$1::timestamptz"
+			nowExpr = func(argNum int) string { return fmt.Sprintf("$%d::timestamptz", argNum) }
+		}
 		return dbQueries{
-			createTable:   fmt.Sprintf(postgresCreateTableQuery, tableName),
-			dropTable:     fmt.Sprintf(postgresDropTableQuery, tableName),
-			initLock:      fmt.Sprintf(postgresInitLockQuery, tableName),
-			acquireLock:   fmt.Sprintf(postgresAcquireLockQuery, tableName),
-			releaseLock:   fmt.Sprintf(postgresReleaseLockQuery, tableName),
-			extendLock:    fmt.Sprintf(postgresExtendLockQuery, tableName),
-			intervalMaker: postgresMakeInterval,
+			createTable: fmt.Sprintf(postgresCreateTableQuery, tableName,
+				columnTypeOrDefault(opts.ColumnTypes.LockKey, "varchar(40)"),
+				columnTypeOrDefault(opts.ColumnTypes.Token, "uuid"),
+				columnTypeOrDefault(opts.ColumnTypes.ExpireAt, "timestamp")),
+			dropTable:        fmt.Sprintf(postgresDropTableQuery, tableName),
+			addOwnerColumn:   fmt.Sprintf(postgresAddOwnerColumnQuery, tableName),
+			dropOwnerColumn:  fmt.Sprintf(postgresDropOwnerColumnQuery, tableName),
+			addFenceColumn:   fmt.Sprintf(postgresAddFenceColumnQuery, tableName),
+			dropFenceColumn:  fmt.Sprintf(postgresDropFenceColumnQuery, tableName),
+			convertExpireAt:  convertExpireAt,
+			revertExpireAt:   revertExpireAt,
+			initLock:         fmt.Sprintf(postgresInitLockQuery, tableName),
+			acquireLock:      fmt.Sprintf(postgresAcquireLockQuery, tableName, expireExpr, nowExpr(6)),
+			releaseLock:      fmt.Sprintf(postgresReleaseLockQuery, tableName, expireExpr, nowExpr(3)),
+			extendLock:       fmt.Sprintf(postgresExtendLockQuery, tableName, expireExpr, nowExpr(4)),
+			listLocks:        fmt.Sprintf(postgresListLocksQuery, tableName, expireExpr, nowExpr(1), expireAtExpr),
+			getLockForUpdate: fmt.Sprintf(postgresGetLockForUpdateQuery, tableName, expireExpr, nowExpr(2), expireAtExpr),
+			forceReleaseLock: fmt.Sprintf(postgresForceReleaseLockQuery, tableName, expireExpr, nowExpr(2)),
+			remainingTTL:     fmt.Sprintf(postgresRemainingTTLQuery, tableName, expireAtExpr),
+			intervalMaker:    postgresMakeInterval,
+			now:              now,
+			timeArgMaker:     postgresMakeTimeArg,
 		}, nil
 	case dbkit.DialectMySQL:
+		expireExpr := "UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL ? MICROSECOND))*10000"
+		nowExpr := "UNIX_TIMESTAMP(CURTIME(4))*10000"
+		if now != nil {
+			expireExpr = "?"
+			nowExpr = "?"
+		}
 		return dbQueries{
-			createTable:   fmt.Sprintf(mySQLCreateTableQuery, tableName),
-			dropTable:     fmt.Sprintf(mySQLDropTableQuery, tableName),
-			initLock:      fmt.Sprintf(mySQLInitLockQuery, tableName),
-			acquireLock:   fmt.Sprintf(mySQLAcquireLockQuery, tableName),
-			releaseLock:   fmt.Sprintf(mySQLReleaseLockQuery, tableName),
-			extendLock:    fmt.Sprintf(mySQLExtendLockQuery, tableName),
-			intervalMaker: mySQLMakeInterval,
+			createTable: fmt.Sprintf(mySQLCreateTableQuery, tableName,
+				columnTypeOrDefault(opts.ColumnTypes.LockKey, "VARCHAR(40)"),
+				columnTypeOrDefault(opts.ColumnTypes.Token, "VARCHAR(36)"),
+				columnTypeOrDefault(opts.ColumnTypes.ExpireAt, "BIGINT")),
+			dropTable:        fmt.Sprintf(mySQLDropTableQuery, tableName),
+			addOwnerColumn:   fmt.Sprintf(mySQLAddOwnerColumnQuery, tableName),
+			dropOwnerColumn:  fmt.Sprintf(mySQLDropOwnerColumnQuery, tableName),
+			addFenceColumn:   fmt.Sprintf(mySQLAddFenceColumnQuery, tableName),
+			dropFenceColumn:  fmt.Sprintf(mySQLDropFenceColumnQuery, tableName),
+			initLock:         fmt.Sprintf(mySQLInitLockQuery, tableName),
+			acquireLock:      fmt.Sprintf(mySQLAcquireLockQuery, tableName, expireExpr, nowExpr),
+			releaseLock:      fmt.Sprintf(mySQLReleaseLockQuery, tableName, expireExpr, nowExpr),
+			extendLock:       fmt.Sprintf(mySQLExtendLockQuery, tableName, expireExpr, nowExpr),
+			listLocks:        fmt.Sprintf(mySQLListLocksQuery, tableName, expireExpr, nowExpr),
+			getLockForUpdate: fmt.Sprintf(mySQLGetLockForUpdateQuery, tableName, expireExpr, nowExpr),
+			forceReleaseLock: fmt.Sprintf(mySQLForceReleaseLockQuery, tableName, expireExpr, nowExpr),
+			remainingTTL:     fmt.Sprintf(mySQLRemainingTTLQuery, tableName),
+			intervalMaker:    mySQLMakeInterval,
+			now:              now,
+			timeArgMaker:     mySQLMakeTimeArg,
 		}, nil
 	default:
 		return dbQueries{}, fmt.Errorf("unsupported sql dialect %q", dialect)
 	}
 }
 
-type sqlExecutor interface {
+func columnTypeOrDefault(columnType, defaultType string) string {
+	if columnType == "" {
+		return defaultType
+	}
+	return columnType
+}
+
+// withExpiration prepends the lock expiration argument (TTL interval or expiration time) to the query arguments
+// and appends the current time argument if the database server time is not used.
+func (q *dbQueries) withExpiration(lockTTL time.Duration, args ...interface{}) []interface{} {
+	if q.now == nil {
+		return append([]interface{}{q.intervalMaker(lockTTL)}, args...)
+	}
+	now := q.now()
+	result := make([]interface{}, 0, len(args)+2)
+	result = append(result, q.timeArgMaker(now.Add(lockTTL)))
+	result = append(result, args...)
+	return append(result, q.timeArgMaker(now))
+}
+
+// withNow appends the current time argument to the query arguments if the database server time is not used.
+func (q *dbQueries) withNow(args ...interface{}) []interface{} {
+	if q.now == nil {
+		return args
+	}
+	return append(args, q.timeArgMaker(q.now()))
+}
+
+// SQLExecutor is an interface for executing SQL queries that modify locks.
+// It's implemented by *sql.DB, *sql.Tx, *sql.Conn, *dbr.Tx, *goqu.TxDatabase and so on.
+type SQLExecutor interface {
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
-const createTableMigrationID = "distrlock_00001_create_table"
+// SQLQuerier is an interface for executing SQL queries that read locks.
+// It's implemented by *sql.DB, *sql.Tx, *sql.Conn, *dbr.Tx, *goqu.TxDatabase and so on.
+type SQLQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// SQLExecQuerier is an interface for executing SQL queries that both read and modify locks.
+type SQLExecQuerier interface {
+	SQLExecutor
+	SQLQuerier
+}
+
+var (
+	_ SQLExecQuerier = (*sql.DB)(nil)
+	_ SQLExecQuerier = (*sql.Tx)(nil)
+	_ SQLExecQuerier = (*sql.Conn)(nil)
+)
+
+// IDs of the migrations returned by DBManager.Migrations.
+const (
+	createTableMigrationID    = "distrlock_00001_create_table"
+	addOwnerColumnMigrationID = "distrlock_00002_add_owner_column"
+	addFenceColumnMigrationID = "distrlock_00003_add_fence_column"
+
+	// convertExpireAtMigrationID is an ID of the Postgres-only migration that converts expire_at to timestamptz.
+	convertExpireAtMigrationID = "distrlock_00004_convert_expire_at_to_timestamptz"
+)
 
 //nolint:lll
 const (
-	postgresCreateTableQuery = `CREATE TABLE "%s" (lock_key varchar(40) PRIMARY KEY, token uuid, expire_at timestamp);`
-	postgresDropTableQuery   = `DROP TABLE IF EXISTS "%s";`
-	postgresInitLockQuery    = `INSERT INTO "%s" (lock_key) VALUES ($1) ON CONFLICT (lock_key) DO NOTHING;`
-	postgresAcquireLockQuery = `UPDATE "%s" SET expire_at = NOW() + $1::interval, token = $2 WHERE lock_key = $3 AND ((expire_at IS NULL OR expire_at < NOW()) OR token = $4);`
-	postgresReleaseLockQuery = `UPDATE "%s" SET expire_at = NULL WHERE lock_key = $1 AND token = $2 AND expire_at >= NOW();`
-	postgresExtendLockQuery  = `UPDATE "%s" SET expire_at = NOW() + $1::interval WHERE lock_key = $2 AND token = $3 AND expire_at >= NOW();`
+	postgresCreateTableQuery      = `CREATE TABLE %s (lock_key %s PRIMARY KEY, token %s, expire_at %s);`
+	postgresDropTableQuery        = `DROP TABLE IF EXISTS %s;`
+	postgresAddOwnerColumnQuery   = `ALTER TABLE %s ADD COLUMN owner varchar(255);`
+	postgresDropOwnerColumnQuery  = `ALTER TABLE %s DROP COLUMN owner;`
+	postgresAddFenceColumnQuery   = `ALTER TABLE %s ADD COLUMN fence bigint NOT NULL DEFAULT 0;`
+	postgresDropFenceColumnQuery  = `ALTER TABLE %s DROP COLUMN fence;`
+	postgresConvertExpireAtQuery  = `ALTER TABLE %s ALTER COLUMN expire_at TYPE timestamptz USING expire_at::timestamptz;`
+	postgresRevertExpireAtQuery   = `ALTER TABLE %s ALTER COLUMN expire_at TYPE timestamp USING expire_at::timestamp;`
+	postgresInitLockQuery         = `INSERT INTO %s (lock_key) VALUES ($1) ON CONFLICT (lock_key) DO NOTHING;`
+	postgresAcquireLockQuery      = `UPDATE %[1]s SET expire_at = %[2]s, token = $2, owner = $3, fence = fence + 1 WHERE lock_key = $4 AND ((expire_at IS NULL OR expire_at < %[3]s) OR token = $5);`
+	postgresReleaseLockQuery      = `UPDATE %[1]s SET expire_at = NULL WHERE lock_key = $1 AND token = $2 AND expire_at >= %[3]s;`
+	postgresExtendLockQuery       = `UPDATE %[1]s SET expire_at = %[2]s WHERE lock_key = $2 AND token = $3 AND expire_at >= %[3]s;`
+	postgresListLocksQuery        = `SELECT lock_key, token, (EXTRACT(EPOCH FROM %[4]s)*1000000)::bigint, COALESCE(expire_at >= %[3]s, false), owner, fence FROM %[1]s ORDER BY lock_key;`
+	postgresGetLockForUpdateQuery = `SELECT lock_key, token, (EXTRACT(EPOCH FROM %[4]s)*1000000)::bigint, COALESCE(expire_at >= %[3]s, false), owner, fence FROM %[1]s WHERE lock_key = $1 FOR UPDATE;`
+	postgresForceReleaseLockQuery = `UPDATE %[1]s SET expire_at = NULL WHERE lock_key = $1 AND expire_at >= %[3]s;`
+	postgresRemainingTTLQuery     = `SELECT (EXTRACT(EPOCH FROM %[2]s)*1000000)::bigint, (EXTRACT(EPOCH FROM NOW())*1000000)::bigint FROM %[1]s WHERE lock_key = $1 AND token = $2;`
 )
 
 func postgresMakeInterval(interval time.Duration) string {
 	return fmt.Sprintf("%d microseconds", interval.Microseconds())
 }
 
+func postgresMakeTimeArg(t time.Time) interface{} {
+	return t
+}
+
 //nolint:lll
 const (
-	mySQLCreateTableQuery = "CREATE TABLE `%s` (lock_key VARCHAR(40) PRIMARY KEY, token VARCHAR(36), expire_at BIGINT);"
-	mySQLDropTableQuery   = "DROP TABLE IF EXISTS `%s`;"
-	mySQLInitLockQuery    = "INSERT IGNORE `%s` (lock_key) VALUES (?);"
-	mySQLAcquireLockQuery = "UPDATE `%s` SET expire_at = UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL ? MICROSECOND))*10000, token = ? WHERE lock_key = ? AND ((expire_at IS NULL OR expire_at < UNIX_TIMESTAMP(CURTIME(4))*10000) OR token = ?);"
-	mySQLReleaseLockQuery = "UPDATE `%s` SET expire_at = NULL WHERE lock_key = ? AND token = ? AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000;"
-	mySQLExtendLockQuery  = "UPDATE `%s` SET expire_at = UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL ? MICROSECOND))*10000 WHERE lock_key = ? AND token = ? AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000;"
+	mySQLCreateTableQuery      = "CREATE TABLE %s (lock_key %s PRIMARY KEY, token %s, expire_at %s);"
+	mySQLDropTableQuery        = "DROP TABLE IF EXISTS %s;"
+	mySQLAddOwnerColumnQuery   = "ALTER TABLE %s ADD COLUMN owner VARCHAR(255);"
+	mySQLDropOwnerColumnQuery  = "ALTER TABLE %s DROP COLUMN owner;"
+	mySQLAddFenceColumnQuery   = "ALTER TABLE %s ADD COLUMN fence BIGINT NOT NULL DEFAULT 0;"
+	mySQLDropFenceColumnQuery  = "ALTER TABLE %s DROP COLUMN fence;"
+	mySQLInitLockQuery         = "INSERT IGNORE %s (lock_key) VALUES (?);"
+	mySQLAcquireLockQuery      = "UPDATE %[1]s SET expire_at = %[2]s, token = ?, owner = ?, fence = fence + 1 WHERE lock_key = ? AND (token = ? OR expire_at IS NULL OR expire_at < %[3]s);"
+	mySQLReleaseLockQuery      = "UPDATE %[1]s SET expire_at = NULL WHERE lock_key = ? AND token = ? AND expire_at >= %[3]s;"
+	mySQLExtendLockQuery       = "UPDATE %[1]s SET expire_at = %[2]s WHERE lock_key = ? AND token = ? AND expire_at >= %[3]s;"
+	mySQLListLocksQuery        = "SELECT lock_key, token, expire_at*100, COALESCE(expire_at >= %[3]s, false), owner, fence FROM %[1]s ORDER BY lock_key;"
+	mySQLGetLockForUpdateQuery = "SELECT lock_key, token, expire_at*100, COALESCE(expire_at >= %[3]s, false), owner, fence FROM %[1]s WHERE lock_key = ? FOR UPDATE;"
+	mySQLForceReleaseLockQuery = "UPDATE %[1]s SET expire_at = NULL WHERE lock_key = ? AND expire_at >= %[3]s;"
+	mySQLRemainingTTLQuery     = "SELECT expire_at*100, CAST(UNIX_TIMESTAMP(CURTIME(4))*1000000 AS SIGNED) FROM %s WHERE lock_key = ? AND token = ?;"
 )
 
 func mySQLMakeInterval(interval time.Duration) string {
 	return fmt.Sprintf("%d", interval.Microseconds())
 }
+
+// mySQLMakeTimeArg converts time to the format of expire_at column (Unix time in hundreds of microseconds).
+func mySQLMakeTimeArg(t time.Time) interface{} {
+	return t.UnixMicro() / 100
+}
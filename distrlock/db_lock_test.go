@@ -12,12 +12,14 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
 	gotesting "testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/acronis/go-appkit/log/logtest"
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
@@ -31,6 +33,44 @@ import (
 	_ "github.com/acronis/go-dbkit/postgres"
 )
 
+func TestDBLock_TryAcquireOrInfo(t *gotesting.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	dbManager, err := NewDBManagerWithOpts(dbkit.DialectMySQL, DBManagerOpts{
+		Owner: "worker-1", Now: func() time.Time { return now }})
+	require.NoError(t, err)
+	lock := DBLock{Key: "test-key", manager: dbManager}
+	lockInfoColumns := []string{"lock_key", "token", "expire_at", "acquired", "owner", "fence"}
+	selectQuery := regexp.QuoteMeta("SELECT lock_key, token, expire_at*100") + ".+" + regexp.QuoteMeta("FOR UPDATE")
+
+	// Lock is held by someone else, holder's information is returned without an attempt to acquire the lock.
+	expireAt := now.Add(time.Minute)
+	mock.ExpectQuery(selectQuery).WithArgs("test-key", now.UnixMicro()/100).WillReturnRows(
+		sqlmock.NewRows(lockInfoColumns).AddRow("test-key", "holder-token", expireAt.UnixMicro(), true, "worker-7", 3))
+	holder, err := lock.TryAcquireOrInfo(ctx, db, time.Minute)
+	require.ErrorIs(t, err, ErrLockAlreadyAcquired)
+	require.Equal(t, LockInfo{Key: "test-key", Token: "holder-token", Acquired: true,
+		ExpireAt: time.UnixMicro(expireAt.UnixMicro()), Owner: "worker-7", Fence: 3}, holder)
+	require.Empty(t, lock.Token())
+
+	// Lock is released, so it's acquired.
+	mock.ExpectQuery(selectQuery).WithArgs("test-key", now.UnixMicro()/100).WillReturnRows(
+		sqlmock.NewRows(lockInfoColumns).AddRow("test-key", "holder-token", nil, false, "worker-7", 3))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `distributed_locks` SET expire_at = ?, token = ?, owner = ?, fence = fence + 1")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	holder, err = lock.TryAcquireOrInfo(ctx, db, time.Minute)
+	require.NoError(t, err)
+	require.NotEmpty(t, lock.Token())
+	require.Equal(t, LockInfo{Key: "test-key", Token: lock.Token(), Acquired: true, Owner: "worker-1", Fence: 4}, holder)
+
+	mock.ExpectClose()
+	require.NoError(t, db.Close())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestDBManager_Postgres(t *gotesting.T) {
 	runDBManagerTests(t, dbkit.DialectPostgres)
 }
@@ -51,6 +91,65 @@ func TestDBLock_DoExclusively_MySQL(t *gotesting.T) {
 	runDBLockDoExclusivelyTests(t, dbkit.DialectMySQL)
 }
 
+func TestNewDBManagerWithOpts_SchemaAndColumnTypes(t *gotesting.T) {
+	tests := []struct {
+		dialect         dbkit.Dialect
+		columnTypes     DBColumnTypes
+		wantCreateTable string
+		wantInitLock    string
+	}{
+		{
+			dialect:         dbkit.DialectPostgres,
+			columnTypes:     DBColumnTypes{LockKey: "citext", ExpireAt: "timestamptz"},
+			wantCreateTable: `CREATE TABLE "locks"."app_locks" (lock_key citext PRIMARY KEY, token uuid, expire_at timestamptz);`,
+			wantInitLock:    `INSERT INTO "locks"."app_locks" (lock_key) VALUES ($1) ON CONFLICT (lock_key) DO NOTHING;`,
+		},
+		{
+			dialect:         dbkit.DialectMySQL,
+			columnTypes:     DBColumnTypes{LockKey: "VARBINARY(40)"},
+			wantCreateTable: "CREATE TABLE `locks`.`app_locks` (lock_key VARBINARY(40) PRIMARY KEY, token VARCHAR(36), expire_at BIGINT);",
+			wantInitLock:    "INSERT IGNORE `locks`.`app_locks` (lock_key) VALUES (?);",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *gotesting.T) {
+			dbManager, err := NewDBManagerWithOpts(tt.dialect, DBManagerOpts{
+				TableName:   "app_locks",
+				SchemaName:  "locks",
+				ColumnTypes: tt.columnTypes,
+			})
+			require.NoError(t, err)
+			require.Equal(t, []string{tt.wantCreateTable}, dbManager.Migrations()[0].UpSQL())
+			require.Equal(t, tt.wantInitLock, dbManager.queries.initLock)
+		})
+	}
+
+	_, err := NewDBManagerWithOpts(dbkit.DialectPostgres, DBManagerOpts{SchemaName: strings.Repeat("s", 64)})
+	require.ErrorIs(t, err, dbkit.ErrInvalidIdentifier)
+}
+
+func TestDBManager_Migrations_PostgresTimestamptz(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectPostgres)
+	require.NoError(t, err)
+	migrations := dbManager.Migrations()
+	require.Len(t, migrations, 4)
+	require.Equal(t, convertExpireAtMigrationID, migrations[3].ID())
+	require.Equal(t, []string{
+		`ALTER TABLE "distributed_locks" ALTER COLUMN expire_at TYPE timestamptz USING expire_at::timestamptz;`,
+	}, migrations[3].UpSQL())
+	require.NotContains(t, dbManager.queries.remainingTTL, "::timestamptz")
+
+	// Compatibility mode for the existing tables.
+	legacyDBManager, err := NewDBManagerWithOpts(dbkit.DialectPostgres, DBManagerOpts{PostgresLegacyTimestamp: true})
+	require.NoError(t, err)
+	require.Len(t, legacyDBManager.Migrations(), 3)
+	require.Contains(t, legacyDBManager.queries.remainingTTL, "expire_at::timestamptz")
+
+	mySQLDBManager, err := NewDBManager(dbkit.DialectMySQL)
+	require.NoError(t, err)
+	require.Len(t, mySQLDBManager.Migrations(), 3)
+}
+
 //nolint:gocyclo
 func runDBManagerTests(t *gotesting.T, dialect dbkit.Dialect) {
 	containerCtx, containerCtxClose := context.WithTimeout(context.Background(), time.Minute*2)
@@ -456,6 +555,307 @@ func runDBManagerTests(t *gotesting.T, dialect dbkit.Dialect) {
 		})
 		require.ErrorIs(t, extendErr, ErrLockAlreadyReleased)
 	})
+
+	t.Run("acquire, extend and release lock via *sql.DB", func(t *gotesting.T) {
+		const ctxTimeout = 10 * time.Second
+		const lockTimeout = 1 * time.Second
+		lockKey := uuid.NewString()
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer ctxCancel()
+
+		lock, err := dbManager.NewLock(ctx, dbConn, lockKey)
+		require.NoError(t, err)
+		require.NoError(t, lock.AcquireDB(ctx, dbConn, lockTimeout))
+
+		lock2, err := dbManager.NewLock(ctx, dbConn, lockKey)
+		require.NoError(t, err)
+		require.ErrorIs(t, lock2.AcquireDB(ctx, dbConn, lockTimeout), ErrLockAlreadyAcquired)
+
+		time.Sleep(lockTimeout / 2)
+		require.NoError(t, lock.ExtendDB(ctx, dbConn))
+
+		// Lock is released even if the context is canceled.
+		canceledCtx, canceledCtxCancel := context.WithCancel(ctx)
+		canceledCtxCancel()
+		require.NoError(t, lock.ReleaseDB(canceledCtx, dbConn))
+		require.ErrorIs(t, lock.ExtendDB(ctx, dbConn), ErrLockAlreadyReleased)
+
+		require.NoError(t, lock2.AcquireDB(ctx, dbConn, lockTimeout))
+		require.NoError(t, lock2.ReleaseDB(ctx, dbConn))
+	})
+
+	t.Run("remaining TTL", func(t *gotesting.T) {
+		const ctxTimeout = 10 * time.Second
+		const lockTTL = 10 * time.Second
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer ctxCancel()
+
+		lock1, lock2 := makeTwoLocks(ctx, t, dbConn, dbManager, uuid.NewString(), uuid.NewString())
+		_, err := lock1.RemainingTTL(ctx, dbConn)
+		require.ErrorIs(t, err, ErrLockAlreadyReleased)
+
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Acquire(ctx, tx, lockTTL)
+		}))
+		remainingTTL, err := lock1.RemainingTTL(ctx, dbConn)
+		require.NoError(t, err)
+		require.InDelta(t, lockTTL, remainingTTL, float64(5*time.Second))
+
+		// Lock with another token doesn't have remaining TTL.
+		lock2.Key = lock1.Key
+		lock2.token = uuid.NewString()
+		_, err = lock2.RemainingTTL(ctx, dbConn)
+		require.ErrorIs(t, err, ErrLockAlreadyReleased)
+
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Release(ctx, tx)
+		}))
+		_, err = lock1.RemainingTTL(ctx, dbConn)
+		require.ErrorIs(t, err, ErrLockAlreadyReleased)
+	})
+
+	t.Run("try acquire or get holder info", func(t *gotesting.T) {
+		const ctxTimeout = 10 * time.Second
+		const lockTTL = 10 * time.Second
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer ctxCancel()
+
+		lockKey := uuid.NewString()
+		lock1, lock2 := makeTwoLocks(ctx, t, dbConn, dbManager, lockKey, lockKey)
+
+		var holder LockInfo
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			holder, err = lock1.TryAcquireOrInfo(ctx, tx, lockTTL)
+			return err
+		}))
+		require.NotEmpty(t, lock1.Token())
+		require.Equal(t, LockInfo{Key: lockKey, Token: lock1.Token(), Acquired: true, Fence: 1}, holder)
+
+		require.ErrorIs(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			holder, err = lock2.TryAcquireOrInfo(ctx, tx, lockTTL)
+			return err
+		}), ErrLockAlreadyAcquired)
+		require.Empty(t, lock2.Token())
+		require.Equal(t, lockKey, holder.Key)
+		require.Equal(t, lock1.Token(), holder.Token)
+		require.True(t, holder.Acquired)
+		require.Equal(t, int64(1), holder.Fence)
+		require.WithinDuration(t, time.Now().Add(lockTTL), holder.ExpireAt, 5*time.Second)
+	})
+
+	t.Run("list and force release locks", func(t *gotesting.T) {
+		const ctxTimeout = 10 * time.Second
+		const lockTimeout = 10 * time.Second
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer ctxCancel()
+
+		lock1, lock2 := makeTwoLocks(ctx, t, dbConn, dbManager, uuid.NewString(), uuid.NewString())
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Acquire(ctx, tx, lockTimeout)
+		}))
+
+		findLock := func(key string) LockInfo {
+			locks, err := dbManager.ListLocks(ctx, dbConn)
+			require.NoError(t, err)
+			for _, lock := range locks {
+				if lock.Key == key {
+					return lock
+				}
+			}
+			require.FailNow(t, "lock not found", key)
+			return LockInfo{}
+		}
+
+		lockInfo1 := findLock(lock1.Key)
+		require.True(t, lockInfo1.Acquired)
+		require.Equal(t, lock1.Token(), lockInfo1.Token)
+		require.Equal(t, int64(1), lockInfo1.Fence)
+		require.WithinDuration(t, time.Now().Add(lockTimeout), lockInfo1.ExpireAt, 5*time.Second)
+		lockInfo2 := findLock(lock2.Key)
+		require.False(t, lockInfo2.Acquired)
+		require.True(t, lockInfo2.ExpireAt.IsZero())
+		require.Equal(t, int64(0), lockInfo2.Fence)
+
+		forceRelease := func(key string) error {
+			return dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+				return dbManager.ForceRelease(ctx, tx, key)
+			})
+		}
+		require.NoError(t, forceRelease(lock1.Key))
+		require.False(t, findLock(lock1.Key).Acquired)
+		require.ErrorIs(t, forceRelease(lock1.Key), ErrLockAlreadyReleased)
+		require.ErrorIs(t, forceRelease(lock2.Key), ErrLockAlreadyReleased)
+
+		// Lock may be acquired again after force release.
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock2.Acquire(ctx, tx, lockTimeout)
+		}))
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Acquire(ctx, tx, lockTimeout)
+		}))
+		require.Equal(t, int64(2), findLock(lock1.Key).Fence)
+	})
+
+	t.Run("migrations upgrade existing table in place", func(t *gotesting.T) {
+		const ctxTimeout = 10 * time.Second
+		const lockTTL = 10 * time.Second
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer ctxCancel()
+
+		upgradeDBManager, err := NewDBManagerWithOpts(dialect, DBManagerOpts{TableName: "distributed_locks_upgrade"})
+		require.NoError(t, err)
+		migrations := upgradeDBManager.Migrations()
+		if dialect == dbkit.DialectMySQL {
+			require.Len(t, migrations, 3)
+		} else {
+			require.Len(t, migrations, 4)
+			require.Equal(t, "distrlock_00004_convert_expire_at_to_timestamptz", migrations[3].ID())
+		}
+		require.Equal(t, "distrlock_00001_create_table", migrations[0].ID())
+		require.Equal(t, "distrlock_00002_add_owner_column", migrations[1].ID())
+		require.Equal(t, "distrlock_00003_add_fence_column", migrations[2].ID())
+
+		upgradeMigMngr, err := migrate.NewMigrationsManagerWithOpts(dbConn, dialect, logtest.NewLogger(),
+			migrate.MigrationsManagerOpts{TableName: "distrlock_upgrade_migrations"})
+		require.NoError(t, err)
+		require.NoError(t, upgradeMigMngr.Run(migrations[:1], migrate.MigrationsDirectionUp))
+
+		// Lock acquired by the previous version (before the upgrade) stays acquired after it.
+		lockKey := uuid.NewString()
+		_, lock2 := makeTwoLocks(ctx, t, dbConn, upgradeDBManager, lockKey, lockKey)
+		legacyAcquireQuery := `UPDATE distributed_locks_upgrade SET expire_at = NOW() + interval '10 seconds', token = $1 WHERE lock_key = $2`
+		if dialect == dbkit.DialectMySQL {
+			legacyAcquireQuery = "UPDATE distributed_locks_upgrade " +
+				"SET expire_at = UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL 10 SECOND))*10000, token = ? WHERE lock_key = ?"
+		}
+		_, err = dbConn.ExecContext(ctx, legacyAcquireQuery, uuid.NewString(), lockKey)
+		require.NoError(t, err)
+		require.NoError(t, upgradeMigMngr.Run(migrations, migrate.MigrationsDirectionUp))
+		require.ErrorIs(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock2.Acquire(ctx, tx, lockTTL)
+		}), ErrLockAlreadyAcquired)
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return upgradeDBManager.ForceRelease(ctx, tx, lockKey)
+		}))
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock2.Acquire(ctx, tx, lockTTL)
+		}))
+
+		require.NoError(t, upgradeMigMngr.Run(migrations, migrate.MigrationsDirectionDown))
+	})
+
+	t.Run("operations logging", func(t *gotesting.T) {
+		const ctxTimeout = 10 * time.Second
+		const lockTTL = 10 * time.Second
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer ctxCancel()
+
+		logRecorder := logtest.NewRecorder()
+		loggingDBManager, err := NewDBManagerWithOpts(dialect, DBManagerOpts{Logger: logRecorder})
+		require.NoError(t, err)
+
+		lockKey := uuid.NewString()
+		lock1, lock2 := makeTwoLocks(ctx, t, dbConn, loggingDBManager, lockKey, lockKey)
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Acquire(ctx, tx, lockTTL)
+		}))
+		require.ErrorIs(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock2.Acquire(ctx, tx, lockTTL)
+		}), ErrLockAlreadyAcquired)
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Extend(ctx, tx)
+		}))
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Release(ctx, tx)
+		}))
+
+		entries := logRecorder.Entries()
+		require.Len(t, entries, 4)
+		wantEntries := []struct {
+			msg          string
+			operation    string
+			token        string
+			rowsAffected int64
+		}{
+			{"db lock operation succeeded", "acquire", lock1.Token(), 1},
+			{"db lock operation failed", "acquire", "", 0},
+			{"db lock operation succeeded", "extend", lock1.Token(), 1},
+			{"db lock operation succeeded", "release", lock1.Token(), 1},
+		}
+		for i, want := range wantEntries {
+			entry := entries[i]
+			require.Equal(t, want.msg, entry.Text)
+			operation, found := entry.FindField("distrlock_operation")
+			require.True(t, found)
+			require.Equal(t, want.operation, string(operation.Bytes))
+			key, found := entry.FindField("distrlock_key")
+			require.True(t, found)
+			require.Equal(t, lockKey, string(key.Bytes))
+			rowsAffected, found := entry.FindField("rows_affected")
+			require.True(t, found)
+			require.Equal(t, want.rowsAffected, rowsAffected.Int)
+			if want.token != "" {
+				token, found := entry.FindField("distrlock_token")
+				require.True(t, found)
+				require.Equal(t, want.token, string(token.Bytes))
+			}
+			_, found = entry.FindField("duration_ms")
+			require.True(t, found)
+		}
+	})
+
+	t.Run("lock expiration with custom time source", func(t *gotesting.T) {
+		const ctxTimeout = 10 * time.Second
+		const lockTTL = time.Hour
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer ctxCancel()
+
+		var nowMu sync.Mutex
+		now := time.Now()
+		advance := func(d time.Duration) {
+			nowMu.Lock()
+			defer nowMu.Unlock()
+			now = now.Add(d)
+		}
+		clockDBManager, err := NewDBManagerWithOpts(dialect, DBManagerOpts{Now: func() time.Time {
+			nowMu.Lock()
+			defer nowMu.Unlock()
+			return now
+		}})
+		require.NoError(t, err)
+
+		lockKey := uuid.NewString()
+		lock1, lock2 := makeTwoLocks(ctx, t, dbConn, clockDBManager, lockKey, lockKey)
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Acquire(ctx, tx, lockTTL)
+		}))
+
+		advance(lockTTL / 2)
+		remainingTTL, err := lock1.RemainingTTL(ctx, dbConn)
+		require.NoError(t, err)
+		require.InDelta(t, lockTTL/2, remainingTTL, float64(time.Millisecond))
+		require.ErrorIs(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock2.Acquire(ctx, tx, lockTTL)
+		}), ErrLockAlreadyAcquired)
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Extend(ctx, tx)
+		}))
+
+		advance(lockTTL + time.Second)
+		require.ErrorIs(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock1.Extend(ctx, tx)
+		}), ErrLockAlreadyReleased)
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock2.Acquire(ctx, tx, lockTTL)
+		}))
+	})
 }
 
 func runDBLockDoExclusivelyTests(t *gotesting.T, dialect dbkit.Dialect) {
@@ -515,6 +915,40 @@ func runDBLockDoExclusivelyTests(t *gotesting.T, dialect dbkit.Dialect) {
 		}))
 	})
 
+	t.Run("lock is acquired via custom tx runner", func(t *gotesting.T) {
+		ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer ctxCancel()
+
+		var txCalls int32
+		txRunner := TxRunnerFunc(func(ctx context.Context, fn func(executor SQLExecutor) error) error {
+			atomic.AddInt32(&txCalls, 1)
+			return dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+				return fn(tx)
+			})
+		})
+
+		lockKey := uuid.NewString()
+		lock1, lock2 := makeTwoLocks(ctx, t, dbConn, dbManager, lockKey, lockKey)
+		opts := DoExclusivelyOpts{
+			LockTTL:                time.Second * 3,
+			PeriodicExtendInterval: time.Second,
+			ReleaseTimeout:         time.Second,
+			Logger:                 logtest.NewLogger(),
+		}
+		require.NoError(t, lock1.DoExclusivelyWithTxRunner(ctx, txRunner, opts, func(ctx context.Context) error {
+			require.ErrorIs(t, lock2.DoExclusivelyWithTxRunner(ctx, txRunner, opts, func(ctx context.Context) error {
+				return nil
+			}), ErrLockAlreadyAcquired)
+			return nil
+		}))
+		require.Equal(t, int32(3), atomic.LoadInt32(&txCalls)) // acquire, failed acquire, release
+
+		// Lock is released, so it can be acquired again.
+		require.NoError(t, lock2.DoExclusivelyWithTxRunner(ctx, txRunner, opts, func(ctx context.Context) error {
+			return nil
+		}))
+	})
+
 	t.Run("lock is acquired but periodic extension interval is too long", func(t *gotesting.T) {
 		ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*30)
 		defer ctxCancel()
@@ -0,0 +1,94 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package distrlocktest provides helpers for testing code that uses distributed locks from the distrlock package.
+package distrlocktest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/distrlock"
+)
+
+// Clock is a fake time source that may be used for controlling expiration of distributed locks in tests.
+// It's safe for concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock creates new fake clock that is set to the passed time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by the passed duration.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set sets the clock to the passed time.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// NewDBManager creates new distrlock.DBManager that uses the passed fake clock instead of the database server time.
+// It allows fast-forwarding locks expiration without real sleeps:
+//
+//	clock := distrlocktest.NewClock(time.Now())
+//	dbManager, err := distrlocktest.NewDBManager(dbkit.DialectPostgres, clock)
+//	// ... acquire lock with 1 minute TTL ...
+//	clock.Advance(2 * time.Minute) // Lock is expired now.
+func NewDBManager(dialect dbkit.Dialect, clock *Clock) (*distrlock.DBManager, error) {
+	return NewDBManagerWithOpts(dialect, clock, distrlock.DBManagerOpts{})
+}
+
+// NewDBManagerWithOpts is a more configurable version of the NewDBManager.
+// DBManagerOpts.Now is overridden by the passed fake clock.
+func NewDBManagerWithOpts(dialect dbkit.Dialect, clock *Clock, opts distrlock.DBManagerOpts) (*distrlock.DBManager, error) {
+	opts.Now = clock.Now
+	return distrlock.NewDBManagerWithOpts(dialect, opts)
+}
+
+// Locker is a fake in-memory implementation of the distrlock.Locker interface.
+// Expiration of locks is controlled by the fake clock, and any lock may be expired explicitly (see Expire),
+// so the code that loses the lock (e.g. because of a DB failover) may be tested without a database and real sleeps:
+//
+//	clock := distrlocktest.NewClock(time.Now())
+//	locker := distrlocktest.NewLocker(clock)
+//	// ... run distrlock.DoExclusively(ctx, locker, "key", time.Minute, ...) ...
+//	clock.Advance(2 * time.Minute) // Lock is expired now, so the next extension fails.
+type Locker struct {
+	*distrlock.InMemoryManager
+}
+
+var _ distrlock.Locker = (*Locker)(nil)
+
+// NewLocker creates new fake distrlock.Locker that uses the passed fake clock.
+func NewLocker(clock *Clock) *Locker {
+	return &Locker{distrlock.NewInMemoryManagerWithOpts(distrlock.InMemoryManagerOpts{Now: clock.Now})}
+}
+
+// Expire makes the lock for the key expired regardless of its TTL and token,
+// so its holder fails to extend or release it with distrlock.ErrLockAlreadyReleased error.
+// distrlock.ErrLockAlreadyReleased error will be returned if lock is not acquired.
+func (l *Locker) Expire(key string) error {
+	return l.ForceRelease(context.Background(), key)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package distrlocktest
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/distrlock"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	require.Equal(t, start, clock.Now())
+	clock.Advance(time.Hour)
+	require.Equal(t, start.Add(time.Hour), clock.Now())
+	clock.Set(start)
+	require.Equal(t, start, clock.Now())
+}
+
+func TestNewDBManager(t *testing.T) {
+	ctx := context.Background()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	dbManager, err := NewDBManagerWithOpts(dbkit.DialectMySQL, clock, distrlock.DBManagerOpts{Owner: "worker-1"})
+	require.NoError(t, err)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE `distributed_locks`")).
+		WithArgs("test-key").WillReturnResult(sqlmock.NewResult(0, 1))
+	var lock distrlock.DBLock
+	lock, err = dbManager.NewLock(ctx, db, "test-key")
+	require.NoError(t, err)
+
+	// Expiration is computed using the fake clock (Unix time in hundreds of microseconds).
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `distributed_locks` SET expire_at = ?, token = ?, owner = ?, fence = fence + 1")).
+		WithArgs(start.Add(time.Minute).UnixMicro()/100, "test-token", "worker-1", "test-key", "test-token", start.UnixMicro()/100).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, lock.AcquireWithStaticToken(ctx, db, "test-token", time.Minute))
+
+	clock.Advance(2 * time.Minute)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `distributed_locks` SET expire_at = NULL")).
+		WithArgs("test-key", "test-token", start.Add(2*time.Minute).UnixMicro()/100).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	require.ErrorIs(t, lock.Release(ctx, db), distrlock.ErrLockAlreadyReleased)
+
+	mock.ExpectClose()
+	require.NoError(t, db.Close())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	const lockTTL = time.Minute
+
+	t.Run("lock expires when clock is advanced", func(t *testing.T) {
+		clock := NewClock(time.Now())
+		locker := NewLocker(clock)
+
+		token, err := locker.Acquire(ctx, "key", lockTTL)
+		require.NoError(t, err)
+		_, err = locker.Acquire(ctx, "key", lockTTL)
+		require.ErrorIs(t, err, distrlock.ErrLockAlreadyAcquired)
+
+		clock.Advance(lockTTL + time.Second)
+		require.ErrorIs(t, locker.Extend(ctx, "key", token, lockTTL), distrlock.ErrLockAlreadyReleased)
+		_, err = locker.Acquire(ctx, "key", lockTTL)
+		require.NoError(t, err)
+	})
+
+	t.Run("lock is expired explicitly", func(t *testing.T) {
+		locker := NewLocker(NewClock(time.Now()))
+		token, err := locker.Acquire(ctx, "key", lockTTL)
+		require.NoError(t, err)
+		require.NoError(t, locker.Expire("key"))
+		require.ErrorIs(t, locker.Expire("key"), distrlock.ErrLockAlreadyReleased)
+		require.ErrorIs(t, locker.Release(ctx, "key", token), distrlock.ErrLockAlreadyReleased)
+		require.Empty(t, locker.ListLocks())
+	})
+
+	t.Run("exclusive job is canceled when lock expires", func(t *testing.T) {
+		clock := NewClock(time.Now())
+		locker := NewLocker(clock)
+		logger := logtest.NewRecorder()
+
+		err := distrlock.DoExclusively(ctx, locker, "key", lockTTL, 10*time.Millisecond, time.Second, logger,
+			func(ctx context.Context) error {
+				require.Len(t, locker.ListLocks(), 1)
+				clock.Advance(lockTTL + time.Second)
+				<-ctx.Done()
+				return ctx.Err()
+			})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Empty(t, locker.ListLocks())
+		require.NotEmpty(t, logger.Entries())
+	})
+}
@@ -7,4 +7,21 @@ Released under MIT license.
 // Package distrlock contains DML (distributed lock manager) implementation (now DMLs based on MySQL and PostgreSQL are supported).
 // Now only manager that uses SQL database (PostgreSQL and MySQL are currently supported) is available.
 // Other implementations (for example, based on Redis) will probably be implemented in the future.
+//
+// Locker interface allows doing something exclusively (see DoExclusively) without managing transactions,
+// it's implemented by DBLocker and by InMemoryManager that may be used in unit tests instead of a real database.
+// TxRunner allows running lock queries in transactions started by any database wrapper (dbr, goqu, etc.),
+// so keeping a raw *sql.DB just for locking is not required (see NewDBLockerWithTxRunner).
+// DBLock.AcquireDB, DBLock.ExtendDB and DBLock.ReleaseDB manage transactions internally (bounded by the lock TTL),
+// so the lock may be used with a plain *sql.DB.
+//
+// Each acquisition increments the fencing token of the lock and stores its owner (see DBManagerOpts.Owner),
+// both are returned in LockInfo (distrlock_00002_* and distrlock_00003_* migrations add the columns).
+// DBManagerOpts.SchemaName places the locks table into a separate (e.g. low-privilege) schema,
+// and DBManagerOpts.ColumnTypes customizes column types (e.g. citext keys).
+// Expiration time column of the Postgres table is converted to timestamptz by the distrlock_00004_* migration,
+// DBManagerOpts.PostgresLegacyTimestamp keeps existing tables with timestamp column working without it.
+//
+// Package distrlocktest provides a fake clock, a DB manager and an in-memory fake Locker driven by it,
+// so locks expiration may be fast-forwarded in tests without real sleeps.
 package distrlock
@@ -0,0 +1,39 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package distrlock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+
+	"github.com/acronis/go-dbkit/distrlock"
+)
+
+func ExampleDoExclusively() {
+	// InMemoryManager is used here for simplicity, DBLocker should be used for locking across processes.
+	var locker distrlock.Locker = distrlock.NewInMemoryManager()
+	ctx := context.Background()
+	logger := log.NewDisabledLogger()
+
+	err := distrlock.DoExclusively(ctx, locker, "daily-report", time.Minute, 20*time.Second, time.Second, logger,
+		func(ctx context.Context) error {
+			// Lock is held (and extended periodically) while the function is running.
+			err := distrlock.DoExclusively(ctx, locker, "daily-report", time.Minute, 20*time.Second, time.Second, logger,
+				func(ctx context.Context) error { return nil })
+			fmt.Println(errors.Is(err, distrlock.ErrLockAlreadyAcquired))
+			return nil
+		})
+	fmt.Println(err)
+
+	// Output:
+	// true
+	// <nil>
+}
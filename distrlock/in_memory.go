@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package distrlock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InMemoryManager is an in-process implementation of the Locker interface.
+// It has the same TTL and token semantics as DBManager, but locks are not shared between processes,
+// so it's supposed to be used in unit tests of the code that does something exclusively.
+type InMemoryManager struct {
+	mu    sync.Mutex
+	locks map[string]inMemoryLock
+	now   func() time.Time
+}
+
+var _ Locker = (*InMemoryManager)(nil)
+
+type inMemoryLock struct {
+	token    string
+	expireAt time.Time
+}
+
+// InMemoryManagerOpts represents an options for InMemoryManager.
+type InMemoryManagerOpts struct {
+	// Now returns the current time that is used for computing and checking expiration of locks.
+	// By default (nil), time.Now is used.
+	Now func() time.Time
+}
+
+// NewInMemoryManager creates new in-memory distributed lock manager.
+func NewInMemoryManager() *InMemoryManager {
+	return NewInMemoryManagerWithOpts(InMemoryManagerOpts{})
+}
+
+// NewInMemoryManagerWithOpts is a more configurable version of the NewInMemoryManager.
+func NewInMemoryManagerWithOpts(opts InMemoryManagerOpts) *InMemoryManager {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &InMemoryManager{locks: make(map[string]inMemoryLock), now: opts.Now}
+}
+
+// Acquire acquires lock for the key and returns its token.
+func (m *InMemoryManager) Acquire(ctx context.Context, key string, lockTTL time.Duration) (token string, err error) {
+	token = uuid.NewString()
+	if err = m.AcquireWithStaticToken(ctx, key, token, lockTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// AcquireWithStaticToken acquires lock for the key with a static token.
+// See DBLock.AcquireWithStaticToken for details.
+func (m *InMemoryManager) AcquireWithStaticToken(ctx context.Context, key string, token string, lockTTL time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validateLockKey(key); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if lock, ok := m.locks[key]; ok && lock.token != token && !lock.expireAt.Before(now) {
+		return ErrLockAlreadyAcquired
+	}
+	m.locks[key] = inMemoryLock{token: token, expireAt: now.Add(lockTTL)}
+	return nil
+}
+
+// Release releases lock for the key.
+func (m *InMemoryManager) Release(ctx context.Context, key string, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.getHeldLock(key, token); err != nil {
+		return err
+	}
+	delete(m.locks, key)
+	return nil
+}
+
+// Extend resets expiration timeout for already acquired lock.
+func (m *InMemoryManager) Extend(ctx context.Context, key string, token string, lockTTL time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, err := m.getHeldLock(key, token)
+	if err != nil {
+		return err
+	}
+	lock.expireAt = m.now().Add(lockTTL)
+	m.locks[key] = lock
+	return nil
+}
+
+// ForceRelease releases lock for the key regardless of its token.
+// ErrLockAlreadyReleased error will be returned if lock is not acquired.
+func (m *InMemoryManager) ForceRelease(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lock, ok := m.locks[key]; !ok || lock.expireAt.Before(m.now()) {
+		return ErrLockAlreadyReleased
+	}
+	delete(m.locks, key)
+	return nil
+}
+
+// ListLocks returns all currently acquired locks ordered by key.
+func (m *InMemoryManager) ListLocks() []LockInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	locks := make([]LockInfo, 0, len(m.locks))
+	for key, lock := range m.locks {
+		if lock.expireAt.Before(now) {
+			continue
+		}
+		locks = append(locks, LockInfo{Key: key, Token: lock.token, Acquired: true, ExpireAt: lock.expireAt})
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Key < locks[j].Key })
+	return locks
+}
+
+func (m *InMemoryManager) getHeldLock(key string, token string) (inMemoryLock, error) {
+	lock, ok := m.locks[key]
+	if !ok || lock.token != token || lock.expireAt.Before(m.now()) {
+		return inMemoryLock{}, ErrLockAlreadyReleased
+	}
+	return lock, nil
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package distrlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestInMemoryManager(t *testing.T) {
+	ctx := context.Background()
+	const lockTTL = time.Minute
+
+	t.Run("acquire, extend and release", func(t *testing.T) {
+		clock := &testClock{now: time.Now()}
+		mngr := NewInMemoryManagerWithOpts(InMemoryManagerOpts{Now: clock.Now})
+
+		token, err := mngr.Acquire(ctx, "key", lockTTL)
+		require.NoError(t, err)
+		require.NotEmpty(t, token)
+		_, err = mngr.Acquire(ctx, "key", lockTTL)
+		require.ErrorIs(t, err, ErrLockAlreadyAcquired)
+
+		locks := mngr.ListLocks()
+		require.Len(t, locks, 1)
+		require.Equal(t, LockInfo{Key: "key", Token: token, Acquired: true, ExpireAt: clock.Now().Add(lockTTL)}, locks[0])
+
+		clock.Advance(lockTTL / 2)
+		require.NoError(t, mngr.Extend(ctx, "key", token, lockTTL))
+		clock.Advance(lockTTL / 2)
+		_, err = mngr.Acquire(ctx, "key", lockTTL)
+		require.ErrorIs(t, err, ErrLockAlreadyAcquired)
+		require.ErrorIs(t, mngr.Release(ctx, "key", "another-token"), ErrLockAlreadyReleased)
+
+		require.NoError(t, mngr.Release(ctx, "key", token))
+		require.ErrorIs(t, mngr.Release(ctx, "key", token), ErrLockAlreadyReleased)
+		require.Empty(t, mngr.ListLocks())
+
+		_, err = mngr.Acquire(ctx, "key", lockTTL)
+		require.NoError(t, err)
+	})
+
+	t.Run("lock expiration", func(t *testing.T) {
+		clock := &testClock{now: time.Now()}
+		mngr := NewInMemoryManagerWithOpts(InMemoryManagerOpts{Now: clock.Now})
+
+		token, err := mngr.Acquire(ctx, "key", lockTTL)
+		require.NoError(t, err)
+		clock.Advance(lockTTL + time.Second)
+		require.Empty(t, mngr.ListLocks())
+		require.ErrorIs(t, mngr.Extend(ctx, "key", token, lockTTL), ErrLockAlreadyReleased)
+		require.ErrorIs(t, mngr.Release(ctx, "key", token), ErrLockAlreadyReleased)
+
+		token2, err := mngr.Acquire(ctx, "key", lockTTL)
+		require.NoError(t, err)
+		require.NotEqual(t, token, token2)
+	})
+
+	t.Run("acquire with static token", func(t *testing.T) {
+		mngr := NewInMemoryManager()
+		require.NoError(t, mngr.AcquireWithStaticToken(ctx, "key", "token", lockTTL))
+		require.NoError(t, mngr.AcquireWithStaticToken(ctx, "key", "token", lockTTL))
+		require.ErrorIs(t, mngr.AcquireWithStaticToken(ctx, "key", "another-token", lockTTL), ErrLockAlreadyAcquired)
+	})
+
+	t.Run("force release", func(t *testing.T) {
+		mngr := NewInMemoryManager()
+		token, err := mngr.Acquire(ctx, "key", lockTTL)
+		require.NoError(t, err)
+		require.NoError(t, mngr.ForceRelease(ctx, "key"))
+		require.ErrorIs(t, mngr.ForceRelease(ctx, "key"), ErrLockAlreadyReleased)
+		require.ErrorIs(t, mngr.Extend(ctx, "key", token, lockTTL), ErrLockAlreadyReleased)
+		require.Empty(t, mngr.ListLocks())
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		mngr := NewInMemoryManager()
+		_, err := mngr.Acquire(ctx, "", lockTTL)
+		require.Error(t, err)
+		_, err = mngr.Acquire(ctx, "0123456789012345678901234567890123456789x", lockTTL)
+		require.Error(t, err)
+	})
+}
+
+func TestDoExclusively_InMemory(t *testing.T) {
+	ctx := context.Background()
+	const lockTTL = 100 * time.Millisecond
+	const extendInterval = 20 * time.Millisecond
+
+	mngr := NewInMemoryManager()
+	logger := logtest.NewRecorder()
+
+	var concurrentCalls int32
+	err := DoExclusively(ctx, mngr, "key", lockTTL, extendInterval, time.Second, logger, func(ctx context.Context) error {
+		require.Len(t, mngr.ListLocks(), 1)
+
+		// Lock is periodically extended, so it's not expired after a few TTLs.
+		time.Sleep(lockTTL * 3)
+		require.Len(t, mngr.ListLocks(), 1)
+
+		return DoExclusively(ctx, mngr, "key", lockTTL, extendInterval, time.Second, logger, func(ctx context.Context) error {
+			atomic.AddInt32(&concurrentCalls, 1)
+			return nil
+		})
+	})
+	require.ErrorIs(t, err, ErrLockAlreadyAcquired)
+	require.Zero(t, atomic.LoadInt32(&concurrentCalls))
+	require.Empty(t, mngr.ListLocks(), "lock should be released")
+	require.Empty(t, logger.Entries())
+
+	fnErr := errors.New("fn error")
+	require.ErrorIs(t, DoExclusively(ctx, mngr, "key", lockTTL, extendInterval, time.Second, logger, func(ctx context.Context) error {
+		return fnErr
+	}), fnErr)
+	require.Empty(t, mngr.ListLocks(), "lock should be released")
+}
+
+func TestDoExclusivelyWithOpts_Jitter_InMemory(t *testing.T) {
+	ctx := context.Background()
+	mngr := NewInMemoryManager()
+	logger := logtest.NewRecorder()
+
+	err := DoExclusivelyWithOpts(ctx, mngr, "key", DoExclusivelyOpts{
+		LockTTL:                      100 * time.Millisecond,
+		PeriodicExtendInterval:       20 * time.Millisecond,
+		ReleaseTimeout:               time.Second,
+		Logger:                       logger,
+		LockTTLJitter:                0.2,
+		PeriodicExtendIntervalJitter: 0.5,
+	}, func(ctx context.Context) error {
+		// Lock is periodically extended, so it's not expired after a few TTLs.
+		time.Sleep(300 * time.Millisecond)
+		require.Len(t, mngr.ListLocks(), 1)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, mngr.ListLocks(), "lock should be released")
+	require.Empty(t, logger.Entries())
+}
+
+func TestDoExclusivelyWithOpts_InvalidJitter_InMemory(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    DoExclusivelyOpts
+		wantErr string
+	}{
+		{
+			name:    "negative lock TTL jitter",
+			opts:    DoExclusivelyOpts{LockTTL: time.Minute, PeriodicExtendInterval: time.Second, LockTTLJitter: -0.1},
+			wantErr: "lock TTL jitter must be from 0 to 1, got -0.1",
+		},
+		{
+			name:    "too big periodic extend interval jitter",
+			opts:    DoExclusivelyOpts{LockTTL: time.Minute, PeriodicExtendInterval: time.Second, PeriodicExtendIntervalJitter: 1.5},
+			wantErr: "periodic extend interval jitter must be from 0 to 1, got 1.5",
+		},
+		{
+			name: "lock may expire between extensions",
+			opts: DoExclusivelyOpts{LockTTL: 10 * time.Second, PeriodicExtendInterval: 6 * time.Second,
+				LockTTLJitter: 0.2, PeriodicExtendIntervalJitter: 0.5},
+			wantErr: "periodic extend interval with jitter (up to 9s) must be less than lock TTL with jitter (from 8s)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mngr := NewInMemoryManager()
+			err := DoExclusivelyWithOpts(context.Background(), mngr, "key", tt.opts, func(ctx context.Context) error {
+				t.Fatal("fn should not be called")
+				return nil
+			})
+			require.EqualError(t, err, tt.wantErr)
+			require.Empty(t, mngr.ListLocks())
+		})
+	}
+}
+
+func TestApplyJitter(t *testing.T) {
+	const d = time.Second
+	require.Equal(t, d, applyJitter(d, 0))
+	require.Equal(t, d, applyJitter(d, -0.5))
+	require.Equal(t, time.Duration(0), applyJitter(0, 0.5))
+	for i := 0; i < 1000; i++ {
+		jittered := applyJitter(d, 0.1)
+		require.GreaterOrEqual(t, jittered, 900*time.Millisecond)
+		require.LessOrEqual(t, jittered, 1100*time.Millisecond)
+		require.LessOrEqual(t, applyJitter(d, 5), 2*d)
+	}
+}
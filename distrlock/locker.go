@@ -0,0 +1,252 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package distrlock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+)
+
+const maxLockKeyLen = 40
+
+// Locker is an interface for managing distributed locks by their keys.
+// Unlike DBLock, it doesn't require the caller to manage database transactions,
+// so the code that depends on it may be tested without a database (see InMemoryManager).
+type Locker interface {
+	// Acquire acquires lock for the key and returns its token.
+	// ErrLockAlreadyAcquired error will be returned if lock is held by someone else.
+	Acquire(ctx context.Context, key string, lockTTL time.Duration) (token string, err error)
+
+	// Release releases lock for the key acquired with the passed token.
+	// ErrLockAlreadyReleased error will be returned if lock is already released or expired.
+	Release(ctx context.Context, key string, token string) error
+
+	// Extend resets expiration timeout for the lock acquired with the passed token.
+	// ErrLockAlreadyReleased error will be returned if lock is already released or expired.
+	Extend(ctx context.Context, key string, token string, lockTTL time.Duration) error
+}
+
+// DBLocker implements Locker interface using DBManager.
+// Each operation is performed in a separate transaction.
+type DBLocker struct {
+	manager  *DBManager
+	txRunner TxRunner
+}
+
+var _ Locker = (*DBLocker)(nil)
+
+// NewDBLocker creates new DBLocker.
+func NewDBLocker(manager *DBManager, dbConn *sql.DB) *DBLocker {
+	return NewDBLockerWithTxRunner(manager, NewSQLTxRunner(dbConn))
+}
+
+// NewDBLockerWithTxRunner creates new DBLocker that performs each operation in a transaction started by the passed TxRunner.
+func NewDBLockerWithTxRunner(manager *DBManager, txRunner TxRunner) *DBLocker {
+	return &DBLocker{manager: manager, txRunner: txRunner}
+}
+
+// Acquire acquires lock for the key in the database and returns its token.
+func (l *DBLocker) Acquire(ctx context.Context, key string, lockTTL time.Duration) (token string, err error) {
+	err = l.txRunner.DoInTx(ctx, func(executor SQLExecutor) error {
+		lock, lockErr := l.manager.NewLock(ctx, executor, key)
+		if lockErr != nil {
+			return lockErr
+		}
+		if lockErr = lock.Acquire(ctx, executor, lockTTL); lockErr != nil {
+			return lockErr
+		}
+		token = lock.Token()
+		return nil
+	})
+	return token, err
+}
+
+// Release releases lock for the key in the database.
+func (l *DBLocker) Release(ctx context.Context, key string, token string) error {
+	lock := DBLock{Key: key, token: token, manager: l.manager}
+	return l.txRunner.DoInTx(ctx, func(executor SQLExecutor) error {
+		return lock.Release(ctx, executor)
+	})
+}
+
+// Extend resets expiration timeout for already acquired lock in the database.
+func (l *DBLocker) Extend(ctx context.Context, key string, token string, lockTTL time.Duration) error {
+	lock := DBLock{Key: key, TTL: lockTTL, token: token, manager: l.manager}
+	return l.txRunner.DoInTx(ctx, func(executor SQLExecutor) error {
+		return lock.Extend(ctx, executor)
+	})
+}
+
+// DoExclusivelyOpts represents options for DoExclusivelyWithOpts.
+type DoExclusivelyOpts struct {
+	LockTTL                time.Duration
+	PeriodicExtendInterval time.Duration
+	ReleaseTimeout         time.Duration
+	Logger                 log.FieldLogger
+
+	// LockTTLJitter is a fraction (from 0 to 1) of LockTTL by which it's randomly increased or decreased
+	// once per DoExclusively call. PeriodicExtendIntervalJitter is a fraction (from 0 to 1) of PeriodicExtendInterval
+	// by which each interval between extensions is randomly increased or decreased.
+	// Jitter helps to avoid thundering-herd re-acquisition when many workers lose locks simultaneously
+	// (e.g. after a DB failover). If jitter is used, maximal extension interval must be less than minimal lock TTL,
+	// otherwise DoExclusivelyWithOpts returns an error without acquiring the lock.
+	LockTTLJitter                float64
+	PeriodicExtendIntervalJitter float64
+}
+
+// DoExclusively acquires distributed lock using the passed Locker, starts a separate goroutine
+// that periodical extends it and calls passed function.
+// When function is finished, acquired lock is released.
+func DoExclusively(
+	ctx context.Context,
+	locker Locker,
+	key string,
+	lockTTL time.Duration,
+	periodicExtendInterval time.Duration,
+	releaseTimeout time.Duration,
+	logger log.FieldLogger,
+	fn func(ctx context.Context) error,
+) error {
+	return DoExclusivelyWithOpts(ctx, locker, key, DoExclusivelyOpts{
+		LockTTL:                lockTTL,
+		PeriodicExtendInterval: periodicExtendInterval,
+		ReleaseTimeout:         releaseTimeout,
+		Logger:                 logger,
+	}, fn)
+}
+
+// DoExclusivelyWithOpts is a more configurable version of DoExclusively.
+func DoExclusivelyWithOpts(
+	ctx context.Context, locker Locker, key string, opts DoExclusivelyOpts, fn func(ctx context.Context) error,
+) error {
+	if err := validateJitter(opts); err != nil {
+		return err
+	}
+	lockTTL := applyJitter(opts.LockTTL, opts.LockTTLJitter)
+	var token string
+	acquire := func(ctx context.Context) (string, error) {
+		var err error
+		token, err = locker.Acquire(ctx, key, lockTTL)
+		return token, err
+	}
+	extend := func(ctx context.Context) error {
+		return locker.Extend(ctx, key, token, lockTTL)
+	}
+	release := func(ctx context.Context) error {
+		return locker.Release(ctx, key, token)
+	}
+	return doExclusively(ctx, key, acquire, extend, release, opts, fn)
+}
+
+func doExclusively(
+	ctx context.Context,
+	key string,
+	acquire func(ctx context.Context) (token string, err error),
+	extend func(ctx context.Context) error,
+	release func(ctx context.Context) error,
+	opts DoExclusivelyOpts,
+	fn func(ctx context.Context) error,
+) error {
+	token, acquireLockErr := acquire(ctx)
+	if acquireLockErr != nil {
+		return acquireLockErr
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.NewDisabledLogger()
+	}
+	logger = logger.With(log.String("distrlock_key", key), log.String("distrlock_token", token))
+
+	defer func() {
+		// If the ctx is canceled, we should be able to release the lock.
+		releaseCtx, releaseCtxCancel := context.WithTimeout(context.Background(), opts.ReleaseTimeout)
+		defer releaseCtxCancel()
+		if releaseLockErr := release(releaseCtx); releaseLockErr != nil {
+			logger.Error("failed to release db lock", log.Error(releaseLockErr))
+		}
+	}()
+
+	newCtx, newCtxCancel := context.WithCancel(ctx)
+	defer newCtxCancel()
+	periodicalExtensionExit := make(chan struct{})
+	periodicalExtensionDone := make(chan struct{})
+	defer func() {
+		close(periodicalExtensionDone)
+		<-periodicalExtensionExit
+	}()
+	go func() {
+		defer func() { close(periodicalExtensionExit) }()
+		timer := time.NewTimer(applyJitter(opts.PeriodicExtendInterval, opts.PeriodicExtendIntervalJitter))
+		defer timer.Stop()
+		for {
+			select {
+			case <-periodicalExtensionDone:
+				return
+			case <-timer.C:
+				if extendLockErr := extend(ctx); extendLockErr != nil {
+					logger.Error("failed to extend db lock", log.Error(extendLockErr))
+					if errors.Is(extendLockErr, ErrLockAlreadyReleased) {
+						newCtxCancel() // If lock was already released, let's try to stop exclusive job asap.
+						return
+					}
+				}
+				timer.Reset(applyJitter(opts.PeriodicExtendInterval, opts.PeriodicExtendIntervalJitter))
+			}
+		}
+	}()
+
+	return fn(newCtx)
+}
+
+// validateJitter checks that jitter fractions are within [0, 1] and that the lock cannot expire
+// between extensions because of them.
+func validateJitter(opts DoExclusivelyOpts) error {
+	if opts.LockTTLJitter < 0 || opts.LockTTLJitter > 1 {
+		return fmt.Errorf("lock TTL jitter must be from 0 to 1, got %v", opts.LockTTLJitter)
+	}
+	if opts.PeriodicExtendIntervalJitter < 0 || opts.PeriodicExtendIntervalJitter > 1 {
+		return fmt.Errorf("periodic extend interval jitter must be from 0 to 1, got %v", opts.PeriodicExtendIntervalJitter)
+	}
+	if opts.LockTTLJitter == 0 && opts.PeriodicExtendIntervalJitter == 0 {
+		return nil
+	}
+	maxExtendInterval := float64(opts.PeriodicExtendInterval) * (1 + opts.PeriodicExtendIntervalJitter)
+	minLockTTL := float64(opts.LockTTL) * (1 - opts.LockTTLJitter)
+	if maxExtendInterval >= minLockTTL {
+		return fmt.Errorf("periodic extend interval with jitter (up to %v) must be less than lock TTL with jitter (from %v)",
+			time.Duration(maxExtendInterval), time.Duration(minLockTTL))
+	}
+	return nil
+}
+
+// applyJitter randomly increases or decreases the duration by up to the jitter fraction of it.
+func applyJitter(d time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 || d <= 0 {
+		return d
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return time.Duration(float64(d) * (1 + jitter*(2*rand.Float64()-1))) // nolint: gosec // Cryptographic randomness is not needed.
+}
+
+func validateLockKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("lock key cannot be empty")
+	}
+	if len(key) > maxLockKeyLen {
+		return fmt.Errorf("lock key cannot be longer than %d symbols", maxLockKeyLen)
+	}
+	return nil
+}
@@ -5,4 +5,51 @@ Released under MIT license.
 */
 
 // Package dbkit provides helpers for working with different SQL databases (MySQL, PostgreSQL, SQLite, and MSSQL).
+//
+// # Transactions
+//
+// DoInTx runs a function in a transaction and commits or rolls it back depending on the returned error.
+// Failures of beginning and committing the transaction are returned as *TxBeginError and *TxCommitError,
+// so they may be distinguished via errors.As without string matching. If the rollback fails,
+// its error is attached to the returned one and may be obtained as *TxRollbackError.
+// NewContextWithTx and TxFromContext carry a transaction in the context, and DoInTxCtx reuses it
+// (or begins a new one if there is none), so repository functions may be transaction-agnostic.
+// TxAuditor (put into the context via NewContextWithTxAuditor) is called right before the commit
+// with the entries recorded during the transaction (see RecordAudit), so changes are audited atomically.
+// DoInSchemaTx pins Postgres search_path to the tenant schema via SET LOCAL for the schema-per-tenant multi-tenancy.
+// DoInTxWithStatementTimeout sets a server-side statement timeout for the transaction
+// (Postgres statement_timeout, MySQL max_execution_time), so long-running queries are killed by the server.
+// DoInTxBatched processes a large number of items (e.g. in backfill jobs) in fixed-size batches,
+// each in its own transaction with retries, and reports progress that may be used for resuming (see TxBatchOpts.ResumeFrom).
+//
+// # Connections
+//
+// DoWithConn pins a single connection for features that require connection affinity (MySQL GET_LOCK,
+// Postgres session-level advisory locks, temporary tables), DoWithConnWithOpts also sets session variables
+// on acquire and resets them on release. DoWithTempTable creates a dialect-appropriate temporary table
+// bound to a pinned connection or transaction, allows bulk-loading it (e.g. for large IN-lists) and drops it afterwards.
+// WarmUp concurrently opens and pings connections up front to avoid first-request latency spikes after deploys.
+// MySQL and Postgres may be connected via Unix domain sockets (e.g. of cloud-sql-proxy sidecars)
+// by setting db.mysql.socket or db.postgres.socket instead of the host and port.
+// Custom dialers (GCP Cloud SQL connector, AWS RDS proxy with TLS, SSH tunnels) may be registered by RegisterDialer
+// and selected by name via db.mysql.dialer or db.postgres.dialer.
+//
+// # Pools, shards and replicas
+//
+// PoolManager maintains connection pools keyed by tenant: pools are opened lazily, the least recently used
+// and idle ones are closed once released by all users, and per-pool statistics are exported to Prometheus
+// labeled by the key (so DSNs must not be used as keys).
+// Sharder routes database access to one of the shards (configured as a db.shards list) by the hash of the sharding key
+// and allows running fan-out queries on all shards concurrently (see Sharder.ForEachShard).
+// ReplicaLagChecker measures replication lag of the Postgres or MySQL replica and exports it to Prometheus.
+// Being passed to Router, it removes the stale replica from the read rotation (see RouterOpts.MaxReplicaLag).
+//
+// # Queries and observability
+//
+// QuoteIdentifier and QuoteQualified quote (and ValidateIdentifier validates) table, column and schema names
+// according to the dialect, so they may be safely used in dynamically built SQL.
+// InstrumentedQuerier wraps *sql.DB, *sql.Tx or *sql.Conn and provides Exec, Query and QueryRow
+// that accept an annotation name, collect query metrics via QueryMetrics and log slow queries.
+// DeadlockDiagnostics (see StatementRetryOpts.DeadlockDiagnostics) captures Postgres pg_stat_activity
+// or MySQL SHOW ENGINE INNODB STATUS excerpt on a side connection when a deadlock or serialization failure is retried.
 package dbkit
@@ -0,0 +1,399 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+)
+
+// driverHooks contains callbacks that are called by the wrappers of the driver objects (see wrapConnector).
+// All callbacks are optional.
+type driverHooks struct {
+	// rewriteQuery is called before the query is prepared or executed.
+	// Returned query is passed to the driver and to the other hooks.
+	rewriteQuery func(ctx context.Context, query string) string
+
+	// stmtOpened is called when a statement is prepared. Returned func (if not nil) is called when the statement is closed.
+	stmtOpened func(query string) (closed func())
+
+	// rowsOpened is called when a query returns rows.
+	// Returned func (if not nil) is called when the rows are closed with the number of rows that were read.
+	rowsOpened func(ctx context.Context, query string) (closed func(rowsRead int))
+
+	// execDone is called when a query is executed successfully via Exec.
+	execDone func(ctx context.Context, query string, result driver.Result)
+
+	// connectorClosed is called when *sql.DB opened with the wrapped connector is closed.
+	connectorClosed func()
+}
+
+// NewConnector returns driver.Connector for the specified driver name and DSN.
+// It may be used for opening *sql.DB (via sql.OpenDB) with the wrapped connector
+// (e.g. LeakDetector.WrapConnector or MetricsCollector.WrapConnectorForRowsMetrics).
+func NewConnector(driverName, dsn string) (driver.Connector, error) {
+	drv, err := getDriverByName(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driverCtx, ok := drv.(driver.DriverContext); ok {
+		return driverCtx.OpenConnector(dsn)
+	}
+	return &dsnConnector{driver: drv, dsn: dsn}, nil
+}
+
+// UnwrapDriverConn returns the native driver connection if the passed one is wrapped by dbkit
+// (e.g. by LeakDetector.WrapConnector or MetricsCollector.WrapConnectorForRowsMetrics).
+// It should be used in sql.Conn.Raw callbacks that work with the driver-specific connection type.
+func UnwrapDriverConn(driverConn interface{}) interface{} {
+	for {
+		wrapper, ok := driverConn.(interface{ Unwrap() driver.Conn })
+		if !ok {
+			return driverConn
+		}
+		driverConn = wrapper.Unwrap()
+	}
+}
+
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func wrapConnector(connector driver.Connector, hooks driverHooks) driver.Connector {
+	return &hookedConnector{Connector: connector, hooks: hooks}
+}
+
+type hookedConnector struct {
+	driver.Connector
+	hooks driverHooks
+}
+
+func (c *hookedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedConn{Conn: conn, hooks: c.hooks}, nil
+}
+
+// Close is called by sql.DB.Close.
+func (c *hookedConnector) Close() error {
+	if c.hooks.connectorClosed != nil {
+		c.hooks.connectorClosed()
+	}
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type hookedConn struct {
+	driver.Conn
+	hooks driverHooks
+}
+
+// Unwrap returns the wrapped driver connection (see UnwrapDriverConn).
+func (c *hookedConn) Unwrap() driver.Conn {
+	return c.Conn
+}
+
+func (c *hookedConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrapStmt(stmt, query), nil
+}
+
+func (c *hookedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	query = c.rewriteQuery(ctx, query)
+	var stmt driver.Stmt
+	var err error
+	if connCtx, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = connCtx.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.wrapStmt(stmt, query), nil
+}
+
+func (c *hookedConn) rewriteQuery(ctx context.Context, query string) string {
+	if c.hooks.rewriteQuery == nil {
+		return query
+	}
+	return c.hooks.rewriteQuery(ctx, query)
+}
+
+func (c *hookedConn) wrapStmt(stmt driver.Stmt, query string) driver.Stmt {
+	hookedStmt := &hookedStmt{Stmt: stmt, conn: c.Conn, hooks: c.hooks, query: query}
+	if c.hooks.stmtOpened != nil {
+		hookedStmt.closed = c.hooks.stmtOpened(query)
+	}
+	// database/sql converts arguments via driver.ColumnConverter only if the statement implements it,
+	// so the wrapper should implement it only if the wrapped statement does.
+	if _, ok := stmt.(driver.ColumnConverter); ok {
+		return &hookedColumnConverterStmt{hookedStmt}
+	}
+	return hookedStmt
+}
+
+func (c *hookedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	query = c.rewriteQuery(ctx, query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return wrapRows(ctx, rows, query, c.hooks), nil
+}
+
+func (c *hookedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	query = c.rewriteQuery(ctx, query)
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if c.hooks.execDone != nil {
+		c.hooks.execDone(ctx, query, result)
+	}
+	return result, nil
+}
+
+func (c *hookedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if connBeginTx, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return connBeginTx.BeginTx(ctx, opts)
+	}
+	// Report the same errors as database/sql does for drivers that don't support ConnBeginTx.
+	if opts.Isolation != driver.IsolationLevel(0) {
+		return nil, errNonDefaultIsolationLevel
+	}
+	if opts.ReadOnly {
+		return nil, errReadOnlyTx
+	}
+	return c.Conn.Begin() // nolint: staticcheck // Fallback for drivers that don't support ConnBeginTx.
+}
+
+func (c *hookedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *hookedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *hookedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *hookedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+var (
+	errNonDefaultIsolationLevel = errors.New("sql: driver does not support non-default isolation level")
+	errReadOnlyTx               = errors.New("sql: driver does not support read-only transactions")
+)
+
+type hookedStmt struct {
+	driver.Stmt
+	conn   driver.Conn
+	hooks  driverHooks
+	query  string
+	closed func()
+}
+
+func (s *hookedStmt) Close() error {
+	if s.closed != nil {
+		s.closed()
+	}
+	return s.Stmt.Close()
+}
+
+func (s *hookedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	var result driver.Result
+	var err error
+	if stmtCtx, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = stmtCtx.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err != nil {
+			return nil, err
+		}
+		result, err = s.Stmt.Exec(values) // nolint: staticcheck // Fallback for drivers that don't support StmtExecContext.
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.hooks.execDone != nil {
+		s.hooks.execDone(ctx, s.query, result)
+	}
+	return result, nil
+}
+
+func (s *hookedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	var rows driver.Rows
+	var err error
+	if stmtCtx, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = stmtCtx.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValuesToValues(args); err != nil {
+			return nil, err
+		}
+		rows, err = s.Stmt.Query(values) // nolint: staticcheck // Fallback for drivers that don't support StmtQueryContext.
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wrapRows(ctx, rows, s.query, s.hooks), nil
+}
+
+// CheckNamedValue checks the argument in the same order as database/sql does for not wrapped statements:
+// statement's checker is used first, then the connection's one.
+// If both are missing or return driver.ErrSkip, database/sql falls back to driver.ColumnConverter
+// (see hookedColumnConverterStmt) and the default conversion.
+func (s *hookedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	if checker, ok := s.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// hookedColumnConverterStmt is used for wrapping statements that implement driver.ColumnConverter.
+type hookedColumnConverterStmt struct {
+	*hookedStmt
+}
+
+func (s *hookedColumnConverterStmt) ColumnConverter(idx int) driver.ValueConverter {
+	return s.Stmt.(driver.ColumnConverter).ColumnConverter(idx) // nolint: staticcheck // Deprecated, but still used by drivers.
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, 0, len(args))
+	for _, arg := range args {
+		if arg.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values = append(values, arg.Value)
+	}
+	return values, nil
+}
+
+func wrapRows(ctx context.Context, rows driver.Rows, query string, hooks driverHooks) driver.Rows {
+	hookedRows := &hookedRows{Rows: rows}
+	if hooks.rowsOpened != nil {
+		hookedRows.closed = hooks.rowsOpened(ctx, query)
+	}
+	return hookedRows
+}
+
+type hookedRows struct {
+	driver.Rows
+	rowsRead int
+	closed   func(rowsRead int)
+}
+
+func (r *hookedRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	r.rowsRead++
+	return nil
+}
+
+func (r *hookedRows) Close() error {
+	if r.closed != nil {
+		r.closed(r.rowsRead)
+	}
+	return r.Rows.Close()
+}
+
+func (r *hookedRows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *hookedRows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *hookedRows) ColumnTypeScanType(index int) reflect.Type {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return ct.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *hookedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return ct.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *hookedRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return ct.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *hookedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return ct.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *hookedRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ct.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
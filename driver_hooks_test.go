@@ -0,0 +1,191 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testHooksArg is not supported by the default database/sql converter,
+// so it may be passed only if the driver converts it.
+type testHooksArg struct {
+	val string
+}
+
+type testHooksDriver struct{}
+
+func (d *testHooksDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("not implemented")
+}
+
+type testHooksConn struct {
+	columnConverter bool
+	execArgs        []driver.Value
+}
+
+func (c *testHooksConn) Prepare(string) (driver.Stmt, error) {
+	if c.columnConverter {
+		return &testHooksColumnConverterStmt{testHooksStmt{conn: c}}, nil
+	}
+	return &testHooksStmt{conn: c}, nil
+}
+
+func (c *testHooksConn) Close() error {
+	return nil
+}
+
+func (c *testHooksConn) Begin() (driver.Tx, error) {
+	return testHooksTx{}, nil
+}
+
+type testHooksCheckerConn struct {
+	*testHooksConn
+}
+
+func (c *testHooksCheckerConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if arg, ok := nv.Value.(testHooksArg); ok {
+		nv.Value = arg.val
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+type testHooksStmt struct {
+	conn *testHooksConn
+}
+
+func (s *testHooksStmt) Close() error {
+	return nil
+}
+
+func (s *testHooksStmt) NumInput() int {
+	return -1
+}
+
+func (s *testHooksStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.execArgs = args
+	return driver.RowsAffected(1), nil
+}
+
+func (s *testHooksStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+type testHooksColumnConverterStmt struct {
+	testHooksStmt
+}
+
+func (s *testHooksColumnConverterStmt) ColumnConverter(int) driver.ValueConverter {
+	return testHooksValueConverter{}
+}
+
+type testHooksValueConverter struct{}
+
+func (testHooksValueConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	if arg, ok := v.(testHooksArg); ok {
+		return "converted " + arg.val, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+type testHooksTx struct{}
+
+func (testHooksTx) Commit() error {
+	return nil
+}
+
+func (testHooksTx) Rollback() error {
+	return nil
+}
+
+type testHooksConnector struct {
+	conn driver.Conn
+}
+
+func (c *testHooksConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.conn, nil
+}
+
+func (c *testHooksConnector) Driver() driver.Driver {
+	return &testHooksDriver{}
+}
+
+func openTestHookedDB(t *testing.T, conn driver.Conn) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(wrapConnector(&testHooksConnector{conn: conn}, driverHooks{}))
+	t.Cleanup(func() { require.NoError(t, db.Close()) })
+	return db
+}
+
+func TestHookedStmt_ArgsConversion(t *testing.T) {
+	t.Run("connection checker is used for prepared statements", func(t *testing.T) {
+		conn := &testHooksConn{}
+		db := openTestHookedDB(t, &testHooksCheckerConn{conn})
+		stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?)")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, stmt.Close()) }()
+		_, err = stmt.Exec(testHooksArg{"foo"}, 42)
+		require.NoError(t, err)
+		require.Equal(t, []driver.Value{"foo", int64(42)}, conn.execArgs)
+	})
+
+	t.Run("statement column converter is used", func(t *testing.T) {
+		conn := &testHooksConn{columnConverter: true}
+		db := openTestHookedDB(t, conn)
+		stmt, err := db.Prepare("INSERT INTO t VALUES (?, ?)")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, stmt.Close()) }()
+		_, err = stmt.Exec(testHooksArg{"foo"}, 42)
+		require.NoError(t, err)
+		require.Equal(t, []driver.Value{"converted foo", int64(42)}, conn.execArgs)
+	})
+
+	t.Run("unsupported argument", func(t *testing.T) {
+		db := openTestHookedDB(t, &testHooksConn{})
+		stmt, err := db.Prepare("INSERT INTO t VALUES (?)")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, stmt.Close()) }()
+		_, err = stmt.Exec(testHooksArg{"foo"})
+		require.Error(t, err)
+	})
+}
+
+func TestHookedConn_BeginTx(t *testing.T) {
+	db := openTestHookedDB(t, &testHooksConn{})
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	_, err = db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	require.ErrorIs(t, err, errNonDefaultIsolationLevel)
+
+	_, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	require.ErrorIs(t, err, errReadOnlyTx)
+}
+
+func TestUnwrapDriverConn(t *testing.T) {
+	conn := &testHooksConn{}
+	db := openTestHookedDB(t, conn)
+	sqlConn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, sqlConn.Close()) }()
+
+	require.NoError(t, sqlConn.Raw(func(driverConn interface{}) error {
+		require.IsType(t, &hookedConn{}, driverConn)
+		require.Same(t, conn, UnwrapDriverConn(driverConn))
+		return nil
+	}))
+	require.Same(t, conn, UnwrapDriverConn(conn))
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 
 	"net/url"
+	"strconv"
 
 	"github.com/go-sql-driver/mysql"
 )
@@ -19,33 +20,92 @@ func MakeMSSQLDSN(cfg *MSSQLConfig) string {
 	query := url.Values{}
 	query.Add("database", cfg.Database)
 
+	if cfg.ApplicationName != "" {
+		query.Add("app name", cfg.ApplicationName)
+	}
+
+	user := cfg.User
+	if cfg.FedAuth != "" {
+		query.Add("fedauth", string(cfg.FedAuth))
+		switch cfg.FedAuth {
+		case MSSQLFedAuthMSI:
+			if cfg.ClientID != "" {
+				user = cfg.ClientID
+			}
+		case MSSQLFedAuthServicePrincipal:
+			if cfg.ClientID != "" {
+				user = cfg.ClientID
+				if cfg.TenantID != "" {
+					user += "@" + cfg.TenantID
+				}
+			}
+		case MSSQLFedAuthPassword:
+			if cfg.ClientID != "" {
+				query.Add("applicationclientid", cfg.ClientID)
+			}
+		}
+	}
+
 	u := &url.URL{
 		Scheme:   "sqlserver",
-		User:     url.UserPassword(cfg.User, cfg.Password),
 		Host:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
 		RawQuery: query.Encode(),
 	}
+	if user != "" || cfg.Password != "" {
+		u.User = url.UserPassword(user, cfg.Password)
+	}
 
 	return u.String()
 }
 
 // MakeMySQLDSN makes DSN for opening MySQL database.
+// Note that autocommit=false is added to DSN unless MySQLConfig.Autocommit is set.
 func MakeMySQLDSN(cfg *MySQLConfig) string {
 	c := mysql.NewConfig()
-	c.Net = "tcp"
-	c.Addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	if cfg.Socket != "" {
+		c.Net = "unix"
+		c.Addr = cfg.Socket
+	} else {
+		c.Net = "tcp"
+		c.Addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	}
+	if cfg.Dialer != "" {
+		// Custom dialer is registered in the driver as a network (see RegisterDialer).
+		c.Net = cfg.Dialer
+	}
 	c.User = cfg.User
 	c.Passwd = cfg.Password
 	c.DBName = cfg.Database
 	c.ParseTime = true
 	c.MultiStatements = true
+	c.InterpolateParams = cfg.InterpolateParams
+	c.ClientFoundRows = cfg.ClientFoundRows
+	c.RejectReadOnly = cfg.RejectReadOnly
+	if cfg.MaxAllowedPacket > 0 {
+		c.MaxAllowedPacket = cfg.MaxAllowedPacket
+	}
 	c.Params = make(map[string]string)
-	c.Params["autocommit"] = "false"
+	c.Params["autocommit"] = strconv.FormatBool(cfg.Autocommit)
+	if cfg.ApplicationName != "" {
+		// Config.FormatDSN doesn't serialize ConnectionAttributes, so they are passed as a regular DSN parameter.
+		c.Params["connectionAttributes"] = "program_name:" + cfg.ApplicationName
+	}
 	return c.FormatDSN()
 }
 
-// MakePostgresDSN makes DSN for opening Postgres database.
+// MakePostgresDSN makes DSN for opening Postgres database with lib/pq driver.
+// PostgresConfig.SSLPassword is not supported by lib/pq (it would be sent to the server as a startup parameter),
+// so it's not added to the DSN. Use MakePgxDSN for pgx driver.
 func MakePostgresDSN(cfg *PostgresConfig) string {
+	return makePostgresDSN(cfg, false)
+}
+
+// MakePgxDSN makes DSN for opening Postgres database with pgx driver.
+func MakePgxDSN(cfg *PostgresConfig) string {
+	return makePostgresDSN(cfg, true)
+}
+
+func makePostgresDSN(cfg *PostgresConfig, withSSLPassword bool) string {
 	sslMode := cfg.SSLMode
 	if sslMode == "" {
 		sslMode = PostgresDefaultSSLMode
@@ -57,9 +117,34 @@ func MakePostgresDSN(cfg *PostgresConfig) string {
 		Path:     cfg.Database,
 		RawQuery: fmt.Sprintf("sslmode=%s", url.QueryEscape(string(sslMode))),
 	}
+	if cfg.Socket != "" {
+		// Both lib/pq and pgx take the socket directory from the "host" parameter.
+		connURI.Host = ""
+		connURI.Path = "/" + cfg.Database // Leading slash is not added automatically if host is empty.
+		connURI.RawQuery += fmt.Sprintf("&host=%s", url.QueryEscape(cfg.Socket))
+		if cfg.Port != 0 {
+			connURI.RawQuery += fmt.Sprintf("&port=%d", cfg.Port)
+		}
+	}
+	sslParams := []Parameter{
+		{"sslrootcert", cfg.SSLRootCert},
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
+	}
+	if withSSLPassword {
+		sslParams = append(sslParams, Parameter{"sslpassword", cfg.SSLPassword})
+	}
+	for _, p := range sslParams {
+		if p.Value != "" {
+			connURI.RawQuery += fmt.Sprintf("&%s=%s", p.Name, url.QueryEscape(p.Value))
+		}
+	}
 	if cfg.SearchPath != "" {
 		connURI.RawQuery += fmt.Sprintf("&search_path=%s", url.QueryEscape(cfg.SearchPath))
 	}
+	if cfg.ApplicationName != "" {
+		connURI.RawQuery += fmt.Sprintf("&application_name=%s", url.QueryEscape(cfg.ApplicationName))
+	}
 	if len(cfg.AdditionalParameters) != 0 {
 		for _, p := range cfg.AdditionalParameters {
 			connURI.RawQuery += fmt.Sprintf("&%s=%s", p.Name, url.QueryEscape(p.Value))
@@ -0,0 +1,120 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/acronis/go-dbkit"
+	_ "github.com/acronis/go-dbkit/sqlite"
+)
+
+func openExampleDB() *sql.DB {
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		log.Fatal(err)
+	}
+	dbConn.SetMaxOpenConns(1) // Each connection to the in-memory SQLite database has its own database.
+	if _, err = dbConn.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL)"); err != nil {
+		log.Fatal(err)
+	}
+	return dbConn
+}
+
+func ExampleDoInTx() {
+	dbConn := openExampleDB()
+	defer func() { _ = dbConn.Close() }()
+
+	errNameTaken := errors.New("name is already taken")
+	createUser := func(name string) error {
+		// Transaction is committed if the function returns nil and rolled back otherwise.
+		return dbkit.DoInTx(context.Background(), dbConn, func(tx *sql.Tx) error {
+			var exists bool
+			if err := tx.QueryRow("SELECT COUNT(*) > 0 FROM users WHERE name = ?", name).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				return errNameTaken
+			}
+			_, err := tx.Exec("INSERT INTO users (name) VALUES (?)", name)
+			return err
+		})
+	}
+
+	fmt.Println(createUser("Bob"))
+	fmt.Println(createUser("Bob"))
+
+	// Output:
+	// <nil>
+	// name is already taken
+}
+
+func ExampleDoInTxCtx() {
+	dbConn := openExampleDB()
+	defer func() { _ = dbConn.Close() }()
+
+	// Repository functions don't know whether they are called within the transaction or not.
+	insertUser := func(ctx context.Context, name string) error {
+		return dbkit.DoInTxCtx(ctx, dbConn, func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO users (name) VALUES (?)", name)
+			return err
+		})
+	}
+
+	// Both users are inserted in the same transaction, which is rolled back because of the error.
+	err := dbkit.DoInTxCtx(context.Background(), dbConn, func(ctx context.Context, tx *sql.Tx) error {
+		if err := insertUser(ctx, "Alice"); err != nil {
+			return err
+		}
+		if err := insertUser(ctx, "Bob"); err != nil {
+			return err
+		}
+		return errors.New("something went wrong")
+	})
+	fmt.Println(err)
+
+	var count int
+	if err = dbConn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(count)
+
+	// Output:
+	// something went wrong
+	// 0
+}
+
+func ExampleBulkInsert() {
+	dbConn := openExampleDB()
+	defer func() { _ = dbConn.Close() }()
+
+	rows := [][]interface{}{{1, "Alice"}, {2, "Bob"}, {3, "Sam"}}
+	inserted, err := dbkit.BulkInsertWithOpts(context.Background(), dbConn, dbkit.DialectSQLite, "users",
+		[]string{"id", "name"}, rows, dbkit.BulkInsertOpts{BatchSize: 2})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(inserted)
+
+	// Output:
+	// 3
+}
+
+func ExampleQuoteIdentifier() {
+	fmt.Println(dbkit.QuoteIdentifier(dbkit.DialectPostgres, "user"))
+	fmt.Println(dbkit.QuoteIdentifier(dbkit.DialectMySQL, "user"))
+	fmt.Println(dbkit.QuoteIdentifier(dbkit.DialectMSSQL, "user"))
+
+	// Output:
+	// "user"
+	// `user`
+	// [user]
+}
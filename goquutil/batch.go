@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// ExecBatchOpts represents options for ExecBatchWithOpts.
+type ExecBatchOpts struct {
+	// MultiStatement enables executing all statements in a single round-trip (joined by semicolon).
+	// It's supported by MySQL (multiStatements=true is set in DSN made by dbkit.MakeMySQLDSN) and SQLite.
+	// Note that for prepared expressions MySQL requires interpolateParams=true in DSN,
+	// since server-side prepared statements cannot contain multiple statements.
+	MultiStatement bool
+}
+
+// BatchStatementError is returned by ExecBatch when a statement of the batch fails.
+type BatchStatementError struct {
+	// Index is an index of the failed statement in the batch.
+	// It's -1 in multi-statement mode since the database doesn't report which statement failed.
+	Index int
+	Query string
+	Err   error
+}
+
+// Error returns a string representation of the error.
+func (e *BatchStatementError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("batch execution: %v", e.Err)
+	}
+	return fmt.Sprintf("batch statement #%d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *BatchStatementError) Unwrap() error {
+	return e.Err
+}
+
+// ExecBatch executes multiple DML expressions (UPDATE, DELETE, INSERT) in order within the passed Querier.
+// Execution is stopped on the first failed statement, *BatchStatementError is returned in this case.
+// Results of the successfully executed statements are returned.
+func ExecBatch(q Querier, sqlExpressions []exp.SQLExpression) ([]sql.Result, error) {
+	return ExecBatchWithOpts(q, sqlExpressions, ExecBatchOpts{})
+}
+
+// ExecBatchWithOpts is a more configurable version of ExecBatch.
+// In multi-statement mode, only one result (returned by the driver for the whole batch) is returned.
+func ExecBatchWithOpts(q Querier, sqlExpressions []exp.SQLExpression, opts ExecBatchOpts) ([]sql.Result, error) {
+	if len(sqlExpressions) == 0 {
+		return nil, nil
+	}
+	if opts.MultiStatement {
+		result, err := execMultiStatement(q, sqlExpressions)
+		if err != nil {
+			return nil, err
+		}
+		return []sql.Result{result}, nil
+	}
+
+	results := make([]sql.Result, 0, len(sqlExpressions))
+	for i, sqlExpression := range sqlExpressions {
+		result, err := BuildSQLAndExec(q, sqlExpression)
+		if err != nil {
+			query, _, _ := sqlExpression.ToSQL()
+			return results, &BatchStatementError{Index: i, Query: query, Err: err}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func execMultiStatement(q Querier, sqlExpressions []exp.SQLExpression) (sql.Result, error) {
+	queries := make([]string, 0, len(sqlExpressions))
+	var params []interface{}
+	allPrepared := true
+	for i, sqlExpression := range sqlExpressions {
+		query, exprParams, err := sqlExpression.ToSQL()
+		if err != nil {
+			return nil, &BatchStatementError{Index: i, Query: query, Err: fmt.Errorf("query building: %w", err)}
+		}
+		if !sqlExpression.IsPrepared() {
+			if err = checkNonPreparedStatement(q, query); err != nil {
+				return nil, &BatchStatementError{Index: i, Query: query, Err: err}
+			}
+			allPrepared = false
+		}
+		queries = append(queries, query)
+		params = append(params, exprParams...)
+	}
+	query := strings.Join(queries, ";\n")
+
+	startTime := time.Now()
+	result, err := q.Exec(query, params...)
+	if allPrepared && ObserveSQLQueryDuration != nil {
+		var ctx context.Context
+		if cq, ok := q.(ContextProvider); ok {
+			ctx = cq.Context()
+		}
+		ObserveSQLQueryDuration(query, ctx, startTime, err)
+	}
+	if err != nil {
+		return nil, &BatchStatementError{Index: -1, Query: query, Err: err}
+	}
+	return result, nil
+}
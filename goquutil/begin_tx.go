@@ -0,0 +1,118 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsLabelBeginTxWaitReason is a name of the Prometheus label that contains reason of the BeginTx waiting.
+const MetricsLabelBeginTxWaitReason = "wait_reason"
+
+// Values of the MetricsLabelBeginTxWaitReason label.
+const (
+	// BeginTxWaitReasonPool means that connection pool was exhausted and BeginTx waited for a free connection.
+	BeginTxWaitReasonPool = "pool"
+	// BeginTxWaitReasonServer means that BeginTx didn't wait for a connection, so the time was spent by the server (or network).
+	BeginTxWaitReasonServer = "server"
+)
+
+// DefaultBeginTxDurationBuckets is default buckets into which observations of opening transactions are counted.
+var DefaultBeginTxDurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// BeginTxMetricsOpts represents an options for BeginTxMetrics.
+type BeginTxMetricsOpts struct {
+	// Namespace is a namespace for metrics. It will be prepended to all metric names.
+	Namespace string
+
+	// DurationBuckets is a list of buckets for the durations histogram. DefaultBeginTxDurationBuckets is used by default.
+	DurationBuckets []float64
+}
+
+// BeginTxMetrics represents collector of metrics for opening transactions in DB.DoInTx (see DB.WithBeginTxMetrics).
+type BeginTxMetrics struct {
+	// Durations is a histogram of opening transactions durations labeled by the wait reason.
+	Durations *prometheus.HistogramVec
+
+	// PoolWaitDurations is a counter of the time spent on waiting for a free connection in the pool.
+	PoolWaitDurations prometheus.Counter
+}
+
+// NewBeginTxMetrics creates a new BeginTxMetrics with default options.
+func NewBeginTxMetrics() *BeginTxMetrics {
+	return NewBeginTxMetricsWithOpts(BeginTxMetricsOpts{})
+}
+
+// NewBeginTxMetricsWithOpts is a more configurable version of the NewBeginTxMetrics.
+func NewBeginTxMetricsWithOpts(opts BeginTxMetricsOpts) *BeginTxMetrics {
+	if opts.DurationBuckets == nil {
+		opts.DurationBuckets = DefaultBeginTxDurationBuckets
+	}
+	return &BeginTxMetrics{
+		Durations: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: opts.Namespace,
+				Name:      "db_begin_tx_duration_seconds",
+				Help:      "A histogram of the SQL transactions opening durations.",
+				Buckets:   opts.DurationBuckets,
+			},
+			[]string{MetricsLabelBeginTxWaitReason},
+		),
+		PoolWaitDurations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      "db_begin_tx_pool_wait_seconds_total",
+			Help:      "A total time spent on waiting for a free connection in the pool while opening SQL transactions.",
+		}),
+	}
+}
+
+// MustRegister does registration of metrics collector in Prometheus and panics if any error occurs.
+func (m *BeginTxMetrics) MustRegister() {
+	prometheus.MustRegister(m.Durations, m.PoolWaitDurations)
+}
+
+// Unregister cancels registration of metrics collector in Prometheus.
+func (m *BeginTxMetrics) Unregister() {
+	prometheus.Unregister(m.Durations)
+	prometheus.Unregister(m.PoolWaitDurations)
+}
+
+func (m *BeginTxMetrics) observe(elapsed time.Duration, poolWait beginTxPoolWait) {
+	m.Durations.With(prometheus.Labels{MetricsLabelBeginTxWaitReason: poolWait.reason()}).Observe(elapsed.Seconds())
+	m.PoolWaitDurations.Add(poolWait.duration.Seconds())
+}
+
+// dbStatsProvider is implemented by *sql.DB.
+type dbStatsProvider interface {
+	Stats() sql.DBStats
+}
+
+// beginTxPoolWait contains delta of the connection pool waiting statistics (sql.DBStats) taken around BeginTx.
+// Since statistics is shared by all users of the pool, delta may include waits of the concurrent transactions,
+// but it's still enough to distinguish pool exhaustion from the server slowness.
+type beginTxPoolWait struct {
+	count    int64
+	duration time.Duration
+}
+
+func (w beginTxPoolWait) reason() string {
+	if w.count > 0 {
+		return BeginTxWaitReasonPool
+	}
+	return BeginTxWaitReasonServer
+}
+
+func getPoolWait(before, after sql.DBStats) beginTxPoolWait {
+	w := beginTxPoolWait{count: after.WaitCount - before.WaitCount, duration: after.WaitDuration - before.WaitDuration}
+	if w.count < 0 || w.duration < 0 {
+		return beginTxPoolWait{}
+	}
+	return w
+}
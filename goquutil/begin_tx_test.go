@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/acronis/go-appkit/testutil"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_DoInTx_BeginTxWaitReason(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "begin_tx.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+	dbConn.SetMaxOpenConns(1)
+
+	logRecorder := logtest.NewRecorder()
+	metrics := NewBeginTxMetrics()
+	db := NewDB(context.Background(), goqu.New("sqlite3", dbConn)).
+		WithLogging(logRecorder, "test", 0).
+		WithBeginTxMetrics(metrics)
+	noopWorker := func(q Querier) error { return nil }
+
+	requireOpenTxLogEntry := func(wantWaitReason string, wantPoolWaitCount int64) {
+		t.Helper()
+		entries := logRecorder.Entries()
+		require.NotEmpty(t, entries)
+		require.Contains(t, entries[0].Text, "opened DB transaction (test)")
+		field, found := entries[0].FindField("wait_reason")
+		require.True(t, found)
+		require.Equal(t, wantWaitReason, string(field.Bytes))
+		field, found = entries[0].FindField("pool_wait_count")
+		require.True(t, found)
+		require.Equal(t, wantPoolWaitCount, field.Int)
+	}
+	getHistogram := func(waitReason string) prometheus.Histogram {
+		return metrics.Durations.With(prometheus.Labels{MetricsLabelBeginTxWaitReason: waitReason}).(prometheus.Histogram)
+	}
+
+	// Free connection is available in the pool.
+	require.NoError(t, db.DoInTx(noopWorker))
+	requireOpenTxLogEntry(BeginTxWaitReasonServer, 0)
+	testutil.RequireSamplesCountInHistogram(t, getHistogram(BeginTxWaitReasonServer), 1)
+	require.Equal(t, 0.0, promtestutil.ToFloat64(metrics.PoolWaitDurations))
+
+	// The only connection is busy, so BeginTx has to wait for it.
+	conn, err := dbConn.Conn(context.Background())
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = conn.Close()
+	}()
+	logRecorder.Reset()
+	require.NoError(t, db.DoInTx(noopWorker))
+	requireOpenTxLogEntry(BeginTxWaitReasonPool, 1)
+	testutil.RequireSamplesCountInHistogram(t, getHistogram(BeginTxWaitReasonPool), 1)
+	require.Greater(t, promtestutil.ToFloat64(metrics.PoolWaitDurations), 0.0)
+}
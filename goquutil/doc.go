@@ -7,4 +7,8 @@ Released under MIT license.
 // Package goquutil provides auxiliary routines for working with goqu query builder.
 // Warning: until this notice is removed, current state is "beta", i.e. it is being tested in selected projects
 // for errors and completeness. This also means that APIs may change without any guarantees for backward compatibility.
+//
+// DB.WithBeginTxMetrics enables metrics of opening transactions labeled by the wait reason
+// (connection pool exhaustion or server slowness), the same information is added to the log entry of the slow BeginTx.
+// DB.WithContext derives a copy of the configured DB with a new context, so it may be cheaply scoped to a request.
 package goquutil
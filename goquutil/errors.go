@@ -10,3 +10,6 @@ import "errors"
 
 // ErrNotFound indicates that something was not found in db
 var ErrNotFound = errors.New("not found")
+
+// ErrReturningNotSupported is returned by ExecAndScanReturning when the dialect doesn't support RETURNING clause.
+var ErrReturningNotSupported = errors.New("RETURNING clause is not supported by the dialect")
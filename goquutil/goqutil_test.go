@@ -9,11 +9,18 @@ package goquutil
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"regexp"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/retry"
 	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/mysql"
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlserver"
+	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
@@ -332,3 +339,409 @@ func (s *goquSuite) TestStructSelectColumnsHasFixedOrder() {
 		)
 	}
 }
+
+func (s *goquSuite) TestExecBatch() {
+	countUsers := func(q Querier) int {
+		var rowCount int
+		s.Require().NoError(BuildSQLAndQueryScalar(q, s.bs.Dialect.From("users").Select(goqu.COUNT(goqu.Star())), &rowCount))
+		return rowCount
+	}
+
+	_ = s.db.DoInTx(func(q Querier) error {
+		results, err := ExecBatch(q, []exp.SQLExpression{
+			s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("John")).Prepared(true),
+			s.bs.Dialect.Update("users").Set(goqu.Record{"name": "Robert"}).Where(goqu.I("name").Eq("Bob")).Prepared(true),
+		})
+		s.Require().NoError(err)
+		s.Require().Len(results, 2)
+		for _, result := range results {
+			affected, affectedErr := result.RowsAffected()
+			s.Require().NoError(affectedErr)
+			s.Require().Equal(int64(1), affected)
+		}
+		s.Require().Equal(3, countUsers(q))
+
+		results, err = ExecBatch(q, []exp.SQLExpression{
+			s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("Sam")).Prepared(true),
+			s.bs.Dialect.Insert("users").Rows(goqu.Record{"name": nil}).Prepared(true),
+			s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("Albert")).Prepared(true),
+		})
+		var batchErr *BatchStatementError
+		s.Require().ErrorAs(err, &batchErr)
+		s.Require().Equal(1, batchErr.Index)
+		s.Require().Contains(batchErr.Query, "INSERT")
+		s.Require().Len(results, 1)
+		s.Require().Equal(2, countUsers(q))
+		return nil
+	})
+}
+
+func (s *goquSuite) TestExecBatchMultiStatement() {
+	_ = s.db.DoInTx(func(q Querier) error {
+		results, err := ExecBatchWithOpts(q, []exp.SQLExpression{
+			s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("John")).Prepared(true),
+			s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("Bob")).Prepared(true),
+		}, ExecBatchOpts{MultiStatement: true})
+		s.Require().NoError(err)
+		s.Require().Len(results, 1)
+
+		var rowCount int
+		s.Require().NoError(BuildSQLAndQueryScalar(q, s.bs.Dialect.From("users").Select(goqu.COUNT(goqu.Star())), &rowCount))
+		s.Require().Equal(2, rowCount)
+
+		_, err = ExecBatchWithOpts(q, []exp.SQLExpression{
+			s.bs.Dialect.Insert("users").Rows(goqu.Record{"name": nil}).Prepared(true),
+		}, ExecBatchOpts{MultiStatement: true})
+		var batchErr *BatchStatementError
+		s.Require().ErrorAs(err, &batchErr)
+		s.Require().Equal(-1, batchErr.Index)
+		return nil
+	})
+}
+
+func (s *goquSuite) TestExistsAndCount() {
+	_ = s.db.DoInTx(func(q Querier) error {
+		ds := s.bs.Dialect.From("users").Where(goqu.I("name").Neq("Sam")).Order(goqu.I("id").Desc())
+		tests := []struct {
+			name       string
+			ds         *goqu.SelectDataset
+			wantCount  int64
+			wantExists bool
+		}{
+			{name: "simple", ds: ds, wantCount: 3, wantExists: true},
+			{name: "with limit", ds: ds.Limit(2), wantCount: 2, wantExists: true},
+			{name: "with offset", ds: ds.Limit(2).Offset(2), wantCount: 1, wantExists: true},
+			{name: "with offset, no rows", ds: ds.Limit(10).Offset(3), wantCount: 0, wantExists: false},
+			{name: "with distinct", ds: s.bs.Dialect.From("items").Select("created_at").Distinct(), wantCount: 1, wantExists: true},
+			{name: "with group by", ds: s.bs.Dialect.From("items").Select("user_id").GroupBy("user_id"), wantCount: 2, wantExists: true},
+			{name: "no rows", ds: ds.Where(goqu.I("name").Eq("Unknown")), wantCount: 0, wantExists: false},
+			{name: "aliased columns", ds: s.bs.Dialect.From("users").Select(goqu.C("id").As("user_id"), goqu.Star()), wantCount: 4, wantExists: true},
+			{name: "aggregate", ds: s.bs.Dialect.From("users").Select(goqu.MAX("id")), wantCount: 1, wantExists: true},
+			{name: "aggregate, no rows", ds: s.bs.Dialect.From("users").Select(goqu.COUNT(goqu.Star())).Where(goqu.I("name").Eq("Unknown")), wantCount: 1, wantExists: true},
+			{name: "with having", ds: s.bs.Dialect.From("items").Select("user_id").GroupBy("user_id").Having(goqu.COUNT(goqu.Star()).Gt(1)), wantCount: 0, wantExists: false},
+		}
+		for _, tt := range tests {
+			count, err := Count(q, tt.ds)
+			s.Require().NoError(err, tt.name)
+			s.Require().Equal(tt.wantCount, count, tt.name)
+
+			exists, err := Exists(q, tt.ds)
+			s.Require().NoError(err, tt.name)
+			s.Require().Equal(tt.wantExists, exists, tt.name)
+		}
+		return nil
+	})
+}
+
+func TestExists_MSSQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	ds := goqu.Dialect("sqlserver").From("users").Where(goqu.I("name").Eq("Bob")).Order(goqu.I("id").Asc()).Prepared(true)
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT CASE WHEN EXISTS (SELECT 1 FROM "users" WHERE ("name" = @p1)) THEN 1 ELSE 0 END`)).
+		WithArgs("Bob").WillReturnRows(sqlmock.NewRows([]string{""}).AddRow(1))
+	exists, err := Exists(db, ds)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	mock.ExpectClose()
+	require.NoError(t, db.Close())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsStrippableLimit(t *testing.T) {
+	require.True(t, isStrippableLimit(nil))
+	require.True(t, isStrippableLimit(uint(10)))
+	require.True(t, isStrippableLimit(goqu.L("ALL")))
+	// goqu's Limit(0) clears the clause, but zero limit still may be set via clauses or a literal.
+	require.False(t, isStrippableLimit(uint(0)))
+	require.False(t, isStrippableLimit(goqu.L("0")))
+	require.False(t, isStrippableLimit(goqu.L("?", 10)))
+}
+
+func (s *goquSuite) TestSoftDelete() {
+	_ = s.db.DoInTx(func(q Querier) error {
+		_, err := q.Exec("ALTER TABLE users ADD COLUMN deleted_at DATETIME DEFAULT NULL")
+		s.Require().NoError(err)
+
+		ctx := context.Background()
+		selectNames := func(ctx context.Context) []string {
+			var names []string
+			ds := s.bs.Dialect.From("users").Select("name").Order(goqu.I("id").Asc())
+			s.Require().NoError(QueryAndScanValues(q, ExcludeDeleted(ctx, ds), &names))
+			return names
+		}
+
+		result, err := SoftDelete(q, s.bs.Dialect, "users", goqu.I("name").In("Bob", "John"))
+		s.Require().NoError(err)
+		affected, err := result.RowsAffected()
+		s.Require().NoError(err)
+		s.Require().Equal(int64(2), affected)
+		s.Require().Equal([]string{"Albert", "Sam"}, selectNames(ctx))
+		s.Require().Equal([]string{"Albert", "Bob", "John", "Sam"}, selectNames(WithTrashed(ctx)))
+
+		// Already deleted rows are not touched.
+		result, err = SoftDelete(q, s.bs.Dialect, "users", goqu.I("name").Eq("Bob"))
+		s.Require().NoError(err)
+		affected, err = result.RowsAffected()
+		s.Require().NoError(err)
+		s.Require().Equal(int64(0), affected)
+
+		var items []string
+		s.Require().NoError(QueryAndScanValues(q, ExcludeDeleted(ctx, s.bs.Dialect.From("items").
+			Join(goqu.T("users"), goqu.On(goqu.I("items.user_id").Eq(goqu.I("users.id")))).
+			Select("items.name"), "users"), &items))
+		s.Require().Equal([]string{"foo"}, items)
+
+		_, err = Restore(q, s.bs.Dialect, "users", goqu.I("name").Eq("Bob"))
+		s.Require().NoError(err)
+		s.Require().Equal([]string{"Albert", "Bob", "Sam"}, selectNames(ctx))
+		return nil
+	})
+}
+
+func (s *goquSuite) TestNewTxQuerier() {
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	s.Require().NoError(err)
+	defer func() { s.Require().NoError(dbConn.Close()) }()
+	dbConn.SetMaxOpenConns(1)
+	_, err = dbConn.Exec(sqlCreateAndSeedTestUsersTable)
+	s.Require().NoError(err)
+
+	ctx := context.Background()
+	s.Require().NoError(dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+		q := NewTxQuerier(ctx, tx)
+		s.Require().Equal(ctx, q.(ContextProvider).Context())
+
+		_, execErr := BuildSQLAndExec(q, s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("John")))
+		s.Require().NoError(execErr)
+
+		var user User
+		s.Require().NoError(QueryAndScanStruct(q, s.bs.Dialect.From("users").Where(goqu.I("id").Eq(1)), &user))
+		s.Require().Equal(User{1, "Albert", NullTimeFrom(tt)}, user)
+		return nil
+	}))
+
+	var rowCount int
+	s.Require().NoError(dbConn.QueryRow("SELECT COUNT(*) FROM users").Scan(&rowCount))
+	s.Require().Equal(3, rowCount)
+}
+
+func (s *goquSuite) TestQueryAnnotationFromContext() {
+	var queries []string
+	PreQueryHook = func(ctx context.Context, query string, args ...interface{}) string {
+		queries = append(queries, query)
+		return query
+	}
+	defer func() { PreQueryHook = nil }()
+
+	ctx := dbkit.WithQueryAnnotation(context.Background(), "query_count_users")
+	s.Require().NoError(NewDB(ctx, s.db.db).DoInTx(func(q Querier) error {
+		var rowCount int
+		s.Require().NoError(BuildSQLAndQueryScalar(q, s.bs.Dialect.From("users").Select(goqu.COUNT(goqu.Star())), &rowCount))
+		s.Require().Equal(4, rowCount)
+		return nil
+	}))
+	s.Require().Equal([]string{`/* query_count_users */ SELECT COUNT(*) FROM "users"`}, queries)
+}
+
+func (s *goquSuite) TestRetryOnConflict() {
+	conflictErr := errors.New("conflict")
+	defer dbkit.WithIsRetryable(s.db.db.Db.(*sql.DB).Driver(), func(err error) bool {
+		return errors.Is(err, conflictErr)
+	})()
+	policy := retry.NewConstantBackoffPolicy(time.Millisecond, 3)
+
+	attempts := 0
+	err := RetryOnConflict(s.db.db, policy, func(q Querier) error {
+		attempts++
+		if attempts < 3 {
+			return conflictErr
+		}
+		_, err := BuildSQLAndExec(q, goqu.Update("users").Set(goqu.Record{"name": "Alice"}).Where(goqu.C("id").Eq(1)))
+		return err
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(3, attempts)
+	var name string
+	s.Require().NoError(s.db.db.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name))
+	s.Require().Equal("Alice", name)
+
+	// Non-retryable error is returned immediately.
+	attempts = 0
+	fatalErr := errors.New("fatal")
+	s.Require().ErrorIs(RetryOnConflict(s.db.db, policy, func(q Querier) error {
+		attempts++
+		return fatalErr
+	}), fatalErr)
+	s.Require().Equal(1, attempts)
+
+	// Retryable error is returned when attempts are exhausted.
+	attempts = 0
+	s.Require().ErrorIs(RetryOnConflict(s.db.db, policy, func(q Querier) error {
+		attempts++
+		return conflictErr
+	}), conflictErr)
+	s.Require().Equal(4, attempts)
+}
+
+func (s *goquSuite) TestWithQueryErrorDetails() {
+	ctx := dbkit.WithQueryAnnotation(context.Background(), "query_select_orders")
+	ds := s.bs.Dialect.From("orders").Where(goqu.I("id").Eq(42)).Prepared(true)
+
+	// Parameter values are redacted by default.
+	err := NewDB(ctx, s.db.db).WithQueryErrorDetails(QueryErrorOpts{}).DoInTx(func(q Querier) error {
+		_, execErr := BuildSQLAndQuery(q, ds)
+		return execErr
+	})
+	var queryErr *QueryError
+	s.Require().ErrorAs(err, &queryErr)
+	s.Require().Equal(`/* query_select_orders */ SELECT * FROM "orders" WHERE ("id" = ?)`, queryErr.Query)
+	s.Require().Equal(1, queryErr.ParamsCount)
+	s.Require().Nil(queryErr.Params)
+	s.Require().Equal("query_select_orders", queryErr.Annotation)
+	s.Require().ErrorContains(queryErr.Unwrap(), "no such table: orders")
+
+	err = NewDB(ctx, s.db.db).WithQueryErrorDetails(QueryErrorOpts{IncludeParams: true}).DoInTx(func(q Querier) error {
+		_, execErr := BuildSQLAndQuery(q, ds)
+		return execErr
+	})
+	s.Require().ErrorAs(err, &queryErr)
+	s.Require().Equal([]interface{}{int64(42)}, queryErr.Params)
+
+	// SQL of non-prepared statements is redacted by default since parameter values are inlined into it.
+	nonPrepared := ds.Prepared(false)
+	db := NewDB(ctx, s.db.db).WithNonPreparedStatementsPolicy(NonPreparedStatementsAllow)
+	err = db.WithQueryErrorDetails(QueryErrorOpts{}).DoInTx(func(q Querier) error {
+		_, execErr := BuildSQLAndQuery(q, nonPrepared)
+		return execErr
+	})
+	s.Require().ErrorAs(err, &queryErr)
+	s.Require().True(queryErr.Interpolated)
+	s.Require().Empty(queryErr.Query)
+	s.Require().Equal("query_select_orders", queryErr.Annotation)
+	s.Require().NotContains(err.Error(), "42")
+	s.Require().ErrorContains(err, `non-prepared query (annotation "query_select_orders", SQL is redacted) failed`)
+
+	err = db.WithQueryErrorDetails(QueryErrorOpts{IncludeParams: true}).DoInTx(func(q Querier) error {
+		_, execErr := BuildSQLAndQuery(q, nonPrepared)
+		return execErr
+	})
+	s.Require().ErrorAs(err, &queryErr)
+	s.Require().True(queryErr.Interpolated)
+	s.Require().Equal(`/* query_select_orders */ SELECT * FROM "orders" WHERE ("id" = 42)`, queryErr.Query)
+
+	// Errors are not wrapped if the option is not used.
+	err = NewDB(ctx, s.db.db).DoInTx(func(q Querier) error {
+		_, execErr := BuildSQLAndQuery(q, ds)
+		return execErr
+	})
+	s.Require().Error(err)
+	s.Require().False(errors.As(err, &queryErr))
+}
+
+func (s *goquSuite) TestWithNonPreparedStatementsPolicy() {
+	nonPrepared := s.bs.Dialect.From("users").Select(goqu.COUNT(goqu.Star())).Where(goqu.I("name").Eq("Bob"))
+
+	countUsers := func(db *DB) (int, error) {
+		var rowCount int
+		err := db.DoInTx(func(q Querier) error {
+			return BuildSQLAndQueryScalar(q, nonPrepared, &rowCount)
+		})
+		return rowCount, err
+	}
+
+	for _, policy := range []NonPreparedStatementsPolicy{NonPreparedStatementsAllow, NonPreparedStatementsWarn} {
+		rowCount, err := countUsers(NewDB(context.Background(), s.db.db).WithNonPreparedStatementsPolicy(policy))
+		s.Require().NoError(err)
+		s.Require().Equal(1, rowCount)
+	}
+
+	_, err := countUsers(NewDB(context.Background(), s.db.db).WithNonPreparedStatementsPolicy(NonPreparedStatementsForbid))
+	s.Require().ErrorIs(err, ErrNonPreparedStatement)
+
+	err = NewDB(context.Background(), s.db.db).WithNonPreparedStatementsPolicy(NonPreparedStatementsForbid).DoInTx(func(q Querier) error {
+		_, batchErr := ExecBatchWithOpts(q, []exp.SQLExpression{
+			s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("John")).Prepared(true),
+			s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("Bob")),
+		}, ExecBatchOpts{MultiStatement: true})
+		return batchErr
+	})
+	var batchErr *BatchStatementError
+	s.Require().ErrorAs(err, &batchErr)
+	s.Require().Equal(1, batchErr.Index)
+	s.Require().ErrorIs(err, ErrNonPreparedStatement)
+
+	// Per-DB policy takes precedence over the global flag.
+	IsInsideTest = true
+	defer func() { IsInsideTest = false }()
+	rowCount, err := countUsers(NewDB(context.Background(), s.db.db).WithNonPreparedStatementsPolicy(NonPreparedStatementsAllow))
+	s.Require().NoError(err)
+	s.Require().Equal(1, rowCount)
+	s.Require().Panics(func() { _, _ = countUsers(NewDB(context.Background(), s.db.db)) })
+}
+
+func (s *goquSuite) TestWithContext() {
+	type ctxKey struct{}
+	db := NewDB(context.Background(), s.db.db).WithNonPreparedStatementsPolicy(NonPreparedStatementsForbid)
+	reqCtx := context.WithValue(context.Background(), ctxKey{}, "request")
+	reqDB := db.WithContext(reqCtx)
+	s.Require().NotSame(db, reqDB)
+
+	// Settings are inherited, context is replaced.
+	err := reqDB.DoInTx(func(q Querier) error {
+		s.Require().Equal("request", q.(ContextProvider).Context().Value(ctxKey{}))
+		_, err := BuildSQLAndExec(q, s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("John")))
+		return err
+	})
+	s.Require().ErrorIs(err, ErrNonPreparedStatement)
+
+	// Changing settings of the derived DB doesn't affect the original one.
+	s.Require().NoError(reqDB.WithNonPreparedStatementsPolicy(NonPreparedStatementsAllow).DoInTx(func(q Querier) error {
+		_, err = BuildSQLAndExec(q, s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("John")))
+		return err
+	}))
+	s.Require().Equal(context.Background(), db.ctx)
+	s.Require().ErrorIs(db.DoInTx(func(q Querier) error {
+		_, err = BuildSQLAndExec(q, s.bs.Dialect.Delete("users").Where(goqu.I("name").Eq("Bob")))
+		return err
+	}), ErrNonPreparedStatement)
+
+	// Canceled context of the request doesn't affect the original DB.
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Require().ErrorIs(db.WithContext(canceledCtx).DoInTx(func(q Querier) error { return nil }), context.Canceled)
+	s.Require().NoError(db.DoInTx(func(q Querier) error { return nil }))
+}
+
+func (s *goquSuite) TestExecAndScanReturning() {
+	_ = s.db.DoInTx(func(q Querier) error {
+		var inserted []User
+		s.Require().NoError(ExecAndScanReturning(q, s.bs.Dialect.Insert("users").Rows(
+			goqu.Record{"name": "Alice", "created_at": nil}, goqu.Record{"name": "Carl", "created_at": tt},
+		).Prepared(true), &inserted))
+		s.Require().Equal([]User{{5, "Alice", NullTime{}}, {6, "Carl", NullTimeFrom(tt)}}, inserted)
+
+		type userName struct {
+			Name string `db:"name"`
+		}
+		var updated []userName
+		s.Require().NoError(ExecAndScanReturning(q, s.bs.Dialect.Update("users").
+			Set(goqu.Record{"name": goqu.L("UPPER(name)")}).
+			Where(goqu.I("id").Lt(3)).
+			Returning("name").
+			Prepared(true), &updated))
+		s.Require().ElementsMatch([]userName{{"ALBERT"}, {"BOB"}}, updated)
+
+		var deleted []User
+		s.Require().NoError(ExecAndScanReturning(q, s.bs.Dialect.Delete("users").Where(goqu.I("id").Eq(6)).Prepared(true), &deleted))
+		s.Require().Equal([]User{{6, "Carl", NullTimeFrom(tt)}}, deleted)
+
+		err := ExecAndScanReturning(q, goqu.Dialect("mysql").Insert("users").Rows(goqu.Record{"name": "Dan"}), &inserted)
+		s.Require().ErrorIs(err, ErrReturningNotSupported)
+
+		s.Require().Error(ExecAndScanReturning(q, s.bs.Dialect.From("users"), &inserted))
+		return nil
+	})
+}
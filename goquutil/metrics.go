@@ -0,0 +1,32 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"context"
+	"time"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// NewQueryMetricsObserver creates QueryDurationObserverFunc (it may be assigned to ObserveSQLQueryDuration)
+// that collects durations and errors of SQL queries via the passed dbkit.QueryMetrics
+// (Prometheus-based dbkit.MetricsCollector or otelmetrics.MetricsCollector).
+// getAnnotation returns annotation of the query that is used as a label value, query is not observed if it's empty.
+func NewQueryMetricsObserver(mc dbkit.QueryMetrics, getAnnotation func(query string) string) QueryDurationObserverFunc {
+	return func(preparedQueryString string, ctx context.Context, startTime time.Time, err error) {
+		annotation := getAnnotation(preparedQueryString)
+		if annotation == "" {
+			return
+		}
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		mc.ObserveQueryDuration(ctx, annotation, time.Since(startTime))
+		mc.ObserveQueryError(annotation, err)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/acronis/go-appkit/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func TestNewQueryMetricsObserver(t *testing.T) {
+	mc := dbkit.NewMetricsCollector()
+	observe := NewQueryMetricsObserver(mc, func(query string) string {
+		if query == "SELECT 1" {
+			return "query_select_one"
+		}
+		return ""
+	})
+
+	observe("SELECT 1", nil, time.Now(), nil)
+	observe("SELECT 1", context.Background(), time.Now(), context.DeadlineExceeded)
+	observe("SELECT 2", context.Background(), time.Now(), context.DeadlineExceeded)
+
+	hist := mc.QueryDurations.With(prometheus.Labels{dbkit.MetricsLabelQuery: "query_select_one"}).(prometheus.Histogram)
+	testutil.RequireSamplesCountInHistogram(t, hist, 2)
+	require.Equal(t, 1, promtestutil.CollectAndCount(mc.QueryErrors))
+	require.Equal(t, 1.0, promtestutil.ToFloat64(mc.QueryErrors.With(prometheus.Labels{
+		dbkit.MetricsLabelQuery:      "query_select_one",
+		dbkit.MetricsLabelErrorClass: string(dbkit.QueryErrorClassTimeout),
+	})))
+}
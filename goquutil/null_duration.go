@@ -0,0 +1,229 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NullDuration represents time.Duration that may be null.
+// By default, it's stored as an integer number of nanoseconds (BIGINT column).
+// DurationMicrosecondsEncoder/DurationMicrosecondsDecoder and DurationISO8601Encoder may be used
+// for storing it as an integer number of microseconds or as ISO 8601 text (e.g. for Postgres INTERVAL column).
+type NullDuration struct {
+	Valid    bool
+	Duration time.Duration
+}
+
+// NullDurationFrom creates valid NullDuration from time.Duration
+func NullDurationFrom(d time.Duration) NullDuration {
+	return NullDuration{Duration: d, Valid: true}
+}
+
+// NullBoolFrom creates valid sql.NullBool from bool
+func NullBoolFrom(b bool) sql.NullBool {
+	return sql.NullBool{Bool: b, Valid: true}
+}
+
+// Scan implements the Scanner interface.
+// Integer value is treated as a number of nanoseconds.
+// Text value may be an integer number of nanoseconds, ISO 8601 duration (e.g. "PT1H30M") or Go duration (e.g. "1h30m").
+func (nd *NullDuration) Scan(value interface{}) error {
+	return nd.scan(value, time.Nanosecond)
+}
+
+func (nd *NullDuration) scan(value interface{}, unit time.Duration) error {
+	nd.Duration, nd.Valid = 0, false
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case int64:
+		nd.Duration, nd.Valid = time.Duration(v)*unit, true
+		return nil
+	case float64:
+		nd.Duration, nd.Valid = time.Duration(math.Round(v*float64(unit))), true
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("cannot scan %T into NullDuration", value)
+	}
+	d, err := parseDurationString(s, unit)
+	if err != nil {
+		return err
+	}
+	nd.Duration, nd.Valid = d, true
+	return nil
+}
+
+// Value implements the driver Valuer interface.
+func (nd NullDuration) Value() (driver.Value, error) {
+	if !nd.Valid {
+		return nil, nil
+	}
+	return int64(nd.Duration), nil
+}
+
+// SetValid sets NullDuration valid
+func (nd *NullDuration) SetValid(d time.Duration) {
+	nd.Duration, nd.Valid = d, true
+}
+
+// SetInvalid sets NullDuration invalid
+func (nd *NullDuration) SetInvalid() {
+	nd.Duration, nd.Valid = 0, false
+}
+
+// DurationMicrosecondsEncoder is convenience function for writing NullDuration to db as an integer number of microseconds
+func DurationMicrosecondsEncoder(nd NullDuration) driver.Valuer {
+	return durationMicrosecondsEncoder{nd}
+}
+
+// DurationMicrosecondsDecoder is convenience function for reading NullDuration stored as an integer number of microseconds
+func DurationMicrosecondsDecoder(nd *NullDuration) sql.Scanner {
+	return durationMicrosecondsDecoder{nd}
+}
+
+// DurationISO8601Encoder is convenience function for writing NullDuration to db as ISO 8601 text (e.g. "PT1H30M").
+// Such value may be inserted into Postgres INTERVAL column directly.
+// For reading INTERVAL value back with NullDuration.Scan, IntervalStyle should be set to "iso_8601".
+func DurationISO8601Encoder(nd NullDuration) driver.Valuer {
+	return durationISO8601Encoder{nd}
+}
+
+type durationMicrosecondsEncoder struct {
+	nd NullDuration
+}
+
+func (e durationMicrosecondsEncoder) Value() (driver.Value, error) {
+	if !e.nd.Valid {
+		return nil, nil
+	}
+	return e.nd.Duration.Microseconds(), nil
+}
+
+type durationMicrosecondsDecoder struct {
+	nd *NullDuration
+}
+
+func (d durationMicrosecondsDecoder) Scan(value interface{}) error {
+	return d.nd.scan(value, time.Microsecond)
+}
+
+type durationISO8601Encoder struct {
+	nd NullDuration
+}
+
+func (e durationISO8601Encoder) Value() (driver.Value, error) {
+	if !e.nd.Valid {
+		return nil, nil
+	}
+	return formatISO8601Duration(e.nd.Duration), nil
+}
+
+func parseDurationString(s string, unit time.Duration) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(i) * unit, nil
+	}
+	if d, err := parseISO8601Duration(s); err == nil {
+		return d, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	return 0, fmt.Errorf("cannot parse string '%s' as duration", s)
+}
+
+// formatISO8601Duration formats duration as ISO 8601 duration string using hours, minutes and seconds designators.
+func formatISO8601Duration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+	var sb strings.Builder
+	if d < 0 {
+		sb.WriteByte('-')
+		d = -d
+	}
+	sb.WriteString("PT")
+	if h := d / time.Hour; h > 0 {
+		sb.WriteString(strconv.FormatInt(int64(h), 10) + "H")
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		sb.WriteString(strconv.FormatInt(int64(m), 10) + "M")
+		d -= m * time.Minute
+	}
+	if d > 0 {
+		sb.WriteString(strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S")
+	}
+	return sb.String()
+}
+
+// parseISO8601Duration parses ISO 8601 duration string (e.g. "PT1H30M", "P1DT12H", "-PT0.5S").
+// Years and months designators are not supported since their duration is not fixed.
+func parseISO8601Duration(s string) (time.Duration, error) {
+	orig := s
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign, s = -1, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("invalid ISO 8601 duration '%s'", orig)
+	}
+	s = s[1:]
+
+	var d time.Duration
+	inTime := false
+	for s != "" {
+		if s[0] == 'T' {
+			if inTime || len(s) == 1 {
+				return 0, fmt.Errorf("invalid ISO 8601 duration '%s'", orig)
+			}
+			inTime, s = true, s[1:]
+			continue
+		}
+		i := strings.IndexAny(s, "WDHMS")
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid ISO 8601 duration '%s'", orig)
+		}
+		num, err := strconv.ParseFloat(strings.Replace(s[:i], ",", ".", 1), 64)
+		if err != nil || num < 0 {
+			return 0, fmt.Errorf("invalid ISO 8601 duration '%s'", orig)
+		}
+		var unit time.Duration
+		switch designator := s[i]; {
+		case designator == 'W' && !inTime:
+			unit = 7 * 24 * time.Hour
+		case designator == 'D' && !inTime:
+			unit = 24 * time.Hour
+		case designator == 'H' && inTime:
+			unit = time.Hour
+		case designator == 'M' && inTime:
+			unit = time.Minute
+		case designator == 'S' && inTime:
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("unsupported designator '%c' in ISO 8601 duration '%s'", designator, orig)
+		}
+		d += time.Duration(math.Round(num * float64(unit)))
+		s = s[i+1:]
+	}
+	return sign * d, nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNullDuration_Scan(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    NullDuration
+		wantErr bool
+	}{
+		{name: "nil", value: nil, want: NullDuration{}},
+		{name: "int64 nanoseconds", value: int64(1500), want: NullDurationFrom(1500 * time.Nanosecond)},
+		{name: "float64 nanoseconds", value: float64(2000), want: NullDurationFrom(2 * time.Microsecond)},
+		{name: "integer text", value: []byte("3000"), want: NullDurationFrom(3 * time.Microsecond)},
+		{name: "ISO 8601", value: "PT1H30M", want: NullDurationFrom(90 * time.Minute)},
+		{name: "ISO 8601 with days and fraction", value: "P1DT0.5S", want: NullDurationFrom(24*time.Hour + 500*time.Millisecond)},
+		{name: "negative ISO 8601", value: "-PT2M", want: NullDurationFrom(-2 * time.Minute)},
+		{name: "Go duration", value: "1m30s", want: NullDurationFrom(90 * time.Second)},
+		{name: "ISO 8601 with months", value: "P1M", wantErr: true},
+		{name: "invalid text", value: "abc", wantErr: true},
+		{name: "unsupported type", value: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			nd := NullDurationFrom(time.Hour)
+			err := nd.Scan(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				require.False(t, nd.Valid)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, nd)
+		})
+	}
+}
+
+func TestNullDuration_Value(t *testing.T) {
+	tests := []struct {
+		name   string
+		valuer driver.Valuer
+		want   driver.Value
+	}{
+		{name: "invalid", valuer: NullDuration{}, want: nil},
+		{name: "nanoseconds", valuer: NullDurationFrom(time.Second), want: int64(1000000000)},
+		{name: "microseconds, invalid", valuer: DurationMicrosecondsEncoder(NullDuration{}), want: nil},
+		{name: "microseconds", valuer: DurationMicrosecondsEncoder(NullDurationFrom(time.Second)), want: int64(1000000)},
+		{name: "ISO 8601, invalid", valuer: DurationISO8601Encoder(NullDuration{}), want: nil},
+		{name: "ISO 8601, zero", valuer: DurationISO8601Encoder(NullDurationFrom(0)), want: "PT0S"},
+		{name: "ISO 8601", valuer: DurationISO8601Encoder(NullDurationFrom(26*time.Hour + 3*time.Minute + 1500*time.Millisecond)),
+			want: "PT26H3M1.5S"},
+		{name: "ISO 8601, negative", valuer: DurationISO8601Encoder(NullDurationFrom(-time.Minute)), want: "-PT1M"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.valuer.Value()
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDurationMicrosecondsDecoder(t *testing.T) {
+	var nd NullDuration
+	require.NoError(t, DurationMicrosecondsDecoder(&nd).Scan(int64(1500)))
+	require.Equal(t, NullDurationFrom(1500*time.Microsecond), nd)
+
+	require.NoError(t, DurationMicrosecondsDecoder(&nd).Scan([]byte("2000")))
+	require.Equal(t, NullDurationFrom(2*time.Millisecond), nd)
+
+	require.NoError(t, DurationMicrosecondsDecoder(&nd).Scan(nil))
+	require.Equal(t, NullDuration{}, nd)
+}
+
+func TestNullBoolFrom(t *testing.T) {
+	nb := NullBoolFrom(false)
+	require.True(t, nb.Valid)
+	require.False(t, nb.Bool)
+}
@@ -10,22 +10,58 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
-var nullTimeStringFormats = []string{
+// DefaultNullTimeStringFormats is a default list of formats that are used for parsing text values in NullTime.Scan.
+var DefaultNullTimeStringFormats = []string{
 	"2006-01-02 15:04:05.99999999999999999Z07:00",
 	"2006-01-02 15:04:05.99999999999999999",
 	time.RFC3339,
 	time.RFC3339Nano,
 }
 
+// NullTimeOpts represents options for scanning NullTime.
+type NullTimeOpts struct {
+	// Formats is a list of formats that are tried in order for parsing text values.
+	// DefaultNullTimeStringFormats is used by default.
+	Formats []string
+
+	// Location is used for text values without time zone and for Unix timestamps. UTC is used by default.
+	Location *time.Location
+
+	// UnixTimestampUnit is a unit of integer (or integer text) values that are treated as Unix timestamps
+	// (e.g. time.Second or time.Millisecond). time.Second is used by default.
+	UnixTimestampUnit time.Duration
+}
+
+var defaultNullTimeOpts atomic.Pointer[NullTimeOpts]
+
+// SetDefaultNullTimeOpts sets options that are used by NullTime.Scan (including scanning of NullTime struct fields
+// by QueryAndScanStructs and similar helpers). It's supposed to be called once on the service startup.
+// When different databases store datetimes differently, scan values explicitly via DB.NullTimeDecoder
+// (with options set by DB.WithNullTimeOpts) or NullTimeDecoder.
+func SetDefaultNullTimeOpts(opts NullTimeOpts) {
+	defaultNullTimeOpts.Store(&opts)
+}
+
+func getDefaultNullTimeOpts() NullTimeOpts {
+	if opts := defaultNullTimeOpts.Load(); opts != nil {
+		return *opts
+	}
+	return NullTimeOpts{}
+}
+
 // NullTime is suitable in case of different functions working with time
 // Note! It's suitable for in case you use goqu.MAX(date_column) on SQLite. It's not
 // required on MySQL. MySQL can work with sql.NullTime
 // sql.NullTime is not suitable in such case because on SQLite driver cannot detect
 // type of MAX(date_column) expression as timestamp and handle it as a text, the problem can be
 // in function (rc *SQLiteRows) declTypes() []string at github.com/mattn/go-sqlite3/sqlite3.go
+// Integer values are treated as Unix timestamps.
 type NullTime struct {
 	Valid bool
 	Time  time.Time
@@ -36,40 +72,92 @@ func NullTimeFrom(t time.Time) NullTime {
 	return NullTime{Time: t, Valid: true}
 }
 
+// NullTimeDecoder is convenience function for reading NullTime using the specified options
+func NullTimeDecoder(ns *NullTime, opts NullTimeOpts) sql.Scanner {
+	return nullTimeDecoder{ns: ns, opts: opts}
+}
+
+type nullTimeDecoder struct {
+	ns   *NullTime
+	opts NullTimeOpts
+}
+
+func (d nullTimeDecoder) Scan(value interface{}) error {
+	return d.ns.scan(value, d.opts)
+}
+
 // Scan implements the Scanner interface.
+// Options set by SetDefaultNullTimeOpts are used.
 func (ns *NullTime) Scan(value interface{}) error {
-	var t sql.NullTime
-	err := t.Scan(value)
-	if err == nil {
-		ns.Time, ns.Valid = t.Time, t.Valid
-		return nil
-	}
+	return ns.scan(value, getDefaultNullTimeOpts())
+}
 
-	var s sql.NullString
-	err = s.Scan(value)
-	if err != nil {
-		ns.Time, ns.Valid = time.Time{}, false
-		return err
+func (ns *NullTime) scan(value interface{}, opts NullTimeOpts) error {
+	ns.Time, ns.Valid = time.Time{}, false
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		ns.Time, ns.Valid = v, true
+		return nil
+	case int64:
+		ns.Time, ns.Valid = unixTimestampToTime(time.Duration(v)*opts.unixTimestampUnit(), opts), true
+		return nil
+	case float64:
+		ns.Time, ns.Valid = unixTimestampToTime(time.Duration(math.Round(v*float64(opts.unixTimestampUnit()))), opts), true
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("cannot scan %T into NullTime", value)
 	}
-	parsedTime, err := ns.parseAsTimeString(s.String)
+	parsedTime, err := ns.parseAsTimeString(s, opts)
 	if err != nil {
-		ns.Time, ns.Valid = time.Time{}, false
 		return err
 	}
 	ns.Time, ns.Valid = parsedTime, true
 	return nil
 }
 
-func (ns *NullTime) parseAsTimeString(s string) (time.Time, error) {
-	for idx := range nullTimeStringFormats {
-		t, err := time.Parse(nullTimeStringFormats[idx], s)
+func (ns *NullTime) parseAsTimeString(s string, opts NullTimeOpts) (time.Time, error) {
+	formats := opts.Formats
+	if formats == nil {
+		formats = DefaultNullTimeStringFormats
+	}
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	for idx := range formats {
+		t, err := time.ParseInLocation(formats[idx], s, loc)
 		if err == nil {
 			return t, nil
 		}
 	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return unixTimestampToTime(time.Duration(i)*opts.unixTimestampUnit(), opts), nil
+	}
 	return time.Time{}, fmt.Errorf("cannot parse string '%s' as time", s)
 }
 
+func (opts NullTimeOpts) unixTimestampUnit() time.Duration {
+	if opts.UnixTimestampUnit <= 0 {
+		return time.Second
+	}
+	return opts.UnixTimestampUnit
+}
+
+func unixTimestampToTime(sinceEpoch time.Duration, opts NullTimeOpts) time.Time {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Unix(0, int64(sinceEpoch)).In(loc)
+}
+
 // Value implements the driver Valuer interface.
 func (ns NullTime) Value() (driver.Value, error) {
 	if !ns.Valid {
@@ -0,0 +1,69 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNullTime_Scan(t *testing.T) {
+	tt := time.Date(2024, 3, 15, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    NullTime
+		wantErr bool
+	}{
+		{name: "nil", value: nil, want: NullTime{}},
+		{name: "time", value: tt, want: NullTimeFrom(tt)},
+		{name: "text", value: []byte("2024-03-15 10:20:30"), want: NullTimeFrom(tt)},
+		{name: "RFC3339 text", value: "2024-03-15T10:20:30Z", want: NullTimeFrom(tt)},
+		{name: "Unix timestamp", value: tt.Unix(), want: NullTimeFrom(tt)},
+		{name: "Unix timestamp text", value: "1710498030", want: NullTimeFrom(tt)},
+		{name: "invalid text", value: "abc", wantErr: true},
+		{name: "unsupported type", value: true, wantErr: true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ns := NullTimeFrom(time.Now())
+			err := ns.Scan(tc.value)
+			if tc.wantErr {
+				require.Error(t, err)
+				require.False(t, ns.Valid)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want.Valid, ns.Valid)
+			require.True(t, tc.want.Time.Equal(ns.Time))
+		})
+	}
+}
+
+func TestNullTimeDecoder(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	opts := NullTimeOpts{Formats: []string{"02.01.2006 15:04"}, Location: loc, UnixTimestampUnit: time.Millisecond}
+
+	var ns NullTime
+	require.NoError(t, NullTimeDecoder(&ns, opts).Scan("15.03.2024 13:20"))
+	require.True(t, ns.Valid)
+	require.Equal(t, time.Date(2024, 3, 15, 13, 20, 0, 0, loc), ns.Time)
+
+	require.NoError(t, NullTimeDecoder(&ns, opts).Scan(int64(1710498030500)))
+	require.True(t, ns.Valid)
+	require.Equal(t, time.Date(2024, 3, 15, 13, 20, 30, int(500*time.Millisecond), loc), ns.Time)
+
+	require.Error(t, NullTimeDecoder(&ns, opts).Scan("2024-03-15 10:20:30"))
+	require.False(t, ns.Valid)
+
+	db := (&DB{}).WithNullTimeOpts(opts)
+	require.NoError(t, db.NullTimeDecoder(&ns).Scan("15.03.2024 13:20"))
+	require.Equal(t, time.Date(2024, 3, 15, 13, 20, 0, 0, loc), ns.Time)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"errors"
+	"fmt"
+
+	golibslog "github.com/acronis/go-appkit/log"
+)
+
+// ErrNonPreparedStatement is returned when non-prepared SQL statement is executed
+// while NonPreparedStatementsForbid policy is used.
+var ErrNonPreparedStatement = errors.New("non-prepared sql statement")
+
+// NonPreparedStatementsPolicy defines how non-prepared SQL statements (i.e. statements with interpolated parameters)
+// are handled by the DB (see DB.WithNonPreparedStatementsPolicy).
+type NonPreparedStatementsPolicy int
+
+// Non-prepared statements policies.
+const (
+	// NonPreparedStatementsAllow allows executing non-prepared statements.
+	NonPreparedStatementsAllow NonPreparedStatementsPolicy = iota
+	// NonPreparedStatementsWarn allows executing non-prepared statements, but logs a warning for each of them.
+	NonPreparedStatementsWarn
+	// NonPreparedStatementsForbid forbids executing non-prepared statements, ErrNonPreparedStatement is returned instead.
+	NonPreparedStatementsForbid
+)
+
+// checkNonPreparedStatement handles non-prepared statement according to the policy of the Querier.
+// If the Querier has no policy (i.e. it's not created by DB.DoInTx with DB.WithNonPreparedStatementsPolicy),
+// legacy IsInsideTest behavior is used.
+func checkNonPreparedStatement(q Querier, query string) error {
+	opts := getQuerierOpts(q)
+	if opts.nonPreparedPolicy == nil {
+		if IsInsideTest {
+			panic(fmt.Sprintf("non-prepared sql statement detected: %s", query))
+		}
+		return nil
+	}
+	switch *opts.nonPreparedPolicy {
+	case NonPreparedStatementsWarn:
+		if opts.logger != nil {
+			opts.logger.Warn("non-prepared sql statement detected", golibslog.String("query", query))
+		}
+	case NonPreparedStatementsForbid:
+		return fmt.Errorf("%w: %s", ErrNonPreparedStatement, query)
+	}
+	return nil
+}
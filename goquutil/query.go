@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
@@ -28,7 +29,10 @@ type QueryDurationObserverFunc func(preparedQueryString string, ctx context.Cont
 // ObserveSQLQueryDuration is an actual instance of QueryDurationObserverFunc that is used
 var ObserveSQLQueryDuration QueryDurationObserverFunc
 
-// IsInsideTest when set to true enables some checks that are skipped for production code
+// IsInsideTest when set to true enables some checks that are skipped for production code.
+// Non-prepared statements cause panic if the policy is not set for DB (see DB.WithNonPreparedStatementsPolicy).
+//
+// Deprecated: Use DB.WithNonPreparedStatementsPolicy instead.
 var IsInsideTest bool
 
 // SQLBuilderSettings is sql builder settings representation
@@ -63,14 +67,19 @@ func queryDatabase(
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("query builing: %w", err)
 	}
+	var ctx context.Context
+	if cq, ok := q.(ContextProvider); ok {
+		ctx = cq.Context()
+		literalQuery = dbkit.AnnotateFromContext(ctx, literalQuery)
+	}
 
 	queryCouldBeObserved := false
 	var currentTime time.Time
 	if sqlExpression.IsPrepared() {
 		queryCouldBeObserved = true
 		currentTime = time.Now()
-	} else if IsInsideTest {
-		panic(fmt.Sprintf("non-prepared sql statement detected: %s", literalQuery))
+	} else if err = checkNonPreparedStatement(q, literalQuery); err != nil {
+		return nil, nil, nil, err
 	}
 
 	var queryErr error
@@ -85,14 +94,13 @@ func queryDatabase(
 
 	if queryCouldBeObserved {
 		if ObserveSQLQueryDuration != nil {
-			var ctx context.Context
-			if cq, ok := q.(ContextProvider); ok {
-				ctx = cq.Context()
-			}
 			ObserveSQLQueryDuration(literalQuery, ctx, currentTime, queryErr)
 		}
 	}
 
+	if queryErr != nil {
+		queryErr = wrapQueryError(q, literalQuery, params, sqlExpression.IsPrepared(), queryErr)
+	}
 	return sqlResult, sqlRows, sqlRow, queryErr
 }
 
@@ -130,6 +138,96 @@ func BuildSQLAndQueryScalar(q Querier, sqlExpression exp.SQLExpression, scalar i
 	return nil
 }
 
+// Exists is a function for checking if SELECT statement returns at least one row (SELECT CASE WHEN EXISTS(...) ...).
+// ORDER BY and LIMIT clauses are stripped since they don't affect the result
+// (unless OFFSET is specified or LIMIT is zero or parameterized).
+func Exists(q Querier, ds *goqu.SelectDataset) (bool, error) {
+	clauses := ds.GetClauses()
+	subQuery := ds
+	if clauses.Offset() == 0 {
+		subQuery = subQuery.ClearOrder()
+		if isStrippableLimit(clauses.Limit()) {
+			subQuery = subQuery.ClearLimit()
+		}
+	}
+	if clauses.Distinct() == nil && len(clauses.Compounds()) == 0 && isPlainColumnsSelect(clauses.Select()) {
+		subQuery = subQuery.ClearSelect().Select(goqu.L("1"))
+	}
+	// SELECT EXISTS(...) is not valid in T-SQL, so CASE expression is used to support all dialects.
+	existsExpr := goqu.L("CASE WHEN EXISTS ? THEN 1 ELSE 0 END", subQuery)
+	var exists bool
+	if err := BuildSQLAndQueryScalar(q, newOuterSelectDataset(ds).Select(existsExpr), &exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// Count is a function for counting rows returned by SELECT statement.
+// Selected columns and ORDER BY clause are replaced with COUNT(*) for simple statements.
+// Statements with LIMIT, OFFSET, GROUP BY, HAVING, DISTINCT or compound (UNION, INTERSECT) clauses
+// and statements that select anything but plain columns (e.g. aggregate functions)
+// are counted as subqueries (SELECT COUNT(*) FROM (...)) to keep the result correct.
+func Count(q Querier, ds *goqu.SelectDataset) (int64, error) {
+	clauses := ds.GetClauses()
+	var countDS *goqu.SelectDataset
+	if clauses.HasLimit() || clauses.Offset() > 0 || clauses.GroupBy() != nil || clauses.Having() != nil ||
+		clauses.Distinct() != nil || len(clauses.Compounds()) > 0 || !isPlainColumnsSelect(clauses.Select()) {
+		subQuery := ds
+		if !clauses.HasLimit() && clauses.Offset() == 0 {
+			subQuery = subQuery.ClearOrder()
+		}
+		countDS = newOuterSelectDataset(ds).From(subQuery.As("count_subquery")).Select(goqu.COUNT(goqu.Star()))
+	} else {
+		countDS = ds.ClearOrder().ClearSelect().Select(goqu.COUNT(goqu.Star()))
+	}
+	var count int64
+	if err := BuildSQLAndQueryScalar(q, countDS, &count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// isStrippableLimit reports whether LIMIT clause may be removed without changing the existence of rows,
+// i.e. it's absent, positive or LIMIT ALL.
+func isStrippableLimit(limit interface{}) bool {
+	switch l := limit.(type) {
+	case nil:
+		return true
+	case uint:
+		return l > 0
+	case exp.LiteralExpression:
+		return len(l.Args()) == 0 && strings.EqualFold(l.Literal(), "ALL")
+	}
+	return false
+}
+
+// isPlainColumnsSelect reports whether only plain (optionally aliased) columns or * are selected,
+// so the number of rows doesn't depend on the selected expressions (unlike aggregate functions).
+func isPlainColumnsSelect(cols exp.ColumnListExpression) bool {
+	if cols == nil {
+		return true
+	}
+	for _, col := range cols.Columns() {
+		if aliased, ok := col.(exp.AliasedExpression); ok {
+			col = aliased.Aliased()
+		}
+		switch c := col.(type) {
+		case exp.IdentifierExpression:
+		case exp.LiteralExpression:
+			if c.Literal() != "*" || len(c.Args()) != 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newOuterSelectDataset(ds *goqu.SelectDataset) *goqu.SelectDataset {
+	return goqu.From().SetDialect(ds.Dialect()).Prepared(ds.IsPrepared())
+}
+
 // ScanEachRow is a helper for scanning multiple rows result set
 func ScanEachRow(rows *sql.Rows, scanRow func(s Scanner) error) (rowsProcessed int, err error) {
 	defer func() { _ = rows.Close() }()
@@ -192,6 +290,48 @@ func QueryAndScanValues(q Querier, query *goqu.SelectDataset, result interface{}
 	return scanner.ScanVals(result)
 }
 
+// dialectsWithoutReturning contains names of the goqu dialects that don't support RETURNING clause.
+var dialectsWithoutReturning = map[string]bool{"mysql": true, "sqlite3": true, "sqlserver": true}
+
+// ExecAndScanReturning executes INSERT, UPDATE or DELETE statement with RETURNING clause
+// and scans returned rows into multiple structs, result is a pointer to slice of structs.
+// It allows fetching written rows in the same round-trip (Postgres only).
+// If RETURNING clause is not specified in the dataset, columns of the result struct are returned.
+// ErrReturningNotSupported is returned for dialects that don't support RETURNING clause (e.g. MySQL).
+func ExecAndScanReturning(q Querier, ds exp.SQLExpression, result interface{}) error {
+	var dialect string
+	switch typedDS := ds.(type) {
+	case *goqu.InsertDataset:
+		dialect = typedDS.Dialect().Dialect()
+		if !typedDS.GetClauses().HasReturning() {
+			ds = typedDS.Returning(result)
+		}
+	case *goqu.UpdateDataset:
+		dialect = typedDS.Dialect().Dialect()
+		if !typedDS.GetClauses().HasReturning() {
+			ds = typedDS.Returning(result)
+		}
+	case *goqu.DeleteDataset:
+		dialect = typedDS.Dialect().Dialect()
+		if !typedDS.GetClauses().HasReturning() {
+			ds = typedDS.Returning(result)
+		}
+	default:
+		return fmt.Errorf("unsupported dataset type %T, insert, update or delete dataset is expected", ds)
+	}
+	if dialectsWithoutReturning[dialect] {
+		return fmt.Errorf("%w [dialect=%s]", ErrReturningNotSupported, dialect)
+	}
+
+	rows, err := BuildSQLAndQuery(q, ds)
+	if err != nil {
+		return err
+	}
+	scanner := exec.NewScanner(rows)
+	defer func() { _ = scanner.Close() }()
+	return scanner.ScanStructs(result)
+}
+
 func prepareSelectsForCompositeRecord(query *goqu.SelectDataset, structTyp interface{}) []interface{} {
 	// prepare SELECT with default values using COALESCE:
 	// SELECT COALESCE(t1.col, ?) AS `t1.col`, ...
@@ -0,0 +1,82 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"fmt"
+
+	golibslog "github.com/acronis/go-appkit/log"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// QueryErrorOpts represents options for reporting details of the failed queries (see DB.WithQueryErrorDetails).
+type QueryErrorOpts struct {
+	// Logger (if set) is used for logging built SQL statement, number of parameters and annotation of the failed query.
+	Logger golibslog.FieldLogger
+
+	// IncludeParams enables exposing parameter values in the QueryError.
+	// Values are redacted by default since they may contain sensitive data.
+	// SQL of non-prepared (interpolated) statements is redacted as well since values are inlined into it.
+	IncludeParams bool
+}
+
+// QueryError is returned by the BuildSQLAndXXX helpers (and all functions based on them)
+// when the query fails within DB.DoInTx and DB.WithQueryErrorDetails is used.
+// It keeps the built SQL statement that is lost in the error returned by the driver.
+type QueryError struct {
+	Query        string // Empty for interpolated statements unless QueryErrorOpts.IncludeParams is true.
+	ParamsCount  int
+	Params       []interface{} // Nil unless QueryErrorOpts.IncludeParams is true.
+	Annotation   string
+	Interpolated bool // True for non-prepared statements that have parameter values inlined into SQL.
+	Err          error
+}
+
+// Error returns a string representation of the error.
+func (e *QueryError) Error() string {
+	if e.Interpolated && e.Query == "" {
+		if e.Annotation != "" {
+			return fmt.Sprintf("non-prepared query (annotation %q, SQL is redacted) failed: %v", e.Annotation, e.Err)
+		}
+		return fmt.Sprintf("non-prepared query (SQL is redacted) failed: %v", e.Err)
+	}
+	return fmt.Sprintf("query %q (%d params) failed: %v", e.Query, e.ParamsCount, e.Err)
+}
+
+// Unwrap returns the original error.
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+func wrapQueryError(q Querier, query string, params []interface{}, prepared bool, err error) error {
+	opts := getQuerierOpts(q).queryErrorOpts
+	if opts == nil {
+		return err
+	}
+	queryErr := &QueryError{
+		ParamsCount:  len(params),
+		Annotation:   dbkit.ParseAnnotationInQuery(query, "", nil),
+		Interpolated: !prepared,
+		Err:          err,
+	}
+	if prepared || opts.IncludeParams {
+		queryErr.Query = query
+	}
+	if opts.IncludeParams {
+		queryErr.Params = params
+	}
+	if opts.Logger != nil {
+		opts.Logger.Error("query failed",
+			golibslog.String("query", queryErr.Query),
+			golibslog.Int("params_count", queryErr.ParamsCount),
+			golibslog.String("annotation", queryErr.Annotation),
+			golibslog.Error(err),
+		)
+	}
+	return queryErr
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/acronis/go-appkit/retry"
+	"github.com/doug-martin/goqu/v9"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// RetryOnConflict calls fn and re-calls it if it fails with the error that is classified as retryable
+// (e.g. deadlock or serialization failure) by dbkit.GetIsRetryable for the driver behind q.
+// It's intended for idempotent statement-level operations when retrying the whole transaction is overkill.
+// Since most databases abort the whole transaction on deadlock, q should not be a transactional Querier.
+// Driver is resolved for *goqu.Database and *sql.DB (and for any Querier that has Driver() method),
+// otherwise only errors classified by the functions registered for nil driver are retried.
+// If q implements ContextProvider, its context is used for waiting between attempts.
+func RetryOnConflict(q Querier, policy retry.Policy, fn func(q Querier) error) error {
+	ctx := context.Background()
+	if cp, ok := q.(ContextProvider); ok {
+		ctx = cp.Context()
+	}
+	return retry.DoWithRetry(ctx, policy, dbkit.GetIsRetryable(querierDriver(q)), nil, func(ctx context.Context) error {
+		return fn(q)
+	})
+}
+
+func querierDriver(q Querier) driver.Driver {
+	switch typedQ := q.(type) {
+	case *goqu.Database:
+		if sqlDB, ok := typedQ.Db.(*sql.DB); ok {
+			return sqlDB.Driver()
+		}
+	case interface{ Driver() driver.Driver }:
+		return typedQ.Driver()
+	}
+	return nil
+}
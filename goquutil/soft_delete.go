@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package goquutil
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// SoftDeleteColumn is a name of the nullable timestamp column that is used for soft deletion convention.
+// Row is considered deleted if this column is not NULL.
+const SoftDeleteColumn = "deleted_at"
+
+type withTrashedCtxKey struct{}
+
+// WithTrashed returns a new context that disables excluding soft-deleted rows by ExcludeDeleted.
+// It's an escape hatch for the cases (e.g. admin tools, purging) when deleted rows should be selected too.
+func WithTrashed(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withTrashedCtxKey{}, true)
+}
+
+// IsWithTrashed checks if soft-deleted rows should not be excluded (see WithTrashed).
+func IsWithTrashed(ctx context.Context) bool {
+	withTrashed, _ := ctx.Value(withTrashedCtxKey{}).(bool)
+	return withTrashed
+}
+
+// NotDeleted returns "<table>.deleted_at IS NULL" expression. Column is not qualified if table is empty.
+func NotDeleted(table string) exp.BooleanExpression {
+	if table == "" {
+		return goqu.C(SoftDeleteColumn).IsNull()
+	}
+	return goqu.T(table).Col(SoftDeleteColumn).IsNull()
+}
+
+// ExcludeDeleted appends "deleted_at IS NULL" condition for each passed table to the SELECT statement.
+// If no tables are passed, unqualified column is used. Nothing is appended if context is created by WithTrashed.
+func ExcludeDeleted(ctx context.Context, ds *goqu.SelectDataset, tables ...string) *goqu.SelectDataset {
+	if IsWithTrashed(ctx) {
+		return ds
+	}
+	if len(tables) == 0 {
+		return ds.Where(NotDeleted(""))
+	}
+	conditions := make([]exp.Expression, 0, len(tables))
+	for _, table := range tables {
+		conditions = append(conditions, NotDeleted(table))
+	}
+	return ds.Where(conditions...)
+}
+
+// SoftDelete marks rows of the table that match the passed conditions as deleted (sets deleted_at to the current UTC time).
+// Already deleted rows are not touched, so their deletion time is preserved.
+func SoftDelete(q Querier, dialect goqu.DialectWrapper, table string, where ...exp.Expression) (sql.Result, error) {
+	return BuildSQLAndExec(q, dialect.Update(table).
+		Set(goqu.Record{SoftDeleteColumn: time.Now().UTC()}).
+		Where(append([]exp.Expression{NotDeleted("")}, where...)...).
+		Prepared(true))
+}
+
+// Restore un-deletes soft-deleted rows of the table that match the passed conditions (sets deleted_at to NULL).
+func Restore(q Querier, dialect goqu.DialectWrapper, table string, where ...exp.Expression) (sql.Result, error) {
+	return BuildSQLAndExec(q, dialect.Update(table).
+		Set(goqu.Record{SoftDeleteColumn: nil}).
+		Where(append([]exp.Expression{goqu.C(SoftDeleteColumn).IsNotNull()}, where...)...).
+		Prepared(true))
+}
@@ -15,6 +15,8 @@ import (
 	"github.com/acronis/go-appkit/httpserver/middleware"
 	golibslog "github.com/acronis/go-appkit/log"
 	"github.com/doug-martin/goqu/v9"
+
+	"github.com/acronis/go-dbkit"
 )
 
 // PreQueryFuncT is type for pre query hook function
@@ -36,16 +38,52 @@ type ContextProvider interface {
 	Context() context.Context
 }
 
+// txContextQuerier is implemented by both *goqu.TxDatabase and *sql.Tx.
+type txContextQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type cancellableTxQuerier struct {
-	ctx context.Context
-	tx  *goqu.TxDatabase
+	ctx  context.Context
+	tx   txContextQuerier
+	opts querierOpts
+}
+
+// querierOpts contains per-DB options that are applied by the BuildSQLAndXXX helpers to the queries of the Querier.
+type querierOpts struct {
+	queryErrorOpts    *QueryErrorOpts
+	nonPreparedPolicy *NonPreparedStatementsPolicy
+	logger            golibslog.FieldLogger
+}
+
+// querierOptsProvider is implemented by queriers that are created by DB.DoInTx.
+type querierOptsProvider interface {
+	querierOpts() querierOpts
+}
+
+func getQuerierOpts(q Querier) querierOpts {
+	if provider, ok := q.(querierOptsProvider); ok {
+		return provider.querierOpts()
+	}
+	return querierOpts{}
 }
 
-func newCancellableTxQuerier(ctx context.Context, tx *goqu.TxDatabase) Querier {
+func newCancellableTxQuerier(ctx context.Context, tx txContextQuerier) Querier {
 	return &cancellableTxQuerier{ctx: ctx, tx: tx}
 }
 
+// NewTxQuerier returns Querier that runs queries within the externally-provided transaction using the passed context.
+// It allows using goquutil helpers in code that is already inside dbkit.DoInTx (e.g. migrations or distributed lock flows).
+// PreQueryHook and PostQueryHook are called the same way as for queries within DB.DoInTx.
+// Query annotation from the context (see dbkit.WithQueryAnnotation) is prepended to the queries.
+func NewTxQuerier(ctx context.Context, tx *sql.Tx) Querier {
+	return newCancellableTxQuerier(ctx, tx)
+}
+
 func (q *cancellableTxQuerier) Exec(query string, args ...interface{}) (sql.Result, error) {
+	query = dbkit.AnnotateFromContext(q.ctx, query)
 	if PreQueryHook != nil {
 		query = PreQueryHook(q.ctx, query, args...)
 	}
@@ -60,6 +98,7 @@ func (q *cancellableTxQuerier) Exec(query string, args ...interface{}) (sql.Resu
 }
 
 func (q *cancellableTxQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	query = dbkit.AnnotateFromContext(q.ctx, query)
 	if PreQueryHook != nil {
 		query = PreQueryHook(q.ctx, query, args...)
 	}
@@ -74,6 +113,7 @@ func (q *cancellableTxQuerier) Query(query string, args ...interface{}) (*sql.Ro
 }
 
 func (q *cancellableTxQuerier) QueryRow(query string, args ...interface{}) *sql.Row {
+	query = dbkit.AnnotateFromContext(q.ctx, query)
 	if PreQueryHook != nil {
 		query = PreQueryHook(q.ctx, query, args...)
 	}
@@ -91,6 +131,10 @@ func (q *cancellableTxQuerier) Context() context.Context {
 	return q.ctx
 }
 
+func (q *cancellableTxQuerier) querierOpts() querierOpts {
+	return q.opts
+}
+
 // DB is a wrapper for goqu.Database
 type DB struct {
 	db                          *goqu.Database
@@ -99,6 +143,10 @@ type DB struct {
 	logger                      golibslog.FieldLogger
 	loggingCtx                  string
 	loggingTimeThresholdBeginTx time.Duration
+	nullTimeOpts                *NullTimeOpts
+	queryErrorOpts              *QueryErrorOpts
+	nonPreparedPolicy           *NonPreparedStatementsPolicy
+	beginTxMetrics              *BeginTxMetrics
 }
 
 // NewDB returns tx wrapper for goqu.Database
@@ -108,24 +156,45 @@ func NewDB(ctx context.Context, db *goqu.Database) *DB {
 
 // DoInTx opens db tx and runs worker func within its context
 func (d *DB) DoInTx(worker func(q Querier) error) error {
+	statsProvider, hasStats := d.db.Db.(dbStatsProvider)
+	hasStats = hasStats && (d.logger != nil || d.beginTxMetrics != nil)
+	var statsBefore sql.DBStats
+	if hasStats {
+		statsBefore = statsProvider.Stats()
+	}
 	start := time.Now()
 
 	tx, err := d.db.BeginTx(d.ctx, d.txOpts)
 	if err != nil {
 		return err
 	}
+	defer dbkit.WatchTx(d.ctx)()
+
+	beginTxElapsed := time.Since(start)
+	var poolWait beginTxPoolWait
+	if hasStats {
+		poolWait = getPoolWait(statsBefore, statsProvider.Stats())
+	}
+	if d.beginTxMetrics != nil {
+		d.beginTxMetrics.observe(beginTxElapsed, poolWait)
+	}
 
 	if d.logger != nil {
-		elapsed := time.Since(start).Milliseconds()
+		elapsed := beginTxElapsed.Milliseconds()
 		var level = golibslog.LevelDebug
 		if elapsed > d.loggingTimeThresholdBeginTx.Milliseconds() {
 			level = golibslog.LevelInfo
 		}
 		d.logger.AtLevel(level, func(logFunc golibslog.LogFunc) {
-			logFunc(
-				fmt.Sprintf("opened DB transaction (%s) in %dms", d.loggingCtx, elapsed),
-				golibslog.Int64("duration_ms", elapsed),
-			)
+			fields := []golibslog.Field{golibslog.Int64("duration_ms", elapsed)}
+			if hasStats {
+				fields = append(fields,
+					golibslog.String("wait_reason", poolWait.reason()),
+					golibslog.Int64("pool_wait_count", poolWait.count),
+					golibslog.Int64("pool_wait_ms", poolWait.duration.Milliseconds()),
+				)
+			}
+			logFunc(fmt.Sprintf("opened DB transaction (%s) in %dms", d.loggingCtx, elapsed), fields...)
 		})
 		if d.ctx != nil {
 			loggingParams := middleware.GetLoggingParamsFromContext(d.ctx)
@@ -136,7 +205,11 @@ func (d *DB) DoInTx(worker func(q Querier) error) error {
 	}
 
 	err = tx.Wrap(func() error {
-		q := newCancellableTxQuerier(d.ctx, tx)
+		q := &cancellableTxQuerier{ctx: d.ctx, tx: tx, opts: querierOpts{
+			queryErrorOpts:    d.queryErrorOpts,
+			nonPreparedPolicy: d.nonPreparedPolicy,
+			logger:            d.logger,
+		}}
 		workerErr := worker(q)
 		start = time.Now()
 		return workerErr
@@ -157,16 +230,72 @@ func (d *DB) DoInTx(worker func(q Querier) error) error {
 	return err
 }
 
+// WithContext returns a shallow copy of the DB with all its settings (tx options, logging, metrics, etc.)
+// but with the new context. It's a cheap way to scope an existing configured DB to a request.
+// Settings of the returned DB may be changed without affecting the original one.
+func (d *DB) WithContext(ctx context.Context) *DB {
+	dbCopy := *d
+	dbCopy.ctx = ctx
+	return &dbCopy
+}
+
 // WithTxOpts allows passing additional options for opened tx
 func (d *DB) WithTxOpts(txOpts *sql.TxOptions) *DB {
 	d.txOpts = txOpts
 	return d
 }
 
-// WithLogging enables logging of time consumed on openning/getting DB connection from pool
+// WithLogging enables logging of time consumed on openning/getting DB connection from pool.
+// If the underlying database provides connection pool statistics (e.g. *sql.DB), log entry also contains
+// the pool waiting time and count, so pool exhaustion may be distinguished from the server slowness.
 func (d *DB) WithLogging(logger golibslog.FieldLogger, loggingCtx string, loggingTimeThresholdBeginTx time.Duration) *DB {
 	d.logger = logger
 	d.loggingCtx = loggingCtx
 	d.loggingTimeThresholdBeginTx = loggingTimeThresholdBeginTx
 	return d
 }
+
+// WithBeginTxMetrics enables collecting metrics of opening transactions in DoInTx.
+// Snapshots of the connection pool statistics (sql.DBStats) are used for detecting whether BeginTx waited
+// for a free connection in the pool (BeginTxWaitReasonPool) or for the server (BeginTxWaitReasonServer).
+func (d *DB) WithBeginTxMetrics(m *BeginTxMetrics) *DB {
+	d.beginTxMetrics = m
+	return d
+}
+
+// WithNullTimeOpts sets options that are used by DB.NullTimeDecoder.
+// It's useful when MySQL/SQLite deployments store datetimes in different formats or time zones.
+// Options affect only values scanned via DB.NullTimeDecoder: NullTime.Scan (and so QueryAndScanStructs,
+// QueryAndScanStruct and other helpers that scan NullTime fields of structs) always uses the options
+// set by SetDefaultNullTimeOpts.
+func (d *DB) WithNullTimeOpts(opts NullTimeOpts) *DB {
+	d.nullTimeOpts = &opts
+	return d
+}
+
+// WithQueryErrorDetails enables wrapping errors of the failed queries within DoInTx into QueryError
+// that contains built SQL statement, number of parameters and annotation.
+// Parameter values (and SQL of non-prepared statements, since values are inlined into it)
+// are redacted unless QueryErrorOpts.IncludeParams is true.
+func (d *DB) WithQueryErrorDetails(opts QueryErrorOpts) *DB {
+	d.queryErrorOpts = &opts
+	return d
+}
+
+// WithNonPreparedStatementsPolicy sets policy for non-prepared statements executed within DoInTx
+// by the BuildSQLAndXXX helpers (and all functions based on them).
+// Warnings (NonPreparedStatementsWarn) are logged with the logger set by WithLogging.
+// If policy is not set, global IsInsideTest flag is used.
+func (d *DB) WithNonPreparedStatementsPolicy(policy NonPreparedStatementsPolicy) *DB {
+	d.nonPreparedPolicy = &policy
+	return d
+}
+
+// NullTimeDecoder returns sql.Scanner for reading NullTime using options set by WithNullTimeOpts.
+// If options are not set, default ones (see SetDefaultNullTimeOpts) are used.
+func (d *DB) NullTimeDecoder(ns *NullTime) sql.Scanner {
+	if d.nullTimeOpts == nil {
+		return NullTimeDecoder(ns, getDefaultNullTimeOpts())
+	}
+	return NullTimeDecoder(ns, *d.nullTimeOpts)
+}
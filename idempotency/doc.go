@@ -0,0 +1,12 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package idempotency provides handling of the idempotency keys (e.g. values of the Idempotency-Key HTTP header)
+// on top of the SQL database. The key of the request is recorded in the same transaction that does the work,
+// and the result is stored together with it, so duplicate requests get the stored result instead of doing the work again.
+// Note that the package of the dialect (e.g. github.com/acronis/go-dbkit/postgres) should be imported,
+// since concurrent duplicates are detected by the unique violation error classification (see dbkit.ClassifyQueryError).
+package idempotency
@@ -0,0 +1,198 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+// DefaultTableName is a name of the table that is used for storing idempotency keys by default.
+const DefaultTableName = "idempotency_keys"
+
+// MaxKeyLength is a maximum length of the idempotency key.
+const MaxKeyLength = 255
+
+// CreateTableMigrationID is an ID of the migration returned by Store.Migrations.
+const CreateTableMigrationID = "idempotency_00001_create_table"
+
+// ErrConcurrentRequest is returned by Store.RunIdempotent when the request with the same key
+// is being processed concurrently. The transaction should be rolled back, and the request may be retried later
+// (the result of the concurrent request will be replayed then).
+var ErrConcurrentRequest = errors.New("request with the same idempotency key is being processed concurrently")
+
+// StoreOpts represents an options for Store.
+type StoreOpts struct {
+	// TableName is a name of the table where idempotency keys are stored. DefaultTableName is used by default.
+	TableName string
+
+	// Now returns the current time that is used for computing and checking expiration of keys.
+	// It's supposed to be set in tests only. By default, time.Now is used.
+	Now func() time.Time
+}
+
+// Store records idempotency keys and results of the requests in the SQL database.
+type Store struct {
+	queries dbQueries
+	now     func() time.Time
+}
+
+// NewStore creates a new Store for the specified dialect.
+func NewStore(dialect dbkit.Dialect) (*Store, error) {
+	return NewStoreWithOpts(dialect, StoreOpts{})
+}
+
+// NewStoreWithOpts is a more configurable version of the NewStore.
+func NewStoreWithOpts(dialect dbkit.Dialect, opts StoreOpts) (*Store, error) {
+	if opts.TableName == "" {
+		opts.TableName = DefaultTableName
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	q, err := newDBQueries(dialect, opts.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{queries: q, now: opts.Now}, nil
+}
+
+// Migrations returns set of migrations that must be applied before using the store.
+func (s *Store) Migrations() []migrate.Migration {
+	return []migrate.Migration{
+		migrate.NewCustomMigration(CreateTableMigrationID, []string{s.queries.createTable}, []string{s.queries.dropTable}, nil, nil),
+	}
+}
+
+// RunIdempotent calls fn within the passed transaction only if the request with the same key
+// was not processed yet (or its record is expired), and stores the result returned by fn for the ttl.
+// For the duplicate request, fn is not called, and the stored result is returned with replayed = true.
+// If fn returns error, it's returned as is, and the transaction should be rolled back, so the key is not recorded.
+// ErrConcurrentRequest is returned if the request with the same key is being processed concurrently.
+func (s *Store) RunIdempotent(
+	ctx context.Context, tx *sql.Tx, key string, ttl time.Duration, fn func(tx *sql.Tx) ([]byte, error),
+) (result []byte, replayed bool, err error) {
+	if err = validateKey(key); err != nil {
+		return nil, false, err
+	}
+	now := s.now()
+
+	var storedResult []byte
+	var expiresAt int64
+	err = tx.QueryRowContext(ctx, s.queries.get, key).Scan(&storedResult, &expiresAt)
+	switch {
+	case err == nil:
+		if expiresAt >= now.UnixMicro() {
+			return storedResult, true, nil
+		}
+		if _, err = tx.ExecContext(ctx, s.queries.deleteExpired, key, now.UnixMicro()); err != nil {
+			return nil, false, fmt.Errorf("delete expired idempotency key: %w", err)
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, false, fmt.Errorf("get idempotency key: %w", err)
+	}
+
+	// Record is inserted before calling fn, so concurrent duplicates fail (or wait for the commit) on the unique key.
+	if _, err = tx.ExecContext(ctx, s.queries.insert, key, now.Add(ttl).UnixMicro()); err != nil {
+		if dbkit.ClassifyQueryError(err) == dbkit.QueryErrorClassUniqueViolation {
+			return nil, false, ErrConcurrentRequest
+		}
+		return nil, false, fmt.Errorf("insert idempotency key: %w", err)
+	}
+	if result, err = fn(tx); err != nil {
+		return nil, false, err
+	}
+	if result == nil {
+		result = []byte{}
+	}
+	if _, err = tx.ExecContext(ctx, s.queries.setResult, result, key); err != nil {
+		return nil, false, fmt.Errorf("store idempotent request result: %w", err)
+	}
+	return result, false, nil
+}
+
+// DeleteExpired deletes all expired idempotency keys. Number of deleted keys is returned.
+// It's supposed to be called periodically for cleaning up the table.
+func (s *Store) DeleteExpired(ctx context.Context, executor SQLExecutor) (int64, error) {
+	result, err := executor.ExecContext(ctx, s.queries.deleteAllExpired, s.now().UnixMicro())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SQLExecutor is an interface for executing SQL statements (*sql.DB, *sql.Tx and *sql.Conn implement it).
+type SQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("idempotency key cannot be empty")
+	}
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("idempotency key length should be less or equal %d", MaxKeyLength)
+	}
+	return nil
+}
+
+type dbQueries struct {
+	createTable      string
+	dropTable        string
+	get              string
+	insert           string
+	setResult        string
+	deleteExpired    string
+	deleteAllExpired string
+}
+
+func newDBQueries(dialect dbkit.Dialect, tableName string) (dbQueries, error) {
+	var createTableQuery string
+	switch dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		createTableQuery = postgresCreateTableQuery
+	case dbkit.DialectMySQL:
+		createTableQuery = mySQLCreateTableQuery
+	case dbkit.DialectSQLite:
+		createTableQuery = sqliteCreateTableQuery
+	case dbkit.DialectMSSQL:
+		createTableQuery = msSQLCreateTableQuery
+	default:
+		return dbQueries{}, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+	if err := dbkit.ValidateIdentifier(dialect, tableName); err != nil {
+		return dbQueries{}, fmt.Errorf("table name: %w", err)
+	}
+	table := dbkit.QuoteIdentifier(dialect, tableName)
+	p := func(n int) string { return dbkit.MakePlaceholder(dialect, n) }
+	return dbQueries{
+		createTable: fmt.Sprintf(createTableQuery, table),
+		dropTable:   fmt.Sprintf("DROP TABLE IF EXISTS %s;", table),
+		get:         fmt.Sprintf("SELECT result, expires_at FROM %s WHERE idempotency_key = %s;", table, p(1)),
+		insert:      fmt.Sprintf("INSERT INTO %s (idempotency_key, expires_at) VALUES (%s, %s);", table, p(1), p(2)),
+		setResult:   fmt.Sprintf("UPDATE %s SET result = %s WHERE idempotency_key = %s;", table, p(1), p(2)),
+		deleteExpired: fmt.Sprintf("DELETE FROM %s WHERE idempotency_key = %s AND expires_at < %s;",
+			table, p(1), p(2)),
+		deleteAllExpired: fmt.Sprintf("DELETE FROM %s WHERE expires_at < %s;", table, p(1)),
+	}, nil
+}
+
+// expires_at contains Unix time in microseconds, so the expiration is checked in the same way for all dialects.
+//
+//nolint:lll
+const (
+	postgresCreateTableQuery = `CREATE TABLE %s (idempotency_key varchar(255) PRIMARY KEY, result bytea, expires_at bigint NOT NULL);`
+	mySQLCreateTableQuery    = `CREATE TABLE %s (idempotency_key VARCHAR(255) PRIMARY KEY, result LONGBLOB, expires_at BIGINT NOT NULL);`
+	sqliteCreateTableQuery   = `CREATE TABLE %s (idempotency_key VARCHAR(255) PRIMARY KEY, result BLOB, expires_at INTEGER NOT NULL);`
+	msSQLCreateTableQuery    = `CREATE TABLE %s (idempotency_key NVARCHAR(255) PRIMARY KEY, result VARBINARY(MAX), expires_at BIGINT NOT NULL);`
+)
@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+	_ "github.com/acronis/go-dbkit/sqlite"
+)
+
+func TestStore_RunIdempotent(t *testing.T) {
+	ctx := context.Background()
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "idempotency.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	now := time.Now()
+	store, err := NewStoreWithOpts(dbkit.DialectSQLite, StoreOpts{Now: func() time.Time { return now }})
+	require.NoError(t, err)
+	migMngr, err := migrate.NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(store.Migrations(), migrate.MigrationsDirectionUp))
+
+	var calls int
+	run := func(key string, fnErr error) (result []byte, replayed bool, err error) {
+		err = dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			var runErr error
+			result, replayed, runErr = store.RunIdempotent(ctx, tx, key, time.Hour, func(tx *sql.Tx) ([]byte, error) {
+				calls++
+				if fnErr != nil {
+					return nil, fnErr
+				}
+				return []byte("order #1 created"), nil
+			})
+			return runErr
+		})
+		return result, replayed, err
+	}
+
+	// The first request is processed.
+	result, replayed, err := run("key-1", nil)
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, []byte("order #1 created"), result)
+	require.Equal(t, 1, calls)
+
+	// Duplicate is replayed.
+	result, replayed, err = run("key-1", nil)
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.Equal(t, []byte("order #1 created"), result)
+	require.Equal(t, 1, calls)
+
+	// Failed request is not recorded.
+	fnErr := errors.New("out of stock")
+	_, _, err = run("key-2", fnErr)
+	require.ErrorIs(t, err, fnErr)
+	_, replayed, err = run("key-2", nil)
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, 3, calls)
+
+	// Expired key is processed again.
+	now = now.Add(2 * time.Hour)
+	_, replayed, err = run("key-1", nil)
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, 4, calls)
+
+	now = now.Add(2 * time.Hour)
+	deleted, err := store.DeleteExpired(ctx, dbConn)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+
+	_, _, err = run("", nil)
+	require.EqualError(t, err, "idempotency key cannot be empty")
+
+	require.NoError(t, migMngr.Run(store.Migrations(), migrate.MigrationsDirectionDown))
+}
+
+func TestStore_RunIdempotent_ConcurrentRequest(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	store, err := NewStore(dbkit.DialectSQLite)
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(store.queries.get)).WithArgs("key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"result", "expires_at"}))
+	mock.ExpectExec(regexp.QuoteMeta(store.queries.insert)).
+		WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey})
+	mock.ExpectRollback()
+	err = dbkit.DoInTx(ctx, db, func(tx *sql.Tx) error {
+		_, _, runErr := store.RunIdempotent(ctx, tx, "key-1", time.Hour, func(tx *sql.Tx) ([]byte, error) {
+			t.Fatalf("fn must not be called")
+			return nil, nil
+		})
+		return runErr
+	})
+	require.ErrorIs(t, err, ErrConcurrentRequest)
+
+	mock.ExpectClose()
+	require.NoError(t, db.Close())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNewStore(t *testing.T) {
+	for _, dialect := range []dbkit.Dialect{
+		dbkit.DialectPostgres, dbkit.DialectPgx, dbkit.DialectMySQL, dbkit.DialectSQLite, dbkit.DialectMSSQL,
+	} {
+		_, err := NewStore(dialect)
+		require.NoError(t, err, dialect)
+	}
+	_, err := NewStore("oracle")
+	require.EqualError(t, err, `unsupported sql dialect "oracle"`)
+}
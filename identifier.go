@@ -0,0 +1,77 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidIdentifier is returned when SQL identifier (e.g. table or schema name) cannot be used.
+var ErrInvalidIdentifier = errors.New("invalid identifier")
+
+// maxIdentifierLengths contains maximum lengths of identifiers for the dialects that limit them.
+// Postgres limits length in bytes, others - in characters.
+var maxIdentifierLengths = map[Dialect]int{
+	DialectMySQL:    64,
+	DialectPostgres: PostgresMaxIdentifierLength,
+	DialectPgx:      PostgresMaxIdentifierLength,
+	DialectMSSQL:    128,
+}
+
+// ValidateIdentifier checks that SQL identifier (e.g. table, column or schema name) may be used with the dialect:
+// it must be a non-empty valid UTF-8 string without NUL characters that doesn't exceed the dialect's length limit.
+// Note that identifiers still must be quoted (see QuoteIdentifier) before using them in SQL.
+func ValidateIdentifier(dialect Dialect, ident string) error {
+	if ident == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidIdentifier)
+	}
+	if !utf8.ValidString(ident) || strings.ContainsRune(ident, 0) {
+		return fmt.Errorf("%w %q: contains invalid characters", ErrInvalidIdentifier, ident)
+	}
+	if maxLen, ok := maxIdentifierLengths[dialect]; ok && identifierLength(dialect, ident) > maxLen {
+		return fmt.Errorf("%w %q: longer than %d characters", ErrInvalidIdentifier, ident, maxLen)
+	}
+	return nil
+}
+
+// QuoteIdentifier quotes SQL identifier (e.g. table or column name) according to the dialect.
+// Quote characters inside the identifier are escaped, so it's safe to concatenate the result into SQL.
+func QuoteIdentifier(dialect Dialect, ident string) string {
+	switch dialect {
+	case DialectMySQL:
+		return "`" + strings.ReplaceAll(ident, "`", "``") + "`"
+	case DialectMSSQL:
+		return "[" + strings.ReplaceAll(ident, "]", "]]") + "]"
+	default:
+		return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+	}
+}
+
+// QuoteQualified quotes schema-qualified SQL identifier (e.g. "public"."users") according to the dialect.
+// If schema is empty, only name is quoted. Both parts are validated with ValidateIdentifier.
+func QuoteQualified(dialect Dialect, schema, name string) (string, error) {
+	if err := ValidateIdentifier(dialect, name); err != nil {
+		return "", err
+	}
+	if schema == "" {
+		return QuoteIdentifier(dialect, name), nil
+	}
+	if err := ValidateIdentifier(dialect, schema); err != nil {
+		return "", err
+	}
+	return QuoteIdentifier(dialect, schema) + "." + QuoteIdentifier(dialect, name), nil
+}
+
+func identifierLength(dialect Dialect, ident string) int {
+	if dialect == DialectPostgres || dialect == DialectPgx {
+		return len(ident)
+	}
+	return utf8.RuneCountInString(ident)
+}
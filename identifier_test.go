@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateIdentifier(t *testing.T) {
+	for _, dialect := range []Dialect{DialectSQLite, DialectMySQL, DialectPostgres, DialectPgx, DialectMSSQL} {
+		require.NoError(t, ValidateIdentifier(dialect, "users"), dialect)
+		require.NoError(t, ValidateIdentifier(dialect, `weird "name"`), dialect)
+		require.ErrorIs(t, ValidateIdentifier(dialect, ""), ErrInvalidIdentifier, dialect)
+		require.ErrorIs(t, ValidateIdentifier(dialect, "users\x00"), ErrInvalidIdentifier, dialect)
+		require.ErrorIs(t, ValidateIdentifier(dialect, "\xff"), ErrInvalidIdentifier, dialect)
+	}
+
+	require.NoError(t, ValidateIdentifier(DialectPostgres, strings.Repeat("a", 63)))
+	require.ErrorIs(t, ValidateIdentifier(DialectPostgres, strings.Repeat("a", 64)), ErrInvalidIdentifier)
+	require.ErrorIs(t, ValidateIdentifier(DialectPgx, strings.Repeat("я", 32)), ErrInvalidIdentifier) // 64 bytes
+	require.NoError(t, ValidateIdentifier(DialectMySQL, strings.Repeat("я", 64)))
+	require.ErrorIs(t, ValidateIdentifier(DialectMySQL, strings.Repeat("a", 65)), ErrInvalidIdentifier)
+	require.ErrorIs(t, ValidateIdentifier(DialectMSSQL, strings.Repeat("a", 129)), ErrInvalidIdentifier)
+	require.NoError(t, ValidateIdentifier(DialectSQLite, strings.Repeat("a", 1000)))
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		ident   string
+		want    string
+	}{
+		{DialectPostgres, `users`, `"users"`},
+		{DialectPgx, `my "users"`, `"my ""users"""`},
+		{DialectSQLite, `users`, `"users"`},
+		{DialectMySQL, "my `users`", "`my ``users```"},
+		{DialectMSSQL, `my [users]`, `[my [users]]]`},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, QuoteIdentifier(tt.dialect, tt.ident))
+	}
+}
+
+func TestQuoteQualified(t *testing.T) {
+	quoted, err := QuoteQualified(DialectPostgres, "public", "users")
+	require.NoError(t, err)
+	require.Equal(t, `"public"."users"`, quoted)
+
+	quoted, err = QuoteQualified(DialectMySQL, "", "users")
+	require.NoError(t, err)
+	require.Equal(t, "`users`", quoted)
+
+	quoted, err = QuoteQualified(DialectMSSQL, "dbo", "users")
+	require.NoError(t, err)
+	require.Equal(t, "[dbo].[users]", quoted)
+
+	_, err = QuoteQualified(DialectPostgres, "public", "")
+	require.ErrorIs(t, err, ErrInvalidIdentifier)
+	_, err = QuoteQualified(DialectPostgres, strings.Repeat("s", 64), "users")
+	require.ErrorIs(t, err, ErrInvalidIdentifier)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+)
+
+// Querier is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// InstrumentedQuerierOpts represents an options for InstrumentedQuerier.
+type InstrumentedQuerierOpts struct {
+	// SlowQueryLogger (if set) is used for logging queries that take longer than SlowQueryThreshold.
+	SlowQueryLogger log.FieldLogger
+
+	// SlowQueryThreshold is a minimal duration of the query to be logged as slow.
+	SlowQueryThreshold time.Duration
+}
+
+// InstrumentedQuerier wraps Querier (*sql.DB, *sql.Tx or *sql.Conn) and provides Exec, Query and QueryRow methods
+// that accept an annotation name, so teams that don't use dbr or goqu have the same observability:
+// query is annotated (see Annotate), its duration and error are collected via QueryMetrics
+// (Prometheus-based MetricsCollector or otelmetrics.MetricsCollector), and slow queries are logged.
+// If the annotation name is empty, the one from the context (see WithQueryAnnotation) is used,
+// and the query is executed without instrumentation if there is no annotation at all.
+// Durations of Query and QueryRow include only sending the query and receiving the first response, not reading rows.
+type InstrumentedQuerier struct {
+	querier            Querier
+	metricsCollector   QueryMetrics
+	slowQueryLogger    log.FieldLogger
+	slowQueryThreshold time.Duration
+}
+
+// NewInstrumentedQuerier creates a new InstrumentedQuerier.
+func NewInstrumentedQuerier(querier Querier, mc QueryMetrics) *InstrumentedQuerier {
+	return NewInstrumentedQuerierWithOpts(querier, mc, InstrumentedQuerierOpts{})
+}
+
+// NewInstrumentedQuerierWithOpts is a more configurable version of the NewInstrumentedQuerier.
+func NewInstrumentedQuerierWithOpts(querier Querier, mc QueryMetrics, opts InstrumentedQuerierOpts) *InstrumentedQuerier {
+	return &InstrumentedQuerier{
+		querier:            querier,
+		metricsCollector:   mc,
+		slowQueryLogger:    opts.SlowQueryLogger,
+		slowQueryThreshold: opts.SlowQueryThreshold,
+	}
+}
+
+// Exec executes the annotated query without returning any rows.
+func (q *InstrumentedQuerier) Exec(ctx context.Context, annotation, query string, args ...interface{}) (sql.Result, error) {
+	annotation, query = q.annotate(ctx, annotation, query)
+	startTime := time.Now()
+	result, err := q.querier.ExecContext(ctx, query, args...)
+	q.observe(ctx, annotation, startTime, err)
+	return result, err
+}
+
+// Query executes the annotated query that returns rows.
+func (q *InstrumentedQuerier) Query(ctx context.Context, annotation, query string, args ...interface{}) (*sql.Rows, error) {
+	annotation, query = q.annotate(ctx, annotation, query)
+	startTime := time.Now()
+	rows, err := q.querier.QueryContext(ctx, query, args...)
+	q.observe(ctx, annotation, startTime, err)
+	return rows, err
+}
+
+// QueryRow executes the annotated query that is expected to return at most one row.
+// sql.ErrNoRows is not observed as an error.
+func (q *InstrumentedQuerier) QueryRow(ctx context.Context, annotation, query string, args ...interface{}) *sql.Row {
+	annotation, query = q.annotate(ctx, annotation, query)
+	startTime := time.Now()
+	row := q.querier.QueryRowContext(ctx, query, args...)
+	q.observe(ctx, annotation, startTime, row.Err())
+	return row
+}
+
+func (q *InstrumentedQuerier) annotate(ctx context.Context, annotation, query string) (string, string) {
+	if annotation == "" {
+		annotation = QueryAnnotationFromContext(ctx)
+	}
+	return annotation, Annotate(query, annotation)
+}
+
+func (q *InstrumentedQuerier) observe(ctx context.Context, annotation string, startTime time.Time, err error) {
+	if annotation == "" {
+		return
+	}
+	elapsed := time.Since(startTime)
+	if q.metricsCollector != nil {
+		q.metricsCollector.ObserveQueryDuration(ctx, annotation, elapsed)
+		q.metricsCollector.ObserveQueryError(annotation, err)
+	}
+	if q.slowQueryLogger != nil && elapsed >= q.slowQueryThreshold {
+		q.slowQueryLogger.Warn("slow SQL query",
+			log.String("annotation", annotation),
+			log.Int64("duration_ms", elapsed.Milliseconds()),
+		)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/acronis/go-appkit/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentedQuerier(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "instrumented.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	mc := NewMetricsCollector()
+	logRecorder := logtest.NewRecorder()
+	q := NewInstrumentedQuerierWithOpts(db, mc, InstrumentedQuerierOpts{SlowQueryLogger: logRecorder})
+	ctx := context.Background()
+	getHistogram := func(annotation string) prometheus.Histogram {
+		return mc.QueryDurations.With(prometheus.Labels{MetricsLabelQuery: annotation}).(prometheus.Histogram)
+	}
+
+	_, err = q.Exec(ctx, "query_create_users", "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	require.NoError(t, err)
+	_, err = q.Exec(WithQueryAnnotation(ctx, "query_insert_user"), "", "INSERT INTO users (name) VALUES (?)", "alice")
+	require.NoError(t, err)
+
+	rows, err := q.Query(ctx, "query_list_users", "SELECT name FROM users")
+	require.NoError(t, err)
+	var names []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"alice"}, names)
+
+	var name string
+	require.NoError(t, q.QueryRow(ctx, "query_get_user", "SELECT name FROM users WHERE id = ?", 1).Scan(&name))
+	require.Equal(t, "alice", name)
+
+	for _, annotation := range []string{"query_create_users", "query_insert_user", "query_list_users", "query_get_user"} {
+		testutil.RequireSamplesCountInHistogram(t, getHistogram(annotation), 1)
+		_, found := logRecorder.FindEntryByFilter(func(entry logtest.RecordedEntry) bool {
+			field, ok := entry.FindField("annotation")
+			return entry.Text == "slow SQL query" && ok && string(field.Bytes) == annotation
+		})
+		require.True(t, found, "slow query should be logged for %s", annotation)
+	}
+
+	_, err = q.Exec(ctx, "query_insert_into_unknown", "INSERT INTO unknown (name) VALUES (?)", "bob")
+	require.Error(t, err)
+	require.Equal(t, 1.0, promtestutil.ToFloat64(mc.QueryErrors.With(prometheus.Labels{
+		MetricsLabelQuery: "query_insert_into_unknown", MetricsLabelErrorClass: string(QueryErrorClassOther),
+	})))
+
+	// Not annotated queries are executed without instrumentation.
+	logRecorder.Reset()
+	_, err = q.Exec(ctx, "", "DELETE FROM users")
+	require.NoError(t, err)
+	require.Empty(t, logRecorder.Entries())
+}
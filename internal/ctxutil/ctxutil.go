@@ -0,0 +1,46 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package ctxutil contains internal context helpers we apply in go-dbkit.
+package ctxutil
+
+import (
+	"context"
+	"time"
+)
+
+// WithoutCancel returns a context that keeps values of the parent one, but is never canceled
+// and has no deadline. It's a replacement of context.WithoutCancel that is available only since Go 1.21.
+func WithoutCancel(parent context.Context) context.Context {
+	if parent == nil {
+		panic("cannot create context from nil parent")
+	}
+	return withoutCancelCtx{parent}
+}
+
+type withoutCancelCtx struct {
+	parent context.Context
+}
+
+func (withoutCancelCtx) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (withoutCancelCtx) Done() <-chan struct{} {
+	return nil
+}
+
+func (withoutCancelCtx) Err() error {
+	return nil
+}
+
+func (c withoutCancelCtx) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+func (c withoutCancelCtx) String() string {
+	return "ctxutil.WithoutCancel"
+}
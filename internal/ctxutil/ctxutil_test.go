@@ -0,0 +1,35 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package ctxutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type ctxKey struct{}
+
+func TestWithoutCancel(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.WithValue(context.Background(), ctxKey{}, "value"), time.Minute)
+	cancel()
+	require.Error(t, parent.Err())
+
+	ctx := WithoutCancel(parent)
+	require.NoError(t, ctx.Err())
+	require.Nil(t, ctx.Done())
+	_, hasDeadline := ctx.Deadline()
+	require.False(t, hasDeadline)
+	require.Equal(t, "value", ctx.Value(ctxKey{}))
+
+	// Derived contexts work as usual.
+	child, childCancel := context.WithCancel(ctx)
+	childCancel()
+	require.ErrorIs(t, child.Err(), context.Canceled)
+}
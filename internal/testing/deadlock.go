@@ -20,7 +20,7 @@ import (
 
 // DeadlockTest is internal function to simulate DB deadlock
 func DeadlockTest(t *testing.T, dialect dbkit.Dialect, checkDeadlockErr func(err error) bool) {
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*30)
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Minute*2)
 	defer ctxCancel()
 	dbConn, stop := MustRunAndOpenTestDB(ctx, string(dialect))
 	defer func() { require.NoError(t, stop(ctx)) }()
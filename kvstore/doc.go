@@ -0,0 +1,12 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package kvstore provides a simple durable key-value store based on the SQL database table
+// (PostgreSQL, MySQL and SQLite are currently supported).
+// It may be used for storing service settings, feature flags and other small pieces of state
+// without designing a separate table each time. Each entry has a version that is incremented on every change,
+// so concurrent updates may be done safely via optimistic locking (see Store.CompareAndSwap).
+package kvstore
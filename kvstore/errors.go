@@ -0,0 +1,17 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package kvstore
+
+import (
+	"errors"
+)
+
+// Key-value store errors.
+var (
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrVersionMismatch = errors.New("entry version mismatch")
+)
@@ -0,0 +1,241 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package kvstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+// DefaultTableName is a name of the table that is used for storing entries by default.
+const DefaultTableName = "kv_store"
+
+// MaxKeyLength is a maximum length of the key.
+const MaxKeyLength = 255
+
+// CreateTableMigrationID is an ID of the migration returned by Store.Migrations.
+const CreateTableMigrationID = "kvstore_00001_create_table"
+
+// Entry represents a key-value entry stored in the database.
+// Version is 1 for the newly created entry and it's incremented on every change.
+type Entry struct {
+	Key     string
+	Value   []byte
+	Version int64
+}
+
+// StoreOpts represents an options for Store.
+type StoreOpts struct {
+	// TableName is a name of the table where entries are stored. DefaultTableName is used by default.
+	TableName string
+}
+
+// Store provides access to the key-value entries stored in the SQL database.
+// All methods accept executor (*sql.DB, *sql.Tx or *sql.Conn), so they may be a part of the bigger transaction.
+type Store struct {
+	queries dbQueries
+}
+
+// NewStore creates a new Store for the specified dialect.
+func NewStore(dialect dbkit.Dialect) (*Store, error) {
+	return NewStoreWithOpts(dialect, StoreOpts{})
+}
+
+// NewStoreWithOpts is a more configurable version of the NewStore.
+func NewStoreWithOpts(dialect dbkit.Dialect, opts StoreOpts) (*Store, error) {
+	if opts.TableName == "" {
+		opts.TableName = DefaultTableName
+	}
+	q, err := newDBQueries(dialect, opts.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{queries: q}, nil
+}
+
+// Migrations returns set of migrations that must be applied before using the store.
+func (s *Store) Migrations() []migrate.Migration {
+	return []migrate.Migration{
+		migrate.NewCustomMigration(CreateTableMigrationID, []string{s.queries.createTable}, []string{s.queries.dropTable}, nil, nil),
+	}
+}
+
+// Get returns entry for the key. ErrKeyNotFound error is returned if there is no such entry.
+func (s *Store) Get(ctx context.Context, querier SQLQuerier, key string) (Entry, error) {
+	entry := Entry{Key: key}
+	err := querier.QueryRowContext(ctx, s.queries.get, key).Scan(&entry.Value, &entry.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Entry{}, ErrKeyNotFound
+		}
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// Set creates or unconditionally updates entry for the key.
+func (s *Store) Set(ctx context.Context, executor SQLExecutor, key string, value []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	_, err := executor.ExecContext(ctx, s.queries.upsert, key, nonNilValue(value))
+	return err
+}
+
+// Delete deletes entry for the key. ErrKeyNotFound error is returned if there is no such entry.
+func (s *Store) Delete(ctx context.Context, executor SQLExecutor, key string) error {
+	return execQueryAndCheck(ctx, executor, s.queries.delete, []interface{}{key}, ErrKeyNotFound)
+}
+
+// CompareAndSwap updates entry for the key only if its current version is equal to the expected one.
+// If expectedVersion is 0, entry is created only if it doesn't exist yet.
+// ErrVersionMismatch error is returned if the entry was changed (or created) concurrently.
+// Usually, the expected version is obtained via Get, so the read-modify-write cycle may be done without locking.
+func (s *Store) CompareAndSwap(ctx context.Context, executor SQLExecutor, key string, value []byte, expectedVersion int64) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if expectedVersion == 0 {
+		return execQueryAndCheck(ctx, executor, s.queries.insertIfAbsent, []interface{}{key, nonNilValue(value)}, ErrVersionMismatch)
+	}
+	return execQueryAndCheck(ctx, executor, s.queries.updateIfVersion,
+		[]interface{}{nonNilValue(value), key, expectedVersion}, ErrVersionMismatch)
+}
+
+// SQLExecutor is an interface for executing SQL statements (*sql.DB, *sql.Tx and *sql.Conn implement it).
+type SQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLQuerier is an interface for querying a single row (*sql.DB, *sql.Tx and *sql.Conn implement it).
+type SQLQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("key length should be less or equal %d", MaxKeyLength)
+	}
+	return nil
+}
+
+// nonNilValue prevents storing NULL, since value column is NOT NULL.
+func nonNilValue(value []byte) []byte {
+	if value == nil {
+		return []byte{}
+	}
+	return value
+}
+
+func execQueryAndCheck(
+	ctx context.Context, executor SQLExecutor, query string, args []interface{}, errOnNoAffectedRows error,
+) error {
+	result, err := executor.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errOnNoAffectedRows
+	}
+	return nil
+}
+
+type dbQueries struct {
+	createTable     string
+	dropTable       string
+	get             string
+	upsert          string
+	insertIfAbsent  string
+	updateIfVersion string
+	delete          string
+}
+
+func newDBQueries(dialect dbkit.Dialect, tableName string) (dbQueries, error) {
+	if err := dbkit.ValidateIdentifier(dialect, tableName); err != nil {
+		return dbQueries{}, fmt.Errorf("table name: %w", err)
+	}
+	table := dbkit.QuoteIdentifier(dialect, tableName)
+	switch dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		return dbQueries{
+			createTable:     fmt.Sprintf(postgresCreateTableQuery, table),
+			dropTable:       fmt.Sprintf(dropTableQuery, table),
+			get:             fmt.Sprintf(postgresGetQuery, table),
+			upsert:          fmt.Sprintf(postgresUpsertQuery, table),
+			insertIfAbsent:  fmt.Sprintf(postgresInsertIfAbsentQuery, table),
+			updateIfVersion: fmt.Sprintf(postgresUpdateIfVersionQuery, table),
+			delete:          fmt.Sprintf(postgresDeleteQuery, table),
+		}, nil
+	case dbkit.DialectMySQL:
+		return dbQueries{
+			createTable:     fmt.Sprintf(mySQLCreateTableQuery, table),
+			dropTable:       fmt.Sprintf(dropTableQuery, table),
+			get:             fmt.Sprintf(getQuery, table),
+			upsert:          fmt.Sprintf(mySQLUpsertQuery, table),
+			insertIfAbsent:  fmt.Sprintf(mySQLInsertIfAbsentQuery, table),
+			updateIfVersion: fmt.Sprintf(updateIfVersionQuery, table),
+			delete:          fmt.Sprintf(deleteQuery, table),
+		}, nil
+	case dbkit.DialectSQLite:
+		return dbQueries{
+			createTable:     fmt.Sprintf(sqliteCreateTableQuery, table),
+			dropTable:       fmt.Sprintf(dropTableQuery, table),
+			get:             fmt.Sprintf(getQuery, table),
+			upsert:          fmt.Sprintf(sqliteUpsertQuery, table),
+			insertIfAbsent:  fmt.Sprintf(sqliteInsertIfAbsentQuery, table),
+			updateIfVersion: fmt.Sprintf(updateIfVersionQuery, table),
+			delete:          fmt.Sprintf(deleteQuery, table),
+		}, nil
+	default:
+		return dbQueries{}, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+}
+
+const dropTableQuery = `DROP TABLE IF EXISTS %s;`
+
+// Queries with "?" placeholders (MySQL and SQLite).
+const (
+	getQuery             = `SELECT kv_value, version FROM %s WHERE kv_key = ?;`
+	updateIfVersionQuery = `UPDATE %s SET kv_value = ?, version = version + 1 WHERE kv_key = ? AND version = ?;`
+	deleteQuery          = `DELETE FROM %s WHERE kv_key = ?;`
+)
+
+//nolint:lll
+const (
+	postgresCreateTableQuery     = `CREATE TABLE %s (kv_key varchar(255) PRIMARY KEY, kv_value bytea NOT NULL, version bigint NOT NULL);`
+	postgresGetQuery             = `SELECT kv_value, version FROM %s WHERE kv_key = $1;`
+	postgresUpsertQuery          = `INSERT INTO %[1]s AS t (kv_key, kv_value, version) VALUES ($1, $2, 1) ON CONFLICT (kv_key) DO UPDATE SET kv_value = excluded.kv_value, version = t.version + 1;`
+	postgresInsertIfAbsentQuery  = `INSERT INTO %s (kv_key, kv_value, version) VALUES ($1, $2, 1) ON CONFLICT (kv_key) DO NOTHING;`
+	postgresUpdateIfVersionQuery = `UPDATE %s SET kv_value = $1, version = version + 1 WHERE kv_key = $2 AND version = $3;`
+	postgresDeleteQuery          = `DELETE FROM %s WHERE kv_key = $1;`
+)
+
+//nolint:lll
+const (
+	mySQLCreateTableQuery    = `CREATE TABLE %s (kv_key VARCHAR(255) PRIMARY KEY, kv_value LONGBLOB NOT NULL, version BIGINT NOT NULL);`
+	mySQLUpsertQuery         = `INSERT INTO %s (kv_key, kv_value, version) VALUES (?, ?, 1) ON DUPLICATE KEY UPDATE kv_value = VALUES(kv_value), version = version + 1;`
+	mySQLInsertIfAbsentQuery = `INSERT IGNORE INTO %s (kv_key, kv_value, version) VALUES (?, ?, 1);`
+)
+
+//nolint:lll
+const (
+	sqliteCreateTableQuery    = `CREATE TABLE %s (kv_key VARCHAR(255) PRIMARY KEY, kv_value BLOB NOT NULL, version INTEGER NOT NULL);`
+	sqliteUpsertQuery         = `INSERT INTO %[1]s (kv_key, kv_value, version) VALUES (?, ?, 1) ON CONFLICT (kv_key) DO UPDATE SET kv_value = excluded.kv_value, version = %[1]s.version + 1;`
+	sqliteInsertIfAbsentQuery = `INSERT INTO %s (kv_key, kv_value, version) VALUES (?, ?, 1) ON CONFLICT (kv_key) DO NOTHING;`
+)
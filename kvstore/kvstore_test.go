@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package kvstore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "kvstore.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	store, err := NewStore(dbkit.DialectSQLite)
+	require.NoError(t, err)
+	migMngr, err := migrate.NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(store.Migrations(), migrate.MigrationsDirectionUp))
+
+	t.Run("set, get and delete", func(t *testing.T) {
+		_, err = store.Get(ctx, dbConn, "feature.enabled")
+		require.ErrorIs(t, err, ErrKeyNotFound)
+
+		require.NoError(t, store.Set(ctx, dbConn, "feature.enabled", []byte("true")))
+		entry, err := store.Get(ctx, dbConn, "feature.enabled")
+		require.NoError(t, err)
+		require.Equal(t, Entry{Key: "feature.enabled", Value: []byte("true"), Version: 1}, entry)
+
+		require.NoError(t, store.Set(ctx, dbConn, "feature.enabled", []byte("false")))
+		entry, err = store.Get(ctx, dbConn, "feature.enabled")
+		require.NoError(t, err)
+		require.Equal(t, Entry{Key: "feature.enabled", Value: []byte("false"), Version: 2}, entry)
+
+		require.NoError(t, store.Delete(ctx, dbConn, "feature.enabled"))
+		require.ErrorIs(t, store.Delete(ctx, dbConn, "feature.enabled"), ErrKeyNotFound)
+		_, err = store.Get(ctx, dbConn, "feature.enabled")
+		require.ErrorIs(t, err, ErrKeyNotFound)
+	})
+
+	t.Run("compare and swap", func(t *testing.T) {
+		require.NoError(t, store.CompareAndSwap(ctx, dbConn, "counter", []byte("1"), 0))
+		require.ErrorIs(t, store.CompareAndSwap(ctx, dbConn, "counter", []byte("1"), 0), ErrVersionMismatch)
+
+		entry, err := store.Get(ctx, dbConn, "counter")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), entry.Version)
+
+		require.NoError(t, store.CompareAndSwap(ctx, dbConn, "counter", []byte("2"), entry.Version))
+		require.ErrorIs(t, store.CompareAndSwap(ctx, dbConn, "counter", []byte("3"), entry.Version), ErrVersionMismatch)
+		require.ErrorIs(t, store.CompareAndSwap(ctx, dbConn, "unknown", []byte("1"), 1), ErrVersionMismatch)
+
+		entry, err = store.Get(ctx, dbConn, "counter")
+		require.NoError(t, err)
+		require.Equal(t, Entry{Key: "counter", Value: []byte("2"), Version: 2}, entry)
+	})
+
+	t.Run("nil value", func(t *testing.T) {
+		require.NoError(t, store.Set(ctx, dbConn, "empty", nil))
+		entry, err := store.Get(ctx, dbConn, "empty")
+		require.NoError(t, err)
+		require.Empty(t, entry.Value)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		require.EqualError(t, store.Set(ctx, dbConn, "", []byte("v")), "key cannot be empty")
+		require.EqualError(t, store.CompareAndSwap(ctx, dbConn, strings.Repeat("k", MaxKeyLength+1), []byte("v"), 0),
+			"key length should be less or equal 255")
+	})
+
+	require.NoError(t, migMngr.Run(store.Migrations(), migrate.MigrationsDirectionDown))
+}
+
+func TestNewStore(t *testing.T) {
+	for _, dialect := range []dbkit.Dialect{dbkit.DialectPostgres, dbkit.DialectPgx, dbkit.DialectMySQL, dbkit.DialectSQLite} {
+		_, err := NewStore(dialect)
+		require.NoError(t, err, dialect)
+	}
+	_, err := NewStore(dbkit.DialectMSSQL)
+	require.EqualError(t, err, `unsupported sql dialect "mssql"`)
+	_, err = NewStoreWithOpts(dbkit.DialectPostgres, StoreOpts{TableName: "settings\x00"})
+	require.ErrorIs(t, err, dbkit.ErrInvalidIdentifier)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+)
+
+// Kinds of resources tracked by LeakDetector.
+const (
+	LeakedResourceRows = "rows"
+	LeakedResourceStmt = "stmt"
+)
+
+// LeakedResource represents rows or prepared statement that was opened but not closed yet.
+type LeakedResource struct {
+	Kind     string
+	Query    string
+	OpenedAt time.Time
+	Stack    string
+}
+
+// LeakDetector tracks *sql.Rows and *sql.Stmt objects (at the driver level) and reports ones that were never closed.
+// It's intended to be used in development and tests only since capturing of stack traces is not cheap.
+// Leaks are reported when the *sql.DB opened by LeakDetector.Open is closed,
+// or on demand via Report and ReportIfPoolExhausted methods.
+type LeakDetector struct {
+	logger log.FieldLogger
+	mu     sync.Mutex
+	nextID uint64
+	opened map[uint64]LeakedResource
+}
+
+// NewLeakDetector creates a new LeakDetector.
+func NewLeakDetector(logger log.FieldLogger) *LeakDetector {
+	if logger == nil {
+		logger = log.NewDisabledLogger()
+	}
+	return &LeakDetector{logger: logger, opened: make(map[uint64]LeakedResource)}
+}
+
+// Open opens database with leak detection using specified driver name and DSN.
+func (d *LeakDetector) Open(driverName, dsn string) (*sql.DB, error) {
+	connector, err := NewConnector(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(d.WrapConnector(connector)), nil
+}
+
+// WrapConnector wraps driver.Connector for tracking rows and prepared statements.
+// Leaks are reported when *sql.DB opened with this connector is closed.
+func (d *LeakDetector) WrapConnector(connector driver.Connector) driver.Connector {
+	return wrapConnector(connector, driverHooks{
+		stmtOpened: func(query string) func() {
+			id := d.track(LeakedResourceStmt, query)
+			return func() { d.untrack(id) }
+		},
+		rowsOpened: func(_ context.Context, query string) func(int) {
+			id := d.track(LeakedResourceRows, query)
+			return func(int) { d.untrack(id) }
+		},
+		connectorClosed: func() { d.Report() },
+	})
+}
+
+// Leaks returns all tracked resources that are not closed yet, ordered by opening time.
+func (d *LeakDetector) Leaks() []LeakedResource {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	leaks := make([]LeakedResource, 0, len(d.opened))
+	for _, res := range d.opened {
+		leaks = append(leaks, res)
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].OpenedAt.Before(leaks[j].OpenedAt)
+	})
+	return leaks
+}
+
+// Report logs all tracked resources that are not closed yet and returns their number.
+func (d *LeakDetector) Report() int {
+	leaks := d.Leaks()
+	for _, leak := range leaks {
+		d.logger.Warn("leaked SQL "+leak.Kind+" (never closed)",
+			log.String("query", leak.Query),
+			log.Int64("opened_ms_ago", time.Since(leak.OpenedAt).Milliseconds()),
+			log.String("stack", leak.Stack),
+		)
+	}
+	return len(leaks)
+}
+
+// ReportIfPoolExhausted reports not closed resources if all connections of the pool are in use.
+func (d *LeakDetector) ReportIfPoolExhausted(db *sql.DB) int {
+	stats := db.Stats()
+	if stats.MaxOpenConnections == 0 || stats.InUse < stats.MaxOpenConnections {
+		return 0
+	}
+	return d.Report()
+}
+
+func (d *LeakDetector) track(kind, query string) uint64 {
+	pcs := make([]uintptr, txWatchdogMaxStackDepth)
+	pcs = pcs[:runtime.Callers(3, pcs)]
+	res := LeakedResource{Kind: kind, Query: query, OpenedAt: time.Now(), Stack: formatStack(pcs)}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	d.opened[d.nextID] = res
+	return d.nextID
+}
+
+func (d *LeakDetector) untrack(id uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.opened, id)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeakDetector(t *testing.T) {
+	const dsn = "leak_detector_test"
+	mockDB, mock, err := sqlmock.NewWithDSN(dsn)
+	require.NoError(t, err)
+	defer func() {
+		mock.ExpectClose()
+		requireNoErrOnClose(t, mockDB)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	logRecorder := logtest.NewRecorder()
+	detector := NewLeakDetector(logRecorder)
+	db, err := detector.Open("sqlmock", dsn)
+	require.NoError(t, err)
+
+	// Closed rows are not reported.
+	mock.ExpectQuery("SELECT id FROM users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	rows, err := db.QueryContext(context.Background(), "SELECT id FROM users")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(detector.Leaks()))
+	require.NoError(t, rows.Close())
+	require.Equal(t, 0, len(detector.Leaks()))
+
+	// Closed statement is not reported.
+	mock.ExpectPrepare("DELETE FROM users").WillBeClosed()
+	stmt, err := db.PrepareContext(context.Background(), "DELETE FROM users")
+	require.NoError(t, err)
+	require.NoError(t, stmt.Close())
+	require.Equal(t, 0, len(detector.Leaks()))
+
+	// Leaked rows are reported with creation stack.
+	mock.ExpectQuery("SELECT name FROM users").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Bob"))
+	_, err = db.QueryContext(context.Background(), "SELECT name FROM users") // nolint: rowserrcheck,sqlclosecheck
+	require.NoError(t, err)
+	leaks := detector.Leaks()
+	require.Equal(t, 1, len(leaks))
+	require.Equal(t, LeakedResourceRows, leaks[0].Kind)
+	require.Equal(t, "SELECT name FROM users", leaks[0].Query)
+	require.Contains(t, leaks[0].Stack, "TestLeakDetector")
+
+	// Only one connection is allowed, and it's held by the leaked rows.
+	db.SetMaxOpenConns(1)
+	require.Equal(t, 1, detector.ReportIfPoolExhausted(db))
+	require.Equal(t, 1, len(logRecorder.Entries()))
+
+	// Leaks are reported at Close time.
+	require.NoError(t, db.Close())
+	require.Equal(t, 2, len(logRecorder.Entries()))
+	logEntry := logRecorder.Entries()[1]
+	require.Equal(t, "leaked SQL rows (never closed)", logEntry.Text)
+	queryField, found := logEntry.FindField("query")
+	require.True(t, found)
+	require.Equal(t, "SELECT name FROM users", string(queryField.Bytes))
+}
@@ -6,16 +6,78 @@ Released under MIT license.
 
 package dbkit
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 // Prometheus labels.
 const (
-	MetricsLabelQuery = "query"
+	MetricsLabelQuery      = "query"
+	MetricsLabelErrorClass = "error_class"
+	MetricsLabelDialect    = "db_dialect"
+	MetricsLabelDatabase   = "db_name"
+	MetricsLabelQueryGroup = "query_group"
+	MetricsLabelTxOutcome  = "outcome"
 )
 
+// Values of the MetricsLabelTxOutcome label.
+const (
+	MetricsTxOutcomeCommitted = "committed"
+	MetricsTxOutcomeFailed    = "failed"
+)
+
+// MetricsQueryGroupDefault is a value of the MetricsLabelQueryGroup label for the queries
+// that don't match any of MetricsCollectorOpts.QueryDurationBucketsOverrides.
+const MetricsQueryGroupDefault = "default"
+
 // DefaultQueryDurationBuckets is default buckets into which observations of executing SQL queries are counted.
 var DefaultQueryDurationBuckets = []float64{0.001, 0.01, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
 
+// DefaultTxAttemptsBuckets is default buckets into which numbers of attempts of the retryable transactions are counted.
+var DefaultTxAttemptsBuckets = []float64{1, 2, 3, 5, 10}
+
+// DefaultQueryRowsBuckets is default buckets into which numbers of rows returned or affected by SQL queries are counted.
+var DefaultQueryRowsBuckets = []float64{0, 1, 10, 100, 1000, 10000, 100000}
+
+// QueryMetrics is an interface for collecting metrics of the annotated SQL queries.
+// It's implemented by MetricsCollector (Prometheus) and by otelmetrics.MetricsCollector (OpenTelemetry),
+// so helpers for query builders (see dbrutil and goquutil packages) may work with either backend.
+type QueryMetrics interface {
+	ObserveQueryDuration(ctx context.Context, annotation string, duration time.Duration)
+	ObserveQueryError(annotation string, err error)
+}
+
+// QueryRowsMetrics is an interface for collecting numbers of rows returned and affected by the annotated SQL queries.
+type QueryRowsMetrics interface {
+	ObserveQueryRowsReturned(annotation string, rows int)
+	ObserveQueryRowsAffected(annotation string, rows int64)
+}
+
+// QueryRetryMetrics is an interface for counting retry attempts of the annotated SQL queries (see StatementRetryOpts).
+type QueryRetryMetrics interface {
+	ObserveQueryRetry(annotation string)
+}
+
+// LongTransactionMetrics is an interface for counting the annotated SQL transactions
+// that stayed open longer than a threshold (see TxWatchdogOpts).
+type LongTransactionMetrics interface {
+	ObserveLongTransaction(annotation string)
+}
+
+// TxAttemptsMetrics is an interface for observing numbers of attempts that the annotated retryable transactions needed
+// (see dbrutil.RetryableTxSession).
+type TxAttemptsMetrics interface {
+	ObserveTxAttempts(annotation string, attempts int, err error)
+}
+
 // MetricsCollectorOpts represents an options for MetricsCollector.
 type MetricsCollectorOpts struct {
 	// Namespace is a namespace for metrics. It will be prepended to all metric names.
@@ -24,22 +86,82 @@ type MetricsCollectorOpts struct {
 	// QueryDurationBuckets is a list of buckets into which observations of executing SQL queries are counted.
 	QueryDurationBuckets []float64
 
+	// QueryDurationBucketsOverrides allows using specific buckets for the queries with the annotations
+	// that start with the specified prefixes (e.g. sub-millisecond lookups or long-running reports).
+	// The first matching override is used, other queries are counted into QueryDurationBuckets.
+	// If overrides are specified, query duration histograms have an additional MetricsLabelQueryGroup const label
+	// with the matched prefix (or MetricsQueryGroupDefault) as a value, so they may be registered together.
+	QueryDurationBucketsOverrides []QueryDurationBucketsOverride
+
 	// ConstLabels is a set of labels that will be applied to all metrics.
 	ConstLabels prometheus.Labels
 
+	// DBConfig (if set) is used for adding dialect and database name labels (see MetricsConstLabels) to all metrics,
+	// so label naming is consistent across services. Labels specified in ConstLabels take precedence.
+	DBConfig *Config
+
 	// CurryingLabelNames is a list of label names that will be curried with the provided labels.
 	// See MetricsCollector.MustCurryWith method for more details.
 	// Keep in mind that if this list is not empty,
 	// MetricsCollector.MustCurryWith method must be called further with the same labels.
 	// Otherwise, the collector will panic.
 	CurriedLabelNames []string
+
+	// ExemplarLabels returns labels of the exemplar that is attached to the query duration observation.
+	// Exemplar is not attached if nil, empty or invalid (e.g. longer than prometheus.ExemplarMaxRunes in total)
+	// labels are returned. Use otelmetrics.TraceExemplarLabels to link observations to the active OpenTelemetry traces.
+	ExemplarLabels func(ctx context.Context) prometheus.Labels
+
+	// DisableExemplars disables attaching exemplars to the query duration observations.
+	DisableExemplars bool
+
+	// EnableRowsMetrics enables histograms of rows returned and rows affected by the annotated SQL queries.
+	// These metrics are collected at the driver level,
+	// so *sql.DB should be opened with the connector wrapped by MetricsCollector.WrapConnectorForRowsMetrics.
+	EnableRowsMetrics bool
+
+	// QueryRowsBuckets is a list of buckets into which numbers of rows returned or affected by SQL queries are counted.
+	QueryRowsBuckets []float64
+}
+
+// QueryDurationBucketsOverride represents buckets for the queries with the annotations that start with the specified prefix.
+type QueryDurationBucketsOverride struct {
+	AnnotationPrefix string
+	Buckets          []float64
 }
 
 // MetricsCollector represents collector of metrics.
 type MetricsCollector struct {
-	QueryDurations *prometheus.HistogramVec
+	QueryDurations   *prometheus.HistogramVec
+	QueryRetries     *prometheus.CounterVec
+	LongTransactions *prometheus.CounterVec
+	QueryErrors      *prometheus.CounterVec
+
+	// TxAttempts is a histogram of numbers of attempts that retryable transactions needed
+	// (see dbrutil.RetryableTxSession), labeled by transaction annotation and outcome.
+	TxAttempts *prometheus.HistogramVec
+
+	// QueryRowsReturned and QueryRowsAffected are nil unless MetricsCollectorOpts.EnableRowsMetrics is set.
+	QueryRowsReturned *prometheus.HistogramVec
+	QueryRowsAffected *prometheus.HistogramVec
+
+	queryDurationsOverrides []queryDurationsOverride
+	exemplarLabels          func(ctx context.Context) prometheus.Labels
 }
 
+type queryDurationsOverride struct {
+	annotationPrefix string
+	queryDurations   *prometheus.HistogramVec
+}
+
+var (
+	_ QueryMetrics           = (*MetricsCollector)(nil)
+	_ QueryRowsMetrics       = (*MetricsCollector)(nil)
+	_ QueryRetryMetrics      = (*MetricsCollector)(nil)
+	_ LongTransactionMetrics = (*MetricsCollector)(nil)
+	_ TxAttemptsMetrics      = (*MetricsCollector)(nil)
+)
+
 // NewMetricsCollector creates a new metrics collector.
 func NewMetricsCollector() *MetricsCollector {
 	return NewMetricsCollectorWithOpts(MetricsCollectorOpts{})
@@ -51,44 +173,331 @@ func NewMetricsCollectorWithOpts(opts MetricsCollectorOpts) *MetricsCollector {
 	if queryDurationBuckets == nil {
 		queryDurationBuckets = DefaultQueryDurationBuckets
 	}
+	if opts.DBConfig != nil {
+		constLabels := MetricsConstLabels(opts.DBConfig)
+		for name, value := range opts.ConstLabels {
+			constLabels[name] = value
+		}
+		opts.ConstLabels = constLabels
+	}
 	labelNames := append(make([]string, 0, len(opts.CurriedLabelNames)+1), opts.CurriedLabelNames...)
 	labelNames = append(labelNames, MetricsLabelQuery)
-	queryDurations := prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
+	makeQueryDurations := func(buckets []float64, queryGroup string) *prometheus.HistogramVec {
+		constLabels := opts.ConstLabels
+		if len(opts.QueryDurationBucketsOverrides) != 0 {
+			constLabels = make(prometheus.Labels, len(opts.ConstLabels)+1)
+			for name, value := range opts.ConstLabels {
+				constLabels[name] = value
+			}
+			constLabels[MetricsLabelQueryGroup] = queryGroup
+		}
+		return prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Name:        "db_query_duration_seconds",
+				Help:        "A histogram of the SQL query durations.",
+				Buckets:     buckets,
+				ConstLabels: constLabels,
+			},
+			labelNames,
+		)
+	}
+	queryDurations := makeQueryDurations(queryDurationBuckets, MetricsQueryGroupDefault)
+	queryDurationsOverrides := make([]queryDurationsOverride, 0, len(opts.QueryDurationBucketsOverrides))
+	for _, override := range opts.QueryDurationBucketsOverrides {
+		queryDurationsOverrides = append(queryDurationsOverrides, queryDurationsOverride{
+			annotationPrefix: override.AnnotationPrefix,
+			queryDurations:   makeQueryDurations(override.Buckets, override.AnnotationPrefix),
+		})
+	}
+	queryRetries := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Namespace:   opts.Namespace,
-			Name:        "db_query_duration_seconds",
-			Help:        "A histogram of the SQL query durations.",
-			Buckets:     queryDurationBuckets,
+			Name:        "db_query_retries_total",
+			Help:        "A counter of the SQL query retry attempts.",
 			ConstLabels: opts.ConstLabels,
 		},
 		labelNames,
 	)
+	longTransactions := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "db_long_transactions_total",
+			Help:        "A counter of the SQL transactions that stayed open longer than a threshold.",
+			ConstLabels: opts.ConstLabels,
+		},
+		labelNames,
+	)
+	queryErrors := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "db_query_errors_total",
+			Help:        "A counter of the SQL query errors by error class.",
+			ConstLabels: opts.ConstLabels,
+		},
+		append(append(make([]string, 0, len(labelNames)+1), labelNames...), MetricsLabelErrorClass),
+	)
+
+	txAttempts := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "db_tx_attempts",
+			Help:        "A histogram of the numbers of attempts that retryable SQL transactions needed.",
+			Buckets:     DefaultTxAttemptsBuckets,
+			ConstLabels: opts.ConstLabels,
+		},
+		append(append(make([]string, 0, len(labelNames)+1), labelNames...), MetricsLabelTxOutcome),
+	)
+
+	var queryRowsReturned, queryRowsAffected *prometheus.HistogramVec
+	if opts.EnableRowsMetrics {
+		queryRowsBuckets := opts.QueryRowsBuckets
+		if queryRowsBuckets == nil {
+			queryRowsBuckets = DefaultQueryRowsBuckets
+		}
+		queryRowsReturned = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Name:        "db_query_rows_returned",
+				Help:        "A histogram of the numbers of rows returned by the SQL queries.",
+				Buckets:     queryRowsBuckets,
+				ConstLabels: opts.ConstLabels,
+			},
+			labelNames,
+		)
+		queryRowsAffected = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Name:        "db_query_rows_affected",
+				Help:        "A histogram of the numbers of rows affected by the SQL queries.",
+				Buckets:     queryRowsBuckets,
+				ConstLabels: opts.ConstLabels,
+			},
+			labelNames,
+		)
+	}
+
+	exemplarLabels := opts.ExemplarLabels
+	if opts.DisableExemplars {
+		exemplarLabels = nil
+	}
 
 	return &MetricsCollector{
-		QueryDurations: queryDurations,
+		QueryDurations:          queryDurations,
+		QueryRetries:            queryRetries,
+		LongTransactions:        longTransactions,
+		QueryErrors:             queryErrors,
+		TxAttempts:              txAttempts,
+		QueryRowsReturned:       queryRowsReturned,
+		QueryRowsAffected:       queryRowsAffected,
+		queryDurationsOverrides: queryDurationsOverrides,
+		exemplarLabels:          exemplarLabels,
 	}
 }
 
+// MetricsConstLabels returns labels with dialect and database name from the passed config.
+// Database name label is omitted if it's not specified in the config.
+func MetricsConstLabels(cfg *Config) prometheus.Labels {
+	labels := prometheus.Labels{MetricsLabelDialect: string(cfg.Dialect)}
+	if dbName := cfg.DatabaseName(); dbName != "" {
+		labels[MetricsLabelDatabase] = dbName
+	}
+	return labels
+}
+
 // MustCurryWith curries the metrics collector with the provided labels.
 func (c *MetricsCollector) MustCurryWith(labels prometheus.Labels) *MetricsCollector {
-	return &MetricsCollector{
-		QueryDurations: c.QueryDurations.MustCurryWith(labels).(*prometheus.HistogramVec),
+	curried := &MetricsCollector{
+		QueryDurations:   c.QueryDurations.MustCurryWith(labels).(*prometheus.HistogramVec),
+		QueryRetries:     c.QueryRetries.MustCurryWith(labels),
+		LongTransactions: c.LongTransactions.MustCurryWith(labels),
+		QueryErrors:      c.QueryErrors.MustCurryWith(labels),
+		TxAttempts:       c.TxAttempts.MustCurryWith(labels).(*prometheus.HistogramVec),
+		exemplarLabels:   c.exemplarLabels,
+	}
+	if c.QueryRowsReturned != nil {
+		curried.QueryRowsReturned = c.QueryRowsReturned.MustCurryWith(labels).(*prometheus.HistogramVec)
+	}
+	if c.QueryRowsAffected != nil {
+		curried.QueryRowsAffected = c.QueryRowsAffected.MustCurryWith(labels).(*prometheus.HistogramVec)
+	}
+	curried.queryDurationsOverrides = make([]queryDurationsOverride, 0, len(c.queryDurationsOverrides))
+	for _, override := range c.queryDurationsOverrides {
+		curried.queryDurationsOverrides = append(curried.queryDurationsOverrides, queryDurationsOverride{
+			annotationPrefix: override.annotationPrefix,
+			queryDurations:   override.queryDurations.MustCurryWith(labels).(*prometheus.HistogramVec),
+		})
+	}
+	return curried
+}
+
+// QueryDurationsFor returns query duration histogram into which observations of the annotated SQL query are counted
+// (see MetricsCollectorOpts.QueryDurationBucketsOverrides).
+func (c *MetricsCollector) QueryDurationsFor(annotation string) *prometheus.HistogramVec {
+	for _, override := range c.queryDurationsOverrides {
+		if strings.HasPrefix(annotation, override.annotationPrefix) {
+			return override.queryDurations
+		}
 	}
+	return c.QueryDurations
+}
+
+// ObserveQueryDuration observes duration of the annotated SQL query.
+// If MetricsCollectorOpts.ExemplarLabels returns valid labels for the passed context,
+// the observation is recorded with an exemplar, so slow queries may be linked to the traces.
+func (c *MetricsCollector) ObserveQueryDuration(ctx context.Context, annotation string, duration time.Duration) {
+	observer := c.QueryDurationsFor(annotation).With(prometheus.Labels{MetricsLabelQuery: annotation})
+	if ctx != nil && c.exemplarLabels != nil {
+		if exemplarLabels := c.exemplarLabels(ctx); len(exemplarLabels) != 0 && isValidExemplarLabels(exemplarLabels) {
+			if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+				exemplarObserver.ObserveWithExemplar(duration.Seconds(), exemplarLabels)
+				return
+			}
+		}
+	}
+	observer.Observe(duration.Seconds())
+}
+
+// ObserveTxAttempts observes number of attempts that the annotated retryable transaction needed.
+// Outcome (see MetricsLabelTxOutcome) is determined by the error of the last attempt.
+func (c *MetricsCollector) ObserveTxAttempts(annotation string, attempts int, err error) {
+	outcome := MetricsTxOutcomeCommitted
+	if err != nil {
+		outcome = MetricsTxOutcomeFailed
+	}
+	c.TxAttempts.With(prometheus.Labels{MetricsLabelQuery: annotation, MetricsLabelTxOutcome: outcome}).Observe(float64(attempts))
+}
+
+// ObserveQueryRetry counts retry attempt of the annotated SQL query.
+func (c *MetricsCollector) ObserveQueryRetry(annotation string) {
+	c.QueryRetries.With(prometheus.Labels{MetricsLabelQuery: annotation}).Inc()
+}
+
+// ObserveLongTransaction counts the annotated SQL transaction that stayed open longer than a threshold.
+func (c *MetricsCollector) ObserveLongTransaction(annotation string) {
+	c.LongTransactions.With(prometheus.Labels{MetricsLabelQuery: annotation}).Inc()
+}
+
+// ObserveQueryError counts error occurred during executing the annotated SQL query.
+// Error is classified by ClassifyQueryError. Nil error and sql.ErrNoRows are not counted.
+func (c *MetricsCollector) ObserveQueryError(annotation string, err error) {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	c.QueryErrors.With(prometheus.Labels{
+		MetricsLabelQuery:      annotation,
+		MetricsLabelErrorClass: string(ClassifyQueryError(err)),
+	}).Inc()
+}
+
+// ObserveQueryRowsReturned observes number of rows returned by the annotated SQL query.
+// It does nothing if rows metrics are not enabled.
+func (c *MetricsCollector) ObserveQueryRowsReturned(annotation string, rows int) {
+	if c.QueryRowsReturned == nil {
+		return
+	}
+	c.QueryRowsReturned.With(prometheus.Labels{MetricsLabelQuery: annotation}).Observe(float64(rows))
+}
+
+// ObserveQueryRowsAffected observes number of rows affected by the annotated SQL query.
+// It does nothing if rows metrics are not enabled.
+func (c *MetricsCollector) ObserveQueryRowsAffected(annotation string, rows int64) {
+	if c.QueryRowsAffected == nil {
+		return
+	}
+	c.QueryRowsAffected.With(prometheus.Labels{MetricsLabelQuery: annotation}).Observe(float64(rows))
+}
+
+// WrapConnectorForRowsMetrics wraps driver.Connector for collecting numbers of rows
+// returned (counted when rows are closed) and affected by the SQL queries.
+// getAnnotation returns annotation of the query (e.g. ParseAnnotationInQuery may be used),
+// queries with empty annotation are not observed.
+func (c *MetricsCollector) WrapConnectorForRowsMetrics(
+	connector driver.Connector, getAnnotation func(query string) string,
+) driver.Connector {
+	return WrapConnectorForRowsMetrics(connector, c, getAnnotation)
+}
+
+// WrapConnectorForRowsMetrics wraps driver.Connector for collecting numbers of rows
+// returned (counted when rows are closed) and affected by the SQL queries via the passed QueryRowsMetrics.
+// getAnnotation returns annotation of the query, queries with empty annotation are not observed.
+func WrapConnectorForRowsMetrics(
+	connector driver.Connector, metrics QueryRowsMetrics, getAnnotation func(query string) string,
+) driver.Connector {
+	return wrapConnector(connector, driverHooks{
+		rowsOpened: func(_ context.Context, query string) func(int) {
+			annotation := getAnnotation(query)
+			if annotation == "" {
+				return nil
+			}
+			return func(rowsRead int) { metrics.ObserveQueryRowsReturned(annotation, rowsRead) }
+		},
+		execDone: func(_ context.Context, query string, result driver.Result) {
+			annotation := getAnnotation(query)
+			if annotation == "" {
+				return
+			}
+			if rowsAffected, err := result.RowsAffected(); err == nil {
+				metrics.ObserveQueryRowsAffected(annotation, rowsAffected)
+			}
+		},
+	})
+}
+
+// isValidExemplarLabels checks exemplar labels the same way as Prometheus client does,
+// since ObserveWithExemplar panics if they are invalid.
+func isValidExemplarLabels(labels prometheus.Labels) bool {
+	var runes int
+	for name, value := range labels {
+		if !isValidExemplarLabelName(name) || !utf8.ValidString(value) {
+			return false
+		}
+		runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+	}
+	return runes <= prometheus.ExemplarMaxRunes
+}
+
+func isValidExemplarLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, b := range []byte(name) {
+		if !((b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || b == '_' || (b >= '0' && b <= '9' && i > 0)) {
+			return false
+		}
+	}
+	return true
 }
 
 // MustRegister does registration of metrics collector in Prometheus and panics if any error occurs.
 func (c *MetricsCollector) MustRegister() {
-	prometheus.MustRegister(c.QueryDurations)
+	prometheus.MustRegister(c.AllMetrics()...)
 }
 
 // Unregister cancels registration of metrics collector in Prometheus.
 func (c *MetricsCollector) Unregister() {
-	prometheus.Unregister(c.QueryDurations)
+	for _, m := range c.AllMetrics() {
+		prometheus.Unregister(m)
+	}
 }
 
 // AllMetrics returns a list of metrics of this collector. This can be used to register these metrics in push gateway.
 func (c *MetricsCollector) AllMetrics() []prometheus.Collector {
-	return []prometheus.Collector{
+	metrics := []prometheus.Collector{
 		c.QueryDurations,
+		c.QueryRetries,
+		c.LongTransactions,
+		c.QueryErrors,
+		c.TxAttempts,
+	}
+	for _, override := range c.queryDurationsOverrides {
+		metrics = append(metrics, override.queryDurations)
+	}
+	if c.QueryRowsReturned != nil {
+		metrics = append(metrics, c.QueryRowsReturned)
+	}
+	if c.QueryRowsAffected != nil {
+		metrics = append(metrics, c.QueryRowsAffected)
 	}
+	return metrics
 }
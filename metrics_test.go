@@ -0,0 +1,243 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsCollector_ObserveQueryDuration(t *testing.T) {
+	getExemplars := func(t *testing.T, mc *MetricsCollector, annotation string) []*dto.Exemplar {
+		t.Helper()
+		var metric dto.Metric
+		hist := mc.QueryDurations.With(prometheus.Labels{MetricsLabelQuery: annotation}).(prometheus.Histogram)
+		require.NoError(t, hist.Write(&metric))
+		require.EqualValues(t, 1, metric.GetHistogram().GetSampleCount())
+		var exemplars []*dto.Exemplar
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			if bucket.GetExemplar() != nil {
+				exemplars = append(exemplars, bucket.GetExemplar())
+			}
+		}
+		return exemplars
+	}
+	makeExemplarLabels := func(labels prometheus.Labels) func(ctx context.Context) prometheus.Labels {
+		return func(ctx context.Context) prometheus.Labels {
+			return labels
+		}
+	}
+
+	t.Run("no exemplars by default", func(t *testing.T) {
+		mc := NewMetricsCollector()
+		mc.ObserveQueryDuration(context.Background(), "query_select_users", 5*time.Millisecond)
+		require.Empty(t, getExemplars(t, mc, "query_select_users"))
+	})
+
+	t.Run("custom exemplar labels", func(t *testing.T) {
+		mc := NewMetricsCollectorWithOpts(MetricsCollectorOpts{
+			ExemplarLabels: makeExemplarLabels(prometheus.Labels{"request_id": "req-1"}),
+		})
+		mc = mc.MustCurryWith(prometheus.Labels{})
+		mc.ObserveQueryDuration(context.Background(), "query_select_users", 5*time.Millisecond)
+		exemplars := getExemplars(t, mc, "query_select_users")
+		require.Len(t, exemplars, 1)
+		require.Len(t, exemplars[0].GetLabel(), 1)
+		require.Equal(t, "request_id", exemplars[0].GetLabel()[0].GetName())
+		require.Equal(t, "req-1", exemplars[0].GetLabel()[0].GetValue())
+		require.Equal(t, 0.005, exemplars[0].GetValue())
+	})
+
+	t.Run("no exemplar for empty labels", func(t *testing.T) {
+		mc := NewMetricsCollectorWithOpts(MetricsCollectorOpts{ExemplarLabels: makeExemplarLabels(nil)})
+		mc.ObserveQueryDuration(context.Background(), "query_select_users", 5*time.Millisecond)
+		require.Empty(t, getExemplars(t, mc, "query_select_users"))
+	})
+
+	t.Run("exemplars are disabled", func(t *testing.T) {
+		mc := NewMetricsCollectorWithOpts(MetricsCollectorOpts{
+			ExemplarLabels:   makeExemplarLabels(prometheus.Labels{"request_id": "req-1"}),
+			DisableExemplars: true,
+		})
+		mc.ObserveQueryDuration(context.Background(), "query_select_users", 5*time.Millisecond)
+		require.Empty(t, getExemplars(t, mc, "query_select_users"))
+	})
+
+	t.Run("invalid exemplar labels", func(t *testing.T) {
+		for _, labels := range []prometheus.Labels{
+			{"request_id": strings.Repeat("x", prometheus.ExemplarMaxRunes)},
+			{"request-id": "req-1"},
+			{"__request_id": "req-1"},
+			{"1request_id": "req-1"},
+			{"request_id": "\xff"},
+		} {
+			mc := NewMetricsCollectorWithOpts(MetricsCollectorOpts{ExemplarLabels: makeExemplarLabels(labels)})
+			require.NotPanics(t, func() {
+				mc.ObserveQueryDuration(context.Background(), "query_select_users", 5*time.Millisecond)
+			}, "labels: %v", labels)
+			require.Empty(t, getExemplars(t, mc, "query_select_users"), "labels: %v", labels)
+		}
+	})
+}
+
+func TestMetricsCollector_WrapConnectorForRowsMetrics(t *testing.T) {
+	const dsn = "rows_metrics_test"
+	mockDB, mock, err := sqlmock.NewWithDSN(dsn)
+	require.NoError(t, err)
+
+	mc := NewMetricsCollectorWithOpts(MetricsCollectorOpts{EnableRowsMetrics: true})
+	require.Len(t, mc.AllMetrics(), 7)
+	getAnnotation := func(query string) string {
+		if strings.HasPrefix(query, "/* ") {
+			return query[len("/* "):strings.Index(query, " */")]
+		}
+		return ""
+	}
+	connector, err := NewConnector("sqlmock", dsn)
+	require.NoError(t, err)
+	db := sql.OpenDB(mc.WrapConnectorForRowsMetrics(connector, getAnnotation))
+	defer func() {
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		mock.ExpectClose()
+		requireNoErrOnClose(t, mockDB)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	getHistogram := func(hv *prometheus.HistogramVec, annotation string) *dto.Histogram {
+		t.Helper()
+		var metric dto.Metric
+		require.NoError(t, hv.With(prometheus.Labels{MetricsLabelQuery: annotation}).(prometheus.Histogram).Write(&metric))
+		return metric.GetHistogram()
+	}
+
+	mock.ExpectQuery("SELECT id FROM users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+	rows, err := db.QueryContext(context.Background(), "/* select_users */ SELECT id FROM users")
+	require.NoError(t, err)
+	var ids []int
+	for rows.Next() {
+		var id int
+		require.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []int{1, 2, 3}, ids)
+	require.NoError(t, rows.Close())
+	hist := getHistogram(mc.QueryRowsReturned, "select_users")
+	require.EqualValues(t, 1, hist.GetSampleCount())
+	require.EqualValues(t, 3, hist.GetSampleSum())
+
+	mock.ExpectExec("DELETE FROM users").WillReturnResult(sqlmock.NewResult(0, 2))
+	_, err = db.ExecContext(context.Background(), "/* delete_users */ DELETE FROM users")
+	require.NoError(t, err)
+	hist = getHistogram(mc.QueryRowsAffected, "delete_users")
+	require.EqualValues(t, 1, hist.GetSampleCount())
+	require.EqualValues(t, 2, hist.GetSampleSum())
+
+	// Not annotated queries are not observed.
+	mock.ExpectExec("DELETE FROM notes").WillReturnResult(sqlmock.NewResult(0, 5))
+	_, err = db.ExecContext(context.Background(), "DELETE FROM notes")
+	require.NoError(t, err)
+	require.EqualValues(t, 0, getHistogram(mc.QueryRowsAffected, "").GetSampleCount())
+
+	// Rows metrics are disabled by default.
+	mc = NewMetricsCollector()
+	require.Nil(t, mc.QueryRowsReturned)
+	require.Nil(t, mc.QueryRowsAffected)
+	require.Len(t, mc.AllMetrics(), 5)
+	mc.ObserveQueryRowsReturned("select_users", 1)
+	mc.ObserveQueryRowsAffected("delete_users", 1)
+}
+
+func TestNewMetricsCollectorWithOpts_DBConfig(t *testing.T) {
+	getConstLabels := func(t *testing.T, mc *MetricsCollector) map[string]string {
+		t.Helper()
+		var metric dto.Metric
+		require.NoError(t, mc.QueryRetries.With(prometheus.Labels{MetricsLabelQuery: "select_users"}).Write(&metric))
+		labels := make(map[string]string)
+		for _, label := range metric.GetLabel() {
+			if label.GetName() != MetricsLabelQuery {
+				labels[label.GetName()] = label.GetValue()
+			}
+		}
+		return labels
+	}
+
+	cfg := &Config{Dialect: DialectPgx, Postgres: PostgresConfig{Database: "users_db"}}
+	mc := NewMetricsCollectorWithOpts(MetricsCollectorOpts{DBConfig: cfg})
+	require.Equal(t, map[string]string{MetricsLabelDialect: "pgx", MetricsLabelDatabase: "users_db"}, getConstLabels(t, mc))
+
+	// Explicit const labels take precedence.
+	mc = NewMetricsCollectorWithOpts(MetricsCollectorOpts{
+		DBConfig:    cfg,
+		ConstLabels: prometheus.Labels{MetricsLabelDatabase: "users", "service": "users-api"},
+	})
+	require.Equal(t, map[string]string{
+		MetricsLabelDialect: "pgx", MetricsLabelDatabase: "users", "service": "users-api",
+	}, getConstLabels(t, mc))
+
+	// Database name is omitted if it's not configured.
+	require.Equal(t, prometheus.Labels{MetricsLabelDialect: "sqlite3"}, MetricsConstLabels(&Config{Dialect: DialectSQLite}))
+	require.Equal(t, prometheus.Labels{MetricsLabelDialect: "sqlite3", MetricsLabelDatabase: "app.db"},
+		MetricsConstLabels(&Config{Dialect: DialectSQLite, SQLite: SQLiteConfig{Path: "/var/lib/app/app.db"}}))
+}
+
+func TestMetricsCollector_QueryDurationBucketsOverrides(t *testing.T) {
+	mc := NewMetricsCollectorWithOpts(MetricsCollectorOpts{
+		QueryDurationBucketsOverrides: []QueryDurationBucketsOverride{
+			{AnnotationPrefix: "lookup_", Buckets: []float64{0.0001, 0.0005, 0.001}},
+			{AnnotationPrefix: "report_", Buckets: []float64{1, 10, 60}},
+		},
+	})
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(mc.QueryDurations))
+	for _, m := range mc.AllMetrics()[4:] {
+		require.NoError(t, registry.Register(m))
+	}
+
+	mc.ObserveQueryDuration(context.Background(), "lookup_user_by_id", 200*time.Microsecond)
+	mc.ObserveQueryDuration(context.Background(), "report_monthly", 30*time.Second)
+	mc.ObserveQueryDuration(context.Background(), "select_users", 5*time.Millisecond)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	bucketsByQuery := make(map[string][]float64)
+	for _, metric := range families[0].GetMetric() {
+		var query, group string
+		for _, label := range metric.GetLabel() {
+			switch label.GetName() {
+			case MetricsLabelQuery:
+				query = label.GetValue()
+			case MetricsLabelQueryGroup:
+				group = label.GetValue()
+			}
+		}
+		var buckets []float64
+		for _, bucket := range metric.GetHistogram().GetBucket() {
+			buckets = append(buckets, bucket.GetUpperBound())
+		}
+		bucketsByQuery[group+"/"+query] = buckets
+	}
+	require.Equal(t, map[string][]float64{
+		"lookup_/lookup_user_by_id": {0.0001, 0.0005, 0.001},
+		"report_/report_monthly":    {1, 10, 60},
+		"default/select_users":      DefaultQueryDurationBuckets,
+	}, bucketsByQuery)
+
+	require.Same(t, mc.QueryDurations, mc.QueryDurationsFor("select_users"))
+	curried := mc.MustCurryWith(prometheus.Labels{})
+	require.Len(t, curried.AllMetrics(), len(mc.AllMetrics()))
+}
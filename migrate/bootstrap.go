@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-appkit/retry"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// migrationsTableBootstrapRetryPolicy is used for retrying creation of the migrations table
+// when it fails because the table is being created concurrently.
+var migrationsTableBootstrapRetryPolicy retry.Policy = retry.NewConstantBackoffPolicy(100*time.Millisecond, 5)
+
+// EnsureMigrationsTable creates the table for bookkeeping of applied migrations if it doesn't exist yet.
+// Table is created (CREATE TABLE IF NOT EXISTS, with the same schema sql-migrate uses) under the same lock as RunLocked,
+// so parallel cold starts of several service instances don't race.
+// Since even IF NOT EXISTS statement may fail when the table is being created concurrently
+// (e.g. by the instance that runs migrations without lock), such errors are retried.
+// ErrMigrationsLockTimeout error is returned if the lock cannot be acquired within lockTimeout.
+func (mm *MigrationsManager) EnsureMigrationsTable(ctx context.Context, lockTimeout time.Duration) error {
+	release, err := acquireMigrationsLock(ctx, mm.db, mm.Dialect, "dbkit_"+mm.migSet.TableName, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return mm.ensureMigrationsTable(ctx)
+}
+
+func (mm *MigrationsManager) ensureMigrationsTable(ctx context.Context) error {
+	err := retry.DoWithRetry(ctx, migrationsTableBootstrapRetryPolicy, isMigrationsTableCreationRace, nil,
+		func(ctx context.Context) error {
+			// sql-migrate creates the table (if it doesn't exist) before reading records from it.
+			_, err := mm.migSet.GetMigrationRecords(mm.db, string(mm.Dialect))
+			return err
+		})
+	if err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+	return nil
+}
+
+// isMigrationsTableCreationRace checks if the error may occur when the table is being created concurrently.
+// MySQL and MSSQL return "table already exists" error, Postgres may also fail with unique violation
+// in its system catalog (pg_type) for CREATE TABLE IF NOT EXISTS statement.
+func isMigrationsTableCreationRace(err error) bool {
+	switch dbkit.ClassifyQueryError(err) {
+	case dbkit.QueryErrorClassDuplicateTable, dbkit.QueryErrorClassUniqueViolation:
+		return true
+	}
+	return false
+}
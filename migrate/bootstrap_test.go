@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/log/logtest"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/mysql"
+)
+
+func TestMigrationsManager_EnsureMigrationsTable(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrations.db"))
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, migMngr.EnsureMigrationsTable(context.Background(), time.Second))
+		var cnt int
+		require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM "+MigrationsTableName).Scan(&cnt))
+		require.Equal(t, 0, cnt)
+	}
+
+	require.NoError(t, migMngr.RunLocked(context.Background(),
+		[]Migration{newTestMigration00001CreateTables()}, MigrationsDirectionUp, time.Second))
+	status, err := migMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, status.AppliedMigrations, 1)
+}
+
+func TestMigrationsManager_ensureMigrationsTable_Retry(t *testing.T) {
+	tableExistsErr := &mysqldriver.MySQLError{Number: uint16(mysql.MySQLErrTableExists), Message: "Table 'migrations' already exists"}
+
+	t.Run("retry on duplicate table error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectExec("create table if not exists").WillReturnError(tableExistsErr)
+		mock.ExpectExec("create table if not exists").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT (.+) FROM").WillReturnRows(sqlmock.NewRows([]string{"id", "applied_at"}))
+		mock.ExpectClose()
+
+		migMngr, err := NewMigrationsManager(db, dbkit.DialectSQLite, logtest.NewLogger())
+		require.NoError(t, err)
+		require.NoError(t, migMngr.ensureMigrationsTable(context.Background()))
+
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		internalErr := errors.New("internal error")
+		mock.ExpectExec("create table if not exists").WillReturnError(internalErr)
+		mock.ExpectClose()
+
+		migMngr, err := NewMigrationsManager(db, dbkit.DialectSQLite, logtest.NewLogger())
+		require.NoError(t, err)
+		err = migMngr.ensureMigrationsTable(context.Background())
+		require.ErrorIs(t, err, internalErr)
+
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/acronis/go-dbkit"
+)
+
+const dbkitModulePath = "github.com/acronis/go-dbkit"
+
+// executionInfoTableSuffix is appended to the name of the bookkeeping table to get the name of the table
+// that stores execution information of applied migrations when MigrationsManagerOpts.RecordExecutionInfo is enabled.
+// Separate table is used because sql-migrate doesn't tolerate unknown columns in the bookkeeping table.
+const executionInfoTableSuffix = "_execution_info"
+
+var executionInfoTableColumnTypes = map[dbkit.Dialect]struct{ id, durationMs, dbkitVersion, appliedBy string }{
+	dbkit.DialectSQLite:   {"VARCHAR(255)", "INTEGER", "TEXT", "TEXT"},
+	dbkit.DialectMySQL:    {"VARCHAR(255)", "BIGINT", "VARCHAR(64)", "VARCHAR(255)"},
+	dbkit.DialectPostgres: {"VARCHAR(255)", "BIGINT", "VARCHAR(64)", "VARCHAR(255)"},
+	dbkit.DialectMSSQL:    {"NVARCHAR(255)", "BIGINT", "NVARCHAR(64)", "NVARCHAR(255)"},
+}
+
+// migrationExecutionInfo contains information about applying the migration.
+type migrationExecutionInfo struct {
+	duration     time.Duration
+	dbkitVersion string
+	appliedBy    string
+}
+
+func (mm *MigrationsManager) executionInfoTable() string {
+	return dbkit.QuoteIdentifier(mm.Dialect, mm.migSet.TableName+executionInfoTableSuffix)
+}
+
+// ensureExecutionInfoTable is an internal migration that creates the table for storing execution information
+// of applied migrations next to the bookkeeping table. It's idempotent: the table is created only if it doesn't exist.
+func (mm *MigrationsManager) ensureExecutionInfoTable(ctx context.Context) error {
+	colTypes, ok := executionInfoTableColumnTypes[mm.Dialect]
+	if !ok {
+		return fmt.Errorf("recording execution info of migrations is not supported for %q dialect", mm.Dialect)
+	}
+	if mm.executionInfoTableExists(ctx) {
+		return nil
+	}
+	table := mm.executionInfoTable()
+	createQuery := fmt.Sprintf("CREATE TABLE %s (id %s NOT NULL PRIMARY KEY, duration_ms %s NULL, "+
+		"dbkit_version %s NULL, applied_by %s NULL)",
+		table, colTypes.id, colTypes.durationMs, colTypes.dbkitVersion, colTypes.appliedBy)
+	if _, err := mm.db.ExecContext(ctx, createQuery); err != nil {
+		return fmt.Errorf("create migrations execution info table: %w", err)
+	}
+	return nil
+}
+
+// executionInfoTableExists checks whether the table for storing execution information exists
+// (i.e. migrations were applied with MigrationsManagerOpts.RecordExecutionInfo enabled at least once).
+func (mm *MigrationsManager) executionInfoTableExists(ctx context.Context) bool {
+	rows, err := mm.db.QueryContext(ctx, fmt.Sprintf("SELECT id FROM %s WHERE 1 = 0", mm.executionInfoTable()))
+	if err != nil {
+		return false
+	}
+	_ = rows.Close()
+	return true
+}
+
+// recordExecutionInfo stores (or deletes) execution information of the migration.
+func (mm *MigrationsManager) recordExecutionInfo(
+	executor sqlExecutor, id string, dir migrate.MigrationDirection, duration time.Duration,
+) error {
+	table := mm.executionInfoTable()
+	ph := func(n int) string { return dbkit.MakePlaceholder(mm.Dialect, n) }
+	// Stale record may be left if the migration was rolled back without recording execution info.
+	if _, err := executor.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %s", table, ph(1)), id); err != nil {
+		return err
+	}
+	if dir != migrate.Up {
+		return nil
+	}
+	_, err := executor.Exec(fmt.Sprintf(
+		"INSERT INTO %s (id, duration_ms, dbkit_version, applied_by) VALUES (%s, %s, %s, %s)",
+		table, ph(1), ph(2), ph(3), ph(4)), id, duration.Milliseconds(), DBKitVersion(), mm.appliedBy())
+	return err
+}
+
+// readExecutionInfo reads execution information of the applied migrations.
+// Nil map is returned if the table doesn't exist yet (it's created only when migrations are run).
+func (mm *MigrationsManager) readExecutionInfo() (map[string]migrationExecutionInfo, error) {
+	if !mm.executionInfoTableExists(context.Background()) {
+		return nil, nil
+	}
+	query := fmt.Sprintf("SELECT id, duration_ms, dbkit_version, applied_by FROM %s", mm.executionInfoTable())
+	rows, err := mm.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	result := make(map[string]migrationExecutionInfo)
+	for rows.Next() {
+		var id string
+		var durationMs sql.NullInt64
+		var dbkitVersion, appliedBy sql.NullString
+		if err = rows.Scan(&id, &durationMs, &dbkitVersion, &appliedBy); err != nil {
+			return nil, err
+		}
+		result[id] = migrationExecutionInfo{
+			duration:     time.Duration(durationMs.Int64) * time.Millisecond,
+			dbkitVersion: dbkitVersion.String,
+			appliedBy:    appliedBy.String,
+		}
+	}
+	return result, rows.Err()
+}
+
+// appliedBy returns identity of the migrations applier.
+func (mm *MigrationsManager) appliedBy() string {
+	if mm.opts.AppliedBy != "" {
+		return mm.opts.AppliedBy
+	}
+	return DefaultAppliedBy()
+}
+
+// DefaultAppliedBy returns default identity of the migrations applier ("<hostname>/<executable name>").
+func DefaultAppliedBy() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return hostname + "/" + filepath.Base(os.Args[0])
+}
+
+// DBKitVersion returns version of the dbkit module the binary is built with (or "unknown" if it cannot be determined).
+func DBKitVersion() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if buildInfo.Main.Path == dbkitModulePath {
+		return buildInfo.Main.Version
+	}
+	for _, dep := range buildInfo.Deps {
+		if dep.Path == dbkitModulePath {
+			if dep.Replace != nil && dep.Replace.Version != "" {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
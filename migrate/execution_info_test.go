@@ -0,0 +1,78 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func TestMigrationsManager_RecordExecutionInfo(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrations.db"))
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	// Status doesn't create the execution info table, it's created only when migrations are run.
+	statusMigMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{RecordExecutionInfo: true})
+	require.NoError(t, err)
+	migStatus, err := statusMigMngr.Status()
+	require.NoError(t, err)
+	require.Empty(t, migStatus.AppliedMigrations)
+	require.False(t, statusMigMngr.executionInfoTableExists(context.Background()))
+
+	// The first migration is applied without recording execution info (e.g. by the previous version of the service).
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+
+	migMngr, err = NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{RecordExecutionInfo: true, AppliedBy: "host-1/my-service"})
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	migStatus, err = migMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 2)
+	require.Equal(t, migrations[0].ID(), migStatus.AppliedMigrations[0].ID)
+	require.Empty(t, migStatus.AppliedMigrations[0].AppliedBy)
+	require.Empty(t, migStatus.AppliedMigrations[0].DBKitVersion)
+	require.Equal(t, migrations[1].ID(), migStatus.AppliedMigrations[1].ID)
+	require.Equal(t, "host-1/my-service", migStatus.AppliedMigrations[1].AppliedBy)
+	require.Equal(t, DBKitVersion(), migStatus.AppliedMigrations[1].DBKitVersion)
+	require.NotEmpty(t, migStatus.AppliedMigrations[1].DBKitVersion)
+	require.GreaterOrEqual(t, migStatus.AppliedMigrations[1].Duration.Milliseconds(), int64(0))
+	require.False(t, migStatus.AppliedMigrations[1].AppliedAt.IsZero())
+
+	// Manager without recording execution info still works with the extended table.
+	plainMigMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	plainStatus, err := plainMigMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, plainStatus.AppliedMigrations, 2)
+	require.Empty(t, plainStatus.AppliedMigrations[1].AppliedBy)
+
+	// Internal migration is idempotent, execution info is deleted on rolling back.
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+	migStatus, err = migMngr.Status()
+	require.NoError(t, err)
+	require.Empty(t, migStatus.AppliedMigrations)
+	var execInfoCount int
+	require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM migrations_execution_info").Scan(&execInfoCount))
+	require.Equal(t, 0, execInfoCount)
+}
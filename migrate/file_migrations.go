@@ -0,0 +1,53 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"fmt"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// LoadMigrationsFromDir loads migrations from *.sql files in the passed directory.
+// Files should have the sql-migrate format ("-- +migrate Up" and "-- +migrate Down" annotations),
+// file name is used as migration ID.
+func LoadMigrationsFromDir(dir string) ([]Migration, error) {
+	rawMigs, err := migrate.FileMigrationSource{Dir: dir}.FindMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("find migrations in directory %q: %w", dir, err)
+	}
+	migrations := make([]Migration, 0, len(rawMigs))
+	for _, rawMig := range rawMigs {
+		migrations = append(migrations, &fileMigration{NullMigration: &NullMigration{}, raw: rawMig})
+	}
+	return migrations, nil
+}
+
+type fileMigration struct {
+	*NullMigration
+	raw *migrate.Migration
+}
+
+// ID returns migration identifier.
+func (m *fileMigration) ID() string {
+	return m.raw.Id
+}
+
+// UpSQL returns a slice of SQL statements that will be executed during applying the migration.
+func (m *fileMigration) UpSQL() []string {
+	return m.raw.Up
+}
+
+// DownSQL returns a slice of SQL statements that will be executed during rolling back the migration.
+func (m *fileMigration) DownSQL() []string {
+	return m.raw.Down
+}
+
+// RawMigration implements RawMigrator interface.
+func (m *fileMigration) RawMigration(Migration) (*migrate.Migration, error) {
+	return m.raw, nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// DestructiveStatementError is returned when a migration contains destructive statement
+// and MigrationsManagerOpts.RefuseDestructiveStatements is enabled.
+type DestructiveStatementError struct {
+	MigrationID string
+	Statement   string
+	Reason      string
+}
+
+// Error returns a string representation of the DestructiveStatementError.
+func (e *DestructiveStatementError) Error() string {
+	return fmt.Sprintf("migration %s contains destructive statement (%s): %s", e.MigrationID, e.Reason, e.Statement)
+}
+
+var (
+	sqlLineCommentRe  = regexp.MustCompile(`--[^\n]*`)
+	sqlBlockCommentRe = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	dropStatementRe   = regexp.MustCompile(`(?i)\bDROP\s+(TABLE|COLUMN|SCHEMA|DATABASE)\b`)
+	truncateRe        = regexp.MustCompile(`(?i)^TRUNCATE\b`)
+	deleteRe          = regexp.MustCompile(`(?i)^DELETE\b`)
+	whereRe           = regexp.MustCompile(`(?i)\bWHERE\b`)
+)
+
+// FindDestructiveStatement returns the first destructive statement (DROP TABLE/COLUMN/SCHEMA/DATABASE, TRUNCATE,
+// DELETE without WHERE) found in the passed queries and the reason why it's considered destructive.
+// Each query may contain several statements separated by semicolons.
+func FindDestructiveStatement(queries []string) (statement string, reason string, found bool) {
+	for _, query := range queries {
+		query = sqlBlockCommentRe.ReplaceAllString(sqlLineCommentRe.ReplaceAllString(query, ""), "")
+		for _, stmt := range strings.Split(query, ";") {
+			stmt = strings.TrimSpace(stmt)
+			if stmt == "" {
+				continue
+			}
+			if match := dropStatementRe.FindStringSubmatch(stmt); match != nil {
+				return stmt, "DROP " + strings.ToUpper(match[1]), true
+			}
+			if truncateRe.MatchString(stmt) {
+				return stmt, "TRUNCATE", true
+			}
+			if deleteRe.MatchString(stmt) && !whereRe.MatchString(stmt) {
+				return stmt, "DELETE without WHERE", true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func (mm *MigrationsManager) checkDestructiveStatements(
+	source migrate.MigrationSource, dir migrate.MigrationDirection, limit int,
+) error {
+	plannedMigs, _, err := mm.migSet.PlanMigration(mm.db, string(mm.Dialect), source, dir, limit)
+	if err != nil {
+		return fmt.Errorf("plan migrations: %w", err)
+	}
+	for _, plannedMig := range plannedMigs {
+		if stmt, reason, found := FindDestructiveStatement(plannedMig.Queries); found {
+			return &DestructiveStatementError{MigrationID: plannedMig.Id, Statement: stmt, Reason: reason}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// ErrMigrationsLockTimeout is returned when the lock that guards running migrations cannot be acquired in time.
+var ErrMigrationsLockTimeout = errors.New("timeout while acquiring migrations lock")
+
+// RunLocked runs all passed migrations under the lock (advisory lock in Postgres, named lock in MySQL,
+// application lock in MSSQL) that prevents concurrent running of migrations by several service instances.
+// Lock is held by a dedicated connection and is released when migrations are finished.
+// SQLite doesn't support such locks, so migrations are run without it.
+// Table for bookkeeping of applied migrations is created under the lock before running migrations (see EnsureMigrationsTable).
+// ErrMigrationsLockTimeout error is returned if the lock cannot be acquired within lockTimeout.
+func (mm *MigrationsManager) RunLocked(
+	ctx context.Context, migrations []Migration, direction MigrationsDirection, lockTimeout time.Duration,
+) error {
+	return mm.RunLimitLocked(ctx, migrations, direction, MigrationsNoLimit, lockTimeout)
+}
+
+// RunLimitLocked does the same as RunLocked but runs at most `limit` migrations (see RunLimit).
+func (mm *MigrationsManager) RunLimitLocked(
+	ctx context.Context, migrations []Migration, direction MigrationsDirection, limit int, lockTimeout time.Duration,
+) error {
+	release, err := acquireMigrationsLock(ctx, mm.db, mm.Dialect, "dbkit_"+mm.migSet.TableName, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err = mm.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return mm.RunLimit(migrations, direction, limit)
+}
+
+func acquireMigrationsLock(
+	ctx context.Context, db *sql.DB, dialect dbkit.Dialect, name string, timeout time.Duration,
+) (release func(), err error) {
+	var lockQuery, unlockQuery string
+	var lockArgs, unlockArgs []interface{}
+	switch dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(name))
+		key := int64(hash.Sum64()) // nolint: gosec // Overflow is expected, any int64 is a valid key.
+		lockQuery, lockArgs = "SELECT pg_advisory_lock($1), 1", []interface{}{key}
+		unlockQuery, unlockArgs = "SELECT pg_advisory_unlock($1)", []interface{}{key}
+	case dbkit.DialectMySQL:
+		timeoutSec := int(math.Ceil(timeout.Seconds()))
+		lockQuery, lockArgs = "SELECT 0, GET_LOCK(?, ?)", []interface{}{name, timeoutSec}
+		unlockQuery, unlockArgs = "SELECT RELEASE_LOCK(?)", []interface{}{name}
+	case dbkit.DialectMSSQL:
+		lockQuery = "DECLARE @result int; " +
+			"EXEC @result = sp_getapplock @Resource = $1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = $2; " +
+			"SELECT 0, CASE WHEN @result >= 0 THEN 1 ELSE 0 END;"
+		lockArgs = []interface{}{name, timeout.Milliseconds()}
+		unlockQuery, unlockArgs = "EXEC sp_releaseapplock @Resource = $1, @LockOwner = 'Session'", []interface{}{name}
+	default:
+		return func() {}, nil
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get connection for migrations lock: %w", err)
+	}
+	lockCtx, lockCtxCancel := context.WithTimeout(ctx, timeout)
+	defer lockCtxCancel()
+	var unused sql.NullInt64
+	var acquired sql.NullInt64
+	if err = conn.QueryRowContext(lockCtx, lockQuery, lockArgs...).Scan(&unused, &acquired); err != nil {
+		_ = conn.Close()
+		if lockCtx.Err() != nil && ctx.Err() == nil {
+			return nil, ErrMigrationsLockTimeout
+		}
+		return nil, fmt.Errorf("acquire migrations lock: %w", err)
+	}
+	if acquired.Int64 != 1 {
+		_ = conn.Close()
+		return nil, ErrMigrationsLockTimeout
+	}
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), unlockQuery, unlockArgs...)
+		_ = conn.Close()
+	}, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package migratetest provides helpers for testing database migrations.
+package migratetest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/acronis/go-appkit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/dbtest"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+// RunUpDownUp applies all passed migrations, rolls them all back and re-applies them again.
+// It checks that all migrations are applied and rolled back successfully,
+// and that rolling back removes all tables created by the migrations (i.e. Down migrations cover Up ones completely).
+// Database should not contain applied migrations before the call.
+func RunUpDownUp(t testing.TB, dbConn *sql.DB, dialect dbkit.Dialect, migrations []migrate.Migration) {
+	t.Helper()
+	ctx := context.Background()
+
+	migMngr, err := migrate.NewMigrationsManager(dbConn, dialect, log.NewDisabledLogger())
+	require.NoError(t, err)
+
+	tablesBefore, err := dbtest.ListTables(ctx, dbConn, dialect, migrate.MigrationsTableName)
+	require.NoError(t, err)
+
+	requireAppliedMigrations := func(wantIDs []string) {
+		t.Helper()
+		migStatus, statusErr := migMngr.Status()
+		require.NoError(t, statusErr)
+		gotIDs := make([]string, 0, len(migStatus.AppliedMigrations))
+		for _, appliedMig := range migStatus.AppliedMigrations {
+			gotIDs = append(gotIDs, appliedMig.ID)
+		}
+		require.Equal(t, wantIDs, gotIDs, "unexpected applied migrations")
+	}
+
+	allIDs := make([]string, 0, len(migrations))
+	for _, m := range migrations {
+		allIDs = append(allIDs, m.ID())
+	}
+
+	require.NoError(t, migMngr.Run(migrations, migrate.MigrationsDirectionUp), "apply migrations")
+	requireAppliedMigrations(allIDs)
+
+	require.NoError(t, migMngr.Run(migrations, migrate.MigrationsDirectionDown), "roll back migrations")
+	requireAppliedMigrations([]string{})
+	tablesAfterDown, err := dbtest.ListTables(ctx, dbConn, dialect, migrate.MigrationsTableName)
+	require.NoError(t, err)
+	require.Equal(t, tablesBefore, tablesAfterDown, "rolling back migrations should remove all created tables")
+
+	require.NoError(t, migMngr.Run(migrations, migrate.MigrationsDirectionUp), "re-apply migrations")
+	requireAppliedMigrations(allIDs)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migratetest
+
+import (
+	"database/sql"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+	_ "github.com/acronis/go-dbkit/sqlite"
+)
+
+type recordingT struct {
+	testing.TB
+	failed bool
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.failed = true
+}
+
+func (t *recordingT) FailNow() {
+	t.failed = true
+	runtime.Goexit()
+}
+
+func (t *recordingT) Helper() {}
+
+func runWithRecordingT(t *testing.T, fn func(t testing.TB)) (failed bool) {
+	recT := &recordingT{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(recT)
+	}()
+	<-done
+	return recT.failed
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migratetest.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, dbConn.Close()) })
+	return dbConn
+}
+
+func TestRunUpDownUp(t *testing.T) {
+	t.Run("complete down migrations", func(t *testing.T) {
+		dbConn := openTestDB(t)
+		migrations := []migrate.Migration{
+			migrate.NewCustomMigration("00001_create_users",
+				[]string{"CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL)"},
+				[]string{"DROP TABLE users"}, nil, nil),
+			migrate.NewCustomMigration("00002_create_notes",
+				[]string{"CREATE TABLE notes (id INTEGER NOT NULL PRIMARY KEY, content TEXT)"},
+				[]string{"DROP TABLE notes"}, nil, nil),
+		}
+		require.False(t, runWithRecordingT(t, func(t testing.TB) {
+			RunUpDownUp(t, dbConn, dbkit.DialectSQLite, migrations)
+		}))
+	})
+
+	t.Run("incomplete down migrations", func(t *testing.T) {
+		dbConn := openTestDB(t)
+		migrations := []migrate.Migration{
+			migrate.NewCustomMigration("00001_create_users_and_notes",
+				[]string{
+					"CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL)",
+					"CREATE TABLE notes (id INTEGER NOT NULL PRIMARY KEY, content TEXT)",
+				},
+				[]string{"DROP TABLE users"}, nil, nil),
+		}
+		require.True(t, runWithRecordingT(t, func(t testing.TB) {
+			RunUpDownUp(t, dbConn, dbkit.DialectSQLite, migrations)
+		}))
+	})
+}
@@ -5,9 +5,26 @@ Released under MIT license.
 */
 
 // Package migrate provides functionality for applying database migrations.
+//
+// OpenAndMigrate opens the database and (if db.migrations.autoRun is enabled) applies migrations under
+// the lock that prevents concurrent running of migrations by several service instances.
+// MigrationsManager.EnsureMigrationsTable creates the bookkeeping table under the same lock,
+// so parallel cold starts don't fail randomly (RunLocked does it automatically).
+// MigrationsManager.CheckUpToDate never applies anything and fails with the lists of pending and unknown applied
+// migrations, so it may be used for gating CI/CD pipelines or readiness probes.
+// MigrationsManager.Report returns a machine-readable document (applied, pending and drifted migrations,
+// last run duration) that may be marshaled to JSON or YAML for deployment tooling.
+// With MigrationsManagerOpts.RecordExecutionInfo enabled, execution duration, dbkit version and applier identity
+// of each applied migration are stored and exposed via MigrationsManager.Status.
+// SeedSet manages idempotent reference (seed) data apart from schema migrations (in a separate bookkeeping table),
+// seeds may be restricted to specific environments and re-applied.
+//
+// Package migratetest provides helpers for testing migrations (e.g. RunUpDownUp checks that migrations
+// may be applied, completely rolled back and re-applied).
 package migrate
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
@@ -55,6 +72,14 @@ type TxDisabler interface {
 	DisableTx() bool
 }
 
+// Validator is an interface for Migration for validating its result (e.g. checking row counts or constraints).
+// Validate is called after applying the migration (in up direction) in the same transaction,
+// returned error fails the migration and the transaction is rolled back.
+// Migration that implements Validator cannot disable transaction (see TxDisabler).
+type Validator interface {
+	Validate(tx *sql.Tx) error
+}
+
 // NullMigration represents an empty basic migration that may be embedded in regular migrations
 // in order to write less code for satisfying the Migration interface.
 type NullMigration struct {
@@ -131,17 +156,36 @@ type MigrationsManager struct {
 	Dialect dbkit.Dialect
 	migSet  migrate.MigrationSet
 	logger  log.FieldLogger
+	lastRun *MigrationsRunInfo
+	opts    MigrationsManagerOpts
 }
 
 // MigrationsManagerOpts holds the Migration Manager options to be used in NewMigrationsManagerWithOpts
 type MigrationsManagerOpts struct {
 	TableName string
+
+	// RefuseDestructiveStatements enables the policy that scans SQL of migrations that are going to be applied
+	// (or rolled back) for destructive statements (DROP TABLE/COLUMN/SCHEMA/DATABASE, TRUNCATE, DELETE without WHERE).
+	// If any of them is found, nothing is applied and *DestructiveStatementError is returned
+	// unless ForceDestructiveStatements is set. It protects production rollbacks from accidental data loss.
+	RefuseDestructiveStatements bool
+	ForceDestructiveStatements  bool
+
+	// RecordExecutionInfo enables storing execution duration, dbkit version and applier identity
+	// for each applied migration. Information is stored in the "<TableName>_execution_info" table
+	// (sql-migrate doesn't tolerate additional columns in the bookkeeping table itself) that is created
+	// by the internal migration on the first run, and it's exposed via Status.
+	RecordExecutionInfo bool
+
+	// AppliedBy is an identity of the migrations applier (e.g. host and service name) that is stored
+	// when RecordExecutionInfo is enabled. DefaultAppliedBy is used if it's empty.
+	AppliedBy string
 }
 
 // NewMigrationsManager creates a new MigrationsManager.
 func NewMigrationsManager(dbConn *sql.DB, dialect dbkit.Dialect, logger log.FieldLogger) (*MigrationsManager, error) {
 	migSet := migrate.MigrationSet{TableName: MigrationsTableName}
-	return &MigrationsManager{dbConn, normalizeDialect(dialect), migSet, logger}, nil
+	return &MigrationsManager{db: dbConn, Dialect: normalizeDialect(dialect), migSet: migSet, logger: logger}, nil
 }
 
 // NewMigrationsManagerWithOpts creates a new MigrationsManager with custom options
@@ -155,8 +199,11 @@ func NewMigrationsManagerWithOpts(
 	if tableName == "" {
 		tableName = MigrationsTableName
 	}
+	if err := dbkit.ValidateIdentifier(dialect, tableName); err != nil {
+		return nil, fmt.Errorf("migrations table name: %w", err)
+	}
 	migSet := migrate.MigrationSet{TableName: tableName}
-	return &MigrationsManager{dbConn, normalizeDialect(dialect), migSet, logger}, nil
+	return &MigrationsManager{db: dbConn, Dialect: normalizeDialect(dialect), migSet: migSet, logger: logger, opts: opts}, nil
 }
 
 // TODO: normalizeDialect sets standard lib/pq driver for pgx dialect because pgx isn't supported by sql-migrate yet.
@@ -210,34 +257,71 @@ func convertMigration(m Migration) (*migrate.Migration, error) {
 	}, nil
 }
 
-// RunLimit runs at most `limit` migrations. Pass 0 (or MigrationsNoLimit const) for no limit (or use Run).
-func (mm *MigrationsManager) RunLimit(migrations []Migration, direction MigrationsDirection, limit int) error {
+// makeMigrationSource converts all passed migrations to the in-memory sql-migrate source.
+func makeMigrationSource(migrations []Migration) (*migrate.MemoryMigrationSource, error) {
 	convertedMigrationList := make([]*migrate.Migration, 0, len(migrations))
 	for i, m := range migrations {
 		if m.ID() == "" {
-			return fmt.Errorf("migration #%d has empty ID", i+1)
+			return nil, fmt.Errorf("migration #%d has empty ID", i+1)
 		}
 
 		convertedMigration, err := convertMigration(m)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		convertedMigrationList = append(convertedMigrationList, convertedMigration)
 	}
+	return &migrate.MemoryMigrationSource{Migrations: convertedMigrationList}, nil
+}
 
-	source := &migrate.MemoryMigrationSource{Migrations: convertedMigrationList}
-
-	var dir migrate.MigrationDirection
+func convertDirection(direction MigrationsDirection) (migrate.MigrationDirection, error) {
 	switch direction {
 	case MigrationsDirectionUp:
-		dir = migrate.Up
+		return migrate.Up, nil
 	case MigrationsDirectionDown:
-		dir = migrate.Down
+		return migrate.Down, nil
 	default:
-		return fmt.Errorf("unknown direction %q", dir)
+		return 0, fmt.Errorf("unknown direction %q", direction)
 	}
+}
 
-	n, err := mm.migSet.ExecMax(mm.db, string(mm.Dialect), source, dir, limit)
+// RunLimit runs at most `limit` migrations. Pass 0 (or MigrationsNoLimit const) for no limit (or use Run).
+func (mm *MigrationsManager) RunLimit(migrations []Migration, direction MigrationsDirection, limit int) error {
+	source, err := makeMigrationSource(migrations)
+	if err != nil {
+		return err
+	}
+
+	dir, err := convertDirection(direction)
+	if err != nil {
+		return err
+	}
+
+	if mm.opts.RefuseDestructiveStatements && !mm.opts.ForceDestructiveStatements {
+		if err = mm.checkDestructiveStatements(source, dir, limit); err != nil {
+			mm.logger.Error("db migration refused", log.String("direction", string(direction)), log.Error(err))
+			return err
+		}
+	}
+
+	if mm.opts.RecordExecutionInfo {
+		if err = mm.ensureExecutionInfoTable(context.Background()); err != nil {
+			mm.logger.Error("db migration failed", log.String("direction", string(direction)), log.Error(err))
+			return err
+		}
+	}
+
+	startedAt := time.Now()
+	var n int
+	if validators := findValidators(migrations); len(validators) != 0 || mm.opts.RecordExecutionInfo {
+		n, err = mm.execWithValidation(source, dir, limit, validators)
+	} else {
+		n, err = mm.migSet.ExecMax(mm.db, string(mm.Dialect), source, dir, limit)
+	}
+	mm.lastRun = &MigrationsRunInfo{Direction: direction, Applied: n, StartedAt: startedAt, Duration: time.Since(startedAt)}
+	if err != nil {
+		mm.lastRun.Error = err.Error()
+	}
 
 	logger := mm.logger.With(log.String("direction", string(direction)), log.Int("applied", n))
 	if err != nil {
@@ -248,7 +332,38 @@ func (mm *MigrationsManager) RunLimit(migrations []Migration, direction Migratio
 	return nil
 }
 
+// PlannedMigration represents a single migration that will be applied (or rolled back) by RunLimit.
+type PlannedMigration struct {
+	ID      string
+	Queries []string
+}
+
+// Plan returns at most `limit` migrations that will be applied (or rolled back) by RunLimit with the same arguments.
+// Pass 0 (or MigrationsNoLimit const) for no limit.
+func (mm *MigrationsManager) Plan(migrations []Migration, direction MigrationsDirection, limit int) ([]PlannedMigration, error) {
+	source, err := makeMigrationSource(migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := convertDirection(direction)
+	if err != nil {
+		return nil, err
+	}
+
+	plannedMigs, _, err := mm.migSet.PlanMigration(mm.db, string(mm.Dialect), source, dir, limit)
+	if err != nil {
+		return nil, fmt.Errorf("plan migrations: %w", err)
+	}
+	result := make([]PlannedMigration, 0, len(plannedMigs))
+	for _, plannedMig := range plannedMigs {
+		result = append(result, PlannedMigration{ID: plannedMig.Id, Queries: plannedMig.Queries})
+	}
+	return result, nil
+}
+
 // Status returns the current migration status.
+// If MigrationsManagerOpts.RecordExecutionInfo is enabled, applied migrations contain execution information.
 func (mm *MigrationsManager) Status() (MigrationStatus, error) {
 	var migStatus MigrationStatus
 
@@ -256,9 +371,21 @@ func (mm *MigrationsManager) Status() (MigrationStatus, error) {
 	if err != nil {
 		return migStatus, fmt.Errorf("get applied migrations: %w", err)
 	}
+	var execInfos map[string]migrationExecutionInfo
+	if mm.opts.RecordExecutionInfo {
+		if execInfos, err = mm.readExecutionInfo(); err != nil {
+			return migStatus, fmt.Errorf("get execution info of applied migrations: %w", err)
+		}
+	}
 	migStatus.AppliedMigrations = make([]AppliedMigration, 0, len(appliedMigRecords))
 	for _, migRec := range appliedMigRecords {
-		migStatus.AppliedMigrations = append(migStatus.AppliedMigrations, AppliedMigration{ID: migRec.Id, AppliedAt: migRec.AppliedAt})
+		appliedMig := AppliedMigration{ID: migRec.Id, AppliedAt: migRec.AppliedAt}
+		if execInfo, ok := execInfos[migRec.Id]; ok {
+			appliedMig.Duration = execInfo.duration
+			appliedMig.DBKitVersion = execInfo.dbkitVersion
+			appliedMig.AppliedBy = execInfo.appliedBy
+		}
+		migStatus.AppliedMigrations = append(migStatus.AppliedMigrations, appliedMig)
 	}
 
 	return migStatus, nil
@@ -266,13 +393,24 @@ func (mm *MigrationsManager) Status() (MigrationStatus, error) {
 
 // AppliedMigration represent a single already applied migration.
 type AppliedMigration struct {
-	ID        string
-	AppliedAt time.Time
+	ID        string    `json:"id" yaml:"id"`
+	AppliedAt time.Time `json:"applied_at" yaml:"applied_at"`
+
+	// Fields below are filled only if MigrationsManagerOpts.RecordExecutionInfo is enabled
+	// and migration was applied with it.
+	Duration     time.Duration `json:"duration,omitempty" yaml:"duration,omitempty"` // Nanoseconds in JSON, Go duration string in YAML.
+	DBKitVersion string        `json:"dbkit_version,omitempty" yaml:"dbkit_version,omitempty"`
+	AppliedBy    string        `json:"applied_by,omitempty" yaml:"applied_by,omitempty"`
 }
 
 // MigrationStatus is the migration status.
 type MigrationStatus struct {
-	AppliedMigrations []AppliedMigration
+	AppliedMigrations []AppliedMigration `json:"applied_migrations" yaml:"applied_migrations"`
+}
+
+// Marshal encodes migration status in the specified machine-readable format.
+func (ms MigrationStatus) Marshal(format OutputFormat) ([]byte, error) {
+	return marshalOutput(ms, format)
 }
 
 // LastAppliedMigration returns last applied migration if it exists.
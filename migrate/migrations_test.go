@@ -11,6 +11,8 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -259,6 +261,10 @@ func TestCreationMigrationManagerWithOpts(t *testing.T) {
 	// Table exists after migrations.
 	require.NoError(t, dbConn.QueryRow("select count(*) from custom_migrations").Scan(&rowsNum))
 	require.Equal(t, 0, rowsNum)
+
+	_, err = NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{TableName: "migrations\x00"})
+	require.ErrorIs(t, err, dbkit.ErrInvalidIdentifier)
 }
 
 func requireNoErrOnClose(t *testing.T, closer io.Closer) {
@@ -316,3 +322,65 @@ func TestMigrationsManager_supportRawMigration(t *testing.T) {
 	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionDown, 1))
 	requireMigrationsApplied(t, dbConn, true, 0, 0)
 }
+
+func TestMigrationsManager_Plan(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	plannedMigs, err := migMngr.Plan(migrations, MigrationsDirectionUp, MigrationsNoLimit)
+	require.NoError(t, err)
+	require.Len(t, plannedMigs, 2)
+	require.Equal(t, migrations[0].ID(), plannedMigs[0].ID)
+	require.Equal(t, migrations[0].UpSQL(), plannedMigs[0].Queries)
+	require.Equal(t, migrations[1].ID(), plannedMigs[1].ID)
+
+	// Planning doesn't apply migrations.
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+	plannedMigs, err = migMngr.Plan(migrations, MigrationsDirectionUp, MigrationsNoLimit)
+	require.NoError(t, err)
+	require.Len(t, plannedMigs, 1)
+	require.Equal(t, migrations[1].ID(), plannedMigs[0].ID)
+
+	plannedMigs, err = migMngr.Plan(migrations, MigrationsDirectionDown, MigrationsNoLimit)
+	require.NoError(t, err)
+	require.Len(t, plannedMigs, 1)
+	require.Equal(t, migrations[0].ID(), plannedMigs[0].ID)
+	require.Equal(t, migrations[0].DownSQL(), plannedMigs[0].Queries)
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+}
+
+func TestLoadMigrationsFromDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00001_create_users.sql"), []byte(`
+-- +migrate Up
+CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL);
+
+-- +migrate Down
+DROP TABLE users;
+`), 0o600))
+
+	migrations, err := LoadMigrationsFromDir(dir)
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+	require.Equal(t, "00001_create_users.sql", migrations[0].ID())
+
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	var usersCount int
+	require.NoError(t, dbConn.QueryRow("select count(*) from users").Scan(&usersCount))
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	require.Error(t, dbConn.QueryRow("select count(*) from users").Scan(&usersCount))
+}
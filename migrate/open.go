@@ -0,0 +1,46 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/acronis/go-appkit/log"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// OpenAndMigrate opens database (see dbkit.Open) and, if it's enabled in the configuration (db.migrations.autoRun),
+// applies passed migrations under the lock that prevents concurrent running of migrations by several service instances
+// (see MigrationsManager.RunLocked). It's a typical boot sequence of the service.
+// It's placed in the migrate package (not in dbkit) since dbkit cannot depend on migrate.
+func OpenAndMigrate(cfg *dbkit.Config, migrations []Migration, logger log.FieldLogger) (*sql.DB, error) {
+	dbConn, err := dbkit.Open(cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Migrations.AutoRun {
+		return dbConn, nil
+	}
+
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, cfg.Dialect, logger, MigrationsManagerOpts{TableName: cfg.Migrations.TableName})
+	if err != nil {
+		_ = dbConn.Close()
+		return nil, err
+	}
+	lockTimeout := cfg.Migrations.LockTimeout
+	if lockTimeout <= 0 {
+		lockTimeout = dbkit.DefaultMigrationsLockTimeout
+	}
+	if err = migMngr.RunLocked(context.Background(), migrations, MigrationsDirectionUp, lockTimeout); err != nil {
+		_ = dbConn.Close()
+		return nil, fmt.Errorf("run migrations: %w", err)
+	}
+	return dbConn, nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func TestOpenAndMigrate(t *testing.T) {
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	makeConfig := func(autoRun bool) *dbkit.Config {
+		return &dbkit.Config{
+			Dialect:      dbkit.DialectSQLite,
+			MaxOpenConns: 1,
+			MaxIdleConns: 1,
+			SQLite:       dbkit.SQLiteConfig{Path: filepath.Join(t.TempDir(), "open_and_migrate.db")},
+			Migrations:   dbkit.MigrationsConfig{AutoRun: autoRun, TableName: "schema_migrations"},
+		}
+	}
+
+	t.Run("migrations are not applied if auto-run is disabled", func(t *testing.T) {
+		dbConn, err := OpenAndMigrate(makeConfig(false), migrations, logtest.NewLogger())
+		require.NoError(t, err)
+		defer requireNoErrOnClose(t, dbConn)
+		requireMigrationsApplied(t, dbConn, true, 0, 0)
+	})
+
+	t.Run("migrations are applied if auto-run is enabled", func(t *testing.T) {
+		cfg := makeConfig(true)
+		dbConn, err := OpenAndMigrate(cfg, migrations, logtest.NewLogger())
+		require.NoError(t, err)
+		defer requireNoErrOnClose(t, dbConn)
+		requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+		var appliedCount int
+		require.NoError(t, dbConn.QueryRow("select count(*) from schema_migrations").Scan(&appliedCount))
+		require.Equal(t, 2, appliedCount)
+
+		// Already applied migrations are skipped on the next start.
+		dbConn2, err := OpenAndMigrate(cfg, migrations, logtest.NewLogger())
+		require.NoError(t, err)
+		defer requireNoErrOnClose(t, dbConn2)
+		requireMigrationsApplied(t, dbConn2, false, 5, 2)
+	})
+}
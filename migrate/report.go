@@ -0,0 +1,204 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// OutputFormat defines possible machine-readable formats of the migration status and report.
+type OutputFormat string
+
+// Machine-readable output formats.
+const (
+	OutputFormatJSON OutputFormat = "json"
+	OutputFormatYAML OutputFormat = "yaml"
+)
+
+// MigrationsRunInfo contains information about the last run of migrations (Run or RunLimit) by the MigrationsManager.
+type MigrationsRunInfo struct {
+	Direction MigrationsDirection `json:"direction" yaml:"direction"`
+	Applied   int                 `json:"applied" yaml:"applied"`
+	StartedAt time.Time           `json:"started_at" yaml:"started_at"`
+	Duration  time.Duration       `json:"duration" yaml:"duration"` // Nanoseconds in JSON, Go duration string in YAML.
+	Error     string              `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// MigrationsReport is a machine-readable document that describes the state of migrations.
+// It's supposed to be consumed by deployment tooling instead of parsing logs.
+type MigrationsReport struct {
+	Dialect dbkit.Dialect `json:"dialect" yaml:"dialect"`
+
+	// Applied contains migrations that are already applied (in the order of applying).
+	Applied []AppliedMigration `json:"applied" yaml:"applied"`
+
+	// Pending contains IDs of the passed migrations that are not applied yet.
+	Pending []string `json:"pending" yaml:"pending"`
+
+	// Drifted contains IDs of the applied migrations that are unknown (i.e. not in the passed migrations).
+	// Usually it means that the database was migrated by a newer version of the application.
+	Drifted []string `json:"drifted" yaml:"drifted"`
+
+	// LastRun contains information about the last run of migrations by the manager (nil if there were no runs).
+	LastRun *MigrationsRunInfo `json:"last_run,omitempty" yaml:"last_run,omitempty"`
+}
+
+// Report returns machine-readable report about the state of the passed migrations in the database.
+func (mm *MigrationsManager) Report(migrations []Migration) (MigrationsReport, error) {
+	migStatus, err := mm.Status()
+	if err != nil {
+		return MigrationsReport{}, err
+	}
+
+	report := MigrationsReport{
+		Dialect: mm.Dialect,
+		Applied: migStatus.AppliedMigrations,
+		Pending: []string{},
+		Drifted: []string{},
+	}
+	if mm.lastRun != nil {
+		lastRun := *mm.lastRun
+		report.LastRun = &lastRun
+	}
+
+	appliedIDs := make([]string, 0, len(migStatus.AppliedMigrations))
+	for _, appliedMig := range migStatus.AppliedMigrations {
+		appliedIDs = append(appliedIDs, appliedMig.ID)
+	}
+	report.Pending, report.Drifted = diffMigrations(migrations, appliedIDs)
+	return report, nil
+}
+
+// diffMigrations returns IDs of the passed migrations that are not applied yet (pending)
+// and IDs of the applied migrations that are unknown (drifted).
+func diffMigrations(migrations []Migration, appliedIDs []string) (pending, drifted []string) {
+	pending, drifted = []string{}, []string{}
+	applied := make(map[string]bool, len(appliedIDs))
+	for _, id := range appliedIDs {
+		applied[id] = true
+	}
+	known := make(map[string]bool, len(migrations))
+	for _, mig := range migrations {
+		known[mig.ID()] = true
+		if !applied[mig.ID()] {
+			pending = append(pending, mig.ID())
+		}
+	}
+	for _, id := range appliedIDs {
+		if !known[id] {
+			drifted = append(drifted, id)
+		}
+	}
+	return pending, drifted
+}
+
+// NotUpToDateError is returned by CheckUpToDate when the database state doesn't match the passed migrations.
+type NotUpToDateError struct {
+	// Pending contains IDs of the passed migrations that are not applied yet.
+	Pending []string
+
+	// Drifted contains IDs of the applied migrations that are unknown (i.e. not in the passed migrations).
+	Drifted []string
+}
+
+// Error returns a string representation of the NotUpToDateError.
+func (e *NotUpToDateError) Error() string {
+	var parts []string
+	if len(e.Pending) != 0 {
+		parts = append(parts, fmt.Sprintf("pending migrations: %s", strings.Join(e.Pending, ", ")))
+	}
+	if len(e.Drifted) != 0 {
+		parts = append(parts, fmt.Sprintf("unknown applied migrations: %s", strings.Join(e.Drifted, ", ")))
+	}
+	return "database migrations are not up to date (" + strings.Join(parts, "; ") + ")"
+}
+
+// CheckUpToDate checks that all passed migrations are applied and there are no applied migrations
+// unknown to the application. It never applies or rolls back migrations and doesn't modify the database schema
+// (missing bookkeeping table means that all migrations are pending), so it's suitable for gating CI/CD pipelines
+// or readiness probes. *NotUpToDateError error with the lists of pending and drifted migrations is returned
+// if the database is not up to date.
+func (mm *MigrationsManager) CheckUpToDate(migrations []Migration) error {
+	appliedIDs, err := mm.readAppliedMigrationIDs(context.Background())
+	if err != nil {
+		return fmt.Errorf("get applied migrations: %w", err)
+	}
+	pending, drifted := diffMigrations(migrations, appliedIDs)
+	if len(pending) != 0 || len(drifted) != 0 {
+		return &NotUpToDateError{Pending: pending, Drifted: drifted}
+	}
+	return nil
+}
+
+// readAppliedMigrationIDs reads IDs of the applied migrations directly from the bookkeeping table.
+// Unlike sql-migrate, it doesn't create the table if it doesn't exist (nil is returned in this case).
+func (mm *MigrationsManager) readAppliedMigrationIDs(ctx context.Context) ([]string, error) {
+	exists, err := mm.migrationsTableExists(ctx)
+	if err != nil || !exists {
+		return nil, err
+	}
+	rows, err := mm.db.QueryContext(ctx, fmt.Sprintf("SELECT id FROM %s ORDER BY id",
+		dbkit.QuoteIdentifier(mm.Dialect, mm.migSet.TableName)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (mm *MigrationsManager) migrationsTableExists(ctx context.Context) (bool, error) {
+	var query string
+	switch mm.Dialect {
+	case dbkit.DialectSQLite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	case dbkit.DialectMySQL:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	case dbkit.DialectMSSQL:
+		query = "SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = SCHEMA_NAME() AND TABLE_NAME = @p1"
+	default:
+		return false, fmt.Errorf("unsupported dialect %q", mm.Dialect)
+	}
+	var count int
+	if err := mm.db.QueryRowContext(ctx, query, mm.migSet.TableName).Scan(&count); err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
+
+// Marshal encodes migrations report in the specified machine-readable format.
+func (r MigrationsReport) Marshal(format OutputFormat) ([]byte, error) {
+	return marshalOutput(r, format)
+}
+
+func marshalOutput(v interface{}, format OutputFormat) ([]byte, error) {
+	switch format {
+	case OutputFormatJSON:
+		return json.Marshal(v)
+	case OutputFormatYAML:
+		return yaml.Marshal(v)
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
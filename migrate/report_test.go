@@ -0,0 +1,140 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func TestMigrationsManager_Report(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	report, err := migMngr.Report(migrations)
+	require.NoError(t, err)
+	require.Equal(t, dbkit.DialectSQLite, report.Dialect)
+	require.Empty(t, report.Applied)
+	require.Equal(t, []string{migrations[0].ID(), migrations[1].ID()}, report.Pending)
+	require.Empty(t, report.Drifted)
+	require.Nil(t, report.LastRun)
+
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+	defer func() { require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown)) }()
+
+	report, err = migMngr.Report(migrations)
+	require.NoError(t, err)
+	require.Len(t, report.Applied, 1)
+	require.Equal(t, migrations[0].ID(), report.Applied[0].ID)
+	require.Equal(t, []string{migrations[1].ID()}, report.Pending)
+	require.Empty(t, report.Drifted)
+	require.NotNil(t, report.LastRun)
+	require.Equal(t, MigrationsDirectionUp, report.LastRun.Direction)
+	require.Equal(t, 1, report.LastRun.Applied)
+	require.Empty(t, report.LastRun.Error)
+
+	// Migration that is applied but unknown for the caller is reported as drifted.
+	report, err = migMngr.Report(migrations[1:])
+	require.NoError(t, err)
+	require.Equal(t, []string{migrations[1].ID()}, report.Pending)
+	require.Equal(t, []string{migrations[0].ID()}, report.Drifted)
+
+	jsonData, err := report.Marshal(OutputFormatJSON)
+	require.NoError(t, err)
+	var jsonDoc map[string]interface{}
+	require.NoError(t, json.Unmarshal(jsonData, &jsonDoc))
+	require.Equal(t, "sqlite3", jsonDoc["dialect"])
+	require.Equal(t, []interface{}{migrations[1].ID()}, jsonDoc["pending"])
+	require.Equal(t, []interface{}{migrations[0].ID()}, jsonDoc["drifted"])
+	require.Equal(t, "up", jsonDoc["last_run"].(map[string]interface{})["direction"])
+
+	yamlData, err := report.Marshal(OutputFormatYAML)
+	require.NoError(t, err)
+	var yamlDoc map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(yamlData, &yamlDoc))
+	require.Equal(t, []interface{}{migrations[1].ID()}, yamlDoc["pending"])
+	require.Equal(t, []interface{}{migrations[0].ID()}, yamlDoc["drifted"])
+
+	_, err = report.Marshal("xml")
+	require.EqualError(t, err, `unknown output format "xml"`)
+}
+
+func TestMigrationsManager_CheckUpToDate(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	err = migMngr.CheckUpToDate(migrations)
+	var notUpToDateErr *NotUpToDateError
+	require.ErrorAs(t, err, &notUpToDateErr)
+	require.Equal(t, []string{migrations[0].ID(), migrations[1].ID()}, notUpToDateErr.Pending)
+	require.Empty(t, notUpToDateErr.Drifted)
+	require.EqualError(t, err, "database migrations are not up to date (pending migrations: "+
+		migrations[0].ID()+", "+migrations[1].ID()+")")
+	requireMigrationsApplied(t, dbConn, true, 0, 0) // Nothing is applied.
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	defer func() { require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown)) }()
+	require.NoError(t, migMngr.CheckUpToDate(migrations))
+
+	err = migMngr.CheckUpToDate(migrations[1:])
+	require.ErrorAs(t, err, &notUpToDateErr)
+	require.Empty(t, notUpToDateErr.Pending)
+	require.Equal(t, []string{migrations[0].ID()}, notUpToDateErr.Drifted)
+	require.EqualError(t, err, "database migrations are not up to date (unknown applied migrations: "+migrations[0].ID()+")")
+}
+
+func TestMigrationsManager_CheckUpToDate_NoMigrationsTable(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrations.db"))
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables()}
+
+	var notUpToDateErr *NotUpToDateError
+	require.ErrorAs(t, migMngr.CheckUpToDate(migrations), &notUpToDateErr)
+	require.Equal(t, []string{migrations[0].ID()}, notUpToDateErr.Pending)
+
+	// Bookkeeping table is not created by the check.
+	exists, err := migMngr.migrationsTableExists(context.Background())
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestMigrationStatus_Marshal(t *testing.T) {
+	migStatus := MigrationStatus{AppliedMigrations: []AppliedMigration{{ID: "00001_init"}}}
+
+	jsonData, err := migStatus.Marshal(OutputFormatJSON)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"applied_migrations":[{"id":"00001_init","applied_at":"0001-01-01T00:00:00Z"}]}`, string(jsonData))
+
+	yamlData, err := migStatus.Marshal(OutputFormatYAML)
+	require.NoError(t, err)
+	var decodedStatus MigrationStatus
+	require.NoError(t, yaml.Unmarshal(yamlData, &decodedStatus))
+	require.Equal(t, migStatus, decodedStatus)
+}
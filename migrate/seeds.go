@@ -0,0 +1,209 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// SeedsTableName contains name of table in a database that stores applied seeds.
+const SeedsTableName = "seeds"
+
+// Seed represents idempotent reference (seed) data that is managed apart from the schema migrations.
+// Since seed may be re-applied (see SeedSet.Reapply), its SQL should be idempotent
+// (e.g. use upserts instead of plain INSERT statements).
+type Seed struct {
+	ID  string
+	SQL []string
+
+	// Environments (if not empty) restricts environments (see SeedSetOpts.Environment) in which the seed is applied.
+	Environments []string
+}
+
+func (s *Seed) matchesEnvironment(env string) bool {
+	if len(s.Environments) == 0 {
+		return true
+	}
+	for _, seedEnv := range s.Environments {
+		if seedEnv == env {
+			return true
+		}
+	}
+	return false
+}
+
+// AppliedSeed represents a single already applied seed.
+type AppliedSeed struct {
+	ID          string    `json:"id" yaml:"id"`
+	Environment string    `json:"environment" yaml:"environment"`
+	AppliedAt   time.Time `json:"applied_at" yaml:"applied_at"`
+}
+
+// SeedSetOpts represents options for SeedSet.
+type SeedSetOpts struct {
+	// TableName is a name of the bookkeeping table. SeedsTableName is used by default.
+	TableName string
+
+	// Environment is a name of the environment (e.g. "dev", "staging", "prod") seeds are applied for.
+	// Seeds are tracked per environment, so the same database may be seeded for different environments.
+	Environment string
+}
+
+// SeedSet applies seeds and tracks them in the separate bookkeeping table.
+// Unlike migrations, seeds don't have down direction and may be re-applied.
+type SeedSet struct {
+	db      *sql.DB
+	dialect dbkit.Dialect
+	logger  log.FieldLogger
+	opts    SeedSetOpts
+}
+
+// NewSeedSet creates a new SeedSet.
+func NewSeedSet(dbConn *sql.DB, dialect dbkit.Dialect, logger log.FieldLogger) (*SeedSet, error) {
+	return NewSeedSetWithOpts(dbConn, dialect, logger, SeedSetOpts{})
+}
+
+// NewSeedSetWithOpts is a more configurable version of creating SeedSet.
+func NewSeedSetWithOpts(dbConn *sql.DB, dialect dbkit.Dialect, logger log.FieldLogger, opts SeedSetOpts) (*SeedSet, error) {
+	if opts.TableName == "" {
+		opts.TableName = SeedsTableName
+	}
+	if err := dbkit.ValidateIdentifier(dialect, opts.TableName); err != nil {
+		return nil, fmt.Errorf("seeds table name: %w", err)
+	}
+	return &SeedSet{db: dbConn, dialect: dialect, logger: logger, opts: opts}, nil
+}
+
+// Apply applies seeds (matching the environment) that are not applied yet. Number of applied seeds is returned.
+// Each seed is applied in a separate transaction together with its bookkeeping record.
+func (s *SeedSet) Apply(ctx context.Context, seeds []Seed) (int, error) {
+	return s.apply(ctx, seeds, false)
+}
+
+// Reapply applies all seeds (matching the environment) regardless of whether they were applied before.
+// Number of applied seeds is returned.
+func (s *SeedSet) Reapply(ctx context.Context, seeds []Seed) (int, error) {
+	return s.apply(ctx, seeds, true)
+}
+
+func (s *SeedSet) apply(ctx context.Context, seeds []Seed, reapply bool) (int, error) {
+	if err := validateSeeds(seeds); err != nil {
+		return 0, err
+	}
+	if err := s.createTable(ctx); err != nil {
+		return 0, err
+	}
+	appliedSeeds, err := s.Applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	appliedIDs := make(map[string]bool, len(appliedSeeds))
+	for _, appliedSeed := range appliedSeeds {
+		appliedIDs[appliedSeed.ID] = true
+	}
+
+	logger := s.logger.With(log.String("environment", s.opts.Environment), log.Bool("reapply", reapply))
+	n := 0
+	for i := range seeds {
+		seed := &seeds[i]
+		if !seed.matchesEnvironment(s.opts.Environment) || (appliedIDs[seed.ID] && !reapply) {
+			continue
+		}
+		if err = s.applySeed(ctx, seed); err != nil {
+			logger.Error("db seeding failed", log.String("seed", seed.ID), log.Int("applied", n), log.Error(err))
+			return n, fmt.Errorf("apply seed %s: %w", seed.ID, err)
+		}
+		n++
+	}
+	logger.Info("db seeding succeeded", log.Int("applied", n))
+	return n, nil
+}
+
+func validateSeeds(seeds []Seed) error {
+	ids := make(map[string]bool, len(seeds))
+	for i := range seeds {
+		if seeds[i].ID == "" {
+			return fmt.Errorf("seed #%d has empty ID", i+1)
+		}
+		if ids[seeds[i].ID] {
+			return fmt.Errorf("seed %s is duplicated", seeds[i].ID)
+		}
+		ids[seeds[i].ID] = true
+	}
+	return nil
+}
+
+func (s *SeedSet) applySeed(ctx context.Context, seed *Seed) error {
+	table := dbkit.QuoteIdentifier(s.dialect, s.opts.TableName)
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE id = %s AND environment = %s",
+		table, dbkit.MakePlaceholder(s.dialect, 1), dbkit.MakePlaceholder(s.dialect, 2))
+	insertQuery := fmt.Sprintf("INSERT INTO %s (id, environment, applied_at) VALUES (%s, %s, %s)",
+		table, dbkit.MakePlaceholder(s.dialect, 1), dbkit.MakePlaceholder(s.dialect, 2), dbkit.MakePlaceholder(s.dialect, 3))
+	return dbkit.DoInTx(ctx, s.db, func(tx *sql.Tx) error {
+		for _, query := range seed.SQL {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, deleteQuery, seed.ID, s.opts.Environment); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, insertQuery, seed.ID, s.opts.Environment, time.Now().UTC())
+		return err
+	})
+}
+
+// Applied returns seeds applied for the environment in the order of applying.
+func (s *SeedSet) Applied(ctx context.Context) ([]AppliedSeed, error) {
+	if err := s.createTable(ctx); err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("SELECT id, environment, applied_at FROM %s WHERE environment = %s ORDER BY applied_at, id",
+		dbkit.QuoteIdentifier(s.dialect, s.opts.TableName), dbkit.MakePlaceholder(s.dialect, 1))
+	rows, err := s.db.QueryContext(ctx, query, s.opts.Environment)
+	if err != nil {
+		return nil, fmt.Errorf("get applied seeds: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	appliedSeeds := []AppliedSeed{}
+	for rows.Next() {
+		var appliedSeed AppliedSeed
+		if err = rows.Scan(&appliedSeed.ID, &appliedSeed.Environment, &appliedSeed.AppliedAt); err != nil {
+			return nil, fmt.Errorf("scan applied seed: %w", err)
+		}
+		appliedSeeds = append(appliedSeeds, appliedSeed)
+	}
+	return appliedSeeds, rows.Err()
+}
+
+func (s *SeedSet) createTable(ctx context.Context) error {
+	table := dbkit.QuoteIdentifier(s.dialect, s.opts.TableName)
+	const columns = "id VARCHAR(255) NOT NULL, environment VARCHAR(255) NOT NULL, applied_at %s NOT NULL, PRIMARY KEY (id, environment)"
+	var query string
+	switch s.dialect {
+	case dbkit.DialectMSSQL:
+		query = fmt.Sprintf("IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s ("+columns+")",
+			strings.ReplaceAll(table, "'", "''"), table, "DATETIME2")
+	case dbkit.DialectMySQL:
+		query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+columns+")", table, "DATETIME(6)")
+	default:
+		query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+columns+")", table, "TIMESTAMP")
+	}
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("create seeds table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func TestSeedSet(t *testing.T) {
+	ctx := context.Background()
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "seeds.db"))
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	_, err = dbConn.Exec(`CREATE TABLE countries (code TEXT NOT NULL PRIMARY KEY, name TEXT NOT NULL)`)
+	require.NoError(t, err)
+
+	seeds := []Seed{
+		{ID: "00001_countries", SQL: []string{
+			`INSERT INTO countries (code, name) VALUES ('DE', 'Germany'), ('FR', 'France') ` +
+				`ON CONFLICT (code) DO UPDATE SET name = excluded.name`,
+		}},
+		{ID: "00002_test_countries", SQL: []string{
+			`INSERT INTO countries (code, name) VALUES ('XX', 'Testland') ON CONFLICT (code) DO NOTHING`,
+		}, Environments: []string{"dev"}},
+	}
+	requireCountriesCount := func(want int) {
+		t.Helper()
+		var count int
+		require.NoError(t, dbConn.QueryRow(`SELECT COUNT(*) FROM countries`).Scan(&count))
+		require.Equal(t, want, count)
+	}
+
+	prodSeedSet, err := NewSeedSetWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), SeedSetOpts{Environment: "prod"})
+	require.NoError(t, err)
+
+	// Only seeds matching the environment are applied.
+	n, err := prodSeedSet.Apply(ctx, seeds)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	requireCountriesCount(2)
+	appliedSeeds, err := prodSeedSet.Applied(ctx)
+	require.NoError(t, err)
+	require.Len(t, appliedSeeds, 1)
+	require.Equal(t, "00001_countries", appliedSeeds[0].ID)
+	require.Equal(t, "prod", appliedSeeds[0].Environment)
+	require.WithinDuration(t, time.Now(), appliedSeeds[0].AppliedAt, time.Minute)
+
+	// Already applied seeds are skipped.
+	n, err = prodSeedSet.Apply(ctx, seeds)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// Seeds may be re-applied, data that was changed manually is restored.
+	_, err = dbConn.Exec(`UPDATE countries SET name = 'Deutschland' WHERE code = 'DE'`)
+	require.NoError(t, err)
+	n, err = prodSeedSet.Reapply(ctx, seeds)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	var name string
+	require.NoError(t, dbConn.QueryRow(`SELECT name FROM countries WHERE code = 'DE'`).Scan(&name))
+	require.Equal(t, "Germany", name)
+	appliedSeeds, err = prodSeedSet.Applied(ctx)
+	require.NoError(t, err)
+	require.Len(t, appliedSeeds, 1)
+
+	// Seeds are tracked per environment.
+	devSeedSet, err := NewSeedSetWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), SeedSetOpts{Environment: "dev"})
+	require.NoError(t, err)
+	n, err = devSeedSet.Apply(ctx, seeds)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	requireCountriesCount(3)
+
+	// Seeds are stored in the separate bookkeeping table, migrations are not affected.
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migStatus, err := migMngr.Status()
+	require.NoError(t, err)
+	require.Empty(t, migStatus.AppliedMigrations)
+
+	_, err = prodSeedSet.Apply(ctx, []Seed{{ID: "dup"}, {ID: "dup"}})
+	require.EqualError(t, err, "seed dup is duplicated")
+	_, err = prodSeedSet.Apply(ctx, []Seed{{ID: "00003_invalid", SQL: []string{`INSERT INTO unknown VALUES (1)`}}})
+	require.ErrorContains(t, err, "apply seed 00003_invalid")
+}
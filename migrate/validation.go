@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func findValidators(migrations []Migration) map[string]Validator {
+	var validators map[string]Validator
+	for _, m := range migrations {
+		if validator, ok := m.(Validator); ok {
+			if validators == nil {
+				validators = make(map[string]Validator)
+			}
+			validators[m.ID()] = validator
+		}
+	}
+	return validators
+}
+
+// execWithValidation does the same as sql-migrate's MigrationSet.ExecMax,
+// but calls Validate for migrations that implement Validator in the same transaction before committing it.
+// Also, it records execution information of migrations if MigrationsManagerOpts.RecordExecutionInfo is enabled.
+func (mm *MigrationsManager) execWithValidation(
+	source migrate.MigrationSource, dir migrate.MigrationDirection, limit int, validators map[string]Validator,
+) (int, error) {
+	plannedMigs, _, err := mm.migSet.PlanMigration(mm.db, string(mm.Dialect), source, dir, limit)
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for _, plannedMig := range plannedMigs {
+		var validator Validator
+		if dir == migrate.Up {
+			validator = validators[plannedMig.Id]
+		}
+		if err = mm.execPlannedMigration(plannedMig, dir, validator); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (mm *MigrationsManager) execPlannedMigration(
+	plannedMig *migrate.PlannedMigration, dir migrate.MigrationDirection, validator Validator,
+) error {
+	exec := func(executor sqlExecutor) error {
+		startedAt := time.Now()
+		for _, stmt := range plannedMig.Queries {
+			stmt = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(stmt, "\n"), " "), ";")
+			if _, err := executor.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return mm.recordMigration(executor, plannedMig.Id, dir, time.Since(startedAt))
+	}
+
+	if plannedMig.DisableTransaction {
+		if validator != nil {
+			return fmt.Errorf("migration %s implements Validator, so it cannot disable transaction", plannedMig.Id)
+		}
+		if err := exec(mm.db); err != nil {
+			return fmt.Errorf("%w handling %s", err, plannedMig.Id)
+		}
+		return nil
+	}
+
+	return dbkit.DoInTx(context.Background(), mm.db, func(tx *sql.Tx) error {
+		if err := exec(tx); err != nil {
+			return fmt.Errorf("%w handling %s", err, plannedMig.Id)
+		}
+		if validator != nil {
+			if err := validator.Validate(tx); err != nil {
+				return fmt.Errorf("validate migration %s: %w", plannedMig.Id, err)
+			}
+		}
+		return nil
+	})
+}
+
+// recordMigration inserts (or deletes) the record about applied migration into the bookkeeping table
+// in the same way as sql-migrate does (plus execution information if it's enabled).
+func (mm *MigrationsManager) recordMigration(
+	executor sqlExecutor, id string, dir migrate.MigrationDirection, duration time.Duration,
+) error {
+	table := dbkit.QuoteIdentifier(mm.Dialect, mm.migSet.TableName)
+	if mm.opts.RecordExecutionInfo {
+		if err := mm.recordExecutionInfo(executor, id, dir, duration); err != nil {
+			return err
+		}
+	}
+	if dir == migrate.Up {
+		_, err := executor.Exec(fmt.Sprintf("INSERT INTO %s (id, applied_at) VALUES (%s, %s)",
+			table, dbkit.MakePlaceholder(mm.Dialect, 1), dbkit.MakePlaceholder(mm.Dialect, 2)), id, time.Now())
+		return err
+	}
+	_, err := executor.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %s", table, dbkit.MakePlaceholder(mm.Dialect, 1)), id)
+	return err
+}
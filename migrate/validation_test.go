@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+)
+
+type testMigration00002SeedTablesWithValidation struct {
+	*testMigration00002SeedTables
+	WantUsersCount int
+}
+
+func (m *testMigration00002SeedTablesWithValidation) Validate(tx *sql.Tx) error {
+	var usersCount int
+	if err := tx.QueryRow("select count(*) from users").Scan(&usersCount); err != nil {
+		return err
+	}
+	if usersCount != m.WantUsersCount {
+		return fmt.Errorf("unexpected users count %d, want %d", usersCount, m.WantUsersCount)
+	}
+	return nil
+}
+
+func TestMigrationsManager_Validator(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	validatedMigration := &testMigration00002SeedTablesWithValidation{
+		testMigration00002SeedTables: newTestMigration00002SeedTabled(),
+		WantUsersCount:               100,
+	}
+	migrations := []Migration{newTestMigration00001CreateTables(), validatedMigration}
+
+	// Validation fails, so the second migration is rolled back.
+	err = migMngr.Run(migrations, MigrationsDirectionUp)
+	require.EqualError(t, err, "validate migration 00002_seed_users_and_notes_tables: unexpected users count 5, want 100")
+	requireMigrationsApplied(t, dbConn, false, 0, 0)
+	migStatus, err := migMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 1)
+	require.Equal(t, migrations[0].ID(), migStatus.AppliedMigrations[0].ID)
+
+	// Validation succeeds.
+	validatedMigration.WantUsersCount = 5
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+	migStatus, err = migMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 2)
+
+	// Validation is not called for rollback.
+	validatedMigration.WantUsersCount = 100
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+	migStatus, err = migMngr.Status()
+	require.NoError(t, err)
+	require.Empty(t, migStatus.AppliedMigrations)
+}
@@ -0,0 +1,21 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package mssql
+
+import (
+	"testing"
+
+	"github.com/acronis/go-dbkit"
+	testing2 "github.com/acronis/go-dbkit/internal/testing"
+)
+
+func TestDeadlockErrorHandling(t *testing.T) {
+	testing2.DeadlockTest(t, dbkit.DialectMSSQL,
+		func(err error) bool {
+			return CheckMSSQLError(err, MSSQLErrDeadlock)
+		})
+}
@@ -12,6 +12,9 @@ Released under MIT license.
 package mssql
 
 import (
+	"errors"
+	"strings"
+
 	mssql "github.com/denisenkom/go-mssqldb"
 
 	"github.com/acronis/go-dbkit"
@@ -19,14 +22,62 @@ import (
 
 // nolint
 func init() {
-	dbkit.RegisterIsRetryableFunc(&mssql.Driver{}, func(err error) bool {
-		if msErr, ok := err.(mssql.Error); ok {
-			if msErr.Number == int32(MSSQLErrDeadlock) { // deadlock error
-				return true
-			}
+	dbkit.RegisterIsRetryableFunc(&mssql.Driver{}, isRetryable)
+	// Driver registered by the github.com/denisenkom/go-mssqldb/azuread package has a different type.
+	dbkit.RegisterIsRetryableFuncForName(dbkit.MSSQLAzureDriverName, isRetryable)
+	dbkit.RegisterQueryErrorClassifier(classifyQueryError)
+}
+
+func isRetryable(err error) bool {
+	if msErr, ok := err.(mssql.Error); ok {
+		if msErr.Number == int32(MSSQLErrDeadlock) { // deadlock error
+			return true
 		}
+		return isTransientErrCode(ErrCode(msErr.Number))
+	}
+	return false
+}
+
+// IsTransientMSSQLError checks if the passed error (or any error in its chain) is a transient MSSQL error
+// (e.g. Azure SQL database is temporarily unavailable or lock request timeout is exceeded),
+// so the operation may be retried.
+func IsTransientMSSQLError(err error) bool {
+	var msErr mssql.Error
+	if !errors.As(err, &msErr) {
 		return false
-	})
+	}
+	return isTransientErrCode(ErrCode(msErr.Number))
+}
+
+// isTransientErrCode checks if the MSSQL error code is in the documented list of Azure SQL transient errors.
+// See https://learn.microsoft.com/en-us/azure/azure-sql/database/troubleshoot-common-errors-issues.
+func isTransientErrCode(code ErrCode) bool {
+	switch code {
+	case MSSQLErrDatabaseUnavailable, MSSQLErrServiceError, MSSQLErrServiceBusy,
+		MSSQLErrResourceLimitReached, MSSQLErrResourceLimitReachedMin,
+		MSSQLErrCannotOpenDatabase, MSSQLErrLockRequestTimeout:
+		return true
+	}
+	return false
+}
+
+// classifyQueryError returns class of the MSSQL error or an empty string if the error is not a MSSQL one.
+func classifyQueryError(err error) dbkit.QueryErrorClass {
+	msErr, ok := err.(mssql.Error)
+	if !ok {
+		return ""
+	}
+	switch ErrCode(msErr.SQLErrorNumber()) {
+	case MSSQLErrDeadlock:
+		return dbkit.QueryErrorClassDeadlock
+	case MSSQLErrCodeUniqueViolation, MSSQLErrCodeUniqueIndexViolation:
+		return dbkit.QueryErrorClassUniqueViolation
+	case MSSQLErrObjectExists:
+		return dbkit.QueryErrorClassDuplicateTable
+	case MSSQLErrLockRequestTimeout:
+		return dbkit.QueryErrorClassTimeout
+	}
+	return dbkit.QueryErrorClassOther
 }
 
 // ErrCode defines the type for MSSQL error codes.
@@ -37,6 +88,16 @@ const (
 	MSSQLErrDeadlock                 ErrCode = 1205
 	MSSQLErrCodeUniqueViolation      ErrCode = 2627
 	MSSQLErrCodeUniqueIndexViolation ErrCode = 2601
+	MSSQLErrLockRequestTimeout       ErrCode = 1222
+	MSSQLErrObjectExists             ErrCode = 2714
+
+	// Azure SQL transient errors.
+	MSSQLErrDatabaseUnavailable     ErrCode = 40613
+	MSSQLErrServiceError            ErrCode = 40197
+	MSSQLErrServiceBusy             ErrCode = 40501
+	MSSQLErrResourceLimitReached    ErrCode = 10928
+	MSSQLErrResourceLimitReachedMin ErrCode = 10929
+	MSSQLErrCannotOpenDatabase      ErrCode = 4060
 )
 
 // CheckMSSQLError checks if the passed error relates to MSSQL and it's internal code matches the one from the argument.
@@ -46,3 +107,34 @@ func CheckMSSQLError(err error, errCode ErrCode) bool {
 	}
 	return false
 }
+
+// UniqueViolationConstraint returns name of the violated unique constraint (or unique index)
+// if the passed error (or any error in its chain) is a MSSQL unique violation error.
+// Name is parsed from the error message.
+func UniqueViolationConstraint(err error) (string, bool) {
+	var msErr mssql.Error
+	if !errors.As(err, &msErr) {
+		return "", false
+	}
+	var marker string
+	switch ErrCode(msErr.SQLErrorNumber()) {
+	case MSSQLErrCodeUniqueViolation:
+		// Violation of UNIQUE KEY constraint 'UQ_users_email'. Cannot insert duplicate key in object 'dbo.users'. ...
+		marker = " constraint '"
+	case MSSQLErrCodeUniqueIndexViolation:
+		// Cannot insert duplicate key row in object 'dbo.users' with unique index 'IX_users_email'. ...
+		marker = " with unique index '"
+	default:
+		return "", false
+	}
+	nameStart := strings.Index(msErr.Message, marker)
+	if nameStart == -1 {
+		return "", false
+	}
+	name := msErr.Message[nameStart+len(marker):]
+	nameEnd := strings.IndexByte(name, '\'')
+	if nameEnd == -1 {
+		return "", false
+	}
+	return name[:nameEnd], true
+}
@@ -8,6 +8,7 @@ package mssql
 
 import (
 	"database/sql/driver"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -23,4 +24,53 @@ func TestMSSQLIsRetryable(t *testing.T) {
 	require.True(t, isRetryable(mssql.Error{Number: 1205}))
 	require.False(t, isRetryable(driver.ErrBadConn))
 	require.True(t, isRetryable(fmt.Errorf("wrapped error: %w", mssql.Error{Number: 1205})))
+	for _, code := range []int32{40613, 40197, 40501, 10928, 10929, 4060, 1222} {
+		require.True(t, isRetryable(mssql.Error{Number: code}), "code: %d", code)
+	}
+	require.False(t, isRetryable(mssql.Error{Number: 2627}))
+}
+
+func TestIsTransientMSSQLError(t *testing.T) {
+	require.True(t, IsTransientMSSQLError(mssql.Error{Number: int32(MSSQLErrDatabaseUnavailable)}))
+	require.True(t, IsTransientMSSQLError(fmt.Errorf("wrapped error: %w", mssql.Error{Number: int32(MSSQLErrServiceBusy)})))
+	require.False(t, IsTransientMSSQLError(mssql.Error{Number: int32(MSSQLErrDeadlock)}))
+	require.False(t, IsTransientMSSQLError(errors.New("some error")))
+	require.False(t, IsTransientMSSQLError(nil))
+}
+
+func TestClassifyQueryError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want dbkit.QueryErrorClass
+	}{
+		{mssql.Error{Number: int32(MSSQLErrDeadlock)}, dbkit.QueryErrorClassDeadlock},
+		{mssql.Error{Number: int32(MSSQLErrCodeUniqueIndexViolation)}, dbkit.QueryErrorClassUniqueViolation},
+		{mssql.Error{Number: int32(MSSQLErrObjectExists)}, dbkit.QueryErrorClassDuplicateTable},
+		{fmt.Errorf("wrapped: %w", mssql.Error{Number: int32(MSSQLErrLockRequestTimeout)}), dbkit.QueryErrorClassTimeout},
+		{mssql.Error{Number: 208}, dbkit.QueryErrorClassOther},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, dbkit.ClassifyQueryError(tt.err), "error: %v", tt.err)
+	}
+}
+
+func TestUniqueViolationConstraint(t *testing.T) {
+	constraint, ok := UniqueViolationConstraint(fmt.Errorf("wrapped: %w", mssql.Error{
+		Number:  int32(MSSQLErrCodeUniqueViolation),
+		Message: "Violation of UNIQUE KEY constraint 'UQ_users_email'. Cannot insert duplicate key in object 'dbo.users'.",
+	}))
+	require.True(t, ok)
+	require.Equal(t, "UQ_users_email", constraint)
+
+	constraint, ok = UniqueViolationConstraint(mssql.Error{
+		Number:  int32(MSSQLErrCodeUniqueIndexViolation),
+		Message: "Cannot insert duplicate key row in object 'dbo.users' with unique index 'IX_users_email'.",
+	})
+	require.True(t, ok)
+	require.Equal(t, "IX_users_email", constraint)
+
+	_, ok = UniqueViolationConstraint(mssql.Error{Number: int32(MSSQLErrDeadlock)})
+	require.False(t, ok)
+	_, ok = UniqueViolationConstraint(errors.New("some error"))
+	require.False(t, ok)
 }
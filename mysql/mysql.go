@@ -9,10 +9,17 @@ Released under MIT license.
 // To register mysql as retryable func use side effect import like so:
 //
 //	import _ "github.com/acronis/go-dbkit/mysql"
+//
+// Driver flags interpolateParams, clientFoundRows, rejectReadOnly and maxAllowedPacket may be set
+// in the db.mysql.* configuration (rejectReadOnly is needed for clean failover with ProxySQL/Aurora).
+// Note that MySQL DSN contains autocommit=false by default for compatibility,
+// set db.mysql.autocommit to true to run non-transactional statements in the autocommit mode.
 package mysql
 
 import (
+	"database/sql/driver"
 	"errors"
+	"strings"
 
 	"github.com/go-sql-driver/mysql"
 
@@ -21,18 +28,72 @@ import (
 
 // nolint
 func init() {
-	dbkit.RegisterIsRetryableFunc(&mysql.MySQLDriver{}, func(err error) bool {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			switch mysqlErr.Number {
-			case uint16(MySQLErrDeadlock), uint16(MySQLErrLockTimedOut):
-				return true
-			}
-		}
-		if err == mysql.ErrInvalidConn {
+	dbkit.RegisterIsRetryableFunc(&mysql.MySQLDriver{}, isRetryable)
+	dbkit.RegisterQueryErrorClassifier(classifyQueryError)
+}
+
+// nolint: errorlint // errors are unwrapped by the caller
+func isRetryable(err error) bool {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		switch mysqlErr.Number {
+		case uint16(MySQLErrDeadlock), uint16(MySQLErrLockTimedOut):
 			return true
 		}
+	}
+	if err == mysql.ErrInvalidConn {
+		return true
+	}
+	return false
+}
+
+// RegisterClusterRetryableErrors registers additional IsRetryable func for the MySQL driver
+// that treats errors specific for Galera Cluster (WSREP) and MySQL Group Replication
+// (e.g. node is not ready yet, transaction is rolled back during commit because of the certification conflict),
+// interrupted queries and broken connections (driver.ErrBadConn, e.g. when the node is killed) as retryable.
+// mysql.ErrInvalidConn is retryable regardless of this registration.
+// It's opt-in since not every deployment is a cluster, and such errors may mean a permanent failure for a single server.
+// It should be called once (e.g. on the application startup).
+func RegisterClusterRetryableErrors() {
+	dbkit.RegisterIsRetryableFunc(&mysql.MySQLDriver{}, isClusterRetryable)
+}
+
+// nolint: errorlint // errors are unwrapped by the caller
+func isClusterRetryable(err error) bool {
+	if err == driver.ErrBadConn {
+		return true
+	}
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
 		return false
-	})
+	}
+	switch MySQLErrCode(mysqlErr.Number) {
+	case MySQLErrWSREPNotPrepared, MySQLErrErrorDuringCommit, MySQLErrTransactionRollbackDuringCommit,
+		MySQLErrQueryInterrupted:
+		return true
+	}
+	return false
+}
+
+// classifyQueryError returns class of the MySQL error or an empty string if the error is not a MySQL one.
+func classifyQueryError(err error) dbkit.QueryErrorClass {
+	if err == mysql.ErrInvalidConn { // nolint: errorlint // errors are unwrapped by the caller
+		return dbkit.QueryErrorClassConnection
+	}
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return ""
+	}
+	switch MySQLErrCode(mysqlErr.Number) {
+	case MySQLErrDeadlock:
+		return dbkit.QueryErrorClassDeadlock
+	case MySQLErrCodeDupEntry:
+		return dbkit.QueryErrorClassUniqueViolation
+	case MySQLErrTableExists:
+		return dbkit.QueryErrorClassDuplicateTable
+	case MySQLErrLockTimedOut, MySQLErrQueryInterrupted, MySQLErrQueryTimeout, MariaDBErrStatementTimeout:
+		return dbkit.QueryErrorClassTimeout
+	}
+	return dbkit.QueryErrorClassOther
 }
 
 // MySQLErrCode defines the type for MySQL error codes.
@@ -44,6 +105,15 @@ const (
 	MySQLErrCodeDupEntry MySQLErrCode = 1062
 	MySQLErrDeadlock     MySQLErrCode = 1213
 	MySQLErrLockTimedOut MySQLErrCode = 1205
+	MySQLErrTableExists  MySQLErrCode = 1050
+
+	MySQLErrQueryInterrupted   MySQLErrCode = 1317 // Query execution was interrupted (e.g. by KILL QUERY).
+	MySQLErrQueryTimeout       MySQLErrCode = 3024 // Maximum statement execution time exceeded (MySQL).
+	MariaDBErrStatementTimeout MySQLErrCode = 1969 // Query execution was interrupted (max_statement_time exceeded).
+
+	MySQLErrWSREPNotPrepared                MySQLErrCode = 1047 // WSREP has not yet prepared node for application use (Galera).
+	MySQLErrErrorDuringCommit               MySQLErrCode = 1180 // Got error during COMMIT (Galera).
+	MySQLErrTransactionRollbackDuringCommit MySQLErrCode = 3101 // Plugin instructed to rollback transaction (Group Replication).
 )
 
 // CheckMySQLError checks if the passed error relates to MySQL and it's internal code matches the one from the argument.
@@ -54,3 +124,24 @@ func CheckMySQLError(err error, errCode MySQLErrCode) bool {
 	}
 	return false
 }
+
+// DupEntryKey returns name of the unique key (index) that is violated
+// if the passed error (or any error in its chain) is a MySQL duplicate entry error.
+// Name is parsed from the error message ("Duplicate entry '...' for key '...'"),
+// table name prefix (added by MySQL 8.0.19+) is removed.
+func DupEntryKey(err error) (string, bool) {
+	var mySQLError *mysql.MySQLError
+	if !errors.As(err, &mySQLError) || mySQLError.Number != uint16(MySQLErrCodeDupEntry) {
+		return "", false
+	}
+	const keyMarker = " for key '"
+	keyStart := strings.LastIndex(mySQLError.Message, keyMarker)
+	if keyStart == -1 {
+		return "", false
+	}
+	key := strings.TrimSuffix(mySQLError.Message[keyStart+len(keyMarker):], "'")
+	if dotIdx := strings.LastIndexByte(key, '.'); dotIdx != -1 {
+		key = key[dotIdx+1:]
+	}
+	return key, true
+}
@@ -46,7 +46,35 @@ func TestMysqlIsRetryable(t *testing.T) {
 	})))
 }
 
-// TestCheckMySQLError covers behavior of CheckMySQLError func.
+func TestRegisterClusterRetryableErrors(t *testing.T) {
+	clusterErrCodes := []MySQLErrCode{
+		MySQLErrWSREPNotPrepared,
+		MySQLErrErrorDuringCommit,
+		MySQLErrTransactionRollbackDuringCommit,
+		MySQLErrQueryInterrupted,
+	}
+
+	isRetryable := dbkit.GetIsRetryable(&mysql.MySQLDriver{})
+	for _, code := range clusterErrCodes {
+		require.False(t, isRetryable(&mysql.MySQLError{Number: uint16(code)}), "code: %d", code)
+	}
+	require.False(t, isRetryable(driver.ErrBadConn))
+
+	defer dbkit.WithIsRetryable(&mysql.MySQLDriver{}, isRetryable)()
+	RegisterClusterRetryableErrors()
+
+	isRetryable = dbkit.GetIsRetryable(&mysql.MySQLDriver{})
+	for _, code := range clusterErrCodes {
+		require.True(t, isRetryable(&mysql.MySQLError{Number: uint16(code)}), "code: %d", code)
+		require.True(t, isRetryable(fmt.Errorf("wrapped error: %w", &mysql.MySQLError{Number: uint16(code)})), "code: %d", code)
+	}
+	require.True(t, isRetryable(driver.ErrBadConn))
+	require.True(t, isRetryable(fmt.Errorf("wrapped error: %w", driver.ErrBadConn)))
+	require.True(t, isRetryable(mysql.ErrInvalidConn))
+	require.True(t, isRetryable(&mysql.MySQLError{Number: uint16(MySQLErrDeadlock)}))
+	require.False(t, isRetryable(&mysql.MySQLError{Number: uint16(MySQLErrCodeDupEntry)}))
+}
+
 func TestCheckMySQLError(t *testing.T) {
 	var deadlockErr MySQLErrCode = 1213
 	sqlErr := &mysql.MySQLError{
@@ -59,3 +87,58 @@ func TestCheckMySQLError(t *testing.T) {
 	require.True(t, CheckMySQLError(sqlErr, deadlockErr))
 	require.True(t, CheckMySQLError(wrapperSQLErr, deadlockErr))
 }
+
+func TestClassifyQueryError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want dbkit.QueryErrorClass
+	}{
+		{&mysql.MySQLError{Number: uint16(MySQLErrDeadlock)}, dbkit.QueryErrorClassDeadlock},
+		{&mysql.MySQLError{Number: uint16(MySQLErrCodeDupEntry)}, dbkit.QueryErrorClassUniqueViolation},
+		{&mysql.MySQLError{Number: uint16(MySQLErrTableExists)}, dbkit.QueryErrorClassDuplicateTable},
+		{&mysql.MySQLError{Number: uint16(MySQLErrLockTimedOut)}, dbkit.QueryErrorClassTimeout},
+		{fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: uint16(MariaDBErrStatementTimeout)}), dbkit.QueryErrorClassTimeout},
+		{mysql.ErrInvalidConn, dbkit.QueryErrorClassConnection},
+		{&mysql.MySQLError{Number: 1146}, dbkit.QueryErrorClassOther},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, dbkit.ClassifyQueryError(tt.err), "error: %v", tt.err)
+	}
+}
+
+func TestDupEntryKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantKey string
+		wantOK  bool
+	}{
+		{
+			name:    "MySQL 5.7",
+			err:     &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'bob@example.com' for key 'email_uq'"},
+			wantKey: "email_uq",
+			wantOK:  true,
+		},
+		{
+			name:    "MySQL 8, wrapped",
+			err:     fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1-2' for key 'users.PRIMARY'"}),
+			wantKey: "PRIMARY",
+			wantOK:  true,
+		},
+		{
+			name: "not a dup entry error",
+			err:  &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+		},
+		{
+			name: "not a MySQL error",
+			err:  fmt.Errorf("some error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := DupEntryKey(tt.err)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.wantKey, key)
+		})
+	}
+}
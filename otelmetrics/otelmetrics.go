@@ -0,0 +1,262 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package otelmetrics provides collector of SQL queries metrics based on the OpenTelemetry metrics API.
+// It's an alternative to the Prometheus-based dbkit.MetricsCollector, instruments have the same semantic names,
+// so dashboards and alerts may be shared between services that use different backends.
+//
+// Both collectors implement dbkit.QueryMetrics interface, so they may be used with dbrutil and goquutil helpers,
+// as well as dbkit.QueryRetryMetrics, dbkit.LongTransactionMetrics and dbkit.TxAttemptsMetrics interfaces.
+package otelmetrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// Names of the instruments.
+const (
+	InstrumentQueryDuration     = "db.query.duration"
+	InstrumentQueryRetries      = "db.query.retries"
+	InstrumentLongTransactions  = "db.long_transactions"
+	InstrumentQueryErrors       = "db.query.errors"
+	InstrumentQueryRowsReturned = "db.query.rows_returned"
+	InstrumentQueryRowsAffected = "db.query.rows_affected"
+	InstrumentTxAttempts        = "db.tx.attempts"
+)
+
+// ExemplarLabelTraceID is a name of the exemplar label that contains trace ID (see TraceExemplarLabels).
+const ExemplarLabelTraceID = "trace_id"
+
+// MetricsCollectorOpts represents an options for MetricsCollector.
+type MetricsCollectorOpts struct {
+	// Namespace is a namespace for metrics. It will be prepended (with a dot) to all instrument names.
+	Namespace string
+
+	// QueryDurationBuckets is a list of explicit bucket boundaries (in seconds) for the query duration histogram.
+	// dbkit.DefaultQueryDurationBuckets is used by default.
+	QueryDurationBuckets []float64
+
+	// QueryRowsBuckets is a list of explicit bucket boundaries for the histograms of rows returned and affected by queries.
+	// dbkit.DefaultQueryRowsBuckets is used by default.
+	QueryRowsBuckets []float64
+
+	// TxAttemptsBuckets is a list of explicit bucket boundaries for the histogram of retryable transaction attempts.
+	// dbkit.DefaultTxAttemptsBuckets is used by default.
+	TxAttemptsBuckets []float64
+
+	// Attributes is a set of attributes that will be applied to all measurements.
+	Attributes []attribute.KeyValue
+
+	// DBConfig (if set) is used for adding dialect and database name attributes (see dbkit.MetricsConstLabels)
+	// to all measurements. Attributes specified in Attributes take precedence.
+	DBConfig *dbkit.Config
+}
+
+// MetricsCollector collects metrics of SQL queries via OpenTelemetry instruments.
+// It implements dbkit.QueryMetrics, dbkit.QueryRowsMetrics, dbkit.QueryRetryMetrics, dbkit.LongTransactionMetrics
+// and dbkit.TxAttemptsMetrics interfaces, so it may be used with dbrutil.QueryMetricsEventReceiver,
+// goquutil.NewQueryMetricsObserver, dbkit.StatementRetryOpts, dbkit.TxWatchdogOpts and dbrutil.RetryableTxSession.
+type MetricsCollector struct {
+	QueryDurations    metric.Float64Histogram
+	QueryRetries      metric.Int64Counter
+	LongTransactions  metric.Int64Counter
+	QueryErrors       metric.Int64Counter
+	QueryRowsReturned metric.Int64Histogram
+	QueryRowsAffected metric.Int64Histogram
+	TxAttempts        metric.Int64Histogram
+
+	attrs []attribute.KeyValue
+}
+
+var (
+	_ dbkit.QueryMetrics           = (*MetricsCollector)(nil)
+	_ dbkit.QueryRowsMetrics       = (*MetricsCollector)(nil)
+	_ dbkit.QueryRetryMetrics      = (*MetricsCollector)(nil)
+	_ dbkit.LongTransactionMetrics = (*MetricsCollector)(nil)
+	_ dbkit.TxAttemptsMetrics      = (*MetricsCollector)(nil)
+)
+
+// NewMetricsCollector creates a new metrics collector that creates instruments with the passed meter.
+func NewMetricsCollector(meter metric.Meter) (*MetricsCollector, error) {
+	return NewMetricsCollectorWithOpts(meter, MetricsCollectorOpts{})
+}
+
+// NewMetricsCollectorWithOpts is a more configurable version of creating MetricsCollector.
+func NewMetricsCollectorWithOpts(meter metric.Meter, opts MetricsCollectorOpts) (*MetricsCollector, error) {
+	queryDurationBuckets := opts.QueryDurationBuckets
+	if queryDurationBuckets == nil {
+		queryDurationBuckets = dbkit.DefaultQueryDurationBuckets
+	}
+	queryRowsBuckets := opts.QueryRowsBuckets
+	if queryRowsBuckets == nil {
+		queryRowsBuckets = dbkit.DefaultQueryRowsBuckets
+	}
+	txAttemptsBuckets := opts.TxAttemptsBuckets
+	if txAttemptsBuckets == nil {
+		txAttemptsBuckets = dbkit.DefaultTxAttemptsBuckets
+	}
+	makeName := func(name string) string {
+		if opts.Namespace == "" {
+			return name
+		}
+		return opts.Namespace + "." + name
+	}
+
+	c := &MetricsCollector{attrs: makeAttributes(opts)}
+	var err error
+	if c.QueryDurations, err = meter.Float64Histogram(makeName(InstrumentQueryDuration),
+		metric.WithDescription("A histogram of the SQL query durations."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(queryDurationBuckets...),
+	); err != nil {
+		return nil, fmt.Errorf("create %s histogram: %w", InstrumentQueryDuration, err)
+	}
+	if c.QueryRetries, err = meter.Int64Counter(makeName(InstrumentQueryRetries),
+		metric.WithDescription("A counter of the SQL query retry attempts."),
+	); err != nil {
+		return nil, fmt.Errorf("create %s counter: %w", InstrumentQueryRetries, err)
+	}
+	if c.LongTransactions, err = meter.Int64Counter(makeName(InstrumentLongTransactions),
+		metric.WithDescription("A counter of the SQL transactions that stayed open longer than a threshold."),
+	); err != nil {
+		return nil, fmt.Errorf("create %s counter: %w", InstrumentLongTransactions, err)
+	}
+	if c.QueryErrors, err = meter.Int64Counter(makeName(InstrumentQueryErrors),
+		metric.WithDescription("A counter of the SQL query errors by error class."),
+	); err != nil {
+		return nil, fmt.Errorf("create %s counter: %w", InstrumentQueryErrors, err)
+	}
+	if c.QueryRowsReturned, err = meter.Int64Histogram(makeName(InstrumentQueryRowsReturned),
+		metric.WithDescription("A histogram of the numbers of rows returned by the SQL queries."),
+		metric.WithExplicitBucketBoundaries(queryRowsBuckets...),
+	); err != nil {
+		return nil, fmt.Errorf("create %s histogram: %w", InstrumentQueryRowsReturned, err)
+	}
+	if c.QueryRowsAffected, err = meter.Int64Histogram(makeName(InstrumentQueryRowsAffected),
+		metric.WithDescription("A histogram of the numbers of rows affected by the SQL queries."),
+		metric.WithExplicitBucketBoundaries(queryRowsBuckets...),
+	); err != nil {
+		return nil, fmt.Errorf("create %s histogram: %w", InstrumentQueryRowsAffected, err)
+	}
+	if c.TxAttempts, err = meter.Int64Histogram(makeName(InstrumentTxAttempts),
+		metric.WithDescription("A histogram of the numbers of attempts that retryable SQL transactions needed."),
+		metric.WithExplicitBucketBoundaries(txAttemptsBuckets...),
+	); err != nil {
+		return nil, fmt.Errorf("create %s histogram: %w", InstrumentTxAttempts, err)
+	}
+	return c, nil
+}
+
+func makeAttributes(opts MetricsCollectorOpts) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if opts.DBConfig != nil {
+		for name, value := range dbkit.MetricsConstLabels(opts.DBConfig) {
+			if !hasAttribute(opts.Attributes, name) {
+				attrs = append(attrs, attribute.String(name, value))
+			}
+		}
+	}
+	return append(attrs, opts.Attributes...)
+}
+
+func hasAttribute(attrs []attribute.KeyValue, key string) bool {
+	for _, attr := range attrs {
+		if string(attr.Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MetricsCollector) makeAttributes(annotation string, extra ...attribute.KeyValue) metric.MeasurementOption {
+	attrs := make([]attribute.KeyValue, 0, len(c.attrs)+1+len(extra))
+	attrs = append(attrs, c.attrs...)
+	attrs = append(attrs, attribute.String(dbkit.MetricsLabelQuery, annotation))
+	attrs = append(attrs, extra...)
+	return metric.WithAttributes(attrs...)
+}
+
+// ObserveQueryDuration observes duration of the annotated SQL query.
+// Context is passed to the instrument, so exemplars may be attached by the SDK.
+func (c *MetricsCollector) ObserveQueryDuration(ctx context.Context, annotation string, duration time.Duration) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c.QueryDurations.Record(ctx, duration.Seconds(), c.makeAttributes(annotation))
+}
+
+// ObserveQueryError counts error occurred during executing the annotated SQL query.
+// Error is classified by dbkit.ClassifyQueryError. Nil error and sql.ErrNoRows are not counted.
+func (c *MetricsCollector) ObserveQueryError(annotation string, err error) {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	c.QueryErrors.Add(context.Background(), 1, c.makeAttributes(annotation,
+		attribute.String(dbkit.MetricsLabelErrorClass, string(dbkit.ClassifyQueryError(err)))))
+}
+
+// ObserveQueryRetry counts retry attempt of the annotated SQL query.
+func (c *MetricsCollector) ObserveQueryRetry(annotation string) {
+	c.QueryRetries.Add(context.Background(), 1, c.makeAttributes(annotation))
+}
+
+// ObserveLongTransaction counts the annotated SQL transaction that stayed open longer than a threshold.
+func (c *MetricsCollector) ObserveLongTransaction(annotation string) {
+	c.LongTransactions.Add(context.Background(), 1, c.makeAttributes(annotation))
+}
+
+// ObserveTxAttempts observes number of attempts that the annotated retryable transaction needed.
+// Outcome (see dbkit.MetricsLabelTxOutcome) is determined by the error of the last attempt.
+func (c *MetricsCollector) ObserveTxAttempts(annotation string, attempts int, err error) {
+	outcome := dbkit.MetricsTxOutcomeCommitted
+	if err != nil {
+		outcome = dbkit.MetricsTxOutcomeFailed
+	}
+	c.TxAttempts.Record(context.Background(), int64(attempts), c.makeAttributes(annotation,
+		attribute.String(dbkit.MetricsLabelTxOutcome, outcome)))
+}
+
+// ObserveQueryRowsReturned observes number of rows returned by the annotated SQL query.
+func (c *MetricsCollector) ObserveQueryRowsReturned(annotation string, rows int) {
+	c.QueryRowsReturned.Record(context.Background(), int64(rows), c.makeAttributes(annotation))
+}
+
+// ObserveQueryRowsAffected observes number of rows affected by the annotated SQL query.
+func (c *MetricsCollector) ObserveQueryRowsAffected(annotation string, rows int64) {
+	c.QueryRowsAffected.Record(context.Background(), rows, c.makeAttributes(annotation))
+}
+
+// WrapConnectorForRowsMetrics wraps driver.Connector for collecting numbers of rows returned and affected by the SQL queries.
+// See dbkit.WrapConnectorForRowsMetrics for more details.
+func (c *MetricsCollector) WrapConnectorForRowsMetrics(
+	connector driver.Connector, getAnnotation func(query string) string,
+) driver.Connector {
+	return dbkit.WrapConnectorForRowsMetrics(connector, c, getAnnotation)
+}
+
+// TraceExemplarLabels returns exemplar labels with ID of the sampled OpenTelemetry trace from the context.
+// Nil is returned if there is no such trace.
+// It may be used as dbkit.MetricsCollectorOpts.ExemplarLabels, so observations of the Prometheus-based
+// dbkit.MetricsCollector are linked to the active OpenTelemetry traces.
+func TraceExemplarLabels(ctx context.Context) prometheus.Labels {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.HasTraceID() || !spanCtx.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{ExemplarLabelTraceID: spanCtx.TraceID().String()}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package otelmetrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/acronis/go-dbkit"
+)
+
+type measurement struct {
+	instrument string
+	value      float64
+	attrs      attribute.Set
+}
+
+type recordingMeter struct {
+	noop.Meter
+	mu           sync.Mutex
+	measurements []measurement
+}
+
+func (m *recordingMeter) record(instrument string, value float64, attrs attribute.Set) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.measurements = append(m.measurements, measurement{instrument: instrument, value: value, attrs: attrs})
+}
+
+func (m *recordingMeter) Float64Histogram(name string, _ ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	return &recordingFloat64Histogram{meter: m, name: name}, nil
+}
+
+func (m *recordingMeter) Int64Histogram(name string, _ ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	return &recordingInt64Histogram{meter: m, name: name}, nil
+}
+
+func (m *recordingMeter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return &recordingInt64Counter{meter: m, name: name}, nil
+}
+
+type recordingFloat64Histogram struct {
+	noop.Float64Histogram
+	meter *recordingMeter
+	name  string
+}
+
+func (h *recordingFloat64Histogram) Record(_ context.Context, value float64, opts ...metric.RecordOption) {
+	h.meter.record(h.name, value, metric.NewRecordConfig(opts).Attributes())
+}
+
+type recordingInt64Histogram struct {
+	noop.Int64Histogram
+	meter *recordingMeter
+	name  string
+}
+
+func (h *recordingInt64Histogram) Record(_ context.Context, value int64, opts ...metric.RecordOption) {
+	h.meter.record(h.name, float64(value), metric.NewRecordConfig(opts).Attributes())
+}
+
+type recordingInt64Counter struct {
+	noop.Int64Counter
+	meter *recordingMeter
+	name  string
+}
+
+func (c *recordingInt64Counter) Add(_ context.Context, value int64, opts ...metric.AddOption) {
+	c.meter.record(c.name, float64(value), metric.NewAddConfig(opts).Attributes())
+}
+
+func TestMetricsCollector(t *testing.T) {
+	meter := &recordingMeter{}
+	mc, err := NewMetricsCollectorWithOpts(meter, MetricsCollectorOpts{
+		Namespace:  "users_api",
+		Attributes: []attribute.KeyValue{attribute.String("service", "users-api")},
+		DBConfig:   &dbkit.Config{Dialect: dbkit.DialectPgx, Postgres: dbkit.PostgresConfig{Database: "users_db"}},
+	})
+	require.NoError(t, err)
+
+	mc.ObserveQueryDuration(context.Background(), "select_users", 5*time.Millisecond)
+	mc.ObserveQueryError("select_users", nil)
+	mc.ObserveQueryError("select_users", sql.ErrNoRows)
+	mc.ObserveQueryError("select_users", context.DeadlineExceeded)
+	mc.ObserveQueryRetry("select_users")
+	mc.ObserveLongTransaction("update_users")
+	mc.ObserveQueryRowsReturned("select_users", 3)
+	mc.ObserveQueryRowsAffected("update_users", 2)
+	mc.ObserveTxAttempts("update_users", 2, nil)
+
+	wantAttrs := func(annotation string, extra ...attribute.KeyValue) attribute.Set {
+		return attribute.NewSet(append([]attribute.KeyValue{
+			attribute.String("service", "users-api"),
+			attribute.String(dbkit.MetricsLabelDialect, "pgx"),
+			attribute.String(dbkit.MetricsLabelDatabase, "users_db"),
+			attribute.String(dbkit.MetricsLabelQuery, annotation),
+		}, extra...)...)
+	}
+	require.Equal(t, []measurement{
+		{"users_api." + InstrumentQueryDuration, 0.005, wantAttrs("select_users")},
+		{"users_api." + InstrumentQueryErrors, 1, wantAttrs("select_users",
+			attribute.String(dbkit.MetricsLabelErrorClass, string(dbkit.QueryErrorClassTimeout)))},
+		{"users_api." + InstrumentQueryRetries, 1, wantAttrs("select_users")},
+		{"users_api." + InstrumentLongTransactions, 1, wantAttrs("update_users")},
+		{"users_api." + InstrumentQueryRowsReturned, 3, wantAttrs("select_users")},
+		{"users_api." + InstrumentQueryRowsAffected, 2, wantAttrs("update_users")},
+		{"users_api." + InstrumentTxAttempts, 2, wantAttrs("update_users",
+			attribute.String(dbkit.MetricsLabelTxOutcome, dbkit.MetricsTxOutcomeCommitted))},
+	}, meter.measurements)
+}
+
+type failingMeter struct {
+	noop.Meter
+}
+
+func (failingMeter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return nil, errors.New("invalid instrument")
+}
+
+func TestNewMetricsCollector_Error(t *testing.T) {
+	_, err := NewMetricsCollector(failingMeter{})
+	require.EqualError(t, err, "create db.query.retries counter: invalid instrument")
+}
+
+func TestMetricsCollector_TxWatchdog(t *testing.T) {
+	meter := &recordingMeter{}
+	mc, err := NewMetricsCollector(meter)
+	require.NoError(t, err)
+
+	watchdog := dbkit.NewTxWatchdog(dbkit.TxWatchdogOpts{Threshold: time.Millisecond, MetricsCollector: mc})
+	stop := watchdog.Watch("update_users")
+	require.Eventually(t, func() bool {
+		meter.mu.Lock()
+		defer meter.mu.Unlock()
+		return len(meter.measurements) == 1
+	}, time.Second, 5*time.Millisecond)
+	stop()
+	require.Equal(t, InstrumentLongTransactions, meter.measurements[0].instrument)
+}
+
+func TestTraceExemplarLabels(t *testing.T) {
+	traceID := trace.TraceID{0x0a, 0x0b}
+	makeCtx := func(flags trace.TraceFlags) context.Context {
+		return trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{0x01},
+			TraceFlags: flags,
+		}))
+	}
+
+	require.Equal(t, prometheus.Labels{ExemplarLabelTraceID: traceID.String()}, TraceExemplarLabels(makeCtx(trace.FlagsSampled)))
+	require.Nil(t, TraceExemplarLabels(makeCtx(0)), "trace is not sampled")
+	require.Nil(t, TraceExemplarLabels(context.Background()))
+
+	// Observations of the Prometheus-based collector are linked to the traces.
+	mc := dbkit.NewMetricsCollectorWithOpts(dbkit.MetricsCollectorOpts{ExemplarLabels: TraceExemplarLabels})
+	mc.ObserveQueryDuration(makeCtx(trace.FlagsSampled), "select_users", 5*time.Millisecond)
+	var metric dto.Metric
+	hist := mc.QueryDurations.With(prometheus.Labels{dbkit.MetricsLabelQuery: "select_users"}).(prometheus.Histogram)
+	require.NoError(t, hist.Write(&metric))
+	var exemplars []*dto.Exemplar
+	for _, bucket := range metric.GetHistogram().GetBucket() {
+		if bucket.GetExemplar() != nil {
+			exemplars = append(exemplars, bucket.GetExemplar())
+		}
+	}
+	require.Len(t, exemplars, 1)
+	require.Equal(t, ExemplarLabelTraceID, exemplars[0].GetLabel()[0].GetName())
+	require.Equal(t, traceID.String(), exemplars[0].GetLabel()[0].GetValue())
+}
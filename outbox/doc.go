@@ -0,0 +1,14 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+// Package outbox implements the transactional outbox pattern on top of the SQL database
+// (PostgreSQL, MySQL and SQLite are currently supported).
+// Messages are written into the outbox table within the same transaction as the business data (see Outbox.WriteInTx),
+// and Poller reads them in batches and passes them to the user-defined handler (e.g. publishing to the message broker).
+// Messages are deleted only after successful handling, so they are delivered at least once.
+// Several pollers may work with the same table concurrently since rows are locked with FOR UPDATE SKIP LOCKED
+// (PostgreSQL and MySQL 8.0+).
+package outbox
@@ -0,0 +1,202 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+// DefaultTableName is a name of the table that is used for storing messages by default.
+const DefaultTableName = "outbox_messages"
+
+// CreateTableMigrationID is an ID of the migration returned by Outbox.Migrations.
+const CreateTableMigrationID = "outbox_00001_create_table"
+
+// Message represents a message stored in the outbox.
+type Message struct {
+	// ID is assigned by the database and it reflects the order in which messages were written.
+	ID int64
+	// Topic is a destination of the message (e.g. topic or queue name of the message broker).
+	Topic string
+	// Key is an optional key of the message (e.g. partition key).
+	Key     string
+	Payload []byte
+	// CreatedAt is set by WriteInTx.
+	CreatedAt time.Time
+}
+
+// Opts represents an options for Outbox.
+type Opts struct {
+	// TableName is a name of the table where messages are stored. DefaultTableName is used by default.
+	TableName string
+}
+
+// Outbox provides writing messages into the outbox table.
+type Outbox struct {
+	dialect dbkit.Dialect
+	queries dbQueries
+}
+
+// New creates a new Outbox for the specified dialect.
+func New(dialect dbkit.Dialect) (*Outbox, error) {
+	return NewWithOpts(dialect, Opts{})
+}
+
+// NewWithOpts is a more configurable version of the New.
+func NewWithOpts(dialect dbkit.Dialect, opts Opts) (*Outbox, error) {
+	if opts.TableName == "" {
+		opts.TableName = DefaultTableName
+	}
+	q, err := newDBQueries(dialect, opts.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &Outbox{dialect: dialect, queries: q}, nil
+}
+
+// Migrations returns set of migrations that must be applied before using the outbox.
+func (o *Outbox) Migrations() []migrate.Migration {
+	return []migrate.Migration{
+		migrate.NewCustomMigration(CreateTableMigrationID, []string{o.queries.createTable}, []string{o.queries.dropTable}, nil, nil),
+	}
+}
+
+// WriteInTx writes messages into the outbox. It's supposed to be called within the transaction
+// that changes the business data, so messages are enqueued only if the transaction is committed.
+func (o *Outbox) WriteInTx(ctx context.Context, tx SQLExecutor, messages ...Message) error {
+	now := time.Now().UTC()
+	for i := range messages {
+		if messages[i].Topic == "" {
+			return fmt.Errorf("message #%d: topic cannot be empty", i)
+		}
+		payload := messages[i].Payload
+		if payload == nil {
+			payload = []byte{}
+		}
+		if _, err := tx.ExecContext(ctx, o.queries.insert, messages[i].Topic, messages[i].Key, payload, now); err != nil {
+			return fmt.Errorf("write outbox message: %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) fetchBatch(ctx context.Context, tx *sql.Tx, limit int) ([]Message, error) {
+	rows, err := tx.QueryContext(ctx, o.queries.selectBatch, limit)
+	if err != nil {
+		return nil, fmt.Errorf("select outbox messages: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err = rows.Scan(&msg.ID, &msg.Topic, &msg.Key, &msg.Payload, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan outbox message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read outbox messages: %w", err)
+	}
+	return messages, nil
+}
+
+func (o *Outbox) deleteBatch(ctx context.Context, tx *sql.Tx, messages []Message) error {
+	placeholders := make([]string, 0, len(messages))
+	args := make([]interface{}, 0, len(messages))
+	for i := range messages {
+		placeholders = append(placeholders, dbkit.MakePlaceholder(o.dialect, i+1))
+		args = append(args, messages[i].ID)
+	}
+	query := fmt.Sprintf(o.queries.deleteBatch, strings.Join(placeholders, ", "))
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("delete outbox messages: %w", err)
+	}
+	return nil
+}
+
+// SQLExecutor is an interface for executing SQL statements (*sql.Tx implements it).
+type SQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type dbQueries struct {
+	createTable string
+	dropTable   string
+	insert      string
+	selectBatch string
+	deleteBatch string // with %s for the list of placeholders
+}
+
+func newDBQueries(dialect dbkit.Dialect, tableName string) (dbQueries, error) {
+	if err := dbkit.ValidateIdentifier(dialect, tableName); err != nil {
+		return dbQueries{}, fmt.Errorf("table name: %w", err)
+	}
+	table := dbkit.QuoteIdentifier(dialect, tableName)
+	// Table name is formatted in advance, "%%s" becomes a verb for the list of placeholders.
+	deleteBatch := fmt.Sprintf(deleteBatchQuery, table)
+	switch dialect {
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		return dbQueries{
+			createTable: fmt.Sprintf(postgresCreateTableQuery, table),
+			dropTable:   fmt.Sprintf(dropTableQuery, table),
+			insert:      fmt.Sprintf(postgresInsertQuery, table),
+			selectBatch: fmt.Sprintf(postgresSelectBatchQuery, table),
+			deleteBatch: deleteBatch,
+		}, nil
+	case dbkit.DialectMySQL:
+		return dbQueries{
+			createTable: fmt.Sprintf(mySQLCreateTableQuery, table),
+			dropTable:   fmt.Sprintf(dropTableQuery, table),
+			insert:      fmt.Sprintf(insertQuery, table),
+			selectBatch: fmt.Sprintf(mySQLSelectBatchQuery, table),
+			deleteBatch: deleteBatch,
+		}, nil
+	case dbkit.DialectSQLite:
+		return dbQueries{
+			createTable: fmt.Sprintf(sqliteCreateTableQuery, table),
+			dropTable:   fmt.Sprintf(dropTableQuery, table),
+			insert:      fmt.Sprintf(insertQuery, table),
+			selectBatch: fmt.Sprintf(sqliteSelectBatchQuery, table),
+			deleteBatch: deleteBatch,
+		}, nil
+	default:
+		return dbQueries{}, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+}
+
+const (
+	dropTableQuery   = `DROP TABLE IF EXISTS %s;`
+	insertQuery      = `INSERT INTO %s (topic, msg_key, payload, created_at) VALUES (?, ?, ?, ?);`
+	deleteBatchQuery = `DELETE FROM %s WHERE id IN (%%s);`
+)
+
+//nolint:lll
+const (
+	postgresCreateTableQuery = `CREATE TABLE %s (id bigserial PRIMARY KEY, topic varchar(255) NOT NULL, msg_key varchar(255) NOT NULL, payload bytea NOT NULL, created_at timestamptz NOT NULL);`
+	postgresInsertQuery      = `INSERT INTO %s (topic, msg_key, payload, created_at) VALUES ($1, $2, $3, $4);`
+	postgresSelectBatchQuery = `SELECT id, topic, msg_key, payload, created_at FROM %s ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED;`
+)
+
+//nolint:lll
+const (
+	mySQLCreateTableQuery = `CREATE TABLE %s (id BIGINT AUTO_INCREMENT PRIMARY KEY, topic VARCHAR(255) NOT NULL, msg_key VARCHAR(255) NOT NULL, payload LONGBLOB NOT NULL, created_at DATETIME(6) NOT NULL);`
+	mySQLSelectBatchQuery = `SELECT id, topic, msg_key, payload, created_at FROM %s ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED;`
+)
+
+//nolint:lll
+const (
+	sqliteCreateTableQuery = `CREATE TABLE %s (id INTEGER PRIMARY KEY AUTOINCREMENT, topic VARCHAR(255) NOT NULL, msg_key VARCHAR(255) NOT NULL, payload BLOB NOT NULL, created_at TIMESTAMP NOT NULL);`
+	sqliteSelectBatchQuery = `SELECT id, topic, msg_key, payload, created_at FROM %s ORDER BY id LIMIT ?;`
+)
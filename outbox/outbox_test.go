@@ -0,0 +1,162 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	ob, err := New(dbkit.DialectSQLite)
+	require.NoError(t, err)
+	migMngr, err := migrate.NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(ob.Migrations(), migrate.MigrationsDirectionUp))
+
+	writeMessages := func(t *testing.T, messages ...Message) {
+		t.Helper()
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return ob.WriteInTx(ctx, tx, messages...)
+		}))
+	}
+
+	t.Run("messages are not written if transaction is rolled back", func(t *testing.T) {
+		txErr := errors.New("business logic error")
+		require.ErrorIs(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			if err := ob.WriteInTx(ctx, tx, Message{Topic: "users", Payload: []byte("created")}); err != nil {
+				return err
+			}
+			return txErr
+		}), txErr)
+
+		poller := NewPoller(dbConn, ob, func(ctx context.Context, messages []Message) error {
+			t.Fatalf("handler must not be called")
+			return nil
+		})
+		handled, err := poller.PollOnce(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 0, handled)
+	})
+
+	t.Run("messages are handled in batches and deleted", func(t *testing.T) {
+		writeMessages(t,
+			Message{Topic: "users", Key: "1", Payload: []byte("created")},
+			Message{Topic: "users", Key: "1", Payload: []byte("updated")},
+			Message{Topic: "orders", Key: "7", Payload: []byte("created")},
+		)
+
+		var batches [][]Message
+		poller := NewPollerWithOpts(dbConn, ob, func(ctx context.Context, messages []Message) error {
+			batches = append(batches, messages)
+			return nil
+		}, PollerOpts{BatchSize: 2})
+
+		handled, err := poller.PollOnce(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, handled)
+		handled, err = poller.PollOnce(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, handled)
+		handled, err = poller.PollOnce(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 0, handled)
+
+		require.Len(t, batches, 2)
+		require.Len(t, batches[0], 2)
+		require.Equal(t, "users", batches[0][0].Topic)
+		require.Equal(t, "1", batches[0][0].Key)
+		require.Equal(t, []byte("created"), batches[0][0].Payload)
+		require.False(t, batches[0][0].CreatedAt.IsZero())
+		require.Equal(t, []byte("updated"), batches[0][1].Payload)
+		require.Less(t, batches[0][0].ID, batches[0][1].ID)
+		require.Equal(t, "orders", batches[1][0].Topic)
+
+		require.Equal(t, 3.0, testutil.ToFloat64(poller.MessagesHandled))
+		require.Equal(t, 0.0, testutil.ToFloat64(poller.BatchesFailed))
+	})
+
+	t.Run("messages are kept if handler fails", func(t *testing.T) {
+		writeMessages(t, Message{Topic: "users", Payload: []byte("deleted")})
+
+		handlerErr := errors.New("broker is unavailable")
+		failingPoller := NewPoller(dbConn, ob, func(ctx context.Context, messages []Message) error {
+			return handlerErr
+		})
+		_, err := failingPoller.PollOnce(ctx)
+		require.ErrorIs(t, err, handlerErr)
+		require.Equal(t, 1.0, testutil.ToFloat64(failingPoller.BatchesFailed))
+
+		var handledMessages []Message
+		poller := NewPoller(dbConn, ob, func(ctx context.Context, messages []Message) error {
+			handledMessages = append(handledMessages, messages...)
+			return nil
+		})
+		handled, err := poller.PollOnce(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, handled)
+		require.Equal(t, []byte("deleted"), handledMessages[0].Payload)
+	})
+
+	t.Run("run until context is canceled", func(t *testing.T) {
+		writeMessages(t, Message{Topic: "users"}, Message{Topic: "users"}, Message{Topic: "users"})
+
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var mu sync.Mutex
+		var handledCount int
+		poller := NewPollerWithOpts(dbConn, ob, func(ctx context.Context, messages []Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handledCount += len(messages)
+			return nil
+		}, PollerOpts{BatchSize: 1, PollInterval: 10 * time.Millisecond})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			poller.Run(runCtx)
+		}()
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return handledCount == 3
+		}, time.Second*5, time.Millisecond*10)
+		cancel()
+		<-done
+	})
+
+	require.EqualError(t, ob.WriteInTx(ctx, dbConn, Message{}), "message #0: topic cannot be empty")
+	require.NoError(t, migMngr.Run(ob.Migrations(), migrate.MigrationsDirectionDown))
+}
+
+func TestNew(t *testing.T) {
+	for _, dialect := range []dbkit.Dialect{dbkit.DialectPostgres, dbkit.DialectPgx, dbkit.DialectMySQL, dbkit.DialectSQLite} {
+		_, err := New(dialect)
+		require.NoError(t, err, dialect)
+	}
+	_, err := New(dbkit.DialectMSSQL)
+	require.EqualError(t, err, `unsupported sql dialect "mssql"`)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// Default values of the PollerOpts.
+const (
+	DefaultPollerBatchSize    = 100
+	DefaultPollerPollInterval = time.Second
+)
+
+// DefaultBatchDurationBuckets is default buckets into which observations of handling batches of messages are counted.
+var DefaultBatchDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Handler handles a batch of messages (e.g. publishes them to the message broker).
+// If error is returned, the whole batch will be passed to the handler again later,
+// so handling should be idempotent (messages are delivered at least once).
+type Handler func(ctx context.Context, messages []Message) error
+
+// PollerOpts represents an options for Poller.
+type PollerOpts struct {
+	// BatchSize is a maximum number of messages passed to the handler at once. DefaultPollerBatchSize is used by default.
+	BatchSize int
+
+	// PollInterval is an interval between polls when the outbox is empty or handling failed.
+	// DefaultPollerPollInterval is used by default.
+	PollInterval time.Duration
+
+	// Logger (if set) is used for logging failures of polling.
+	Logger log.FieldLogger
+
+	// MetricsNamespace is a namespace for metrics. It will be prepended to all metric names.
+	MetricsNamespace string
+}
+
+// Poller reads messages from the outbox in batches and passes them to the handler.
+// Each batch is read, handled and deleted in a single transaction, so the rows are locked
+// and other pollers skip them while the handler is working.
+type Poller struct {
+	// MessagesHandled is a counter of the successfully handled messages.
+	MessagesHandled prometheus.Counter
+	// BatchesFailed is a counter of the batches that were not handled or deleted because of errors.
+	BatchesFailed prometheus.Counter
+	// BatchDurations is a histogram of the durations of handling batches.
+	BatchDurations prometheus.Histogram
+
+	db           *sql.DB
+	outbox       *Outbox
+	handler      Handler
+	batchSize    int
+	pollInterval time.Duration
+	logger       log.FieldLogger
+}
+
+// NewPoller creates a new Poller.
+func NewPoller(db *sql.DB, outbox *Outbox, handler Handler) *Poller {
+	return NewPollerWithOpts(db, outbox, handler, PollerOpts{})
+}
+
+// NewPollerWithOpts is a more configurable version of the NewPoller.
+func NewPollerWithOpts(db *sql.DB, outbox *Outbox, handler Handler, opts PollerOpts) *Poller {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultPollerBatchSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollerPollInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.NewDisabledLogger()
+	}
+	return &Poller{
+		MessagesHandled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: opts.MetricsNamespace,
+			Name:      "db_outbox_messages_handled_total",
+			Help:      "A counter of the successfully handled outbox messages.",
+		}),
+		BatchesFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: opts.MetricsNamespace,
+			Name:      "db_outbox_batches_failed_total",
+			Help:      "A counter of the outbox batches that failed to be handled.",
+		}),
+		BatchDurations: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: opts.MetricsNamespace,
+			Name:      "db_outbox_batch_duration_seconds",
+			Help:      "A histogram of the outbox batch handling durations.",
+			Buckets:   DefaultBatchDurationBuckets,
+		}),
+		db:           db,
+		outbox:       outbox,
+		handler:      handler,
+		batchSize:    opts.BatchSize,
+		pollInterval: opts.PollInterval,
+		logger:       opts.Logger,
+	}
+}
+
+// PollOnce reads a single batch of messages, passes it to the handler and deletes handled messages.
+// Number of handled messages is returned.
+func (p *Poller) PollOnce(ctx context.Context) (int, error) {
+	startTime := time.Now()
+	var handled int
+	err := dbkit.DoInTx(ctx, p.db, func(tx *sql.Tx) error {
+		messages, err := p.outbox.fetchBatch(ctx, tx, p.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		if err = p.handler(ctx, messages); err != nil {
+			return fmt.Errorf("handle outbox messages: %w", err)
+		}
+		if err = p.outbox.deleteBatch(ctx, tx, messages); err != nil {
+			return err
+		}
+		handled = len(messages)
+		return nil
+	})
+	if err != nil {
+		p.BatchesFailed.Inc()
+		return 0, err
+	}
+	if handled != 0 {
+		p.MessagesHandled.Add(float64(handled))
+		p.BatchDurations.Observe(time.Since(startTime).Seconds())
+	}
+	return handled, nil
+}
+
+// Run polls the outbox until the passed context is canceled. Usually it's called in a separate goroutine.
+// Batches are read one by one while the outbox is full, otherwise Poller waits for PollerOpts.PollInterval.
+func (p *Poller) Run(ctx context.Context) {
+	for {
+		handled, err := p.PollOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			p.logger.Error("outbox polling failed", log.Error(err))
+		}
+		if err == nil && handled == p.batchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+// MustRegister does registration of metrics collector in Prometheus and panics if any error occurs.
+func (p *Poller) MustRegister() {
+	prometheus.MustRegister(p.MessagesHandled, p.BatchesFailed, p.BatchDurations)
+}
+
+// Unregister cancels registration of metrics collector in Prometheus.
+func (p *Poller) Unregister() {
+	prometheus.Unregister(p.MessagesHandled)
+	prometheus.Unregister(p.BatchesFailed)
+	prometheus.Unregister(p.BatchDurations)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package pgx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	pg "github.com/jackc/pgx/v4/stdlib"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// BulkLoadOpts represents options for BulkLoadWithOpts.
+type BulkLoadOpts struct {
+	// FallbackDialect is a dialect that is used for multi-row INSERT statements (see dbkit.BulkInsert)
+	// if the database is opened not via pgx driver. dbkit.DialectPostgres is used by default.
+	FallbackDialect dbkit.Dialect
+
+	// FallbackBatchSize is a maximum number of rows that are inserted by a single INSERT statement in the fallback mode.
+	FallbackBatchSize int
+}
+
+// BulkLoad loads rows into the table using Postgres COPY FROM protocol, that is much faster than INSERT statements.
+// Table name may be qualified with schema (e.g. "public.users"). Number of loaded rows is returned.
+// If the database is opened not via pgx driver, rows are inserted by multi-row INSERT statements.
+func BulkLoad(ctx context.Context, db *sql.DB, table string, columns []string, rows [][]interface{}) (int64, error) {
+	return BulkLoadWithOpts(ctx, db, table, columns, rows, BulkLoadOpts{})
+}
+
+// BulkLoadWithOpts is a more configurable version of BulkLoad.
+func BulkLoadWithOpts(
+	ctx context.Context, db *sql.DB, table string, columns []string, rows [][]interface{}, opts BulkLoadOpts,
+) (int64, error) {
+	if _, ok := db.Driver().(*pg.Driver); !ok {
+		dialect := opts.FallbackDialect
+		if dialect == "" {
+			dialect = dbkit.DialectPostgres
+		}
+		return dbkit.BulkInsertWithOpts(ctx, db, dialect, table, columns, rows, dbkit.BulkInsertOpts{BatchSize: opts.FallbackBatchSize})
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("get connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	var loaded int64
+	err = conn.Raw(func(driverConn interface{}) error {
+		stdlibConn, ok := dbkit.UnwrapDriverConn(driverConn).(*pg.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection type %T", driverConn)
+		}
+		var copyErr error
+		loaded, copyErr = stdlibConn.Conn().CopyFrom(ctx, strings.Split(table, "."), columns, pgx.CopyFromRows(rows))
+		return copyErr
+	})
+	if err != nil {
+		return 0, fmt.Errorf("copy rows into %q table: %w", table, err)
+	}
+	return loaded, nil
+}
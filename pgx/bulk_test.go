@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package pgx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	gotesting "testing"
+	"time"
+
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/dbtest"
+)
+
+func TestBulkLoad(t *gotesting.T) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Minute*2)
+	defer ctxCancel()
+
+	testDB := dbtest.MustRunAndOpen(ctx, dbkit.DialectPgx, dbtest.Opts{})
+	defer func() { require.NoError(t, testDB.Stop(ctx)) }()
+
+	t.Run("plain connection", func(t *gotesting.T) {
+		testBulkLoad(ctx, t, testDB.DB, "bulk_users")
+	})
+
+	t.Run("wrapped connector", func(t *gotesting.T) {
+		// Driver connections are wrapped by dbkit, so BulkLoad should unwrap them for using COPY FROM.
+		wrappedDB, err := dbkit.NewLeakDetector(logtest.NewLogger()).Open(testDB.Config.DriverNameAndDSN())
+		require.NoError(t, err)
+		defer func() { require.NoError(t, wrappedDB.Close()) }()
+		testBulkLoad(ctx, t, wrappedDB, "bulk_users_wrapped")
+	})
+}
+
+func testBulkLoad(ctx context.Context, t *gotesting.T, conn *sql.DB, table string) {
+	t.Helper()
+
+	_, err := conn.ExecContext(ctx, `CREATE TABLE `+table+` (id BIGINT PRIMARY KEY, name TEXT NOT NULL)`)
+	require.NoError(t, err)
+
+	const rowsNum = 10000
+	rows := make([][]interface{}, 0, rowsNum)
+	for i := 0; i < rowsNum; i++ {
+		rows = append(rows, []interface{}{int64(i + 1), fmt.Sprintf("user-%d", i+1)})
+	}
+	loaded, err := BulkLoad(ctx, conn, "public."+table, []string{"id", "name"}, rows)
+	require.NoError(t, err)
+	require.EqualValues(t, rowsNum, loaded)
+
+	var count int
+	var lastName string
+	require.NoError(t, conn.QueryRowContext(ctx, "SELECT COUNT(*), MAX(name) FROM "+table).Scan(&count, &lastName))
+	require.Equal(t, rowsNum, count)
+	require.Equal(t, "user-9999", lastName)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package pgx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Default values of the ListenerOpts.
+const (
+	DefaultListenerReconnectInitialInterval = 500 * time.Millisecond
+	DefaultListenerReconnectMaxInterval     = 30 * time.Second
+	DefaultListenerHandlerMaxRetries        = 3
+)
+
+// NotificationHandler handles notification received from the Postgres channel.
+// If error is returned, handler is retried with exponential backoff (see ListenerOpts.HandlerMaxRetries).
+type NotificationHandler func(ctx context.Context, notification *pgconn.Notification) error
+
+// ListenerOpts represents options for Listener.
+type ListenerOpts struct {
+	// Logger is used for logging connection and handling errors.
+	Logger log.FieldLogger
+
+	// ReconnectInitialInterval and ReconnectMaxInterval configure exponential backoff between reconnection attempts.
+	ReconnectInitialInterval time.Duration
+	ReconnectMaxInterval     time.Duration
+
+	// HandlerMaxRetries is a maximum number of retries of the handler that returned an error.
+	// Negative value disables retries.
+	HandlerMaxRetries int
+
+	// OnConnect (if set) is called every time the connection is (re-)established and all channels are subscribed.
+	// Notifications sent while the listener was disconnected are lost,
+	// so it's a good place for invalidating the whole cache.
+	OnConnect func(ctx context.Context) error
+}
+
+// Listener listens Postgres channels (LISTEN/NOTIFY) on the dedicated connection and calls handlers for received notifications.
+// Connection is re-established automatically (with exponential backoff) and channels are re-subscribed.
+type Listener struct {
+	connect func(ctx context.Context) (*pgx.Conn, error)
+	opts    ListenerOpts
+	logger  log.FieldLogger
+
+	mu       sync.Mutex
+	handlers map[string]NotificationHandler
+}
+
+// NewListener creates a new Listener that connects to the Postgres using the passed connection string.
+func NewListener(connString string) *Listener {
+	return NewListenerWithOpts(connString, ListenerOpts{})
+}
+
+// NewListenerWithOpts is a more configurable version of creating Listener.
+func NewListenerWithOpts(connString string, opts ListenerOpts) *Listener {
+	if opts.ReconnectInitialInterval <= 0 {
+		opts.ReconnectInitialInterval = DefaultListenerReconnectInitialInterval
+	}
+	if opts.ReconnectMaxInterval <= 0 {
+		opts.ReconnectMaxInterval = DefaultListenerReconnectMaxInterval
+	}
+	if opts.HandlerMaxRetries == 0 {
+		opts.HandlerMaxRetries = DefaultListenerHandlerMaxRetries
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.NewDisabledLogger()
+	}
+	return &Listener{
+		connect: func(ctx context.Context) (*pgx.Conn, error) {
+			return pgx.Connect(ctx, connString)
+		},
+		opts:     opts,
+		logger:   logger,
+		handlers: make(map[string]NotificationHandler),
+	}
+}
+
+// Handle registers handler for the channel. It should be called before Run.
+func (l *Listener) Handle(channel string, handler NotificationHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers[channel] = handler
+}
+
+// Run listens all channels with registered handlers until the passed context is done.
+// Notifications are handled sequentially in the order they were received.
+func (l *Listener) Run(ctx context.Context) error {
+	if len(l.channels()) == 0 {
+		return errors.New("no channels to listen")
+	}
+	reconnectBackOff := backoff.NewExponentialBackOff()
+	reconnectBackOff.InitialInterval = l.opts.ReconnectInitialInterval
+	reconnectBackOff.MaxInterval = l.opts.ReconnectMaxInterval
+	reconnectBackOff.MaxElapsedTime = 0
+	for {
+		err := l.listen(ctx, reconnectBackOff.Reset)
+		if ctx.Err() != nil {
+			return nil
+		}
+		delay := reconnectBackOff.NextBackOff()
+		l.logger.Error("postgres listener connection is lost, it will be re-established",
+			log.Error(err), log.Int64("reconnect_in_ms", delay.Milliseconds()))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (l *Listener) channels() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	channels := make([]string, 0, len(l.handlers))
+	for channel := range l.handlers {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func (l *Listener) handler(channel string) NotificationHandler {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.handlers[channel]
+}
+
+func (l *Listener) listen(ctx context.Context, onConnected func()) error {
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer func() {
+		closeCtx, closeCtxCancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer closeCtxCancel()
+		_ = conn.Close(closeCtx)
+	}()
+
+	for _, channel := range l.channels() {
+		if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("listen channel %q: %w", channel, err)
+		}
+	}
+	onConnected()
+	if l.opts.OnConnect != nil {
+		if err = l.opts.OnConnect(ctx); err != nil {
+			l.logger.Error("postgres listener OnConnect callback failed", log.Error(err))
+		}
+	}
+
+	for {
+		notification, waitErr := conn.WaitForNotification(ctx)
+		if waitErr != nil {
+			return fmt.Errorf("wait for notification: %w", waitErr)
+		}
+		l.handleNotification(ctx, notification)
+	}
+}
+
+func (l *Listener) handleNotification(ctx context.Context, notification *pgconn.Notification) {
+	handler := l.handler(notification.Channel)
+	if handler == nil {
+		return
+	}
+	var handlerBackOff backoff.BackOff = &backoff.StopBackOff{}
+	if l.opts.HandlerMaxRetries > 0 {
+		handlerBackOff = backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(l.opts.HandlerMaxRetries))
+	}
+	if err := backoff.Retry(func() error {
+		return handler(ctx, notification)
+	}, backoff.WithContext(handlerBackOff, ctx)); err != nil {
+		l.logger.Error("failed to handle postgres notification",
+			log.String("channel", notification.Channel), log.Error(err))
+	}
+}
+
+// Notify sends notification with the payload to the Postgres channel.
+func Notify(ctx context.Context, db *sql.DB, channel, payload string) error {
+	_, err := db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package pgx
+
+import (
+	"context"
+	"errors"
+	gotesting "testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+	"github.com/acronis/go-dbkit/dbtest"
+)
+
+func TestListener(t *gotesting.T) {
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Minute*2)
+	defer ctxCancel()
+
+	testDB := dbtest.MustRunAndOpen(ctx, dbkit.DialectPgx, dbtest.Opts{})
+	defer func() { require.NoError(t, testDB.Stop(ctx)) }()
+
+	connected := make(chan struct{}, 10)
+	received := make(chan string, 10)
+	listener := NewListenerWithOpts(dbkit.MakePostgresDSN(&testDB.Config.Postgres), ListenerOpts{
+		ReconnectInitialInterval: 10 * time.Millisecond,
+		OnConnect: func(ctx context.Context) error {
+			connected <- struct{}{}
+			return nil
+		},
+	})
+	failedOnce := false
+	listener.Handle("cache_invalidation", func(ctx context.Context, notification *pgconn.Notification) error {
+		if notification.Payload == "fail_once" && !failedOnce {
+			failedOnce = true
+			return errors.New("temporary error")
+		}
+		received <- notification.Payload
+		return nil
+	})
+
+	listenerCtx, listenerCtxCancel := context.WithCancel(ctx)
+	listenerDone := make(chan error)
+	go func() { listenerDone <- listener.Run(listenerCtx) }()
+
+	waitFor := func(ch <-chan struct{}) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(30 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	requireReceived := func(wantPayload string) {
+		t.Helper()
+		select {
+		case payload := <-received:
+			require.Equal(t, wantPayload, payload)
+		case <-time.After(30 * time.Second):
+			t.Fatal("notification is not received")
+		}
+	}
+
+	waitFor(connected)
+	require.NoError(t, Notify(ctx, testDB.DB, "cache_invalidation", "user:1"))
+	requireReceived("user:1")
+
+	// Failed handler is retried.
+	require.NoError(t, Notify(ctx, testDB.DB, "cache_invalidation", "fail_once"))
+	requireReceived("fail_once")
+	require.True(t, failedOnce)
+
+	// Connection is re-established and channel is re-subscribed.
+	_, err := testDB.DB.ExecContext(ctx,
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE query LIKE 'LISTEN%' AND pid <> pg_backend_pid()")
+	require.NoError(t, err)
+	waitFor(connected)
+	require.NoError(t, Notify(ctx, testDB.DB, "cache_invalidation", "user:2"))
+	requireReceived("user:2")
+
+	listenerCtxCancel()
+	require.NoError(t, <-listenerDone)
+}
+
+func TestListener_NoChannels(t *gotesting.T) {
+	require.EqualError(t, NewListener("postgres://localhost").Run(context.Background()), "no channels to listen")
+}
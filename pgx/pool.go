@@ -0,0 +1,253 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package pgx
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// NewPoolConfig creates pgxpool configuration from the dbkit configuration, so services that want native pgx performance
+// may keep using the standard "db.*" configuration.
+// DSN is made from Config.Postgres, Config.MaxOpenConns and Config.ConnMaxLifetime are mapped to the pool limits.
+// Custom dialer (Config.Postgres.Dialer) is used for establishing connections if it's specified.
+// Config.MaxIdleConns has no direct analogue in pgxpool (idle connections are closed by MaxConnIdleTime), so it's ignored.
+func NewPoolConfig(cfg *dbkit.Config) (*pgxpool.Config, error) {
+	if cfg.Dialect != dbkit.DialectPgx && cfg.Dialect != dbkit.DialectPostgres {
+		return nil, fmt.Errorf("pgxpool cannot be used for dialect %q", cfg.Dialect)
+	}
+	poolCfg, err := pgxpool.ParseConfig(dbkit.MakePgxDSN(&cfg.Postgres))
+	if err != nil {
+		return nil, fmt.Errorf("parse pgxpool config: %w", err)
+	}
+	if cfg.Postgres.Dialer != "" {
+		dial, ok := dbkit.LookupDialer(cfg.Postgres.Dialer)
+		if !ok {
+			return nil, fmt.Errorf("dialer %q is not registered", cfg.Postgres.Dialer)
+		}
+		setDialer(&poolCfg.ConnConfig.Config, dial)
+	}
+	if cfg.MaxOpenConns > 0 {
+		poolCfg.MaxConns = int32(cfg.MaxOpenConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.ConnMaxLifetime
+	}
+	return poolCfg, nil
+}
+
+// OpenPool creates a new pgxpool.Pool from the dbkit configuration (see NewPoolConfig)
+// and pings the database if the ping argument is true.
+func OpenPool(ctx context.Context, cfg *dbkit.Config, ping bool) (*pgxpool.Pool, error) {
+	poolCfg, err := NewPoolConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.ConnectConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to pgxpool: %w", err)
+	}
+	if ping {
+		if err = pool.Ping(ctx); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("ping database: %w", err)
+		}
+	}
+	return pool, nil
+}
+
+// PoolStatsCollector exports statistics of the pgxpool.Pool (similar to sql.DBStats) as Prometheus metrics.
+// It implements prometheus.Collector interface.
+type PoolStatsCollector struct {
+	pool *pgxpool.Pool
+
+	openConnectionsDesc    *prometheus.Desc
+	inUseConnectionsDesc   *prometheus.Desc
+	idleConnectionsDesc    *prometheus.Desc
+	maxOpenConnectionsDesc *prometheus.Desc
+	waitCountDesc          *prometheus.Desc
+	acquireDurationDesc    *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*PoolStatsCollector)(nil)
+
+// NewPoolStatsCollector creates a new PoolStatsCollector. Namespace will be prepended to all metric names.
+func NewPoolStatsCollector(pool *pgxpool.Pool, namespace string) *PoolStatsCollector {
+	makeDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, nil)
+	}
+	return &PoolStatsCollector{
+		pool:                   pool,
+		openConnectionsDesc:    makeDesc("db_pool_open_connections", "A number of established connections both in use and idle."),
+		inUseConnectionsDesc:   makeDesc("db_pool_in_use_connections", "A number of connections currently in use."),
+		idleConnectionsDesc:    makeDesc("db_pool_idle_connections", "A number of idle connections."),
+		maxOpenConnectionsDesc: makeDesc("db_pool_max_open_connections", "A maximum number of open connections."),
+		waitCountDesc:          makeDesc("db_pool_wait_count_total", "A total number of connections waited for."),
+		acquireDurationDesc: makeDesc("db_pool_acquire_duration_seconds_total",
+			"A total time spent on acquiring connections from the pool."),
+	}
+}
+
+// Describe implements prometheus.Collector interface.
+func (c *PoolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.openConnectionsDesc
+	ch <- c.inUseConnectionsDesc
+	ch <- c.idleConnectionsDesc
+	ch <- c.maxOpenConnectionsDesc
+	ch <- c.waitCountDesc
+	ch <- c.acquireDurationDesc
+}
+
+// Collect implements prometheus.Collector interface.
+func (c *PoolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.openConnectionsDesc, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.inUseConnectionsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConnectionsDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxOpenConnectionsDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.waitCountDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDurationDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+// PoolQuerier is implemented by pgxpool.Pool, pgxpool.Conn, pgx.Conn and pgx.Tx.
+type PoolQuerier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// QueryMetricsQuerier wraps PoolQuerier (e.g. pgxpool.Pool) and collects metrics about annotated SQL queries
+// via dbkit.QueryMetrics (Prometheus-based dbkit.MetricsCollector or otelmetrics.MetricsCollector).
+// To be collected SQL query should be annotated (comment starting with specified prefix, see dbkit.Annotate).
+// Durations of Query and QueryRow include only sending the query and receiving the first response, not reading rows.
+type QueryMetricsQuerier struct {
+	querier          PoolQuerier
+	metricsCollector dbkit.QueryMetrics
+	annotationPrefix string
+}
+
+var _ PoolQuerier = (*QueryMetricsQuerier)(nil)
+
+// NewQueryMetricsQuerier creates a new QueryMetricsQuerier.
+func NewQueryMetricsQuerier(querier PoolQuerier, mc dbkit.QueryMetrics, annotationPrefix string) *QueryMetricsQuerier {
+	return &QueryMetricsQuerier{querier: querier, metricsCollector: mc, annotationPrefix: annotationPrefix}
+}
+
+// Exec executes the query and observes its duration and error.
+func (q *QueryMetricsQuerier) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	startTime := time.Now()
+	tag, err := q.querier.Exec(ctx, sql, arguments...)
+	q.observe(ctx, sql, startTime, err)
+	return tag, err
+}
+
+// Query executes the query and observes its duration and error.
+func (q *QueryMetricsQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	startTime := time.Now()
+	rows, err := q.querier.Query(ctx, sql, args...)
+	q.observe(ctx, sql, startTime, err)
+	return rows, err
+}
+
+// QueryRow executes the query and observes its duration. Errors are returned on scanning, so they are not observed.
+func (q *QueryMetricsQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	startTime := time.Now()
+	row := q.querier.QueryRow(ctx, sql, args...)
+	q.observe(ctx, sql, startTime, nil)
+	return row
+}
+
+func (q *QueryMetricsQuerier) observe(ctx context.Context, sql string, startTime time.Time, err error) {
+	annotation := dbkit.ParseAnnotationInQuery(sql, q.annotationPrefix, nil)
+	if annotation == "" {
+		return
+	}
+	q.metricsCollector.ObserveQueryDuration(ctx, annotation, time.Since(startTime))
+	q.metricsCollector.ObserveQueryError(annotation, err)
+}
+
+// PoolPinger is implemented by pgxpool.Pool.
+type PoolPinger interface {
+	Ping(ctx context.Context) error
+}
+
+// PoolHealthCheckerOpts represents an options for PoolHealthChecker.
+type PoolHealthCheckerOpts struct {
+	// CheckInterval is an interval between health checks. dbkit.DefaultRouterHealthCheckInterval is used by default.
+	CheckInterval time.Duration
+
+	// CheckTimeout is a timeout for a single health check. dbkit.DefaultRouterHealthCheckTimeout is used by default.
+	CheckTimeout time.Duration
+}
+
+// PoolHealthChecker periodically pings the pool and keeps its health status,
+// the same way as dbkit.Router does it for the read-only *sql.DB endpoint.
+type PoolHealthChecker struct {
+	pinger        PoolPinger
+	checkInterval time.Duration
+	checkTimeout  time.Duration
+	healthy       atomic.Bool
+}
+
+// NewPoolHealthChecker creates a new PoolHealthChecker. Pool is considered healthy until the first failed health check.
+func NewPoolHealthChecker(pinger PoolPinger) *PoolHealthChecker {
+	return NewPoolHealthCheckerWithOpts(pinger, PoolHealthCheckerOpts{})
+}
+
+// NewPoolHealthCheckerWithOpts is a more configurable version of the NewPoolHealthChecker.
+func NewPoolHealthCheckerWithOpts(pinger PoolPinger, opts PoolHealthCheckerOpts) *PoolHealthChecker {
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = dbkit.DefaultRouterHealthCheckInterval
+	}
+	if opts.CheckTimeout == 0 {
+		opts.CheckTimeout = dbkit.DefaultRouterHealthCheckTimeout
+	}
+	c := &PoolHealthChecker{pinger: pinger, checkInterval: opts.CheckInterval, checkTimeout: opts.CheckTimeout}
+	c.healthy.Store(true)
+	return c
+}
+
+// IsHealthy returns true if the pool passed the last health check.
+func (c *PoolHealthChecker) IsHealthy() bool {
+	return c.healthy.Load()
+}
+
+// CheckHealth pings the pool and updates its health status.
+func (c *PoolHealthChecker) CheckHealth(ctx context.Context) error {
+	pingCtx, pingCtxCancel := context.WithTimeout(ctx, c.checkTimeout)
+	defer pingCtxCancel()
+	err := c.pinger.Ping(pingCtx)
+	if err != nil && ctx.Err() != nil {
+		return err // Health check is interrupted, so status is unknown.
+	}
+	c.healthy.Store(err == nil)
+	return err
+}
+
+// RunHealthChecks periodically checks health of the pool until the passed context is canceled.
+// Usually it's called in a separate goroutine.
+func (c *PoolHealthChecker) RunHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(c.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = c.CheckHealth(ctx)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package pgx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	gotesting "testing"
+	"time"
+
+	"github.com/acronis/go-appkit/testutil"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func TestNewPoolConfig(t *gotesting.T) {
+	cfg := &dbkit.Config{
+		Dialect:         dbkit.DialectPgx,
+		MaxOpenConns:    7,
+		MaxIdleConns:    3,
+		ConnMaxLifetime: 5 * time.Minute,
+		Postgres: dbkit.PostgresConfig{
+			Host:            "pghost",
+			Port:            5433,
+			User:            "pgadmin",
+			Password:        "pgpassword",
+			Database:        "pgdb",
+			SSLMode:         dbkit.PostgresSSLModeDisable,
+			ApplicationName: "my-service",
+		},
+	}
+	poolCfg, err := NewPoolConfig(cfg)
+	require.NoError(t, err)
+	require.Equal(t, int32(7), poolCfg.MaxConns)
+	require.Equal(t, 5*time.Minute, poolCfg.MaxConnLifetime)
+	require.Equal(t, "pghost", poolCfg.ConnConfig.Host)
+	require.Equal(t, uint16(5433), poolCfg.ConnConfig.Port)
+	require.Equal(t, "pgdb", poolCfg.ConnConfig.Database)
+	require.Equal(t, "my-service", poolCfg.ConnConfig.RuntimeParams["application_name"])
+
+	_, err = NewPoolConfig(&dbkit.Config{Dialect: dbkit.DialectMySQL})
+	require.EqualError(t, err, `pgxpool cannot be used for dialect "mysql"`)
+}
+
+func TestPoolStatsCollector(t *gotesting.T) {
+	poolCfg, err := NewPoolConfig(&dbkit.Config{Dialect: dbkit.DialectPgx, MaxOpenConns: 5, Postgres: dbkit.PostgresConfig{
+		Host: "localhost", Port: 5432, SSLMode: dbkit.PostgresSSLModeDisable,
+	}})
+	require.NoError(t, err)
+	poolCfg.LazyConnect = true
+	pool, err := pgxpool.ConnectConfig(context.Background(), poolCfg)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	collector := NewPoolStatsCollector(pool, "")
+	require.Equal(t, 6, promtestutil.CollectAndCount(collector))
+	require.NoError(t, promtestutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP db_pool_max_open_connections A maximum number of open connections.
+# TYPE db_pool_max_open_connections gauge
+db_pool_max_open_connections 5
+`), "db_pool_max_open_connections"))
+}
+
+type fakePoolQuerier struct {
+	err error
+}
+
+func (q *fakePoolQuerier) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return nil, q.err
+}
+
+func (q *fakePoolQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return nil, q.err
+}
+
+func (q *fakePoolQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return nil
+}
+
+func TestQueryMetricsQuerier(t *gotesting.T) {
+	ctx := context.Background()
+	mc := dbkit.NewMetricsCollector()
+	fakeQuerier := &fakePoolQuerier{}
+	querier := NewQueryMetricsQuerier(fakeQuerier, mc, "query_")
+
+	_, err := querier.Exec(ctx, dbkit.Annotate("DELETE FROM users", "query_delete_users"))
+	require.NoError(t, err)
+	_, err = querier.Query(ctx, dbkit.Annotate("SELECT * FROM users", "query_select_users"))
+	require.NoError(t, err)
+	_ = querier.QueryRow(ctx, dbkit.Annotate("SELECT * FROM users", "query_select_users"))
+	_ = querier.QueryRow(ctx, "SELECT * FROM users")
+	fakeQuerier.err = errors.New("syntax error")
+	_, err = querier.Exec(ctx, dbkit.Annotate("DELETE FROM users", "query_delete_users"))
+	require.ErrorIs(t, err, fakeQuerier.err)
+
+	for annotation, wantCount := range map[string]int{"query_delete_users": 2, "query_select_users": 2} {
+		hist := mc.QueryDurations.With(prometheus.Labels{dbkit.MetricsLabelQuery: annotation}).(prometheus.Histogram)
+		testutil.RequireSamplesCountInHistogram(t, hist, wantCount)
+	}
+	errorsCounter := mc.QueryErrors.With(prometheus.Labels{
+		dbkit.MetricsLabelQuery:      "query_delete_users",
+		dbkit.MetricsLabelErrorClass: string(dbkit.QueryErrorClassOther),
+	})
+	require.Equal(t, 1.0, promtestutil.ToFloat64(errorsCounter))
+}
+
+type fakePoolPinger struct {
+	err error
+}
+
+func (p *fakePoolPinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestPoolHealthChecker(t *gotesting.T) {
+	pinger := &fakePoolPinger{}
+	checker := NewPoolHealthChecker(pinger)
+	require.True(t, checker.IsHealthy())
+
+	pinger.err = errors.New("connection refused")
+	require.ErrorIs(t, checker.CheckHealth(context.Background()), pinger.err)
+	require.False(t, checker.IsHealthy())
+
+	pinger.err = nil
+	require.NoError(t, checker.CheckHealth(context.Background()))
+	require.True(t, checker.IsHealthy())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pinger.err = context.Canceled
+	require.ErrorIs(t, checker.CheckHealth(ctx), context.Canceled)
+	require.True(t, checker.IsHealthy()) // Health check is interrupted, so status is not changed.
+}
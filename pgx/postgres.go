@@ -9,10 +9,23 @@ Released under MIT license.
 // To register postgres as retryable func use side effect import like so:
 //
 //	import _ "github.com/acronis/go-dbkit/pgx"
+//
+// OpenPool opens native pgxpool.Pool from the standard dbkit.Config (pool sizes are mapped from it).
+// PoolStatsCollector, QueryMetricsQuerier and PoolHealthChecker provide pool statistics,
+// query metrics (via dbkit.QueryMetrics) and health checks for it.
+// Listener allows using Postgres LISTEN/NOTIFY (e.g. for cache invalidation): it listens channels
+// on the dedicated connection that is re-established automatically, and calls registered handlers.
+// Unlike lib/pq, password of the encrypted SSL key (db.postgres.sslPassword) is supported.
 package pgx
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	pg "github.com/jackc/pgx/v4/stdlib"
 
 	"github.com/acronis/go-dbkit"
@@ -27,6 +40,10 @@ func init() {
 				return true
 			case dbkit.PgxErrCodeSerializationFailure:
 				return true
+			case dbkit.PgxErrCodeAdminShutdown, dbkit.PgxErrCodeCannotConnectNow,
+				dbkit.PgxErrCodeConnectionFailure, dbkit.PgxErrCodeTooManyConnections:
+				// Errors that occur during failovers and restarts of the database server.
+				return true
 			}
 			if checkInvalidCachedPlanPgError(pgErr) {
 				return true
@@ -34,6 +51,50 @@ func init() {
 		}
 		return false
 	})
+	dbkit.RegisterQueryErrorClassifier(classifyQueryError)
+	dbkit.RegisterDialerConnectorFactory("pgx", newDialerConnector)
+}
+
+// newDialerConnector creates driver.Connector that establishes connections via the custom dialer (see dbkit.RegisterDialer).
+func newDialerConnector(dsn string, dial dbkit.DialFunc) (driver.Connector, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	setDialer(&connConfig.Config, dial)
+	return pg.GetConnector(*connConfig), nil
+}
+
+// setDialer makes pgconn establish connections via the custom dialer.
+// Host names are passed to the dialer as is (without resolving), since they may be meaningful only for it
+// (e.g. Cloud SQL instance connection names).
+func setDialer(cfg *pgconn.Config, dial dbkit.DialFunc) {
+	cfg.DialFunc = pgconn.DialFunc(dial)
+	cfg.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		return []string{host}, nil
+	}
+}
+
+// classifyQueryError returns class of the pgx error or an empty string if the error is not a Postgres one.
+func classifyQueryError(err error) dbkit.QueryErrorClass {
+	pgErr, ok := err.(*pgconn.PgError)
+	if !ok {
+		return ""
+	}
+	switch dbkit.PostgresErrCode(pgErr.Code) {
+	case dbkit.PgxErrCodeDeadlockDetected:
+		return dbkit.QueryErrorClassDeadlock
+	case dbkit.PgxErrCodeUniqueViolation:
+		return dbkit.QueryErrorClassUniqueViolation
+	case dbkit.PgxErrCodeDuplicateTable:
+		return dbkit.QueryErrorClassDuplicateTable
+	case dbkit.PgxErrCodeQueryCanceled, dbkit.PgxErrCodeLockNotAvailable:
+		return dbkit.QueryErrorClassTimeout
+	}
+	if strings.HasPrefix(pgErr.Code, dbkit.PostgresErrClassConnectionException) {
+		return dbkit.QueryErrorClassConnection
+	}
+	return dbkit.QueryErrorClassOther
 }
 
 // CheckPostgresError checks if the passed error relates to Postgres,
@@ -45,6 +106,16 @@ func CheckPostgresError(err error, errCode dbkit.PostgresErrCode) bool {
 	return false
 }
 
+// UniqueViolationConstraint returns name of the violated unique constraint (or unique index)
+// if the passed error (or any error in its chain) is a Postgres unique violation error.
+func UniqueViolationConstraint(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != string(dbkit.PgxErrCodeUniqueViolation) {
+		return "", false
+	}
+	return pgErr.ConstraintName, true
+}
+
 // CheckInvalidCachedPlanError checks if the passed error is related to the invalid cached plan.
 // By default, https://github.com/jackc/pgx has a cache for prepared statements
 // (https://github.com/jackc/pgx/wiki/Automatic-Prepared-Statement-Caching),
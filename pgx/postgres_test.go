@@ -9,7 +9,9 @@ package pgx
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
+	"net"
 	gotesting "testing"
 	"time"
 
@@ -63,6 +65,23 @@ func TestMakePostgresDSN(t *gotesting.T) {
 	}
 }
 
+func TestMakePostgresDSN_UnixSocket(t *gotesting.T) {
+	dsn := dbkit.MakePostgresDSN(&dbkit.PostgresConfig{
+		User:     "pgadmin",
+		Password: "pgpassword",
+		Database: "pgdb",
+		Port:     5433,
+		SSLMode:  dbkit.PostgresSSLModeDisable,
+		Socket:   "/var/run/postgresql",
+	})
+	connCfg, err := pgconn.ParseConfig(dsn)
+	require.NoError(t, err)
+	require.Equal(t, "/var/run/postgresql", connCfg.Host)
+	require.Equal(t, uint16(5433), connCfg.Port)
+	require.Equal(t, "pgdb", connCfg.Database)
+	require.Equal(t, "pgadmin", connCfg.User)
+}
+
 func TestPostgresIsRetryable(t *gotesting.T) {
 	isRetryable := dbkit.GetIsRetryable(&pg.Driver{})
 	require.NotNil(t, isRetryable)
@@ -70,6 +89,10 @@ func TestPostgresIsRetryable(t *gotesting.T) {
 	retriable := []dbkit.PostgresErrCode{
 		dbkit.PgxErrCodeDeadlockDetected,
 		dbkit.PgxErrCodeSerializationFailure,
+		dbkit.PgxErrCodeAdminShutdown,
+		dbkit.PgxErrCodeCannotConnectNow,
+		dbkit.PgxErrCodeConnectionFailure,
+		dbkit.PgxErrCodeTooManyConnections,
 	}
 	for _, code := range retriable {
 		var err error
@@ -130,3 +153,58 @@ func TestCheckInvalidCachedPlanError(t *gotesting.T) {
 	require.True(t, rows.Next())
 	require.NoError(t, rows.Close())
 }
+
+func TestClassifyQueryError(t *gotesting.T) {
+	tests := []struct {
+		err  error
+		want dbkit.QueryErrorClass
+	}{
+		{&pgconn.PgError{Code: string(dbkit.PgxErrCodeDeadlockDetected)}, dbkit.QueryErrorClassDeadlock},
+		{&pgconn.PgError{Code: string(dbkit.PgxErrCodeUniqueViolation)}, dbkit.QueryErrorClassUniqueViolation},
+		{&pgconn.PgError{Code: string(dbkit.PgxErrCodeDuplicateTable)}, dbkit.QueryErrorClassDuplicateTable},
+		{fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: string(dbkit.PgxErrCodeLockNotAvailable)}), dbkit.QueryErrorClassTimeout},
+		{&pgconn.PgError{Code: "08006"}, dbkit.QueryErrorClassConnection},
+		{&pgconn.PgError{Code: "42P01"}, dbkit.QueryErrorClassOther},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, dbkit.ClassifyQueryError(tt.err), "error: %v", tt.err)
+	}
+}
+
+func TestUniqueViolationConstraint(t *gotesting.T) {
+	constraint, ok := UniqueViolationConstraint(fmt.Errorf("wrapped: %w", &pgconn.PgError{
+		Code: string(dbkit.PgxErrCodeUniqueViolation), ConstraintName: "users_email_key",
+	}))
+	require.True(t, ok)
+	require.Equal(t, "users_email_key", constraint)
+
+	_, ok = UniqueViolationConstraint(&pgconn.PgError{Code: string(dbkit.PgxErrCodeDeadlockDetected)})
+	require.False(t, ok)
+	_, ok = UniqueViolationConstraint(fmt.Errorf("some error"))
+	require.False(t, ok)
+}
+
+func TestOpen_WithDialer(t *gotesting.T) {
+	errDial := errors.New("dial error")
+	var dialedAddr string
+	dbkit.RegisterDialer("test-pgx-dialer", func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialedAddr = addr
+		return nil, errDial
+	})
+
+	cfg := &dbkit.Config{Dialect: dbkit.DialectPgx, Postgres: dbkit.PostgresConfig{
+		Host: "db.internal", Port: 5432, User: "user", Database: "mydb", Dialer: "test-pgx-dialer",
+	}}
+	db, err := dbkit.Open(cfg, false)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.ErrorIs(t, db.Ping(), errDial)
+	require.Equal(t, "db.internal:5432", dialedAddr)
+
+	poolCfg, err := NewPoolConfig(cfg)
+	require.NoError(t, err)
+	dialedAddr = ""
+	_, err = poolCfg.ConnConfig.DialFunc(context.Background(), "tcp", "db.internal:5432")
+	require.ErrorIs(t, err, errDial)
+	require.Equal(t, "db.internal:5432", dialedAddr)
+}
@@ -0,0 +1,387 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default values of PoolManager options.
+const (
+	DefaultPoolManagerMaxPools    = 100
+	DefaultPoolManagerIdleTimeout = 10 * time.Minute
+)
+
+// MetricsLabelPool is a name of the Prometheus label that contains key of the pool managed by PoolManager.
+const MetricsLabelPool = "pool"
+
+// Reasons of closing pools by PoolManager (values of the "reason" label of the evictions counter).
+const (
+	PoolEvictionReasonLRU  = "lru"
+	PoolEvictionReasonIdle = "idle"
+)
+
+// ErrPoolManagerClosed is returned by PoolManager.Acquire when the manager is closed.
+var ErrPoolManagerClosed = errors.New("pool manager is closed")
+
+// PoolOpenFunc opens *sql.DB for the passed key (e.g. resolves DSN by the tenant ID).
+type PoolOpenFunc func(ctx context.Context, key string) (*sql.DB, error)
+
+// PoolManagerOpts represents an options for PoolManager.
+type PoolManagerOpts struct {
+	// MaxPools is a maximum number of simultaneously opened pools.
+	// When it's exceeded, the least recently used pool is closed.
+	// DefaultPoolManagerMaxPools is used by default.
+	MaxPools int
+
+	// IdleTimeout is a duration after which the pool that was not requested is closed (see PoolManager.CloseIdlePools).
+	// DefaultPoolManagerIdleTimeout is used by default. Negative value disables closing idle pools.
+	IdleTimeout time.Duration
+
+	// MetricsNamespace is a namespace for metrics. It will be prepended to all metric names.
+	MetricsNamespace string
+}
+
+// PoolManager maintains *sql.DB handles (connection pools) keyed by tenant ID or any other non-secret string.
+// Pools are opened lazily on the first request, the least recently used pools are evicted when MaxPools is exceeded,
+// and pools that were not requested for IdleTimeout are evicted by CloseIdlePools (see RunIdlePoolsClosing).
+// It's useful for multi-tenant services that connect to thousands of tenant databases.
+//
+// *sql.DB returned by Acquire is leased: the evicted pool is closed only after all its users call the release function,
+// so it should not be stored and should be acquired again for each unit of work.
+// PoolManager implements prometheus.Collector interface and exports statistics of all opened pools
+// labeled by the pool key (MetricsLabelPool). Keys are exposed as is, so they must not contain credentials
+// (i.e. DSN must not be used as a key, it should be resolved by PoolOpenFunc instead).
+type PoolManager struct {
+	open        PoolOpenFunc
+	maxPools    int
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	pools  map[string]*list.Element
+	lru    *list.List // Front is the most recently used pool.
+	closed bool
+
+	evictions              *prometheus.CounterVec
+	poolsDesc              *prometheus.Desc
+	openConnectionsDesc    *prometheus.Desc
+	inUseConnectionsDesc   *prometheus.Desc
+	idleConnectionsDesc    *prometheus.Desc
+	waitCountDesc          *prometheus.Desc
+	waitDurationDesc       *prometheus.Desc
+	maxOpenConnectionsDesc *prometheus.Desc
+}
+
+type managedPool struct {
+	key      string
+	db       *sql.DB
+	err      error
+	ready    chan struct{}
+	lastUsed time.Time
+	refs     int
+	removed  bool
+	closing  bool
+}
+
+var _ prometheus.Collector = (*PoolManager)(nil)
+
+// NewPoolManager creates a new PoolManager that opens pools with the passed function.
+func NewPoolManager(open PoolOpenFunc) *PoolManager {
+	return NewPoolManagerWithOpts(open, PoolManagerOpts{})
+}
+
+// NewPoolManagerWithOpts is a more configurable version of the NewPoolManager.
+func NewPoolManagerWithOpts(open PoolOpenFunc, opts PoolManagerOpts) *PoolManager {
+	if opts.MaxPools <= 0 {
+		opts.MaxPools = DefaultPoolManagerMaxPools
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = DefaultPoolManagerIdleTimeout
+	}
+	makeDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(opts.MetricsNamespace, "", name), help, []string{MetricsLabelPool}, nil)
+	}
+	return &PoolManager{
+		open:        open,
+		maxPools:    opts.MaxPools,
+		idleTimeout: opts.IdleTimeout,
+		pools:       make(map[string]*list.Element),
+		lru:         list.New(),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.MetricsNamespace,
+			Name:      "db_pool_evictions_total",
+			Help:      "A counter of the connection pools closed by the pool manager.",
+		}, []string{"reason"}),
+		poolsDesc: prometheus.NewDesc(prometheus.BuildFQName(opts.MetricsNamespace, "", "db_pools"),
+			"A number of the opened connection pools.", nil, nil),
+		openConnectionsDesc: makeDesc("db_pool_open_connections",
+			"A number of established connections both in use and idle."),
+		inUseConnectionsDesc: makeDesc("db_pool_in_use_connections",
+			"A number of connections currently in use."),
+		idleConnectionsDesc: makeDesc("db_pool_idle_connections",
+			"A number of idle connections."),
+		waitCountDesc: makeDesc("db_pool_wait_count_total",
+			"A total number of connections waited for."),
+		waitDurationDesc: makeDesc("db_pool_wait_duration_seconds_total",
+			"A total time blocked waiting for a new connection."),
+		maxOpenConnectionsDesc: makeDesc("db_pool_max_open_connections",
+			"A maximum number of open connections to the database."),
+	}
+}
+
+// Acquire returns *sql.DB for the passed key and the function that must be called when the caller is done with it.
+// Pool is opened if it's not opened yet. Concurrent calls for the same key wait for the single opening.
+// If opening fails, the error is returned and the next call tries to open the pool again.
+// Pool is never closed while it's acquired, even if it's evicted or the manager is closed in the meantime.
+func (m *PoolManager) Acquire(ctx context.Context, key string) (db *sql.DB, release func(), err error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, nil, ErrPoolManagerClosed
+	}
+	if elem, ok := m.pools[key]; ok {
+		m.lru.MoveToFront(elem)
+		pool := elem.Value.(*managedPool)
+		pool.lastUsed = time.Now()
+		pool.refs++
+		m.mu.Unlock()
+		select {
+		case <-pool.ready:
+			if pool.err != nil {
+				m.release(pool)
+				return nil, nil, pool.err
+			}
+			return pool.db, m.makeReleaseFunc(pool), nil
+		case <-ctx.Done():
+			m.release(pool)
+			return nil, nil, ctx.Err()
+		}
+	}
+	pool := &managedPool{key: key, ready: make(chan struct{}), lastUsed: time.Now(), refs: 1}
+	m.pools[key] = m.lru.PushFront(pool)
+	var evicted []*managedPool
+	for m.lru.Len() > m.maxPools {
+		evicted = append(evicted, m.removeLocked(m.lru.Back()))
+	}
+	evictedCount := len(evicted)
+	evicted = m.closablePoolsLocked(evicted)
+	m.mu.Unlock()
+
+	m.evictions.WithLabelValues(PoolEvictionReasonLRU).Add(float64(evictedCount))
+	_ = closePools(evicted)
+
+	db, err = m.open(ctx, key)
+
+	m.mu.Lock()
+	pool.db, pool.err = db, err
+	if err != nil && !pool.removed {
+		m.removeLocked(m.pools[key])
+	}
+	closed := m.closed
+	close(pool.ready)
+	m.mu.Unlock()
+
+	if err != nil || closed {
+		m.release(pool)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrPoolManagerClosed
+	}
+	// If the pool was evicted while opening, it's still returned and will be closed on release.
+	return db, m.makeReleaseFunc(pool), nil
+}
+
+func (m *PoolManager) makeReleaseFunc(pool *managedPool) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { m.release(pool) })
+	}
+}
+
+// release decrements the number of the pool users and closes the evicted pool when the last user releases it.
+func (m *PoolManager) release(pool *managedPool) {
+	m.mu.Lock()
+	pool.refs--
+	mustClose := m.markClosingLocked(pool)
+	m.mu.Unlock()
+	if mustClose {
+		_ = pool.db.Close()
+	}
+}
+
+// markClosingLocked returns true if the pool is evicted, opened and not used anymore,
+// so it should be closed by the caller. It returns true only once for each pool.
+func (m *PoolManager) markClosingLocked(pool *managedPool) bool {
+	if !pool.removed || pool.closing || pool.refs > 0 || pool.db == nil {
+		return false
+	}
+	select {
+	case <-pool.ready:
+	default:
+		return false // Pool is still being opened.
+	}
+	pool.closing = true
+	return true
+}
+
+// Len returns a number of pools that are opened (or being opened) at the moment.
+func (m *PoolManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// Remove closes the pool for the passed key (if it's opened), e.g. when tenant is deleted or its DSN is changed.
+// If the pool is acquired at the moment, it's closed when the last user releases it.
+func (m *PoolManager) Remove(key string) error {
+	m.mu.Lock()
+	elem, ok := m.pools[key]
+	if !ok {
+		m.mu.Unlock()
+		return nil
+	}
+	pools := m.closablePoolsLocked([]*managedPool{m.removeLocked(elem)})
+	m.mu.Unlock()
+	return closePools(pools)
+}
+
+// CloseIdlePools evicts pools that were not requested for IdleTimeout. It returns a number of evicted pools.
+// Evicted pools that are acquired at the moment are closed when the last user releases them.
+func (m *PoolManager) CloseIdlePools() int {
+	if m.idleTimeout < 0 {
+		return 0
+	}
+	m.mu.Lock()
+	var idle []*managedPool
+	for elem := m.lru.Back(); elem != nil; {
+		pool := elem.Value.(*managedPool)
+		if time.Since(pool.lastUsed) < m.idleTimeout {
+			break // Pools are ordered by the last usage time.
+		}
+		prev := elem.Prev()
+		if pool.refs == 0 { // Pool that is acquired at the moment is not idle.
+			idle = append(idle, m.removeLocked(elem))
+		}
+		elem = prev
+	}
+	closable := m.closablePoolsLocked(idle)
+	m.mu.Unlock()
+
+	m.evictions.WithLabelValues(PoolEvictionReasonIdle).Add(float64(len(idle)))
+	_ = closePools(closable)
+	return len(idle)
+}
+
+// RunIdlePoolsClosing periodically closes idle pools (see CloseIdlePools) until the passed context is canceled.
+// Usually it's called in a separate goroutine.
+func (m *PoolManager) RunIdlePoolsClosing(ctx context.Context) {
+	if m.idleTimeout < 0 {
+		return
+	}
+	ticker := time.NewTicker(m.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.CloseIdlePools()
+		}
+	}
+}
+
+// Close closes all pools. Acquire returns ErrPoolManagerClosed after that.
+// Pools that are acquired at the moment are closed when the last user releases them.
+func (m *PoolManager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	pools := make([]*managedPool, 0, m.lru.Len())
+	for m.lru.Len() > 0 {
+		pools = append(pools, m.removeLocked(m.lru.Front()))
+	}
+	pools = m.closablePoolsLocked(pools)
+	m.mu.Unlock()
+	return closePools(pools)
+}
+
+func (m *PoolManager) removeLocked(elem *list.Element) *managedPool {
+	pool := m.lru.Remove(elem).(*managedPool)
+	delete(m.pools, pool.key)
+	pool.removed = true
+	return pool
+}
+
+// closablePoolsLocked filters out the evicted pools that are still acquired or being opened.
+// Such pools are closed by the last user when it releases the pool.
+func (m *PoolManager) closablePoolsLocked(pools []*managedPool) []*managedPool {
+	closable := pools[:0]
+	for _, pool := range pools {
+		if m.markClosingLocked(pool) {
+			closable = append(closable, pool)
+		}
+	}
+	return closable
+}
+
+func closePools(pools []*managedPool) error {
+	var errs []error
+	for _, pool := range pools {
+		if err := pool.db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Describe implements prometheus.Collector interface.
+func (m *PoolManager) Describe(ch chan<- *prometheus.Desc) {
+	m.evictions.Describe(ch)
+	ch <- m.poolsDesc
+	ch <- m.openConnectionsDesc
+	ch <- m.inUseConnectionsDesc
+	ch <- m.idleConnectionsDesc
+	ch <- m.waitCountDesc
+	ch <- m.waitDurationDesc
+	ch <- m.maxOpenConnectionsDesc
+}
+
+// Collect implements prometheus.Collector interface.
+func (m *PoolManager) Collect(ch chan<- prometheus.Metric) {
+	m.evictions.Collect(ch)
+
+	m.mu.Lock()
+	dbs := make(map[string]*sql.DB, m.lru.Len())
+	for elem := m.lru.Front(); elem != nil; elem = elem.Next() {
+		pool := elem.Value.(*managedPool)
+		select {
+		case <-pool.ready:
+			dbs[pool.key] = pool.db
+		default:
+		}
+	}
+	m.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(m.poolsDesc, prometheus.GaugeValue, float64(len(dbs)))
+	for key, db := range dbs {
+		stats := db.Stats()
+		ch <- prometheus.MustNewConstMetric(m.openConnectionsDesc, prometheus.GaugeValue, float64(stats.OpenConnections), key)
+		ch <- prometheus.MustNewConstMetric(m.inUseConnectionsDesc, prometheus.GaugeValue, float64(stats.InUse), key)
+		ch <- prometheus.MustNewConstMetric(m.idleConnectionsDesc, prometheus.GaugeValue, float64(stats.Idle), key)
+		ch <- prometheus.MustNewConstMetric(m.waitCountDesc, prometheus.CounterValue, float64(stats.WaitCount), key)
+		ch <- prometheus.MustNewConstMetric(m.waitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds(), key)
+		ch <- prometheus.MustNewConstMetric(m.maxOpenConnectionsDesc, prometheus.GaugeValue,
+			float64(stats.MaxOpenConnections), key)
+	}
+}
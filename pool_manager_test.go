@@ -0,0 +1,174 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type poolManagerTestOpener struct {
+	mu     sync.Mutex
+	opened map[string]int
+	mocks  []sqlmock.Sqlmock
+	err    error
+}
+
+func (o *poolManagerTestOpener) open(_ context.Context, key string) (*sql.DB, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return nil, o.err
+	}
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		return nil, err
+	}
+	mock.ExpectClose()
+	if o.opened == nil {
+		o.opened = make(map[string]int)
+	}
+	o.opened[key]++
+	o.mocks = append(o.mocks, mock)
+	return db, nil
+}
+
+func (o *poolManagerTestOpener) requireAllClosed(t *testing.T) {
+	t.Helper()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, mock := range o.mocks {
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func requireAcquireAndRelease(t *testing.T, m *PoolManager, key string) {
+	t.Helper()
+	_, release, err := m.Acquire(context.Background(), key)
+	require.NoError(t, err)
+	release()
+}
+
+func TestPoolManager(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("lazy open and LRU eviction", func(t *testing.T) {
+		opener := &poolManagerTestOpener{}
+		m := NewPoolManagerWithOpts(opener.open, PoolManagerOpts{MaxPools: 2})
+
+		db1, release1, err := m.Acquire(ctx, "tenant1")
+		require.NoError(t, err)
+		release1()
+		db1Again, release1, err := m.Acquire(ctx, "tenant1")
+		require.NoError(t, err)
+		release1()
+		require.Same(t, db1, db1Again)
+
+		requireAcquireAndRelease(t, m, "tenant2")
+		requireAcquireAndRelease(t, m, "tenant1") // tenant2 becomes the least recently used pool.
+		requireAcquireAndRelease(t, m, "tenant3")
+		require.Equal(t, 2, m.Len())
+
+		requireAcquireAndRelease(t, m, "tenant2")
+		require.Equal(t, map[string]int{"tenant1": 1, "tenant2": 2, "tenant3": 1}, opener.opened)
+		require.Equal(t, 2.0, testutil.ToFloat64(m.evictions.WithLabelValues(PoolEvictionReasonLRU)))
+
+		require.NoError(t, m.Close())
+		opener.requireAllClosed(t)
+		_, _, err = m.Acquire(ctx, "tenant1")
+		require.ErrorIs(t, err, ErrPoolManagerClosed)
+	})
+
+	t.Run("closing idle pools", func(t *testing.T) {
+		opener := &poolManagerTestOpener{}
+		m := NewPoolManagerWithOpts(opener.open, PoolManagerOpts{IdleTimeout: 50 * time.Millisecond})
+
+		requireAcquireAndRelease(t, m, "tenant1")
+		time.Sleep(100 * time.Millisecond)
+		requireAcquireAndRelease(t, m, "tenant2")
+
+		require.Equal(t, 1, m.CloseIdlePools())
+		require.Equal(t, 1, m.Len())
+		require.Equal(t, 1.0, testutil.ToFloat64(m.evictions.WithLabelValues(PoolEvictionReasonIdle)))
+
+		require.NoError(t, m.Remove("tenant2"))
+		require.Equal(t, 0, m.Len())
+		opener.requireAllClosed(t)
+	})
+
+	t.Run("acquired pools are closed on release", func(t *testing.T) {
+		opener := &poolManagerTestOpener{}
+		m := NewPoolManagerWithOpts(opener.open, PoolManagerOpts{MaxPools: 1, IdleTimeout: time.Nanosecond})
+
+		db1, release1, err := m.Acquire(ctx, "tenant1")
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		require.Equal(t, 0, m.CloseIdlePools())
+
+		requireAcquireAndRelease(t, m, "tenant2") // tenant1 is evicted but still in use.
+		require.Equal(t, 1.0, testutil.ToFloat64(m.evictions.WithLabelValues(PoolEvictionReasonLRU)))
+		require.NoError(t, db1.PingContext(ctx))
+
+		_, release2, err := m.Acquire(ctx, "tenant2")
+		require.NoError(t, err)
+		require.NoError(t, m.Close())
+		release1()
+		release1() // Release func is idempotent.
+		release2()
+		opener.requireAllClosed(t)
+	})
+
+	t.Run("opening error", func(t *testing.T) {
+		opener := &poolManagerTestOpener{err: errors.New("unknown tenant")}
+		m := NewPoolManager(opener.open)
+
+		_, _, err := m.Acquire(ctx, "tenant1")
+		require.EqualError(t, err, "unknown tenant")
+		require.Equal(t, 0, m.Len())
+
+		opener.err = nil
+		requireAcquireAndRelease(t, m, "tenant1")
+		require.NoError(t, m.Close())
+		opener.requireAllClosed(t)
+	})
+
+	t.Run("concurrent opening", func(t *testing.T) {
+		opener := &poolManagerTestOpener{}
+		m := NewPoolManager(opener.open)
+
+		var wg sync.WaitGroup
+		dbs := make([]*sql.DB, 10)
+		for i := range dbs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var release func()
+				var err error
+				dbs[i], release, err = m.Acquire(ctx, "tenant1")
+				require.NoError(t, err)
+				release()
+			}(i)
+		}
+		wg.Wait()
+		for i := range dbs {
+			require.Same(t, dbs[0], dbs[i])
+		}
+		require.Equal(t, map[string]int{"tenant1": 1}, opener.opened)
+
+		require.Equal(t, 1, testutil.CollectAndCount(m, "db_pool_open_connections"))
+		require.NoError(t, m.Close())
+		opener.requireAllClosed(t)
+	})
+}
@@ -9,9 +9,18 @@ Released under MIT license.
 // To register postgres as retryable func use side effect import like so:
 //
 //	import _ "github.com/acronis/go-dbkit/postgres"
+//
+// Certificates for verify-ca/verify-full SSL modes may be set in the db.postgres.sslRootCert,
+// db.postgres.sslCert and db.postgres.sslKey configuration keys.
 package postgres
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"time"
+
 	pg "github.com/lib/pq"
 
 	"github.com/acronis/go-dbkit"
@@ -27,10 +36,68 @@ func init() {
 				return true
 			case dbkit.PostgresErrCodeSerializationFailure:
 				return true
+			case dbkit.PostgresErrCodeAdminShutdown, dbkit.PostgresErrCodeCannotConnectNow,
+				dbkit.PostgresErrCodeConnectionFailure, dbkit.PostgresErrCodeTooManyConnections:
+				// Errors that occur during failovers and restarts of the database server.
+				return true
 			}
 		}
 		return false
 	})
+	dbkit.RegisterQueryErrorClassifier(classifyQueryError)
+	dbkit.RegisterDialerConnectorFactory("postgres", newDialerConnector)
+}
+
+// newDialerConnector creates driver.Connector that establishes connections via the custom dialer (see dbkit.RegisterDialer).
+func newDialerConnector(dsn string, dial dbkit.DialFunc) (driver.Connector, error) {
+	connector, err := pg.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector.Dialer(pqDialer(dial))
+	return connector, nil
+}
+
+// pqDialer adapts dbkit.DialFunc to pg.Dialer and pg.DialerContext interfaces.
+type pqDialer dbkit.DialFunc
+
+// Dial implements pg.Dialer interface.
+func (d pqDialer) Dial(network, address string) (net.Conn, error) {
+	return d(context.Background(), network, address)
+}
+
+// DialTimeout implements pg.Dialer interface.
+func (d pqDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d(ctx, network, address)
+}
+
+// DialContext implements pg.DialerContext interface.
+func (d pqDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d(ctx, network, address)
+}
+
+// classifyQueryError returns class of the lib/pq error or an empty string if the error is not a Postgres one.
+func classifyQueryError(err error) dbkit.QueryErrorClass {
+	pgErr, ok := err.(*pg.Error)
+	if !ok {
+		return ""
+	}
+	switch dbkit.PostgresErrCode(pgErr.Code.Name()) {
+	case dbkit.PostgresErrCodeDeadlockDetected:
+		return dbkit.QueryErrorClassDeadlock
+	case dbkit.PostgresErrCodeUniqueViolation:
+		return dbkit.QueryErrorClassUniqueViolation
+	case dbkit.PostgresErrCodeDuplicateTable:
+		return dbkit.QueryErrorClassDuplicateTable
+	case dbkit.PostgresErrCodeQueryCanceled, dbkit.PostgresErrCodeLockNotAvailable:
+		return dbkit.QueryErrorClassTimeout
+	}
+	if string(pgErr.Code.Class()) == dbkit.PostgresErrClassConnectionException {
+		return dbkit.QueryErrorClassConnection
+	}
+	return dbkit.QueryErrorClassOther
 }
 
 // CheckPostgresError checks if the passed error relates to Postgres and it's internal code matches the one from the argument.
@@ -41,3 +108,13 @@ func CheckPostgresError(err error, errCode dbkit.PostgresErrCode) bool {
 	}
 	return false
 }
+
+// UniqueViolationConstraint returns name of the violated unique constraint (or unique index)
+// if the passed error (or any error in its chain) is a Postgres unique violation error.
+func UniqueViolationConstraint(err error) (string, bool) {
+	var pgErr *pg.Error
+	if !errors.As(err, &pgErr) || pgErr.Code.Name() != string(dbkit.PostgresErrCodeUniqueViolation) {
+		return "", false
+	}
+	return pgErr.Constraint, true
+}
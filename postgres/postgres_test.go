@@ -7,8 +7,11 @@ Released under MIT license.
 package postgres
 
 import (
+	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
+	"net"
 	"testing"
 
 	pg "github.com/lib/pq"
@@ -57,10 +60,75 @@ func TestMakePostgresDSN(t *testing.T) {
 	}
 }
 
+func TestMakePostgresDSN_UnixSocket(t *testing.T) {
+	dsn := dbkit.MakePostgresDSN(&dbkit.PostgresConfig{
+		User:     "pgadmin",
+		Password: "pgpassword",
+		Database: "pgdb",
+		Port:     5433,
+		SSLMode:  dbkit.PostgresSSLModeDisable,
+		Socket:   "/var/run/postgresql",
+	})
+	connStr, err := pg.ParseURL(dsn)
+	require.NoError(t, err)
+	require.Equal(t, "dbname='pgdb' host='/var/run/postgresql' password='pgpassword' port='5433' sslmode='disable' user='pgadmin'",
+		connStr)
+}
+
 func TestPostgresIsRetryable(t *testing.T) {
 	isRetryable := dbkit.GetIsRetryable(&pg.Driver{})
 	require.NotNil(t, isRetryable)
 	require.True(t, isRetryable(&pg.Error{Code: "40P01"}))
 	require.False(t, isRetryable(driver.ErrBadConn))
 	require.True(t, isRetryable(fmt.Errorf("wrapped error: %w", &pg.Error{Code: "40P01"})))
+	for _, code := range []pg.ErrorCode{"57P01", "57P03", "08006", "53300"} {
+		require.True(t, isRetryable(&pg.Error{Code: code}), "code: %s", code)
+	}
+	require.False(t, isRetryable(&pg.Error{Code: "42P01"}))
+}
+
+func TestClassifyQueryError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want dbkit.QueryErrorClass
+	}{
+		{&pg.Error{Code: "40P01"}, dbkit.QueryErrorClassDeadlock},
+		{&pg.Error{Code: "23505"}, dbkit.QueryErrorClassUniqueViolation},
+		{&pg.Error{Code: "42P07"}, dbkit.QueryErrorClassDuplicateTable},
+		{fmt.Errorf("wrapped: %w", &pg.Error{Code: "57014"}), dbkit.QueryErrorClassTimeout},
+		{&pg.Error{Code: "08006"}, dbkit.QueryErrorClassConnection},
+		{&pg.Error{Code: "42P01"}, dbkit.QueryErrorClassOther},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, dbkit.ClassifyQueryError(tt.err), "error: %v", tt.err)
+	}
+}
+
+func TestUniqueViolationConstraint(t *testing.T) {
+	constraint, ok := UniqueViolationConstraint(fmt.Errorf("wrapped: %w", &pg.Error{Code: "23505", Constraint: "users_email_key"}))
+	require.True(t, ok)
+	require.Equal(t, "users_email_key", constraint)
+
+	_, ok = UniqueViolationConstraint(&pg.Error{Code: "40P01"})
+	require.False(t, ok)
+	_, ok = UniqueViolationConstraint(fmt.Errorf("some error"))
+	require.False(t, ok)
+}
+
+func TestOpen_WithDialer(t *testing.T) {
+	errDial := errors.New("dial error")
+	var dialedAddr string
+	dbkit.RegisterDialer("test-pq-dialer", func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialedAddr = addr
+		return nil, errDial
+	})
+
+	cfg := &dbkit.Config{Dialect: dbkit.DialectPostgres, Postgres: dbkit.PostgresConfig{
+		Host: "db.internal", Port: 5432, User: "user", Database: "mydb", Dialer: "test-pq-dialer",
+	}}
+	db, err := dbkit.Open(cfg, false)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.ErrorIs(t, db.Ping(), errDial)
+	require.Equal(t, "db.internal:5432", dialedAddr)
 }
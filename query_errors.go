@@ -0,0 +1,58 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	queryErrorClassifiersMu sync.RWMutex
+	queryErrorClassifiers   []func(err error) QueryErrorClass
+)
+
+// RegisterQueryErrorClassifier registers callback that determines class of the database-specific error.
+// Callback should return an empty string if the error is unknown for it.
+// Dialect packages (e.g. github.com/acronis/go-dbkit/mysql) register their classifiers in init().
+func RegisterQueryErrorClassifier(classify func(err error) QueryErrorClass) {
+	queryErrorClassifiersMu.Lock()
+	defer queryErrorClassifiersMu.Unlock()
+	queryErrorClassifiers = append(queryErrorClassifiers, classify)
+}
+
+// ClassifyQueryError returns class of the error occurred during executing SQL query.
+// Registered classifiers (see RegisterQueryErrorClassifier) are applied to the error and all errors it wraps.
+// Context errors and transient connection errors (see IsTransientConnectionError) are classified independently of the dialect.
+// QueryErrorClassOther is returned if the error cannot be classified, and an empty string is returned for nil error.
+func ClassifyQueryError(err error) QueryErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	queryErrorClassifiersMu.RLock()
+	classifiers := queryErrorClassifiers
+	queryErrorClassifiersMu.RUnlock()
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		for _, classify := range classifiers {
+			if errClass := classify(e); errClass != "" {
+				return errClass
+			}
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return QueryErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return QueryErrorClassCanceled
+	case IsTransientConnectionError(err):
+		return QueryErrorClassConnection
+	}
+	return QueryErrorClassOther
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testClassifiedError struct {
+	class QueryErrorClass
+}
+
+func (e *testClassifiedError) Error() string {
+	return "test error: " + string(e.class)
+}
+
+func TestClassifyQueryError(t *testing.T) {
+	RegisterQueryErrorClassifier(func(err error) QueryErrorClass {
+		var testErr *testClassifiedError
+		if errors.As(err, &testErr) {
+			return testErr.class
+		}
+		return ""
+	})
+
+	tests := []struct {
+		name string
+		err  error
+		want QueryErrorClass
+	}{
+		{"nil", nil, ""},
+		{"registered classifier", &testClassifiedError{QueryErrorClassDeadlock}, QueryErrorClassDeadlock},
+		{"registered classifier, wrapped", fmt.Errorf("exec: %w", &testClassifiedError{QueryErrorClassUniqueViolation}),
+			QueryErrorClassUniqueViolation},
+		{"deadline exceeded", fmt.Errorf("exec: %w", context.DeadlineExceeded), QueryErrorClassTimeout},
+		{"canceled", context.Canceled, QueryErrorClassCanceled},
+		{"bad connection", driver.ErrBadConn, QueryErrorClassConnection},
+		{"unknown", errors.New("syntax error"), QueryErrorClassOther},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ClassifyQueryError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultReplicaLagCheckInterval is a default interval between replication lag measurements (see ReplicaLagChecker.RunChecks).
+const DefaultReplicaLagCheckInterval = 5 * time.Second
+
+// MetricsLabelReplica is a name of the Prometheus label that contains name of the replica.
+const MetricsLabelReplica = "replica"
+
+// ErrNotReplica is returned by ReplicaLagChecker when the database is not a replica or replication is not running.
+var ErrNotReplica = errors.New("database is not a running replica")
+
+// ErrReplicaLagTooHigh is returned by Router.CheckHealth when replication lag of the read-only endpoint exceeds the limit.
+var ErrReplicaLagTooHigh = errors.New("replication lag is too high")
+
+const postgresReplicaLagQuery = `SELECT pg_is_in_recovery(),
+	CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`
+
+const mySQLReplicaStatusQuery = "SHOW REPLICA STATUS"
+
+// mySQLLegacyReplicaStatusQuery is used by MySQL before 8.0.22 and MariaDB (before 10.5.1)
+// that don't support SHOW REPLICA STATUS syntax.
+const mySQLLegacyReplicaStatusQuery = "SHOW SLAVE STATUS"
+
+// mySQLErrParseError is a MySQL error code (ER_PARSE_ERROR) that is returned for unsupported syntax.
+const mySQLErrParseError = 1064
+
+// ReplicaLagCheckerOpts represents an options for ReplicaLagChecker.
+type ReplicaLagCheckerOpts struct {
+	// ReplicaName is a value of the MetricsLabelReplica label of the lag gauge.
+	ReplicaName string
+
+	// CheckInterval is an interval between measurements in RunChecks. DefaultReplicaLagCheckInterval is used by default.
+	CheckInterval time.Duration
+
+	// MetricsNamespace is a namespace for metrics. It will be prepended to all metric names.
+	MetricsNamespace string
+}
+
+// ReplicaLagChecker measures replication lag of the Postgres (streaming replication) or MySQL replica
+// and exports it as a Prometheus gauge. It may be passed to the Router (see RouterOpts.ReplicaLagChecker),
+// so stale replica is removed from the read rotation.
+type ReplicaLagChecker struct {
+	// Lag is a gauge with the last measured replication lag in seconds.
+	Lag prometheus.Gauge
+
+	db            *sql.DB
+	dialect       Dialect
+	checkInterval time.Duration
+	lastLag       atomic.Int64
+
+	useMySQLLegacyStatusQuery atomic.Bool
+}
+
+// NewReplicaLagChecker creates a new ReplicaLagChecker for the passed replica database.
+func NewReplicaLagChecker(db *sql.DB, dialect Dialect) *ReplicaLagChecker {
+	return NewReplicaLagCheckerWithOpts(db, dialect, ReplicaLagCheckerOpts{})
+}
+
+// NewReplicaLagCheckerWithOpts is a more configurable version of the NewReplicaLagChecker.
+func NewReplicaLagCheckerWithOpts(db *sql.DB, dialect Dialect, opts ReplicaLagCheckerOpts) *ReplicaLagChecker {
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = DefaultReplicaLagCheckInterval
+	}
+	return &ReplicaLagChecker{
+		Lag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   opts.MetricsNamespace,
+			Name:        "db_replica_lag_seconds",
+			Help:        "A replication lag of the database replica.",
+			ConstLabels: prometheus.Labels{MetricsLabelReplica: opts.ReplicaName},
+		}),
+		db:            db,
+		dialect:       dialect,
+		checkInterval: opts.CheckInterval,
+	}
+}
+
+// CheckLag measures replication lag and updates the gauge.
+func (c *ReplicaLagChecker) CheckLag(ctx context.Context) (time.Duration, error) {
+	var lag time.Duration
+	var err error
+	switch c.dialect {
+	case DialectPostgres, DialectPgx:
+		lag, err = c.checkPostgresLag(ctx)
+	case DialectMySQL:
+		lag, err = c.checkMySQLLag(ctx)
+	default:
+		return 0, fmt.Errorf("replication lag check is not supported for dialect %q", c.dialect)
+	}
+	if err != nil {
+		return 0, err
+	}
+	c.lastLag.Store(int64(lag))
+	c.Lag.Set(lag.Seconds())
+	return lag, nil
+}
+
+// LastLag returns the last measured replication lag.
+func (c *ReplicaLagChecker) LastLag() time.Duration {
+	return time.Duration(c.lastLag.Load())
+}
+
+// RunChecks periodically measures replication lag until the passed context is canceled.
+// Usually it's called in a separate goroutine. It's not needed if the checker is used by the Router.
+func (c *ReplicaLagChecker) RunChecks(ctx context.Context) {
+	ticker := time.NewTicker(c.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = c.CheckLag(ctx)
+		}
+	}
+}
+
+// MustRegister does registration of the lag gauge in Prometheus and panics if any error occurs.
+func (c *ReplicaLagChecker) MustRegister() {
+	prometheus.MustRegister(c.Lag)
+}
+
+// Unregister cancels registration of the lag gauge in Prometheus.
+func (c *ReplicaLagChecker) Unregister() {
+	prometheus.Unregister(c.Lag)
+}
+
+func (c *ReplicaLagChecker) checkPostgresLag(ctx context.Context) (time.Duration, error) {
+	var inRecovery bool
+	var lagSeconds float64
+	if err := c.db.QueryRowContext(ctx, postgresReplicaLagQuery).Scan(&inRecovery, &lagSeconds); err != nil {
+		return 0, fmt.Errorf("query replication lag: %w", err)
+	}
+	if !inRecovery {
+		return 0, ErrNotReplica
+	}
+	return time.Duration(lagSeconds * float64(time.Second)), nil
+}
+
+func (c *ReplicaLagChecker) checkMySQLLag(ctx context.Context) (time.Duration, error) {
+	rows, err := c.queryMySQLReplicaStatus(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("query replica status: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, fmt.Errorf("get replica status columns: %w", err)
+	}
+	lagColumnIdx := -1
+	for i, column := range columns {
+		// Seconds_Behind_Master is used by MySQL before 8.0.22 and MariaDB.
+		if column == "Seconds_Behind_Source" || column == "Seconds_Behind_Master" {
+			lagColumnIdx = i
+			break
+		}
+	}
+	if lagColumnIdx == -1 {
+		return 0, errors.New("replica status doesn't contain lag column")
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return 0, fmt.Errorf("read replica status: %w", err)
+		}
+		return 0, ErrNotReplica
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return 0, fmt.Errorf("scan replica status: %w", err)
+	}
+	if values[lagColumnIdx] == nil {
+		return 0, ErrNotReplica // Replication SQL thread is not running.
+	}
+	lagSeconds, err := strconv.ParseInt(string(values[lagColumnIdx]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse replication lag: %w", err)
+	}
+	return time.Duration(lagSeconds) * time.Second, nil
+}
+
+// queryMySQLReplicaStatus executes SHOW REPLICA STATUS and falls back to SHOW SLAVE STATUS
+// if the server doesn't support the new syntax (the fallback is remembered for the next checks).
+func (c *ReplicaLagChecker) queryMySQLReplicaStatus(ctx context.Context) (*sql.Rows, error) {
+	if c.useMySQLLegacyStatusQuery.Load() {
+		return c.db.QueryContext(ctx, mySQLLegacyReplicaStatusQuery)
+	}
+	rows, err := c.db.QueryContext(ctx, mySQLReplicaStatusQuery)
+	var mySQLErr *mysql.MySQLError
+	if err == nil || !errors.As(err, &mySQLErr) || mySQLErr.Number != mySQLErrParseError {
+		return rows, err
+	}
+	if rows, err = c.db.QueryContext(ctx, mySQLLegacyReplicaStatusQuery); err != nil {
+		return nil, err
+	}
+	c.useMySQLLegacyStatusQuery.Store(true)
+	return rows, nil
+}
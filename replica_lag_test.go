@@ -0,0 +1,109 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicaLagChecker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("postgres", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		checker := NewReplicaLagCheckerWithOpts(db, DialectPgx, ReplicaLagCheckerOpts{ReplicaName: "pg-replica"})
+
+		mock.ExpectQuery(regexp.QuoteMeta(postgresReplicaLagQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"pg_is_in_recovery", "lag"}).AddRow(true, 1.5))
+		lag, err := checker.CheckLag(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1500*time.Millisecond, lag)
+		require.Equal(t, lag, checker.LastLag())
+		require.Equal(t, 1.5, testutil.ToFloat64(checker.Lag))
+
+		mock.ExpectQuery(regexp.QuoteMeta(postgresReplicaLagQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"pg_is_in_recovery", "lag"}).AddRow(false, 0))
+		_, err = checker.CheckLag(ctx)
+		require.ErrorIs(t, err, ErrNotReplica)
+		require.Equal(t, 1500*time.Millisecond, checker.LastLag())
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		checker := NewReplicaLagChecker(db, DialectMySQL)
+
+		mock.ExpectQuery(mySQLReplicaStatusQuery).WillReturnRows(
+			sqlmock.NewRows([]string{"Replica_IO_State", "Seconds_Behind_Source"}).AddRow("Waiting for source", "7"))
+		lag, err := checker.CheckLag(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 7*time.Second, lag)
+
+		mock.ExpectQuery(mySQLReplicaStatusQuery).WillReturnRows(
+			sqlmock.NewRows([]string{"Slave_IO_State", "Seconds_Behind_Master"}).AddRow("", nil))
+		_, err = checker.CheckLag(ctx)
+		require.ErrorIs(t, err, ErrNotReplica)
+
+		mock.ExpectQuery(mySQLReplicaStatusQuery).WillReturnRows(
+			sqlmock.NewRows([]string{"Replica_IO_State", "Seconds_Behind_Source"}))
+		_, err = checker.CheckLag(ctx)
+		require.ErrorIs(t, err, ErrNotReplica)
+
+		mock.ExpectQuery(mySQLReplicaStatusQuery).WillReturnError(errors.New("connection refused"))
+		_, err = checker.CheckLag(ctx)
+		require.EqualError(t, err, "query replica status: connection refused")
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("mysql, legacy replica status syntax", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		checker := NewReplicaLagChecker(db, DialectMySQL)
+
+		// MySQL before 8.0.22 and MariaDB don't support SHOW REPLICA STATUS.
+		mock.ExpectQuery(mySQLReplicaStatusQuery).WillReturnError(&mysql.MySQLError{
+			Number: mySQLErrParseError, Message: "You have an error in your SQL syntax"})
+		mock.ExpectQuery(mySQLLegacyReplicaStatusQuery).WillReturnRows(
+			sqlmock.NewRows([]string{"Slave_IO_State", "Seconds_Behind_Master"}).AddRow("Waiting for master", "3"))
+		lag, err := checker.CheckLag(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3*time.Second, lag)
+
+		// Legacy syntax is used for the next checks right away.
+		mock.ExpectQuery(mySQLLegacyReplicaStatusQuery).WillReturnRows(
+			sqlmock.NewRows([]string{"Slave_IO_State", "Seconds_Behind_Master"}).AddRow("Waiting for master", "5"))
+		lag, err = checker.CheckLag(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 5*time.Second, lag)
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unsupported dialect", func(t *testing.T) {
+		_, err := NewReplicaLagChecker(nil, DialectSQLite).CheckLag(ctx)
+		require.EqualError(t, err, `replication lag check is not supported for dialect "sqlite3"`)
+	})
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"github.com/acronis/go-appkit/retry"
+	"github.com/cenkalti/backoff/v4"
+)
+
+// StatementRetryOpts represents an options for ExecWithRetry and QueryWithRetry.
+type StatementRetryOpts struct {
+	// Policy is a retry policy. If it's nil, statement is executed only once.
+	Policy retry.Policy
+
+	// MetricsCollector is used for counting retry attempts (may be nil).
+	// It's implemented by MetricsCollector (Prometheus) and by otelmetrics.MetricsCollector (OpenTelemetry).
+	MetricsCollector QueryRetryMetrics
+
+	// Annotation is used as a value of the "query" label for metrics.
+	// Retry attempts of statements without annotation are not counted.
+	Annotation string
+
+	// DeadlockDiagnostics (optional) is notified about retryable errors, so diagnostic info may be captured and logged.
+	DeadlockDiagnostics *DeadlockDiagnostics
+}
+
+// ExecWithRetry executes a query without returning any rows.
+// Unlike DoInTx with retries, only the single statement (not the whole transaction) is re-executed
+// if it fails with the error that is retryable for the driver of the passed *sql.DB.
+func ExecWithRetry(
+	ctx context.Context, dbConn *sql.DB, opts StatementRetryOpts, query string, args ...interface{},
+) (sql.Result, error) {
+	var result sql.Result
+	err := doStatementWithRetry(ctx, dbConn, opts, func(ctx context.Context) error {
+		var execErr error
+		result, execErr = dbConn.ExecContext(ctx, query, args...)
+		return execErr
+	})
+	return result, err
+}
+
+// QueryWithRetry executes a query that returns rows.
+// Only the query execution is retried, errors that occur during iteration over returned rows are not.
+func QueryWithRetry(
+	ctx context.Context, dbConn *sql.DB, opts StatementRetryOpts, query string, args ...interface{},
+) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := doStatementWithRetry(ctx, dbConn, opts, func(ctx context.Context) error {
+		var queryErr error
+		rows, queryErr = dbConn.QueryContext(ctx, query, args...)
+		return queryErr
+	})
+	return rows, err
+}
+
+func doStatementWithRetry(ctx context.Context, dbConn *sql.DB, opts StatementRetryOpts, fn func(ctx context.Context) error) error {
+	if opts.Policy == nil {
+		return fn(ctx)
+	}
+	countRetries := opts.MetricsCollector != nil && opts.Annotation != ""
+	var notify func(err error, d time.Duration)
+	if countRetries || opts.DeadlockDiagnostics != nil {
+		notify = func(err error, d time.Duration) {
+			if countRetries {
+				opts.MetricsCollector.ObserveQueryRetry(opts.Annotation)
+			}
+			if opts.DeadlockDiagnostics != nil {
+				opts.DeadlockDiagnostics.Notify(err)
+			}
+		}
+	}
+	return retry.DoWithRetry(ctx, opts.Policy, GetIsRetryable(dbConn.Driver()), notify, fn)
+}
+
+// NewRetryPolicyFromConfig creates a retry policy with exponential backoff from the passed configuration.
+func NewRetryPolicyFromConfig(cfg *RetryPolicyConfig) retry.Policy {
+	return &configRetryPolicy{cfg: *cfg}
+}
+
+type configRetryPolicy struct {
+	cfg RetryPolicyConfig
+}
+
+// NewBackOff implements retry.Policy interface.
+func (p *configRetryPolicy) NewBackOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = p.cfg.BaseBackoff
+	b.MaxInterval = p.cfg.MaxBackoff
+	b.RandomizationFactor = p.cfg.Jitter
+	b.MaxElapsedTime = 0 // Number of attempts is limited instead.
+	b.Reset()
+	maxRetries := p.cfg.MaxAttempts - 1
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	return backoff.WithMaxRetries(b, uint64(maxRetries))
+}
+
+// NewIsRetryableFromConfig creates a function that tells if error is retryable for the driver
+// according to the error classes enabled in the passed configuration.
+func NewIsRetryableFromConfig(cfg *RetryPolicyConfig, d driver.Driver) retry.IsRetryable {
+	var funcs []retry.IsRetryable
+	for _, errClass := range cfg.ErrorClasses {
+		switch errClass {
+		case RetryErrorClassDialect:
+			funcs = append(funcs, GetIsRetryable(d))
+		case RetryErrorClassTransient:
+			funcs = append(funcs, IsTransientConnectionError)
+		}
+	}
+	return func(err error) bool {
+		for _, isRetryable := range funcs {
+			if isRetryable(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
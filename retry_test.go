@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/retry"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+var errTestRetryable = errors.New("retryable error")
+
+func TestExecWithRetry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	defer WithIsRetryable(db.Driver(), func(err error) bool {
+		return errors.Is(err, errTestRetryable)
+	})()
+
+	mc := NewMetricsCollector()
+	opts := StatementRetryOpts{
+		Policy:           retry.NewExponentialBackoffPolicy(time.Millisecond, 3),
+		MetricsCollector: mc,
+		Annotation:       "update_users",
+	}
+
+	mock.ExpectExec("UPDATE users").WillReturnError(errTestRetryable)
+	mock.ExpectExec("UPDATE users").WillReturnError(errTestRetryable)
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	result, err := ExecWithRetry(context.Background(), db, opts, "UPDATE users SET name = ?", "Bob")
+	require.NoError(t, err)
+	affected, err := result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), affected)
+	requireCounterValue(t, mc.QueryRetries.With(prometheus.Labels{MetricsLabelQuery: "update_users"}), 2)
+}
+
+func TestQueryWithRetry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	defer WithIsRetryable(db.Driver(), func(err error) bool {
+		return errors.Is(err, errTestRetryable)
+	})()
+
+	t.Run("retryable error", func(t *testing.T) {
+		mock.ExpectQuery("SELECT name FROM users").WillReturnError(errTestRetryable)
+		mock.ExpectQuery("SELECT name FROM users").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Bob"))
+
+		opts := StatementRetryOpts{Policy: retry.NewExponentialBackoffPolicy(time.Millisecond, 3)}
+		rows, err := QueryWithRetry(context.Background(), db, opts, "SELECT name FROM users")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, rows.Close()) }()
+		require.True(t, rows.Next())
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		require.Equal(t, "Bob", name)
+	})
+
+	t.Run("non-retryable error", func(t *testing.T) {
+		mock.ExpectQuery("SELECT name FROM users").WillReturnError(errors.New("fatal error"))
+
+		opts := StatementRetryOpts{Policy: retry.NewExponentialBackoffPolicy(time.Millisecond, 3)}
+		rows, err := QueryWithRetry(context.Background(), db, opts, "SELECT name FROM users")
+		require.ErrorContains(t, err, "fatal error")
+		require.Nil(t, rows)
+	})
+
+	mock.ExpectClose()
+}
+
+func requireCounterValue(t *testing.T, counter prometheus.Counter, want float64) {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, counter.Write(&m))
+	require.Equal(t, want, m.GetCounter().GetValue())
+}
+
+func TestNewRetryPolicyFromConfig(t *testing.T) {
+	cfg := &RetryPolicyConfig{
+		ErrorClasses: []RetryErrorClass{RetryErrorClassTransient},
+		MaxAttempts:  3,
+		BaseBackoff:  time.Millisecond,
+		MaxBackoff:   2 * time.Millisecond,
+	}
+
+	var attempts int
+	err := retry.DoWithRetry(context.Background(), NewRetryPolicyFromConfig(cfg), NewIsRetryableFromConfig(cfg, nil), nil,
+		func(ctx context.Context) error {
+			attempts++
+			return driver.ErrBadConn
+		})
+	require.ErrorIs(t, err, driver.ErrBadConn)
+	require.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = retry.DoWithRetry(context.Background(), NewRetryPolicyFromConfig(cfg), NewIsRetryableFromConfig(cfg, nil), nil,
+		func(ctx context.Context) error {
+			attempts++
+			return errTestRetryable
+		})
+	require.ErrorIs(t, err, errTestRetryable)
+	require.Equal(t, 1, attempts)
+}
@@ -7,22 +7,61 @@ Released under MIT license.
 package dbkit
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"io"
 	"reflect"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/acronis/go-appkit/retry"
 )
 
-var retryableErrors = map[reflect.Type]retry.IsRetryable{}
+var (
+	retryableMu           sync.RWMutex
+	retryableErrors       = map[reflect.Type][]retry.IsRetryable{}
+	retryableErrorsByName = map[string][]retry.IsRetryable{}
+)
+
+var (
+	driverNamesMu sync.RWMutex
+	driverNames   = map[reflect.Type][]string{}
+)
 
 // GetIsRetryable returns a function that can tell for given driver if error is retryable.
+// Functions registered for the driver type and for the names under which the driver is registered
+// in database/sql (see RegisterIsRetryableFuncForName) are taken into account.
+// Returned function is not affected by registrations made after the call.
 func GetIsRetryable(d driver.Driver) retry.IsRetryable {
-	t := reflect.TypeOf(d)
-	if r, ok := retryableErrors[t]; ok {
-		return r
+	names := getDriverNames(d)
+
+	retryableMu.RLock()
+	funcs := append([]retry.IsRetryable(nil), retryableErrors[reflect.TypeOf(d)]...)
+	for _, name := range names {
+		funcs = append(funcs, retryableErrorsByName[name]...)
+	}
+	retryableMu.RUnlock()
+
+	if len(funcs) == 0 {
+		return isRetryableNoDriver
+	}
+	return func(e error) bool {
+		for ; e != nil; e = errors.Unwrap(e) {
+			for _, retryable := range funcs {
+				if retryable(e) {
+					return true
+				}
+			}
+		}
+		return false
 	}
-	return isRetryableNoDriver
+}
+
+// GetIsRetryableForDB returns a function that can tell for the driver of given *sql.DB if error is retryable.
+func GetIsRetryableForDB(db *sql.DB) retry.IsRetryable {
+	return GetIsRetryable(db.Driver())
 }
 
 func isRetryableNoDriver(error) bool {
@@ -31,19 +70,126 @@ func isRetryableNoDriver(error) bool {
 
 // RegisterIsRetryableFunc registers callback to determinate specific DB error is retryable or not.
 // Several registered functions will be called one after another in FIFO order before some function returns true.
-// Note: this function is not concurrent-safe. Typical scenario: register all custom IsRetryable in module init()
+// Typical scenario: register all custom IsRetryable in module init().
 func RegisterIsRetryableFunc(d driver.Driver, retryable retry.IsRetryable) {
 	t := reflect.TypeOf(d)
-	prev, ok := retryableErrors[t]
-	retryableErrors[t] = func(e error) bool {
-		for ; e != nil; e = errors.Unwrap(e) {
-			if ok && prev(e) {
-				return true
-			}
-			if retryable(e) {
-				return true
-			}
+	retryableMu.Lock()
+	defer retryableMu.Unlock()
+	retryableErrors[t] = append(retryableErrors[t], retryable)
+}
+
+// RegisterIsRetryableFuncForName registers callback to determinate specific DB error is retryable or not
+// for the driver registered in database/sql with the given name (the one that is passed to sql.Open).
+// It's useful when the driver instance is not available or when the driver is wrapped
+// (e.g. for instrumentation) and so its type differs from the original one.
+// database/sql doesn't allow resolving a driver by its name without DSN, so names are known only for drivers
+// that were resolved by dbkit itself (e.g. via Open or NewConnector).
+func RegisterIsRetryableFuncForName(driverName string, retryable retry.IsRetryable) {
+	retryableMu.Lock()
+	defer retryableMu.Unlock()
+	retryableErrorsByName[driverName] = append(retryableErrorsByName[driverName], retryable)
+}
+
+// UnregisterIsRetryableFunc removes all callbacks registered for the driver by RegisterIsRetryableFunc.
+func UnregisterIsRetryableFunc(d driver.Driver) {
+	retryableMu.Lock()
+	defer retryableMu.Unlock()
+	delete(retryableErrors, reflect.TypeOf(d))
+}
+
+// UnregisterIsRetryableFuncForName removes all callbacks registered for the driver name by RegisterIsRetryableFuncForName.
+func UnregisterIsRetryableFuncForName(driverName string) {
+	retryableMu.Lock()
+	defer retryableMu.Unlock()
+	delete(retryableErrorsByName, driverName)
+}
+
+// WithIsRetryable replaces all callbacks registered for the driver by RegisterIsRetryableFunc with the passed one
+// (nil means no callbacks at all) and returns a function that restores previous registrations.
+// It's intended to be used in tests:
+//
+//	defer dbkit.WithIsRetryable(&mysql.MySQLDriver{}, func(err error) bool { return true })()
+func WithIsRetryable(d driver.Driver, retryable retry.IsRetryable) (restore func()) {
+	t := reflect.TypeOf(d)
+	retryableMu.Lock()
+	defer retryableMu.Unlock()
+	prev, hadPrev := retryableErrors[t]
+	if retryable != nil {
+		retryableErrors[t] = []retry.IsRetryable{retryable}
+	} else {
+		delete(retryableErrors, t)
+	}
+	return func() {
+		retryableMu.Lock()
+		defer retryableMu.Unlock()
+		if hadPrev {
+			retryableErrors[t] = prev
+		} else {
+			delete(retryableErrors, t)
 		}
+	}
+}
+
+// transientConnectionErrorMessages contains substrings of error messages that indicate transient transport failures.
+// Used for drivers that don't wrap underlying network errors.
+var transientConnectionErrorMessages = []string{
+	"connection reset by peer",
+	"broken pipe",
+	"connection refused",
+	"unexpected EOF",
+}
+
+// IsTransientConnectionError is a dialect-independent classifier of transient transport failures
+// (connection reset, broken pipe, unexpected EOF, driver.ErrBadConn, errors that implement SafeToRetry() bool
+// like ones from github.com/jackc/pgconn, etc.).
+// It isn't registered for any driver by default since statement could be already executed on the server side
+// when the connection is lost, so it should be opted into explicitly:
+//
+//	dbkit.RegisterIsRetryableFunc(&mysql.MySQLDriver{}, dbkit.IsTransientConnectionError)
+func IsTransientConnectionError(err error) bool {
+	if err == nil {
 		return false
 	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var safeToRetryErr interface{ SafeToRetry() bool }
+	if errors.As(err, &safeToRetryErr) && safeToRetryErr.SafeToRetry() {
+		return true
+	}
+	errMsg := err.Error()
+	for _, msg := range transientConnectionErrorMessages {
+		if strings.Contains(errMsg, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// rememberDriverName records the name under which the driver is registered in database/sql.
+func rememberDriverName(name string, d driver.Driver) {
+	t := reflect.TypeOf(d)
+	driverNamesMu.Lock()
+	defer driverNamesMu.Unlock()
+	for _, n := range driverNames[t] {
+		if n == name {
+			return
+		}
+	}
+	driverNames[t] = append(driverNames[t], name)
+}
+
+// getDriverNames returns names under which the driver type is registered in database/sql
+// (only names that were recorded by rememberDriverName are returned).
+func getDriverNames(d driver.Driver) []string {
+	if d == nil {
+		return nil
+	}
+	driverNamesMu.RLock()
+	defer driverNamesMu.RUnlock()
+	return driverNames[reflect.TypeOf(d)]
 }
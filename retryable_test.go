@@ -8,24 +8,29 @@ package dbkit
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	"reflect"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/acronis/go-appkit/retry"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMultipleIsRetryError(t *testing.T) {
 	var called string
 
 	// cleanup handlers
-	oldHandlers := retryableErrors
-	retryableErrors = map[reflect.Type]retry.IsRetryable{}
-	defer func() {
-		retryableErrors = oldHandlers
-	}()
+	defer WithIsRetryable(nil, nil)()
 
 	RegisterIsRetryableFunc(nil, func(e error) bool {
 		called += "1"
@@ -47,3 +52,154 @@ func TestMultipleIsRetryError(t *testing.T) {
 
 	assert.Equal(t, "123", called, "Wrong call order")
 }
+
+func TestRegisterIsRetryableFuncForName(t *testing.T) {
+	const dsn = "retryable-func-for-name"
+	mockDB, mock, err := sqlmock.NewWithDSN(dsn)
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, mockDB)
+	mock.ExpectClose()
+
+	// Driver name is known only if the driver was resolved by dbkit.
+	connector, err := NewConnector("sqlmock", dsn)
+	require.NoError(t, err)
+	db := sql.OpenDB(connector)
+	defer requireNoErrOnClose(t, db)
+
+	errRetryable := errors.New("retryable error")
+	RegisterIsRetryableFuncForName("sqlmock", func(e error) bool {
+		return e == errRetryable
+	})
+	defer UnregisterIsRetryableFuncForName("sqlmock")
+
+	isRetryable := GetIsRetryableForDB(db)
+	require.True(t, isRetryable(errRetryable))
+	require.True(t, isRetryable(fmt.Errorf("wrapped: %w", errRetryable)))
+	require.False(t, isRetryable(errors.New("another error")))
+
+	require.False(t, GetIsRetryable(nil)(errRetryable))
+}
+
+const strictDSNDriverName = "dbkit-strict-dsn"
+
+var registerStrictDSNDriverOnce sync.Once
+
+// strictDSNDriver rejects an empty DSN in OpenConnector as many real drivers do.
+type strictDSNDriver struct{}
+
+func (d *strictDSNDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (d *strictDSNDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	if dsn == "" {
+		return nil, errors.New("empty dsn")
+	}
+	return &dsnConnector{driver: d, dsn: dsn}, nil
+}
+
+func TestRegisterIsRetryableFuncForName_DriverRejectingEmptyDSN(t *testing.T) {
+	registerStrictDSNDriverOnce.Do(func() {
+		sql.Register(strictDSNDriverName, &strictDSNDriver{})
+	})
+
+	errRetryable := errors.New("retryable error")
+	RegisterIsRetryableFuncForName(strictDSNDriverName, func(e error) bool {
+		return e == errRetryable
+	})
+	defer UnregisterIsRetryableFuncForName(strictDSNDriverName)
+
+	_, err := NewConnector(strictDSNDriverName, "")
+	require.EqualError(t, err, "empty dsn")
+
+	connector, err := NewConnector(strictDSNDriverName, "strict-dsn")
+	require.NoError(t, err)
+	db := sql.OpenDB(connector)
+	defer requireNoErrOnClose(t, db)
+
+	require.True(t, GetIsRetryableForDB(db)(errRetryable))
+	require.True(t, GetIsRetryable(&strictDSNDriver{})(errRetryable))
+}
+
+func TestWithIsRetryable(t *testing.T) {
+	errRetryable := errors.New("retryable error")
+
+	restore := WithIsRetryable(nil, func(e error) bool {
+		return e == errRetryable
+	})
+	require.True(t, GetIsRetryable(nil)(errRetryable))
+
+	RegisterIsRetryableFunc(nil, func(e error) bool {
+		return true
+	})
+	require.True(t, GetIsRetryable(nil)(errors.New("any error")))
+
+	restore()
+	require.False(t, GetIsRetryable(nil)(errRetryable))
+
+	RegisterIsRetryableFunc(nil, func(e error) bool {
+		return e == errRetryable
+	})
+	require.True(t, GetIsRetryable(nil)(errRetryable))
+	UnregisterIsRetryableFunc(nil)
+	require.False(t, GetIsRetryable(nil)(errRetryable))
+}
+
+func TestRegisterIsRetryableFunc_Concurrent(t *testing.T) {
+	defer WithIsRetryable(nil, nil)()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterIsRetryableFunc(nil, func(e error) bool {
+				return false
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = GetIsRetryable(nil)(errors.New("any error"))
+		}()
+	}
+	wg.Wait()
+}
+
+type safeToRetryError struct {
+	safe bool
+}
+
+func (e *safeToRetryError) Error() string {
+	return "safe to retry error"
+}
+
+func (e *safeToRetryError) SafeToRetry() bool {
+	return e.safe
+}
+
+func TestIsTransientConnectionError(t *testing.T) {
+	tests := []struct {
+		Name string
+		Err  error
+		Want bool
+	}{
+		{Name: "nil", Err: nil, Want: false},
+		{Name: "bad conn", Err: driver.ErrBadConn, Want: true},
+		{Name: "EOF", Err: fmt.Errorf("handshake: %w", io.EOF), Want: true},
+		{Name: "unexpected EOF", Err: io.ErrUnexpectedEOF, Want: true},
+		{
+			Name: "connection reset",
+			Err:  &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+			Want: true,
+		},
+		{Name: "connection reset message", Err: errors.New("read tcp: connection reset by peer"), Want: true},
+		{Name: "safe to retry", Err: fmt.Errorf("connect: %w", &safeToRetryError{safe: true}), Want: true},
+		{Name: "not safe to retry", Err: &safeToRetryError{safe: false}, Want: false},
+		{Name: "regular error", Err: errors.New("syntax error"), Want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			require.Equal(t, tt.Want, IsTransientConnectionError(tt.Err))
+		})
+	}
+}
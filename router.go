@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Default values of Router options.
+const (
+	DefaultRouterHealthCheckInterval = 5 * time.Second
+	DefaultRouterHealthCheckTimeout  = time.Second
+	DefaultRouterMaxReplicaLag       = 30 * time.Second
+)
+
+// RouterOpts represents an options for Router.
+type RouterOpts struct {
+	// HealthCheckInterval is an interval between health checks of the read-only endpoint.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is a timeout for a single health check of the read-only endpoint.
+	HealthCheckTimeout time.Duration
+
+	// ReplicaLagChecker (if set) is used for measuring replication lag of the read-only endpoint during health checks.
+	// Replica with the lag that exceeds MaxReplicaLag is considered unhealthy.
+	ReplicaLagChecker *ReplicaLagChecker
+
+	// MaxReplicaLag is a maximum acceptable replication lag of the read-only endpoint.
+	// DefaultRouterMaxReplicaLag is used by default.
+	MaxReplicaLag time.Duration
+}
+
+// Router routes database access between the primary (read-write) and the read-only endpoints.
+// If the read-only endpoint is not configured or is unhealthy, the primary one is used instead.
+type Router struct {
+	primary         *sql.DB
+	readOnly        *sql.DB
+	readOnlyHealthy atomic.Bool
+	checkInterval   time.Duration
+	checkTimeout    time.Duration
+	lagChecker      *ReplicaLagChecker
+	maxReplicaLag   time.Duration
+}
+
+// NewRouter creates a new Router. readOnly may be nil, in this case the primary endpoint is always used.
+// Read-only endpoint is considered healthy until the first failed health check.
+func NewRouter(primary, readOnly *sql.DB) *Router {
+	return NewRouterWithOpts(primary, readOnly, RouterOpts{})
+}
+
+// NewRouterWithOpts is a more configurable version of the NewRouter.
+func NewRouterWithOpts(primary, readOnly *sql.DB, opts RouterOpts) *Router {
+	if opts.HealthCheckInterval == 0 {
+		opts.HealthCheckInterval = DefaultRouterHealthCheckInterval
+	}
+	if opts.HealthCheckTimeout == 0 {
+		opts.HealthCheckTimeout = DefaultRouterHealthCheckTimeout
+	}
+	if opts.MaxReplicaLag == 0 {
+		opts.MaxReplicaLag = DefaultRouterMaxReplicaLag
+	}
+	r := &Router{
+		primary:       primary,
+		readOnly:      readOnly,
+		checkInterval: opts.HealthCheckInterval,
+		checkTimeout:  opts.HealthCheckTimeout,
+		lagChecker:    opts.ReplicaLagChecker,
+		maxReplicaLag: opts.MaxReplicaLag,
+	}
+	r.readOnlyHealthy.Store(readOnly != nil)
+	return r
+}
+
+// Primary returns the primary (read-write) database.
+func (r *Router) Primary() *sql.DB {
+	return r.primary
+}
+
+// ReadOnly returns the read-only database if it's configured and healthy, otherwise the primary one is returned.
+func (r *Router) ReadOnly() *sql.DB {
+	if r.readOnlyHealthy.Load() {
+		return r.readOnly
+	}
+	return r.primary
+}
+
+// IsReadOnlyHealthy returns true if the read-only endpoint is configured and passed the last health check.
+func (r *Router) IsReadOnlyHealthy() bool {
+	return r.readOnlyHealthy.Load()
+}
+
+// CheckHealth pings the read-only endpoint (and checks its replication lag if RouterOpts.ReplicaLagChecker is set)
+// and updates its health status.
+func (r *Router) CheckHealth(ctx context.Context) error {
+	if r.readOnly == nil {
+		return nil
+	}
+	pingCtx, pingCtxCancel := context.WithTimeout(ctx, r.checkTimeout)
+	defer pingCtxCancel()
+	err := r.readOnly.PingContext(pingCtx)
+	if err == nil && r.lagChecker != nil {
+		var lag time.Duration
+		if lag, err = r.lagChecker.CheckLag(pingCtx); err == nil && lag > r.maxReplicaLag {
+			err = fmt.Errorf("%w: %s", ErrReplicaLagTooHigh, lag)
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		return err // Health check is interrupted, so status is unknown.
+	}
+	r.readOnlyHealthy.Store(err == nil)
+	return err
+}
+
+// RunHealthChecks periodically checks health of the read-only endpoint until the passed context is canceled.
+// Usually it's called in a separate goroutine.
+func (r *Router) RunHealthChecks(ctx context.Context) {
+	if r.readOnly == nil {
+		return
+	}
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = r.CheckHealth(ctx)
+		}
+	}
+}
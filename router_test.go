@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	primary, primaryMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, primary)
+	primaryMock.ExpectClose()
+
+	readOnly, readOnlyMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer func() {
+		requireNoErrOnClose(t, readOnly)
+		require.NoError(t, readOnlyMock.ExpectationsWereMet())
+	}()
+
+	t.Run("read-only endpoint is not configured", func(t *testing.T) {
+		r := NewRouter(primary, nil)
+		require.Same(t, primary, r.Primary())
+		require.Same(t, primary, r.ReadOnly())
+		require.False(t, r.IsReadOnlyHealthy())
+		require.NoError(t, r.CheckHealth(context.Background()))
+	})
+
+	t.Run("fallback to primary when read-only endpoint is unhealthy", func(t *testing.T) {
+		r := NewRouter(primary, readOnly)
+		require.Same(t, primary, r.Primary())
+		require.Same(t, readOnly, r.ReadOnly())
+
+		readOnlyMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		require.EqualError(t, r.CheckHealth(context.Background()), "connection refused")
+		require.False(t, r.IsReadOnlyHealthy())
+		require.Same(t, primary, r.ReadOnly())
+
+		readOnlyMock.ExpectPing()
+		require.NoError(t, r.CheckHealth(context.Background()))
+		require.True(t, r.IsReadOnlyHealthy())
+		require.Same(t, readOnly, r.ReadOnly())
+	})
+
+	t.Run("stale replica is removed from the read rotation", func(t *testing.T) {
+		r := NewRouterWithOpts(primary, readOnly, RouterOpts{
+			ReplicaLagChecker: NewReplicaLagChecker(readOnly, DialectPgx),
+			MaxReplicaLag:     10 * time.Second,
+		})
+
+		readOnlyMock.ExpectPing()
+		readOnlyMock.ExpectQuery(regexp.QuoteMeta(postgresReplicaLagQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"pg_is_in_recovery", "lag"}).AddRow(true, 15))
+		require.ErrorIs(t, r.CheckHealth(context.Background()), ErrReplicaLagTooHigh)
+		require.False(t, r.IsReadOnlyHealthy())
+		require.Same(t, primary, r.ReadOnly())
+
+		readOnlyMock.ExpectPing()
+		readOnlyMock.ExpectQuery(regexp.QuoteMeta(postgresReplicaLagQuery)).
+			WillReturnRows(sqlmock.NewRows([]string{"pg_is_in_recovery", "lag"}).AddRow(true, 2))
+		require.NoError(t, r.CheckHealth(context.Background()))
+		require.True(t, r.IsReadOnlyHealthy())
+		require.Same(t, readOnly, r.ReadOnly())
+	})
+
+	readOnlyMock.ExpectClose()
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PostgresMaxIdentifierLength is a maximum length of the identifier (e.g. schema name) in Postgres.
+const PostgresMaxIdentifierLength = 63
+
+// ErrInvalidSchemaName is returned when the schema name cannot be used for pinning search_path (see DoInSchemaTx).
+var ErrInvalidSchemaName = errors.New("invalid schema name")
+
+var schemaNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// ValidateSchemaName checks that the passed name may be safely used as a tenant schema name in Postgres.
+// Only lowercase ASCII letters, digits and underscores are allowed (name cannot start with a digit),
+// the length is limited by PostgresMaxIdentifierLength, and system schemas ("pg_*", "information_schema") are rejected.
+func ValidateSchemaName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: name is empty", ErrInvalidSchemaName)
+	case len(name) > PostgresMaxIdentifierLength:
+		return fmt.Errorf("%w %q: name is longer than %d characters", ErrInvalidSchemaName, name, PostgresMaxIdentifierLength)
+	case !schemaNameRegexp.MatchString(name):
+		return fmt.Errorf("%w %q: only lowercase letters, digits and underscores are allowed", ErrInvalidSchemaName, name)
+	case strings.HasPrefix(name, "pg_") || name == "information_schema":
+		return fmt.Errorf("%w %q: system schema cannot be used", ErrInvalidSchemaName, name)
+	}
+	return nil
+}
+
+// SchemaTxOpts represents options for DoInSchemaTxWithOpts.
+type SchemaTxOpts struct {
+	// TxOptions are passed to the BeginTx.
+	TxOptions *sql.TxOptions
+
+	// SharedSchemas are appended to the search_path after the tenant schema (e.g. "public" with shared tables or extensions).
+	SharedSchemas []string
+}
+
+// DoInSchemaTx begins a new transaction with search_path pinned to the passed (tenant) schema via SET LOCAL,
+// calls passed function and does commit or rollback depending on whether the function returns an error or not.
+// search_path is reset when the transaction ends, so the connection may be safely returned to the pool
+// and reused for another tenant. It's useful for the schema-per-tenant multi-tenancy in Postgres.
+// Schema name is validated by ValidateSchemaName before the transaction is started.
+func DoInSchemaTx(ctx context.Context, dbConn *sql.DB, schema string, fn func(tx *sql.Tx) error) error {
+	return DoInSchemaTxWithOpts(ctx, dbConn, schema, SchemaTxOpts{}, fn)
+}
+
+// DoInSchemaTxWithOpts is a more configurable version of DoInSchemaTx.
+func DoInSchemaTxWithOpts(ctx context.Context, dbConn *sql.DB, schema string, opts SchemaTxOpts, fn func(tx *sql.Tx) error) error {
+	query, err := makeSetLocalSearchPathQuery(schema, opts.SharedSchemas)
+	if err != nil {
+		return err
+	}
+	return DoInTxWithOpts(ctx, dbConn, opts.TxOptions, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("set search_path: %w", err)
+		}
+		return fn(tx)
+	})
+}
+
+func makeSetLocalSearchPathQuery(schema string, sharedSchemas []string) (string, error) {
+	schemas := append([]string{schema}, sharedSchemas...)
+	quoted := make([]string, 0, len(schemas))
+	for _, s := range schemas {
+		if err := ValidateSchemaName(s); err != nil {
+			return "", err
+		}
+		quoted = append(quoted, QuoteIdentifier(DialectPostgres, s))
+	}
+	return "SET LOCAL search_path TO " + strings.Join(quoted, ", "), nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSchemaName(t *testing.T) {
+	for _, name := range []string{"tenant_1", "_tenant", "t", strings.Repeat("a", PostgresMaxIdentifierLength)} {
+		require.NoError(t, ValidateSchemaName(name), name)
+	}
+	for _, name := range []string{
+		"", "1tenant", "Tenant", "tenant-1", `tenant"; DROP TABLE users; --`, "tenant 1",
+		"pg_catalog", "information_schema", strings.Repeat("a", PostgresMaxIdentifierLength+1),
+	} {
+		require.ErrorIs(t, ValidateSchemaName(name), ErrInvalidSchemaName, name)
+	}
+}
+
+func TestDoInSchemaTx(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("search_path is pinned", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`SET LOCAL search_path TO "tenant_1", "public"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM users").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		require.NoError(t, DoInSchemaTxWithOpts(ctx, db, "tenant_1", SchemaTxOpts{SharedSchemas: []string{"public"}},
+			func(tx *sql.Tx) error {
+				_, execErr := tx.ExecContext(ctx, "DELETE FROM users")
+				return execErr
+			}))
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error is returned from function", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`SET LOCAL search_path TO "tenant_1"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		fnErr := errors.New("fn error")
+		require.ErrorIs(t, DoInSchemaTx(ctx, db, "tenant_1", func(tx *sql.Tx) error {
+			return fnErr
+		}), fnErr)
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid schema name", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		require.ErrorIs(t, DoInSchemaTx(ctx, db, `tenant"; DROP SCHEMA public; --`, func(tx *sql.Tx) error {
+			return nil
+		}), ErrInvalidSchemaName)
+		require.ErrorIs(t, DoInSchemaTxWithOpts(ctx, db, "tenant_1", SchemaTxOpts{SharedSchemas: []string{"Public"}},
+			func(tx *sql.Tx) error {
+				return nil
+			}), ErrInvalidSchemaName)
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// ShardHashFunc returns hash of the sharding key (e.g. tenant ID). Shard is selected as hash modulo number of shards.
+type ShardHashFunc func(key string) uint64
+
+// FNVShardHash is a default ShardHashFunc that uses 64-bit FNV-1a hash.
+func FNVShardHash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// SharderOpts represents an options for Sharder.
+type SharderOpts struct {
+	// Hash is a function for hashing sharding keys. FNVShardHash is used by default.
+	Hash ShardHashFunc
+}
+
+// Sharder routes database access to one of the configured databases (shards) by the sharding key.
+// Note that the number and the order of shards must not be changed while data is stored in them,
+// since keys are mapped to the shards by their indexes.
+type Sharder struct {
+	shards []*sql.DB
+	hash   ShardHashFunc
+}
+
+// NewSharder creates a new Sharder. It panics if no shards are passed.
+func NewSharder(shards []*sql.DB) *Sharder {
+	return NewSharderWithOpts(shards, SharderOpts{})
+}
+
+// NewSharderWithOpts is a more configurable version of the NewSharder.
+func NewSharderWithOpts(shards []*sql.DB, opts SharderOpts) *Sharder {
+	if len(shards) == 0 {
+		panic("at least one shard is required")
+	}
+	if opts.Hash == nil {
+		opts.Hash = FNVShardHash
+	}
+	return &Sharder{shards: shards, hash: opts.Hash}
+}
+
+// ShardIndexFor returns index of the shard for the passed sharding key.
+func (s *Sharder) ShardIndexFor(key string) int {
+	return int(s.hash(key) % uint64(len(s.shards)))
+}
+
+// ShardFor returns database of the shard for the passed sharding key.
+func (s *Sharder) ShardFor(key string) *sql.DB {
+	return s.shards[s.ShardIndexFor(key)]
+}
+
+// Shards returns databases of all shards.
+func (s *Sharder) Shards() []*sql.DB {
+	return s.shards
+}
+
+// ForEachShard calls passed function for each shard concurrently (e.g. for fan-out queries) and waits for all calls.
+// Errors returned by the function are joined, each of them is wrapped with the shard index.
+func (s *Sharder) ForEachShard(ctx context.Context, fn func(ctx context.Context, shardIndex int, db *sql.DB) error) error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, db := range s.shards {
+		wg.Add(1)
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			if err := fn(ctx, i, db); err != nil {
+				errs[i] = fmt.Errorf("shard #%d: %w", i, err)
+			}
+		}(i, db)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Close closes databases of all shards.
+func (s *Sharder) Close() error {
+	var errs []error
+	for i, db := range s.shards {
+		if err := db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close shard #%d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OpenShards opens databases of all shards specified in the configuration (see Config.Shards).
+// If opening of any shard fails, already opened ones are closed.
+func OpenShards(cfg *Config, ping bool) ([]*sql.DB, error) {
+	if len(cfg.Shards) == 0 {
+		return nil, errors.New("no shards are configured")
+	}
+	shards := make([]*sql.DB, 0, len(cfg.Shards))
+	for i, shardCfg := range cfg.Shards {
+		db, err := Open(shardCfg, ping)
+		if err != nil {
+			for _, opened := range shards {
+				_ = opened.Close()
+			}
+			return nil, fmt.Errorf("open shard #%d: %w", i, err)
+		}
+		shards = append(shards, db)
+	}
+	return shards, nil
+}
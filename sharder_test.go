@@ -0,0 +1,78 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharder(t *testing.T) {
+	shards := make([]*sql.DB, 3)
+	mocks := make([]sqlmock.Sqlmock, 3)
+	for i := range shards {
+		var err error
+		shards[i], mocks[i], err = sqlmock.New()
+		require.NoError(t, err)
+	}
+
+	t.Run("routing by key", func(t *testing.T) {
+		sharder := NewSharderWithOpts(shards, SharderOpts{Hash: func(key string) uint64 {
+			n, _ := strconv.ParseUint(key, 10, 64)
+			return n
+		}})
+		require.Same(t, shards[0], sharder.ShardFor("3"))
+		require.Same(t, shards[1], sharder.ShardFor("4"))
+		require.Same(t, shards[2], sharder.ShardFor("5"))
+		require.Equal(t, shards, sharder.Shards())
+	})
+
+	t.Run("default hash is stable", func(t *testing.T) {
+		sharder := NewSharder(shards)
+		for _, key := range []string{"tenant1", "tenant2", "tenant3"} {
+			require.Equal(t, sharder.ShardIndexFor(key), sharder.ShardIndexFor(key))
+			require.Equal(t, int(FNVShardHash(key)%3), sharder.ShardIndexFor(key))
+		}
+	})
+
+	t.Run("fan-out", func(t *testing.T) {
+		sharder := NewSharder(shards)
+		var mu sync.Mutex
+		var visited []int
+		shardErr := errors.New("shard is unavailable")
+		err := sharder.ForEachShard(context.Background(), func(ctx context.Context, shardIndex int, db *sql.DB) error {
+			require.Same(t, shards[shardIndex], db)
+			mu.Lock()
+			visited = append(visited, shardIndex)
+			mu.Unlock()
+			if shardIndex == 1 {
+				return shardErr
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, shardErr)
+		require.EqualError(t, err, "shard #1: shard is unavailable")
+		require.ElementsMatch(t, []int{0, 1, 2}, visited)
+	})
+
+	require.Panics(t, func() { NewSharder(nil) })
+
+	for _, mock := range mocks {
+		mock.ExpectClose()
+	}
+	require.NoError(t, NewSharder(shards).Close())
+	for _, mock := range mocks {
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DefaultCloseGracefullyPollInterval is a default interval for checking whether all connections returned to the pool.
+const DefaultCloseGracefullyPollInterval = 100 * time.Millisecond
+
+// CloseGracefullyOpts represents options for CloseGracefullyWithOpts.
+type CloseGracefullyOpts struct {
+	// ShrinkPool enables shrinking the connections pool before waiting, so connections are released as early as possible.
+	// Idle connections are closed, connections returned to the pool are closed immediately,
+	// and the number of open connections is limited to 1 (database/sql treats 0 as no limit).
+	// Note that it doesn't prevent new work: new queries are still executed (one at a time),
+	// so the caller should stop accepting new requests before closing the database.
+	ShrinkPool bool
+
+	// PollInterval is an interval for checking whether all connections returned to the pool.
+	// DefaultCloseGracefullyPollInterval is used by default.
+	PollInterval time.Duration
+}
+
+// CloseGracefully waits until all in-use connections return to the pool (or the passed context is done) and closes the database.
+// It returns the number of connections that were still in use when the context was done (i.e. were cut off).
+// It's useful for clean termination of the service (e.g. pod in Kubernetes).
+// New work is not gated by the function, so it should be called after the service stops accepting new requests.
+func CloseGracefully(ctx context.Context, db *sql.DB) (cutOff int, err error) {
+	return CloseGracefullyWithOpts(ctx, db, CloseGracefullyOpts{})
+}
+
+// CloseGracefullyWithOpts is a more configurable version of CloseGracefully.
+func CloseGracefullyWithOpts(ctx context.Context, db *sql.DB, opts CloseGracefullyOpts) (cutOff int, err error) {
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultCloseGracefullyPollInterval
+	}
+	if opts.ShrinkPool {
+		db.SetMaxIdleConns(0)
+		db.SetMaxOpenConns(1)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if inUse := db.Stats().InUse; inUse == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			cutOff = db.Stats().InUse
+			return cutOff, db.Close()
+		case <-ticker.C:
+		}
+	}
+	return 0, db.Close()
+}
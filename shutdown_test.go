@@ -0,0 +1,57 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseGracefully(t *testing.T) {
+	t.Run("all connections are returned", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		mock.ExpectClose()
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = conn.Close()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		cutOff, err := CloseGracefullyWithOpts(ctx, db, CloseGracefullyOpts{ShrinkPool: true, PollInterval: 10 * time.Millisecond})
+		require.NoError(t, err)
+		require.Equal(t, 0, cutOff)
+		require.Equal(t, 0, db.Stats().OpenConnections)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("connections are cut off", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		mock.ExpectClose()
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		defer func() {
+			_ = conn.Close()
+			require.NoError(t, mock.ExpectationsWereMet())
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		cutOff, err := CloseGracefully(ctx, db)
+		require.NoError(t, err)
+		require.Equal(t, 1, cutOff)
+		require.Error(t, db.PingContext(context.Background()))
+	})
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+)
+
+// CheckpointMode defines a mode of the WAL checkpoint.
+type CheckpointMode string
+
+// WAL checkpoint modes. See https://www.sqlite.org/pragma.html#pragma_wal_checkpoint for details.
+const (
+	CheckpointModePassive  CheckpointMode = "PASSIVE"
+	CheckpointModeFull     CheckpointMode = "FULL"
+	CheckpointModeRestart  CheckpointMode = "RESTART"
+	CheckpointModeTruncate CheckpointMode = "TRUNCATE"
+)
+
+// DefaultAutoCheckpointInterval is a default interval between WAL checkpoints made by StartAutoCheckpoint.
+const DefaultAutoCheckpointInterval = time.Minute
+
+// CheckpointResult represents a result of the WAL checkpoint.
+type CheckpointResult struct {
+	// Busy is true if the checkpoint could not complete because of the concurrent readers or writers.
+	Busy bool
+	// LogFrames is a number of modified pages that have been written to the WAL file.
+	LogFrames int
+	// CheckpointedFrames is a number of pages in the WAL file that have been successfully moved back into the database file.
+	CheckpointedFrames int
+}
+
+// Checkpoint runs WAL checkpoint in the specified mode.
+// It's useful for long-running services that use WAL journal mode, since WAL file may grow indefinitely
+// if automatic checkpoints cannot complete because of the constant readers.
+func Checkpoint(ctx context.Context, db *sql.DB, mode CheckpointMode) (CheckpointResult, error) {
+	switch mode {
+	case CheckpointModePassive, CheckpointModeFull, CheckpointModeRestart, CheckpointModeTruncate:
+	default:
+		return CheckpointResult{}, fmt.Errorf("unknown checkpoint mode %q", mode)
+	}
+	var res CheckpointResult
+	var busy int
+	if err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint("+string(mode)+")").Scan(
+		&busy, &res.LogFrames, &res.CheckpointedFrames); err != nil {
+		return CheckpointResult{}, fmt.Errorf("wal checkpoint: %w", err)
+	}
+	res.Busy = busy != 0
+	return res, nil
+}
+
+// AutoCheckpointOpts represents options for StartAutoCheckpoint.
+type AutoCheckpointOpts struct {
+	// Interval is an interval between checkpoints. DefaultAutoCheckpointInterval is used by default.
+	Interval time.Duration
+	// Mode is a checkpoint mode. CheckpointModePassive is used by default.
+	Mode CheckpointMode
+	// Logger is used for logging failed checkpoints.
+	Logger log.FieldLogger
+}
+
+// StartAutoCheckpoint starts goroutine that periodically runs WAL checkpoint until the returned stop function is called.
+// Stop function waits for the goroutine to finish.
+func StartAutoCheckpoint(db *sql.DB, opts AutoCheckpointOpts) (stop func()) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultAutoCheckpointInterval
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = CheckpointModePassive
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.NewDisabledLogger()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				res, err := Checkpoint(ctx, db, mode)
+				if err != nil {
+					if ctx.Err() == nil {
+						logger.Error("failed to checkpoint sqlite WAL", log.Error(err))
+					}
+					continue
+				}
+				if res.Busy {
+					logger.Warn("sqlite WAL checkpoint could not complete",
+						log.Int("log_frames", res.LogFrames), log.Int("checkpointed_frames", res.CheckpointedFrames))
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
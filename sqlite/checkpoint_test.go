@@ -0,0 +1,49 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "checkpoint.db")
+	dbConn, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	_, err = dbConn.Exec(createFooTable)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = dbConn.ExecContext(ctx, `insert into foo (name) values ("name")`)
+		require.NoError(t, err)
+	}
+
+	res, err := Checkpoint(ctx, dbConn, CheckpointModeTruncate)
+	require.NoError(t, err)
+	require.False(t, res.Busy)
+	walInfo, err := os.Stat(dbPath + "-wal")
+	require.NoError(t, err)
+	require.EqualValues(t, 0, walInfo.Size())
+
+	_, err = Checkpoint(ctx, dbConn, "UNKNOWN")
+	require.EqualError(t, err, `unknown checkpoint mode "UNKNOWN"`)
+
+	stop := StartAutoCheckpoint(dbConn, AutoCheckpointOpts{Interval: 10 * time.Millisecond})
+	_, err = dbConn.ExecContext(ctx, `insert into foo (name) values ("name")`)
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+}
@@ -0,0 +1,49 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrProcessLockHeld is returned by AcquireProcessLock when the lock is held by another process.
+var ErrProcessLockHeld = errors.New("process lock is held by another process")
+
+// ProcessLock is an exclusive lock based on the OS file lock (flock on Unix systems, LockFileEx on Windows).
+// It may be used as a single-writer guard for the SQLite database file, so only one process (e.g. service instance
+// in the on-prem single-node deployment) works with the database, and SQLITE_BUSY errors caused
+// by concurrent writers from different processes are avoided.
+// Lock is released automatically by OS if the process is terminated.
+type ProcessLock struct {
+	file *os.File
+}
+
+// AcquireProcessLock acquires exclusive lock on the file with the passed path (file is created if it doesn't exist).
+// It doesn't wait, ErrProcessLockHeld is returned if the lock is held by another process.
+// Typically, the path is the database file path with ".lock" suffix.
+func AcquireProcessLock(path string) (*ProcessLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+	if err = lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &ProcessLock{file: file}, nil
+}
+
+// Release releases the lock.
+func (l *ProcessLock) Release() error {
+	if err := unlockFile(l.file); err != nil {
+		_ = l.file.Close()
+		return fmt.Errorf("unlock file: %w", err)
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,24 @@
+//go:build !unix && !windows
+
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package sqlite
+
+import (
+	"errors"
+	"os"
+)
+
+var errProcessLockNotSupported = errors.New("process lock is not supported on this platform")
+
+func lockFile(file *os.File) error {
+	return errProcessLockNotSupported
+}
+
+func unlockFile(file *os.File) error {
+	return errProcessLockNotSupported
+}
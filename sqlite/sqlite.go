@@ -9,6 +9,9 @@ Released under MIT license.
 // To register sqlite as retryable func use side effect import like so:
 //
 //	import _ "github.com/acronis/go-dbkit/sqlite"
+//
+// DoInImmediateTx runs a function in the transaction started by BEGIN IMMEDIATE,
+// and AcquireProcessLock provides a file lock that guarantees a single writer process for the database.
 package sqlite
 
 import (
@@ -22,12 +25,34 @@ func init() {
 	dbkit.RegisterIsRetryableFunc(&sqlite3.SQLiteDriver{}, func(err error) bool {
 		if sqliteErr, ok := err.(sqlite3.Error); ok {
 			switch sqliteErr.Code {
+			// Primary code is also set for extended ones (SQLITE_BUSY_SNAPSHOT, SQLITE_BUSY_RECOVERY,
+			// SQLITE_LOCKED_SHAREDCACHE and so on), so they are retried too.
+			// SQLITE_BUSY_SNAPSHOT occurs in WAL mode when read transaction is upgraded to write one,
+			// but the database snapshot is outdated. Whole transaction should be retried.
 			case sqlite3.ErrLocked, sqlite3.ErrBusy:
 				return true
 			}
 		}
 		return false
 	})
+	dbkit.RegisterQueryErrorClassifier(classifyQueryError)
+}
+
+// classifyQueryError returns class of the SQLite error or an empty string if the error is not a SQLite one.
+func classifyQueryError(err error) dbkit.QueryErrorClass {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok {
+		return ""
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrLocked, sqlite3.ErrBusy:
+		return dbkit.QueryErrorClassTimeout
+	}
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return dbkit.QueryErrorClassUniqueViolation
+	}
+	return dbkit.QueryErrorClassOther
 }
 
 // CheckSQLiteError checks if the passed error relates to SQLite and it's internal code matches the one from the argument.
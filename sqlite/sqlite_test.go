@@ -142,6 +142,37 @@ func TestSqliteIsRetryable(t *testing.T) {
 	})))
 }
 
+func TestSqliteIsRetryable_BusySnapshot(t *testing.T) {
+	ctx := context.Background()
+	dbConn, err := sql.Open("sqlite3", t.TempDir()+"/TestSqliteIsRetryable_BusySnapshot.db?_journal_mode=WAL")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	_, err = dbConn.ExecContext(ctx, createFooTable)
+	require.NoError(t, err)
+
+	readConn, err := dbConn.Conn(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, readConn.Close()) }()
+	writeConn, err := dbConn.Conn(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, writeConn.Close()) }()
+
+	// Read transaction takes a snapshot, which becomes outdated after the concurrent write,
+	// so it cannot be upgraded to write one.
+	_, err = readConn.ExecContext(ctx, "BEGIN")
+	require.NoError(t, err)
+	var cnt int
+	require.NoError(t, readConn.QueryRowContext(ctx, "SELECT COUNT(*) FROM foo").Scan(&cnt))
+	_, err = writeConn.ExecContext(ctx, `insert into foo values (1, "one")`)
+	require.NoError(t, err)
+	_, err = readConn.ExecContext(ctx, `insert into foo values (2, "two")`)
+	require.True(t, CheckSQLiteError(err, sqlite3.ErrBusySnapshot), "unexpected error: %v", err)
+	require.True(t, dbkit.GetIsRetryable(dbConn.Driver())(err))
+	_, err = readConn.ExecContext(ctx, "ROLLBACK")
+	require.NoError(t, err)
+}
+
 func execAndSleepInTx(ctx context.Context, dbConn *sql.DB, stmt string, errCh chan error, sleepTime time.Duration) {
 	tx, txErr := dbConn.BeginTx(ctx, nil)
 	if txErr != nil {
@@ -200,3 +231,19 @@ db:
 		require.Equal(t, sql.LevelDefault, cfg.TxIsolationLevel())
 	})
 }
+
+func TestClassifyQueryError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want dbkit.QueryErrorClass
+	}{
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, dbkit.QueryErrorClassTimeout},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, dbkit.QueryErrorClassUniqueViolation},
+		{fmt.Errorf("wrapped: %w", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}),
+			dbkit.QueryErrorClassUniqueViolation},
+		{sqlite3.Error{Code: sqlite3.ErrError}, dbkit.QueryErrorClassOther},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, dbkit.ClassifyQueryError(tt.err), "error: %v", tt.err)
+	}
+}
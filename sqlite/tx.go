@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// DoInImmediateTx begins a new transaction with BEGIN IMMEDIATE statement on the dedicated connection,
+// calls passed function and does commit or rollback depending on whether the function returns an error or not.
+// Write lock is acquired at the beginning of the transaction (instead of the first write statement as for BEGIN DEFERRED),
+// so SQLITE_BUSY error may be returned only by the BEGIN statement (busy timeout is respected there)
+// and not in the middle of the transaction, when it cannot be retried automatically.
+// Statements inside the function should be executed via the passed connection.
+// If all transactions should be immediate, "_txlock=immediate" DSN parameter may be used instead.
+func DoInImmediateTx(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return &beginImmediateTxError{inner: err}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+			panic(p)
+		}
+		if err != nil {
+			_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+			return
+		}
+		if _, err = conn.ExecContext(ctx, "COMMIT"); err != nil {
+			_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+			err = &dbkit.TxCommitError{Inner: err}
+		}
+	}()
+	return fn(conn)
+}
+
+// beginImmediateTxError is returned when BEGIN IMMEDIATE statement is failed.
+// It may be obtained via errors.As as *dbkit.TxBeginError.
+type beginImmediateTxError struct {
+	inner error
+}
+
+// Unwrap returns the original error, so IsRetryable works with it.
+func (e *beginImmediateTxError) Unwrap() error {
+	return e.inner
+}
+
+// As allows to obtain the error via errors.As as *dbkit.TxBeginError.
+func (e *beginImmediateTxError) As(target interface{}) bool {
+	if beginErr, ok := target.(**dbkit.TxBeginError); ok {
+		*beginErr = &dbkit.TxBeginError{Inner: e.inner}
+		return true
+	}
+	return false
+}
+
+// Error returns a string representation of beginImmediateTxError.
+func (e *beginImmediateTxError) Error() string {
+	return fmt.Sprintf("begin immediate tx: %s", e.inner)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/acronis/go-dbkit"
+)
+
+func TestDoInImmediateTx(t *testing.T) {
+	ctx := context.Background()
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "immediate_tx.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	_, err = dbConn.Exec(createFooTable)
+	require.NoError(t, err)
+
+	countRows := func() int {
+		var n int
+		require.NoError(t, dbConn.QueryRowContext(ctx, "select count(*) from foo").Scan(&n))
+		return n
+	}
+
+	require.NoError(t, DoInImmediateTx(ctx, dbConn, func(conn *sql.Conn) error {
+		_, execErr := conn.ExecContext(ctx, `insert into foo values (1, "one")`)
+		return execErr
+	}))
+	require.Equal(t, 1, countRows())
+
+	fnErr := errors.New("fn error")
+	require.ErrorIs(t, DoInImmediateTx(ctx, dbConn, func(conn *sql.Conn) error {
+		if _, execErr := conn.ExecContext(ctx, `insert into foo values (2, "two")`); execErr != nil {
+			return execErr
+		}
+		return fnErr
+	}), fnErr)
+	require.Equal(t, 1, countRows())
+
+	require.Panics(t, func() {
+		_ = DoInImmediateTx(ctx, dbConn, func(conn *sql.Conn) error {
+			_, _ = conn.ExecContext(ctx, `insert into foo values (3, "three")`)
+			panic("fn panic")
+		})
+	})
+	require.Equal(t, 1, countRows())
+}
+
+func TestBeginImmediateTxError(t *testing.T) {
+	innerErr := errors.New("database is locked")
+	err := error(&beginImmediateTxError{inner: innerErr})
+	require.EqualError(t, err, "begin immediate tx: database is locked")
+	require.ErrorIs(t, err, innerErr)
+	var beginErr *dbkit.TxBeginError
+	require.ErrorAs(t, err, &beginErr)
+	require.Equal(t, innerErr, beginErr.Inner)
+}
+
+func TestProcessLock(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "app.db.lock")
+
+	lock, err := AcquireProcessLock(lockPath)
+	require.NoError(t, err)
+
+	_, err = AcquireProcessLock(lockPath)
+	require.ErrorIs(t, err, ErrProcessLockHeld)
+
+	require.NoError(t, lock.Release())
+
+	lock, err = AcquireProcessLock(lockPath)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-dbkit/internal/ctxutil"
+)
+
+// DoInTxWithStatementTimeout begins a new transaction with the server-side statement timeout,
+// calls passed function and does commit or rollback depending on whether the function returns an error or not.
+// Long-running queries are killed by the server even if the client context is mishandled (e.g. not canceled).
+// Postgres uses SET LOCAL statement_timeout, so it's reset automatically when the transaction ends.
+// MySQL uses max_execution_time session variable (it's applied to SELECT statements only)
+// that is set on the dedicated connection and reset to the global value after the transaction ends
+// (if it cannot be reset, e.g. due to the broken connection, the connection is discarded instead of returning it to the pool).
+// Timeout is not set if it's not positive.
+func DoInTxWithStatementTimeout(
+	ctx context.Context, dbConn *sql.DB, dialect Dialect, timeout time.Duration, fn func(tx *sql.Tx) error,
+) error {
+	return DoInTxWithStatementTimeoutWithOpts(ctx, dbConn, dialect, timeout, nil, fn)
+}
+
+// DoInTxWithStatementTimeoutWithOpts is a bit more configurable version of DoInTxWithStatementTimeout
+// that allows passing tx options.
+func DoInTxWithStatementTimeoutWithOpts(
+	ctx context.Context, dbConn *sql.DB, dialect Dialect, timeout time.Duration, txOpts *sql.TxOptions, fn func(tx *sql.Tx) error,
+) error {
+	if timeout <= 0 {
+		return DoInTxWithOpts(ctx, dbConn, txOpts, fn)
+	}
+	timeoutMs := timeout.Milliseconds()
+	if timeoutMs == 0 {
+		timeoutMs = 1
+	}
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		return DoInTxWithOpts(ctx, dbConn, txOpts, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeoutMs)); err != nil {
+				return fmt.Errorf("set statement timeout: %w", err)
+			}
+			return fn(tx)
+		})
+	case DialectMySQL:
+		return DoWithConn(ctx, dbConn, func(conn *sql.Conn) (err error) {
+			if _, err = conn.ExecContext(ctx, fmt.Sprintf("SET SESSION max_execution_time = %d", timeoutMs)); err != nil {
+				return fmt.Errorf("set statement timeout: %w", err)
+			}
+			defer func() {
+				// Session variables are not transactional in MySQL, so it should be reset explicitly
+				// (even if the context is canceled) before the connection is returned to the pool.
+				resetErr := ResetSessionVariable(ctxutil.WithoutCancel(ctx), conn, DialectMySQL, "max_execution_time")
+				if resetErr != nil {
+					// Session state is unknown, so the connection should not be reused.
+					_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+					if err == nil {
+						err = fmt.Errorf("reset statement timeout: %w", resetErr)
+					}
+				}
+			}()
+			return doInTx(ctx, conn, txOpts, fn)
+		})
+	default:
+		return fmt.Errorf("statement timeout is not supported for dialect %q", dialect)
+	}
+}
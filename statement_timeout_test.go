@@ -0,0 +1,114 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoInTxWithStatementTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("postgres", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("SET LOCAL statement_timeout = 1500")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM users").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		require.NoError(t, DoInTxWithStatementTimeout(ctx, db, DialectPgx, 1500*time.Millisecond, func(tx *sql.Tx) error {
+			_, execErr := tx.ExecContext(ctx, "DELETE FROM users")
+			return execErr
+		}))
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("mysql, timeout is reset", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectExec(regexp.QuoteMeta("SET SESSION max_execution_time = 2000")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		mock.ExpectExec(regexp.QuoteMeta("SET SESSION max_execution_time = DEFAULT")).WillReturnResult(sqlmock.NewResult(0, 0))
+		fnErr := errors.New("fn error")
+		require.ErrorIs(t, DoInTxWithStatementTimeoutWithOpts(ctx, db, DialectMySQL, 2*time.Second, &sql.TxOptions{},
+			func(tx *sql.Tx) error {
+				return fnErr
+			}), fnErr)
+
+		// Connection is discarded if timeout cannot be reset.
+		mock.ExpectExec(regexp.QuoteMeta("SET SESSION max_execution_time = 2000")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		mock.ExpectExec(regexp.QuoteMeta("SET SESSION max_execution_time = DEFAULT")).WillReturnError(errors.New("reset error"))
+		mock.ExpectClose()
+		require.EqualError(t, DoInTxWithStatementTimeout(ctx, db, DialectMySQL, 2*time.Second, func(tx *sql.Tx) error {
+			return nil
+		}), "reset statement timeout: reset session variable max_execution_time: reset error")
+		require.Equal(t, 0, db.Stats().OpenConnections)
+
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("mysql, context is canceled", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		// Transaction is rolled back by database/sql due to the canceled context,
+		// and sqlmock connection doesn't support resetting its session, so it's discarded.
+		mock.ExpectExec(regexp.QuoteMeta("SET SESSION max_execution_time = 2000")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		mock.ExpectClose()
+		cancelCtx, cancel := context.WithCancel(ctx)
+		require.ErrorIs(t, DoInTxWithStatementTimeout(cancelCtx, db, DialectMySQL, 2*time.Second, func(tx *sql.Tx) error {
+			cancel()
+			return cancelCtx.Err()
+		}), context.Canceled)
+		require.Eventually(t, func() bool {
+			return db.Stats().OpenConnections == 0
+		}, time.Second, 10*time.Millisecond)
+
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("timeout is not set", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		require.NoError(t, DoInTxWithStatementTimeout(ctx, db, DialectSQLite, 0, func(tx *sql.Tx) error {
+			return nil
+		}))
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unsupported dialect", func(t *testing.T) {
+		require.EqualError(t, DoInTxWithStatementTimeout(ctx, nil, DialectSQLite, time.Second, func(tx *sql.Tx) error {
+			return nil
+		}), `statement timeout is not supported for dialect "sqlite3"`)
+	})
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/acronis/go-dbkit/internal/ctxutil"
+)
+
+// SQLExecutor is an interface for executing SQL statements (*sql.DB, *sql.Conn and *sql.Tx implement it).
+type SQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// TempTableColumn represents a column of the temporary table.
+type TempTableColumn struct {
+	Name string
+	// Type is a SQL type of the column in terms of the dialect (e.g. "BIGINT" or "VARCHAR(255)").
+	Type string
+}
+
+// TempTable represents a temporary table created by CreateTempTable.
+// Temporary tables are visible only within the session (connection), so TempTable should be used with the executor
+// bound to a single connection: *sql.Tx or *sql.Conn (see DoWithConn), but not *sql.DB.
+// It's a common workaround for the limit of the query parameters:
+// large IN-lists or staging data are loaded into the temporary table that is joined then.
+type TempTable struct {
+	dialect    Dialect
+	executor   SQLExecutor
+	quotedName string
+	columns    []string
+}
+
+// CreateTempTable creates a temporary table with the specified columns in the dialect-appropriate way.
+// For MSSQL, "#" prefix is added to the name, since it's required for local temporary tables.
+// Table should be dropped (see TempTable.Drop) when it's not needed anymore; DoWithTempTable does it automatically.
+func CreateTempTable(
+	ctx context.Context, executor SQLExecutor, dialect Dialect, name string, columns []TempTableColumn,
+) (*TempTable, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns specified")
+	}
+	if dialect == DialectMSSQL && !strings.HasPrefix(name, "#") {
+		name = "#" + name
+	}
+	if err := ValidateIdentifier(dialect, name); err != nil {
+		return nil, fmt.Errorf("temporary table name: %w", err)
+	}
+	t := &TempTable{dialect: dialect, executor: executor, quotedName: QuoteIdentifier(dialect, name)}
+	columnDefs := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if err := ValidateIdentifier(dialect, column.Name); err != nil {
+			return nil, fmt.Errorf("temporary table column: %w", err)
+		}
+		t.columns = append(t.columns, column.Name)
+		columnDefs = append(columnDefs, QuoteIdentifier(dialect, column.Name)+" "+column.Type)
+	}
+
+	var createPrefix string
+	switch dialect {
+	case DialectPostgres, DialectPgx, DialectMySQL:
+		createPrefix = "CREATE TEMPORARY TABLE "
+	case DialectSQLite:
+		createPrefix = "CREATE TEMP TABLE "
+	case DialectMSSQL:
+		createPrefix = "CREATE TABLE "
+	default:
+		return nil, fmt.Errorf("temporary tables are not supported for dialect %q", dialect)
+	}
+	query := createPrefix + t.quotedName + " (" + strings.Join(columnDefs, ", ") + ")"
+	if _, err := executor.ExecContext(ctx, query); err != nil {
+		return nil, fmt.Errorf("create temporary table: %w", err)
+	}
+	return t, nil
+}
+
+// DoWithTempTable creates a temporary table, calls passed function and drops the table,
+// even if the function returns an error or the context is canceled.
+func DoWithTempTable(
+	ctx context.Context, executor SQLExecutor, dialect Dialect, name string, columns []TempTableColumn, fn func(t *TempTable) error,
+) (err error) {
+	var t *TempTable
+	if t, err = CreateTempTable(ctx, executor, dialect, name, columns); err != nil {
+		return err
+	}
+	defer func() {
+		if dropErr := t.Drop(ctxutil.WithoutCancel(ctx)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+	return fn(t)
+}
+
+// Name returns quoted name of the temporary table, so it may be used in SQL (e.g. in JOIN clause).
+func (t *TempTable) Name() string {
+	return t.quotedName
+}
+
+// SelectColumn returns SELECT statement for the column of the temporary table.
+// It's useful for replacing large IN-lists: "WHERE id IN (" + t.SelectColumn("id") + ")".
+func (t *TempTable) SelectColumn(column string) string {
+	return "SELECT " + QuoteIdentifier(t.dialect, column) + " FROM " + t.quotedName
+}
+
+// Insert loads rows into the temporary table via multi-row INSERT statements (see BulkInsert).
+// Values in rows should be in the same order as columns passed to CreateTempTable. Number of inserted rows is returned.
+func (t *TempTable) Insert(ctx context.Context, rows [][]interface{}) (int64, error) {
+	return bulkInsert(ctx, t.executor, t.dialect, t.quotedName, t.columns, rows, 0)
+}
+
+// Drop drops the temporary table.
+func (t *TempTable) Drop(ctx context.Context) error {
+	var query string
+	switch t.dialect {
+	case DialectMySQL:
+		query = "DROP TEMPORARY TABLE IF EXISTS " + t.quotedName
+	default:
+		query = "DROP TABLE IF EXISTS " + t.quotedName
+	}
+	if _, err := t.executor.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("drop temporary table: %w", err)
+	}
+	return nil
+}
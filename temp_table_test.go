@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoWithTempTable(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "temp_table.db"))
+		require.NoError(t, err)
+		defer requireNoErrOnClose(t, db)
+
+		_, err = db.ExecContext(ctx, `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`)
+		require.NoError(t, err)
+		_, err = BulkInsert(ctx, db, DialectSQLite, "users", []string{"id", "name"},
+			[][]interface{}{{1, "Alice"}, {2, "Bob"}, {3, "Carol"}, {4, "Dave"}})
+		require.NoError(t, err)
+
+		var names []string
+		require.NoError(t, DoWithConn(ctx, db, func(conn *sql.Conn) error {
+			return DoWithTempTable(ctx, conn, DialectSQLite, "tmp_user_ids", []TempTableColumn{{Name: "id", Type: "INTEGER"}},
+				func(tmp *TempTable) error {
+					inserted, insertErr := tmp.Insert(ctx, [][]interface{}{{2}, {4}, {5}})
+					require.NoError(t, insertErr)
+					require.Equal(t, int64(3), inserted)
+
+					rows, queryErr := conn.QueryContext(ctx,
+						"SELECT name FROM users WHERE id IN ("+tmp.SelectColumn("id")+") ORDER BY name")
+					if queryErr != nil {
+						return queryErr
+					}
+					defer func() { _ = rows.Close() }()
+					for rows.Next() {
+						var name string
+						if scanErr := rows.Scan(&name); scanErr != nil {
+							return scanErr
+						}
+						names = append(names, name)
+					}
+					return rows.Err()
+				})
+		}))
+		require.Equal(t, []string{"Bob", "Dave"}, names)
+	})
+
+	t.Run("table is dropped if function fails", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		fnErr := errors.New("fn error")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TEMPORARY TABLE `tmp_ids` (`id` BIGINT)")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("DROP TEMPORARY TABLE IF EXISTS `tmp_ids`")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		require.ErrorIs(t, DoInTx(ctx, db, func(tx *sql.Tx) error {
+			return DoWithTempTable(ctx, tx, DialectMySQL, "tmp_ids", []TempTableColumn{{Name: "id", Type: "BIGINT"}},
+				func(tmp *TempTable) error {
+					return fnErr
+				})
+		}), fnErr)
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("mssql local temporary table", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE [#tmp_ids] ([id] BIGINT)")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO [#tmp_ids] ([id]) VALUES ($1), ($2)")).
+			WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta("DROP TABLE IF EXISTS [#tmp_ids]")).WillReturnResult(sqlmock.NewResult(0, 0))
+		require.NoError(t, DoWithConn(ctx, db, func(conn *sql.Conn) error {
+			return DoWithTempTable(ctx, conn, DialectMSSQL, "tmp_ids", []TempTableColumn{{Name: "id", Type: "BIGINT"}},
+				func(tmp *TempTable) error {
+					require.Equal(t, "[#tmp_ids]", tmp.Name())
+					_, insertErr := tmp.Insert(ctx, [][]interface{}{{1}, {2}})
+					return insertErr
+				})
+		}))
+
+		mock.ExpectClose()
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/acronis/go-appkit/retry"
+)
+
+// DefaultTxBatchSize is a default number of items processed in a single transaction by DoInTxBatched.
+const DefaultTxBatchSize = 1000
+
+// TxBatchProgress represents a progress of DoInTxBatched.
+type TxBatchProgress struct {
+	// Next is an index of the first unprocessed item. It may be saved and used as TxBatchOpts.ResumeFrom
+	// for resuming processing after the failure or restart.
+	Next int
+
+	// Total is a total number of items.
+	Total int
+
+	// Batches is a number of committed batches (transactions) in the current call.
+	Batches int
+}
+
+// TxBatchOpts represents an options for DoInTxBatched.
+type TxBatchOpts struct {
+	// BatchSize is a number of items processed in a single transaction. DefaultTxBatchSize is used by default.
+	BatchSize int
+
+	// TxOptions (optional) are used for beginning transactions.
+	TxOptions *sql.TxOptions
+
+	// RetryPolicy is a retry policy for each batch. If it's nil, batch transaction is executed only once.
+	// Errors are retried if they are retryable for the driver of the passed *sql.DB (see GetIsRetryable).
+	RetryPolicy retry.Policy
+
+	// ResumeFrom is an index of the first item to process (e.g. TxBatchProgress.Next saved by the previous run).
+	ResumeFrom int
+
+	// OnProgress (optional) is called after each committed batch.
+	OnProgress func(progress TxBatchProgress)
+}
+
+// DoInTxBatched processes total items (e.g. elements of a large slice in backfill jobs) in fixed-size batches,
+// each batch in its own transaction (with retries if RetryPolicy is specified).
+// The function is called with the transaction and the half-open range [start, end) of the items indexes.
+// It returns an index of the first unprocessed item, so processing may be resumed from it (see TxBatchOpts.ResumeFrom).
+func DoInTxBatched(
+	ctx context.Context, dbConn *sql.DB, total int, opts TxBatchOpts, fn func(tx *sql.Tx, start, end int) error,
+) (next int, err error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultTxBatchSize
+	}
+	isRetryable := GetIsRetryable(dbConn.Driver())
+	progress := TxBatchProgress{Next: opts.ResumeFrom, Total: total}
+	for progress.Next < total {
+		if err = ctx.Err(); err != nil {
+			return progress.Next, err
+		}
+		start, end := progress.Next, progress.Next+batchSize
+		if end > total {
+			end = total
+		}
+		doBatch := func(ctx context.Context) error {
+			return DoInTxWithOpts(ctx, dbConn, opts.TxOptions, func(tx *sql.Tx) error {
+				return fn(tx, start, end)
+			})
+		}
+		if opts.RetryPolicy != nil {
+			err = retry.DoWithRetry(ctx, opts.RetryPolicy, isRetryable, nil, doBatch)
+		} else {
+			err = doBatch(ctx)
+		}
+		if err != nil {
+			return progress.Next, fmt.Errorf("process batch [%d, %d): %w", start, end, err)
+		}
+		progress.Next = end
+		progress.Batches++
+		if opts.OnProgress != nil {
+			opts.OnProgress(progress)
+		}
+	}
+	return progress.Next, nil
+}
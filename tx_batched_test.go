@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/retry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoInTxBatched(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "batched.db"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	_, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+
+	items := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	errBatch := errors.New("batch error")
+	var failedStart int
+	insertItems := func(tx *sql.Tx, start, end int) error {
+		for _, item := range items[start:end] {
+			if _, err := tx.Exec("INSERT INTO items (id) VALUES (?)", item); err != nil {
+				return err
+			}
+		}
+		if start == failedStart {
+			return errBatch
+		}
+		return nil
+	}
+	countItems := func() (cnt int) {
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM items").Scan(&cnt))
+		return cnt
+	}
+
+	// The third batch fails, so the first two ones are committed.
+	failedStart = 6
+	var progresses []TxBatchProgress
+	opts := TxBatchOpts{BatchSize: 3, OnProgress: func(progress TxBatchProgress) {
+		progresses = append(progresses, progress)
+	}}
+	next, err := DoInTxBatched(context.Background(), db, len(items), opts, insertItems)
+	require.ErrorIs(t, err, errBatch)
+	require.EqualError(t, err, "process batch [6, 9): batch error")
+	require.Equal(t, 6, next)
+	require.Equal(t, []TxBatchProgress{{Next: 3, Total: 10, Batches: 1}, {Next: 6, Total: 10, Batches: 2}}, progresses)
+	require.Equal(t, 6, countItems())
+
+	// Processing is resumed from the failed batch.
+	failedStart = -1
+	progresses = nil
+	opts.ResumeFrom = next
+	next, err = DoInTxBatched(context.Background(), db, len(items), opts, insertItems)
+	require.NoError(t, err)
+	require.Equal(t, len(items), next)
+	require.Equal(t, []TxBatchProgress{{Next: 9, Total: 10, Batches: 1}, {Next: 10, Total: 10, Batches: 2}}, progresses)
+	require.Equal(t, len(items), countItems())
+}
+
+func TestDoInTxBatched_Retry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	errRetryable := errors.New("retryable error")
+	defer WithIsRetryable(db.Driver(), func(err error) bool { return errors.Is(err, errRetryable) })()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE items").WithArgs(0, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit().WillReturnError(errRetryable)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE items").WithArgs(0, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE items").WithArgs(2, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	opts := TxBatchOpts{BatchSize: 2, RetryPolicy: retry.NewConstantBackoffPolicy(time.Millisecond, 3)}
+	next, err := DoInTxBatched(context.Background(), db, 3, opts, func(tx *sql.Tx, start, end int) error {
+		_, execErr := tx.Exec("UPDATE items SET processed = 1 WHERE idx >= ? AND idx < ?", start, end)
+		return execErr
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, next)
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+)
+
+type txCtxKey int
+
+const ctxKeyTx txCtxKey = iota
+
+// NewContextWithTx creates a new context with the transaction.
+// It allows repository functions to be transaction-agnostic (see DoInTxCtx):
+// dbr users may wrap it into dbr.Tx, goqu users - via goqu.NewTx, and raw SQL users may use it directly.
+func NewContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, ctxKeyTx, tx)
+}
+
+// TxFromContext returns the transaction set by NewContextWithTx or nil if there is no transaction in the context.
+func TxFromContext(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(ctxKeyTx).(*sql.Tx)
+	return tx
+}
+
+// DoInTxCtx calls passed function within the transaction from the context (see NewContextWithTx) if there is one,
+// so commit or rollback is left to the code that has begun it.
+// Otherwise, it begins a new transaction (as DoInTx does) and passes the context with it to the function,
+// so nested DoInTxCtx calls reuse the same transaction.
+func DoInTxCtx(ctx context.Context, dbConn *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return DoInTxCtxWithOpts(ctx, dbConn, nil, fn)
+}
+
+// DoInTxCtxWithOpts is a bit more configurable version of DoInTxCtx that allows passing tx options.
+// Options are ignored if the transaction from the context is reused.
+func DoInTxCtxWithOpts(
+	ctx context.Context, dbConn *sql.DB, txOpts *sql.TxOptions, fn func(ctx context.Context, tx *sql.Tx) error,
+) error {
+	if tx := TxFromContext(ctx); tx != nil {
+		return fn(ctx, tx)
+	}
+	return DoInTxWithOpts(ctx, dbConn, txOpts, func(tx *sql.Tx) error {
+		return fn(NewContextWithTx(ctx, tx), tx)
+	})
+}
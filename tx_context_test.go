@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoInTxCtx(t *testing.T) {
+	insertUser := func(ctx context.Context, db *sql.DB, name string) error {
+		return DoInTxCtx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO users (name) VALUES (?)", name)
+			return err
+		})
+	}
+
+	t.Run("new transaction is begun if there is no one in the context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer func() {
+			requireNoErrOnClose(t, db)
+			require.NoError(t, mock.ExpectationsWereMet())
+		}()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO users").WithArgs("alice").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectClose()
+
+		require.Nil(t, TxFromContext(context.Background()))
+		require.NoError(t, insertUser(context.Background(), db, "alice"))
+	})
+
+	t.Run("nested calls reuse transaction from the context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer func() {
+			requireNoErrOnClose(t, db)
+			require.NoError(t, mock.ExpectationsWereMet())
+		}()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO users").WithArgs("alice").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO users").WithArgs("bob").WillReturnError(errors.New("insert error"))
+		mock.ExpectRollback()
+		mock.ExpectClose()
+
+		err = DoInTxCtx(context.Background(), db, func(ctx context.Context, tx *sql.Tx) error {
+			require.Same(t, tx, TxFromContext(ctx))
+			if err := insertUser(ctx, db, "alice"); err != nil {
+				return err
+			}
+			return insertUser(ctx, db, "bob")
+		})
+		require.EqualError(t, err, "insert error")
+	})
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import "fmt"
+
+// TxBeginError is an error that may occur when beginning transaction is failed.
+// Since nothing has been executed within the transaction yet, it's always safe to retry the whole transaction.
+type TxBeginError struct {
+	Inner error
+}
+
+// Unwrap unwraps internal error for IsRetryable algorithm.
+func (e *TxBeginError) Unwrap() error {
+	return e.Inner
+}
+
+// Error returns a string representation of TxBeginError.
+func (e *TxBeginError) Error() string {
+	return fmt.Sprintf("begin tx: %s", e.Inner)
+}
+
+// TxCommitError is an error that may occur when committing transaction is failed.
+// Note that if it's caused by the connection error, the transaction may be committed on the server side.
+type TxCommitError struct {
+	Inner error
+}
+
+// Unwrap unwraps internal error for IsRetryable algorithm.
+func (e *TxCommitError) Unwrap() error {
+	return e.Inner
+}
+
+// Error returns a string representation of TxCommitError.
+func (e *TxCommitError) Error() string {
+	return fmt.Sprintf("commit tx: %s", e.Inner)
+}
+
+// TxRollbackError is an error that may occur when rollback has failed.
+type TxRollbackError struct {
+	Inner error
+}
+
+// Unwrap unwraps internal error for IsRetryable algorithm.
+func (e *TxRollbackError) Unwrap() error {
+	return e.Inner
+}
+
+// Error returns a string representation of TxRollbackError.
+func (e *TxRollbackError) Error() string {
+	return fmt.Sprintf("rollback tx: %s", e.Inner)
+}
+
+// txRollbackFailedError is returned when the transaction is failed and its rollback is failed too.
+// It wraps the original error and exposes the rollback one via errors.As.
+type txRollbackFailedError struct {
+	err         error
+	rollbackErr *TxRollbackError
+}
+
+// Unwrap returns the original error, so IsRetryable and ClassifyQueryError work with it.
+func (e *txRollbackFailedError) Unwrap() error {
+	return e.err
+}
+
+// As allows to obtain the rollback error via errors.As.
+func (e *txRollbackFailedError) As(target interface{}) bool {
+	if rollbackErr, ok := target.(**TxRollbackError); ok {
+		*rollbackErr = e.rollbackErr
+		return true
+	}
+	return false
+}
+
+// Error returns a string representation of txRollbackFailedError.
+func (e *txRollbackFailedError) Error() string {
+	return fmt.Sprintf("%s; %s", e.err, e.rollbackErr)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+)
+
+type txWatchdogCtxKey int
+
+const (
+	ctxKeyTxWatchdog txWatchdogCtxKey = iota
+	ctxKeyTxAnnotation
+)
+
+const txWatchdogMaxStackDepth = 32
+
+// TxWatchdogOpts represents an options for TxWatchdog.
+type TxWatchdogOpts struct {
+	// Threshold is a duration after which the transaction is considered as long-running.
+	Threshold time.Duration
+
+	// Logger is used for logging warnings about long-running transactions.
+	Logger log.FieldLogger
+
+	// MetricsCollector (optional) is used for counting long-running transactions.
+	// It's implemented by MetricsCollector (Prometheus) and by otelmetrics.MetricsCollector (OpenTelemetry).
+	MetricsCollector LongTransactionMetrics
+}
+
+// TxWatchdog logs a warning with stack trace and annotation when a transaction stays open longer than a threshold.
+// Watchdog should be put into the context (see NewContextWithTxWatchdog),
+// and it will be used by DoInTx, DoInTxWithOpts and transaction wrappers from dbrutil and goquutil packages.
+type TxWatchdog struct {
+	threshold        time.Duration
+	logger           log.FieldLogger
+	metricsCollector LongTransactionMetrics
+}
+
+// NewTxWatchdog creates a new TxWatchdog.
+func NewTxWatchdog(opts TxWatchdogOpts) *TxWatchdog {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.NewDisabledLogger()
+	}
+	return &TxWatchdog{threshold: opts.Threshold, logger: logger, metricsCollector: opts.MetricsCollector}
+}
+
+// Watch starts watching for the transaction and returns a function that should be called when transaction is finished.
+func (w *TxWatchdog) Watch(annotation string) (stop func()) {
+	if w == nil || w.threshold <= 0 {
+		return func() {}
+	}
+	pcs := make([]uintptr, txWatchdogMaxStackDepth)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	startedAt := time.Now()
+	timer := time.AfterFunc(w.threshold, func() {
+		w.logger.Warn("long-running SQL transaction",
+			log.String("annotation", annotation),
+			log.Int64("duration_ms", time.Since(startedAt).Milliseconds()),
+			log.String("stack", formatStack(pcs)),
+		)
+		if w.metricsCollector != nil {
+			w.metricsCollector.ObserveLongTransaction(annotation)
+		}
+	})
+	return func() {
+		timer.Stop()
+	}
+}
+
+func formatStack(pcs []uintptr) string {
+	var sb strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		_, _ = fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
+
+// NewContextWithTxWatchdog creates a new context with TxWatchdog.
+func NewContextWithTxWatchdog(ctx context.Context, w *TxWatchdog) context.Context {
+	return context.WithValue(ctx, ctxKeyTxWatchdog, w)
+}
+
+// GetTxWatchdogFromContext extracts TxWatchdog from the context.
+func GetTxWatchdogFromContext(ctx context.Context) *TxWatchdog {
+	if ctx == nil {
+		return nil
+	}
+	w, _ := ctx.Value(ctxKeyTxWatchdog).(*TxWatchdog)
+	return w
+}
+
+// NewContextWithTxAnnotation creates a new context with annotation of the transaction
+// that will be used for logging and metrics of TxWatchdog.
+func NewContextWithTxAnnotation(ctx context.Context, annotation string) context.Context {
+	return context.WithValue(ctx, ctxKeyTxAnnotation, annotation)
+}
+
+// GetTxAnnotationFromContext extracts annotation of the transaction from the context.
+func GetTxAnnotationFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	annotation, _ := ctx.Value(ctxKeyTxAnnotation).(string)
+	return annotation
+}
+
+// WatchTx starts watching for the transaction with TxWatchdog from the context (if any)
+// and returns a function that should be called when transaction is finished.
+func WatchTx(ctx context.Context) (stop func()) {
+	return GetTxWatchdogFromContext(ctx).Watch(GetTxAnnotationFromContext(ctx))
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxWatchdog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		requireNoErrOnClose(t, db)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	t.Run("long-running transaction is reported", func(t *testing.T) {
+		logRecorder := logtest.NewRecorder()
+		mc := NewMetricsCollector()
+		watchdog := NewTxWatchdog(TxWatchdogOpts{Threshold: 10 * time.Millisecond, Logger: logRecorder, MetricsCollector: mc})
+		ctx := NewContextWithTxAnnotation(NewContextWithTxWatchdog(context.Background(), watchdog), "long_tx")
+
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		require.NoError(t, DoInTx(ctx, db, func(tx *sql.Tx) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}))
+
+		require.Equal(t, 1, len(logRecorder.Entries()))
+		logEntry := logRecorder.Entries()[0]
+		require.Equal(t, "long-running SQL transaction", logEntry.Text)
+		annotationField, found := logEntry.FindField("annotation")
+		require.True(t, found)
+		require.Equal(t, "long_tx", string(annotationField.Bytes))
+		stackField, found := logEntry.FindField("stack")
+		require.True(t, found)
+		require.Contains(t, string(stackField.Bytes), "TestTxWatchdog")
+		requireCounterValue(t, mc.LongTransactions.With(prometheus.Labels{MetricsLabelQuery: "long_tx"}), 1)
+	})
+
+	t.Run("fast transaction is not reported", func(t *testing.T) {
+		logRecorder := logtest.NewRecorder()
+		watchdog := NewTxWatchdog(TxWatchdogOpts{Threshold: time.Second, Logger: logRecorder})
+		ctx := NewContextWithTxWatchdog(context.Background(), watchdog)
+
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		require.NoError(t, DoInTx(ctx, db, func(tx *sql.Tx) error {
+			return nil
+		}))
+		require.Empty(t, logRecorder.Entries())
+	})
+
+	mock.ExpectClose()
+}
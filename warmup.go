@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// WarmUpOpts represents an options for WarmUpWithOpts.
+type WarmUpOpts struct {
+	// PrimingQuery (if set) is executed on each established connection (e.g. for loading caches or setting up
+	// prepared state on the server side).
+	PrimingQuery string
+}
+
+// WarmUp concurrently opens and pings n connections to avoid latency spikes on the first requests (e.g. after deploys).
+// Number of connections is bounded by the maximum number of open connections (see sql.DB.SetMaxOpenConns).
+// Established connections are returned to the pool, so MaxIdleConns should be not less than n to keep all of them.
+// It returns number of successfully established connections and errors (joined) of the failed ones.
+func WarmUp(ctx context.Context, db *sql.DB, n int) (int, error) {
+	return WarmUpWithOpts(ctx, db, n, WarmUpOpts{})
+}
+
+// WarmUpWithOpts is a more configurable version of the WarmUp.
+func WarmUpWithOpts(ctx context.Context, db *sql.DB, n int, opts WarmUpOpts) (int, error) {
+	if maxOpen := db.Stats().MaxOpenConnections; maxOpen > 0 && n > maxOpen {
+		n = maxOpen
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+
+	conns := make([]*sql.Conn, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], errs[i] = warmUpConn(ctx, db, opts)
+		}(i)
+	}
+	wg.Wait()
+
+	// Connections are held until all of them are established, so each goroutine gets a separate one.
+	var established int
+	for i, conn := range conns {
+		if conn == nil {
+			errs[i] = fmt.Errorf("warm up connection #%d: %w", i, errs[i])
+			continue
+		}
+		established++
+		if closeErr := conn.Close(); closeErr != nil && !errors.Is(closeErr, sql.ErrConnDone) {
+			errs[i] = fmt.Errorf("release connection #%d: %w", i, closeErr)
+		}
+	}
+	return established, errors.Join(errs...)
+}
+
+func warmUpConn(ctx context.Context, db *sql.DB, opts WarmUpOpts) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open connection: %w", err)
+	}
+	if err = conn.PingContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("ping: %w", err)
+	}
+	if opts.PrimingQuery != "" {
+		if _, err = conn.ExecContext(ctx, opts.PrimingQuery); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("execute priming query: %w", err)
+		}
+	}
+	return conn, nil
+}
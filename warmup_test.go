@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmUp(t *testing.T) {
+	ctx := context.Background()
+
+	openDB := func(t *testing.T) *sql.DB {
+		t.Helper()
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "warmup.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, db.Close()) })
+		return db
+	}
+
+	t.Run("connections are established", func(t *testing.T) {
+		db := openDB(t)
+		db.SetMaxIdleConns(5)
+		established, err := WarmUp(ctx, db, 5)
+		require.NoError(t, err)
+		require.Equal(t, 5, established)
+		require.Equal(t, 5, db.Stats().OpenConnections)
+		require.Equal(t, 5, db.Stats().Idle)
+	})
+
+	t.Run("number of connections is bounded by MaxOpenConns", func(t *testing.T) {
+		db := openDB(t)
+		db.SetMaxOpenConns(3)
+		db.SetMaxIdleConns(3)
+		established, err := WarmUpWithOpts(ctx, db, 10, WarmUpOpts{PrimingQuery: "SELECT 1"})
+		require.NoError(t, err)
+		require.Equal(t, 3, established)
+		require.Equal(t, 3, db.Stats().Idle)
+	})
+
+	t.Run("priming query fails", func(t *testing.T) {
+		db := openDB(t)
+		established, err := WarmUpWithOpts(ctx, db, 2, WarmUpOpts{PrimingQuery: "SELECT * FROM unknown_table"})
+		require.ErrorContains(t, err, "warm up connection #0: execute priming query")
+		require.ErrorContains(t, err, "warm up connection #1: execute priming query")
+		require.Equal(t, 0, established)
+	})
+}